@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// requestTimeout limits API requests other than streams
+const requestTimeout = 60 * time.Second
+
+// client calls the versioned JSON API of a server
+type client struct {
+	server string
+	token  string
+}
+
+// apiError is an error response of the API
+type apiError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *apiError) Error() string {
+	if e.Status == http.StatusUnauthorized {
+		return e.Message + ", run gothctl login again"
+	}
+	return e.Message
+}
+
+// pagination describes the page of a list
+type pagination struct {
+	Page       int `json:"page"`
+	PerPage    int `json:"per_page"`
+	Total      int `json:"total"`
+	TotalPages int `json:"total_pages"`
+}
+
+// sseEvent is a Server-Sent Event
+type sseEvent struct {
+	ID    string
+	Event string
+	Data  string
+}
+
+// get requests path under /api/v1 and decodes the data of the response into out
+func (c *client) get(path string, query url.Values, out interface{}) error {
+	return c.do(http.MethodGet, path, query, nil, out)
+}
+
+// list requests a page of a list and decodes its items into out
+func (c *client) list(path string, query url.Values, out interface{}) (pagination, error) {
+	var page pagination
+	resp, err := c.send(http.MethodGet, path, query, nil, requestTimeout)
+	if err != nil {
+		return page, err
+	}
+	defer resp.Body.Close()
+
+	body := struct {
+		Data       interface{} `json:"data"`
+		Pagination *pagination `json:"pagination"`
+	}{Data: out, Pagination: &page}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return page, fmt.Errorf("invalid response from %s: %w", c.server, err)
+	}
+	return page, nil
+}
+
+// do sends a request with a JSON body, or a raw body given as []byte, and decodes the data of
+// the response into out unless it is nil
+func (c *client) do(method, path string, query url.Values, body, out interface{}) error {
+	resp, err := c.send(method, path, query, body, requestTimeout)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	envelope := struct {
+		Data interface{} `json:"data"`
+	}{Data: out}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return fmt.Errorf("invalid response from %s: %w", c.server, err)
+	}
+	return nil
+}
+
+// stream follows a Server-Sent Events endpoint, calling handle for every event until it
+// returns false, and returns the ID of the last event received. A stream that ends early is
+// resumed after lastID by calling stream again.
+func (c *client) stream(path string, query url.Values, lastID string, handle func(sseEvent) bool) (string, bool, error) {
+	req, err := c.newRequest(http.MethodGet, path, query, nil)
+	if err != nil {
+		return lastID, false, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return lastID, false, fmt.Errorf("failed to reach %s: %w", c.server, err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return lastID, false, err
+	}
+
+	var event sseEvent
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if event.Data != "" || event.Event != "" {
+				if event.ID != "" {
+					lastID = event.ID
+				}
+				if !handle(event) {
+					return lastID, true, nil
+				}
+			}
+			event = sseEvent{}
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "id":
+			event.ID = value
+		case "event":
+			event.Event = value
+		case "data":
+			if event.Data != "" {
+				event.Data += "\n"
+			}
+			event.Data += value
+		}
+	}
+	return lastID, false, scanner.Err()
+}
+
+// send sends a request and returns the response if it succeeded
+func (c *client) send(method, path string, query url.Values, body interface{}, timeout time.Duration) (*http.Response, error) {
+	req, err := c.newRequest(method, path, query, body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := (&http.Client{Timeout: timeout}).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach %s: %w", c.server, err)
+	}
+	if err := checkResponse(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// newRequest builds an authenticated request to path under /api/v1
+func (c *client) newRequest(method, path string, query url.Values, body interface{}) (*http.Request, error) {
+	var reader io.Reader
+	contentType := ""
+	switch body := body.(type) {
+	case nil:
+	case []byte:
+		reader = bytes.NewReader(body)
+		contentType = "text/plain"
+	default:
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+
+	target := c.server + "/api/v1" + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return req, nil
+}
+
+// checkResponse turns error responses into an *apiError
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode < 400 {
+		return nil
+	}
+
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	var body struct {
+		Error *apiError `json:"error"`
+	}
+	if json.Unmarshal(data, &body) == nil && body.Error != nil {
+		return body.Error
+	}
+	// Not the API, a proxy in front of it for example
+	message := strings.TrimSpace(string(data))
+	if message == "" {
+		message = http.StatusText(resp.StatusCode)
+	}
+	return &apiError{Status: resp.StatusCode, Message: fmt.Sprintf("%s (HTTP %d)", message, resp.StatusCode)}
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"goth-deploy/internal/models"
+)
+
+// streamRetryDelay is how long to wait before resuming a log stream that ended early
+const streamRetryDelay = 2 * time.Second
+
+// projectsCommand runs the projects subcommands
+func projectsCommand(cli *client, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	switch args[0] {
+	case "list", "ls":
+		return listProjects(cli, args[1:])
+	case "create":
+		return createProject(cli, args[1:])
+	case "delete", "rm":
+		return deleteProject(cli, args[1:])
+	default:
+		return errUsage
+	}
+}
+
+// listProjects prints the projects the token can see
+func listProjects(cli *client, args []string) error {
+	if len(args) > 0 {
+		return errUsage
+	}
+	projects, err := cli.listProjects()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tSUBDOMAIN\tBRANCH\tSTATUS\tLAST DEPLOY\tREPOSITORY")
+	for _, project := range projects {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", project.ID, project.Name, project.Subdomain, project.Branch, project.Status, formatTime(project.LastDeploy), project.RepoURL)
+	}
+	return w.Flush()
+}
+
+// createProject creates a project, by default for the git checkout in the working directory
+func createProject(cli *client, args []string) error {
+	flags := newFlags("projects create")
+	name := flags.String("name", "", "project name (default: repository name)")
+	repo := flags.String("repo", "", "repository URL (default: the git remote)")
+	branch := flags.String("branch", "", "branch to deploy (default: main)")
+	subdomain := flags.String("subdomain", "", "subdomain (default: generated from the name)")
+	build := flags.String("build", "", "build command (default: go build -o main .)")
+	start := flags.String("start", "", "start command (default: ./main)")
+	org := flags.Int64("org", 0, "ID of the organization to create the project in")
+	if positional, err := parseFlags(flags, args); err != nil {
+		return err
+	} else if len(positional) > 0 {
+		return errUsage
+	}
+
+	if *repo == "" {
+		remote, err := gitRemoteURL()
+		if err != nil {
+			return fmt.Errorf("--repo is required outside a git checkout: %w", err)
+		}
+		*repo = remote
+	}
+	if *name == "" {
+		*name = path.Base(normalizeRepoURL(*repo))
+	}
+
+	body := map[string]interface{}{"name": *name, "repo_url": *repo}
+	for key, value := range map[string]string{"branch": *branch, "subdomain": *subdomain, "build_command": *build, "start_command": *start} {
+		if value != "" {
+			body[key] = value
+		}
+	}
+	if *org != 0 {
+		body["organization_id"] = *org
+	}
+
+	var created struct {
+		Project    models.Project     `json:"project"`
+		Deployment *models.Deployment `json:"deployment"`
+	}
+	if err := cli.do("POST", "/projects", nil, body, &created); err != nil {
+		return err
+	}
+
+	fmt.Printf("Created project %s (%d) from %s, branch %s\n", created.Project.Subdomain, created.Project.ID, created.Project.RepoURL, created.Project.Branch)
+	if created.Deployment != nil {
+		fmt.Printf("Started deployment #%d, follow it with: gothctl logs --build %d --follow\n", created.Deployment.ID, created.Deployment.ID)
+	} else {
+		fmt.Println("The first deployment failed to start, run gothctl deploy to retry")
+	}
+	return nil
+}
+
+// deleteProject deletes a project after confirmation
+func deleteProject(cli *client, args []string) error {
+	flags := newFlags("projects delete")
+	yes := flags.Bool("yes", false, "do not ask for confirmation")
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errUsage
+	}
+
+	project, err := cli.resolveProject(positional[0])
+	if err != nil {
+		return err
+	}
+	if !*yes && !confirm(fmt.Sprintf("Delete project %s (%d) with all its deployments?", project.Subdomain, project.ID)) {
+		return fmt.Errorf("aborted")
+	}
+
+	if err := cli.do("DELETE", fmt.Sprintf("/projects/%d", project.ID), nil, nil, nil); err != nil {
+		return err
+	}
+	fmt.Printf("Deleted project %s\n", project.Subdomain)
+	return nil
+}
+
+// deploy deploys a project and optionally follows the build log
+func deploy(cli *client, args []string) error {
+	flags := newFlags("deploy")
+	sha := flags.String("sha", "", "commit to deploy (default: latest commit of the branch)")
+	follow := flags.Bool("follow", false, "follow the build log until the deployment finishes")
+	project, _, err := projectArgs(cli, flags, args, 0)
+	if err != nil {
+		return err
+	}
+
+	var deployment models.Deployment
+	if err := cli.do("POST", fmt.Sprintf("/projects/%d/deployments", project.ID), nil, map[string]string{"commit_sha": *sha}, &deployment); err != nil {
+		return err
+	}
+	fmt.Printf("Started deployment #%d of %s\n", deployment.ID, project.Subdomain)
+
+	if *follow {
+		return followBuildLog(cli, deployment.ID)
+	}
+	return nil
+}
+
+// rollback rolls a project back to a deployment, by default the last successful one before
+// the release currently serving
+func rollback(cli *client, args []string) error {
+	project, positional, err := projectArgs(cli, newFlags("rollback"), args, 1)
+	if err != nil {
+		return err
+	}
+
+	var target int64
+	if len(positional) == 1 {
+		if target, err = strconv.ParseInt(strings.TrimPrefix(positional[0], "#"), 10, 64); err != nil {
+			return fmt.Errorf("invalid deployment %q", positional[0])
+		}
+	} else {
+		var deployments []models.Deployment
+		if _, err := cli.list(fmt.Sprintf("/projects/%d/deployments", project.ID), url.Values{"per_page": {"100"}}, &deployments); err != nil {
+			return err
+		}
+
+		// A rollback serves the release of the deployment it rolled back to
+		var current int64
+		if project.ActiveDeploymentID != nil {
+			current = *project.ActiveDeploymentID
+			for _, deployment := range deployments {
+				if deployment.ID == current && deployment.RollbackOf != nil {
+					current = *deployment.RollbackOf
+				}
+			}
+		}
+		for _, deployment := range deployments {
+			if deployment.Status == models.StatusSuccess && deployment.RollbackOf == nil && (current == 0 || deployment.ID < current) {
+				target = deployment.ID
+				break
+			}
+		}
+		if target == 0 {
+			return fmt.Errorf("no earlier successful deployment of %s to roll back to", project.Subdomain)
+		}
+	}
+
+	var deployment models.Deployment
+	if err := cli.do("POST", fmt.Sprintf("/deployments/%d/rollback", target), nil, nil, &deployment); err != nil {
+		return err
+	}
+	fmt.Printf("Rolling %s back to deployment #%d (deployment #%d)\n", project.Subdomain, target, deployment.ID)
+	return nil
+}
+
+// deployments prints the latest deployments of a project
+func deployments(cli *client, args []string) error {
+	flags := newFlags("deployments")
+	limit := flags.Int("limit", 10, "number of deployments to show, at most 100")
+	project, _, err := projectArgs(cli, flags, args, 0)
+	if err != nil {
+		return err
+	}
+
+	var list []models.Deployment
+	if _, err := cli.list(fmt.Sprintf("/projects/%d/deployments", project.ID), url.Values{"per_page": {strconv.Itoa(*limit)}}, &list); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tCOMMIT\tSTARTED\tFINISHED\tNOTE")
+	for _, deployment := range list {
+		var notes []string
+		if project.ActiveDeploymentID != nil && deployment.ID == *project.ActiveDeploymentID {
+			notes = append(notes, "active")
+		}
+		if deployment.RollbackOf != nil {
+			notes = append(notes, fmt.Sprintf("rollback to #%d", *deployment.RollbackOf))
+		}
+		if deployment.ErrorMsg != "" {
+			notes = append(notes, deployment.ErrorMsg)
+		}
+		note := strings.Join(notes, ", ")
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", deployment.ID, deployment.Status, shortSHA(deployment.CommitSHA), formatTime(&deployment.StartedAt), formatTime(deployment.FinishedAt), note)
+	}
+	return w.Flush()
+}
+
+// logs prints the runtime logs of a project, or the build log of a deployment
+func logs(cli *client, args []string) error {
+	flags := newFlags("logs")
+	follow := flags.Bool("follow", false, "keep printing new lines")
+	flags.BoolVar(follow, "f", false, "short for --follow")
+	build := flags.Int64("build", 0, "print the build log of this deployment instead")
+	tail := flags.Int("tail", 100, "number of recent lines to print first")
+	since := flags.String("since", "", "only lines since an RFC 3339 time or a duration like 15m")
+	stream := flags.String("stream", "", "only stdout or stderr")
+	level := flags.String("level", "", "only these levels, comma separated")
+	grep := flags.String("grep", "", "only lines matching a regular expression")
+
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if *build != 0 {
+		if len(positional) > 0 {
+			return errUsage
+		}
+		if *follow {
+			return followBuildLog(cli, *build)
+		}
+		var buildLog struct {
+			Log string `json:"log"`
+		}
+		if err := cli.get(fmt.Sprintf("/deployments/%d/logs", *build), nil, &buildLog); err != nil {
+			return err
+		}
+		fmt.Print(buildLog.Log)
+		return nil
+	}
+
+	if len(positional) > 1 {
+		return errUsage
+	}
+	name := ""
+	if len(positional) == 1 {
+		name = positional[0]
+	}
+	project, err := cli.resolveProject(name)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	for key, value := range map[string]string{"since": *since, "stream": *stream, "level": *level, "grep": *grep} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	if *tail > 0 && *since == "" {
+		query.Set("tail", strconv.Itoa(*tail))
+	}
+
+	if !*follow {
+		var lines []models.RuntimeLogLine
+		if err := cli.get(fmt.Sprintf("/projects/%d/logs", project.ID), query, &lines); err != nil {
+			return err
+		}
+		for _, line := range lines {
+			printLogLine(line)
+		}
+		return nil
+	}
+
+	// The time range does not apply to followed lines, since becomes a tail
+	query.Del("since")
+	lastID := ""
+	for {
+		lastID, _, err = cli.stream(fmt.Sprintf("/projects/%d/logs/stream", project.ID), query, lastID, func(event sseEvent) bool {
+			var line models.RuntimeLogLine
+			if json.Unmarshal([]byte(event.Data), &line) == nil {
+				printLogLine(line)
+			}
+			return true
+		})
+		if _, ok := err.(*apiError); ok {
+			return err
+		}
+		// The server ends streams of clients that fell behind, resume after the last line
+		time.Sleep(streamRetryDelay)
+	}
+}
+
+// followBuildLog prints the build log of a deployment until it finishes, and fails unless it
+// succeeded
+func followBuildLog(cli *client, deploymentID int64) error {
+	status := ""
+	lastID := ""
+	for status == "" {
+		var done bool
+		var err error
+		lastID, done, err = cli.stream(fmt.Sprintf("/deployments/%d/logs/stream", deploymentID), nil, lastID, func(event sseEvent) bool {
+			if event.Event == "done" {
+				status = event.Data
+				return false
+			}
+			fmt.Println(event.Data)
+			return true
+		})
+		if _, ok := err.(*apiError); ok {
+			return err
+		}
+		if !done {
+			time.Sleep(streamRetryDelay)
+		}
+	}
+
+	if status != models.StatusSuccess {
+		return fmt.Errorf("deployment #%d %s", deploymentID, status)
+	}
+	fmt.Printf("Deployment #%d succeeded\n", deploymentID)
+	return nil
+}
+
+// control starts, stops or restarts the application of a project
+func control(cli *client, action string, args []string) error {
+	project, _, err := projectArgs(cli, newFlags(action), args, 0)
+	if err != nil {
+		return err
+	}
+
+	if err := cli.do("POST", fmt.Sprintf("/projects/%d/%s", project.ID, action), nil, nil, nil); err != nil {
+		return err
+	}
+	switch action {
+	case "stop":
+		fmt.Printf("Stopped %s\n", project.Subdomain)
+	case "start":
+		fmt.Printf("Starting %s\n", project.Subdomain)
+	default:
+		fmt.Printf("Restarting %s\n", project.Subdomain)
+	}
+	return nil
+}
+
+// projectArgs parses the flags of a command taking an optional project and up to extra more
+// positional arguments, and resolves the project. The first positional argument is taken as
+// the project only if more are given than extra.
+func projectArgs(cli *client, flags *flag.FlagSet, args []string, extra int) (*models.Project, []string, error) {
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(positional) > extra+1 {
+		return nil, nil, errUsage
+	}
+
+	name := ""
+	if len(positional) > extra {
+		name, positional = positional[0], positional[1:]
+	}
+	project, err := cli.resolveProject(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return project, positional, nil
+}
+
+// printLogLine prints a runtime log line
+func printLogLine(line models.RuntimeLogLine) {
+	stream := ""
+	if line.Stream == models.LogStreamStderr {
+		stream = " [stderr]"
+	}
+	fmt.Printf("%s%s %s\n", line.Time.Local().Format("2006-01-02 15:04:05"), stream, line.Text)
+}
+
+// formatTime formats an optional time for tables
+func formatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+// shortSHA shortens a commit SHA for tables
+func shortSHA(sha string) string {
+	if len(sha) > 8 {
+		return sha[:8]
+	}
+	return sha
+}
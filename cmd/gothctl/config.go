@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"goth-deploy/internal/models"
+)
+
+// config is what gothctl stores between runs
+type config struct {
+	Server string `json:"server"`
+	Token  string `json:"token"`
+}
+
+// configPath returns where the config is stored
+func configPath() (string, error) {
+	if path := os.Getenv("GOTHCTL_CONFIG"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the config directory: %w", err)
+	}
+	return filepath.Join(dir, "gothctl", "config.json"), nil
+}
+
+// readConfig reads the stored config
+func readConfig() (config, error) {
+	var cfg config
+	path, err := configPath()
+	if err != nil {
+		return cfg, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return cfg, fmt.Errorf("failed to read config: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("failed to parse config %s: %w", path, err)
+		}
+	}
+	return cfg, nil
+}
+
+// loadConfig reads the stored config, overridden by GOTHCTL_SERVER and GOTHCTL_TOKEN
+func loadConfig() (config, error) {
+	cfg, err := readConfig()
+	if err != nil {
+		return cfg, err
+	}
+	if server := os.Getenv("GOTHCTL_SERVER"); server != "" {
+		cfg.Server = server
+	}
+	if token := os.Getenv("GOTHCTL_TOKEN"); token != "" {
+		cfg.Token = token
+	}
+	return cfg, nil
+}
+
+// saveConfig stores the config, readable only by the user since it holds the token
+func saveConfig(cfg config) error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	return nil
+}
+
+// newClient creates an API client from the stored config
+func newClient() (*client, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Server == "" || cfg.Token == "" {
+		return nil, fmt.Errorf("not logged in, run gothctl login --server URL first")
+	}
+	return &client{server: strings.TrimRight(cfg.Server, "/"), token: cfg.Token}, nil
+}
+
+// login checks an API token against the server and stores both
+func login(args []string) error {
+	flags := newFlags("login")
+	server := flags.String("server", "", "URL of the GoTH Deployer server")
+	token := flags.String("token", "", "API token (default: read from standard input)")
+	if _, err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	cfg, err := readConfig()
+	if err != nil {
+		return err
+	}
+	if *server != "" {
+		cfg.Server = *server
+	}
+	if cfg.Server == "" {
+		return fmt.Errorf("--server is required")
+	}
+	cfg.Server = strings.TrimRight(cfg.Server, "/")
+	if !strings.HasPrefix(cfg.Server, "http://") && !strings.HasPrefix(cfg.Server, "https://") {
+		cfg.Server = "https://" + cfg.Server
+	}
+
+	cfg.Token = *token
+	if cfg.Token == "" {
+		fmt.Fprint(os.Stderr, "API token (create one with POST /api/tokens while signed in): ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("failed to read the token: %w", err)
+		}
+		cfg.Token = strings.TrimSpace(line)
+	}
+
+	var me struct {
+		User  models.User      `json:"user"`
+		Token *models.APIToken `json:"token"`
+	}
+	cli := &client{server: cfg.Server, token: cfg.Token}
+	if err := cli.get("/user", nil, &me); err != nil {
+		return err
+	}
+	if err := saveConfig(cfg); err != nil {
+		return err
+	}
+
+	fmt.Printf("Logged in to %s as %s\n", cfg.Server, me.User.Username)
+	if me.Token != nil {
+		fmt.Printf("Token %q with scopes %s, expires %s\n", me.Token.Name, strings.Join(me.Token.Scopes, ", "), me.Token.ExpiresAt.Local().Format(time.DateOnly))
+	}
+	return nil
+}
+
+// logout forgets the stored token, the server is kept for the next login
+func logout(args []string) error {
+	if len(args) > 0 {
+		return errUsage
+	}
+	cfg, err := readConfig()
+	if err != nil {
+		return err
+	}
+	cfg.Token = ""
+	if err := saveConfig(cfg); err != nil {
+		return err
+	}
+	fmt.Println("Logged out. Revoke the token on the server if it is no longer needed")
+	return nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"goth-deploy/internal/models"
+)
+
+// envCommand runs the env subcommands. They take the project with -p, since their positional
+// arguments are variables.
+func envCommand(cli *client, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	flags := newFlags("env " + args[0])
+	projectName := flags.String("p", "", "project ID, name or subdomain (default: inferred from git)")
+	scope := flags.String("scope", "", "scope of new variables: build, runtime or both (default both)")
+	apply := flags.String("apply", "", "apply the change right away: restart or redeploy")
+	reveal := flags.Bool("reveal", false, "show values (list only)")
+	replace := flags.Bool("replace", false, "delete variables missing from the file (import only)")
+	dryRun := flags.Bool("dry-run", false, "only show what the import would change (import only)")
+	positional, err := parseFlags(flags, args[1:])
+	if err != nil {
+		return err
+	}
+
+	project, err := cli.resolveProject(*projectName)
+	if err != nil {
+		return err
+	}
+	query := url.Values{}
+	if *apply != "" {
+		query.Set("apply", *apply)
+	}
+
+	switch args[0] {
+	case "list", "ls":
+		if len(positional) > 0 {
+			return errUsage
+		}
+		return listEnv(cli, project, *reveal)
+	case "set":
+		if len(positional) == 0 {
+			return errUsage
+		}
+		return setEnv(cli, project, positional, *scope, query)
+	case "unset":
+		if len(positional) == 0 {
+			return errUsage
+		}
+		return unsetEnv(cli, project, positional, query)
+	case "import":
+		if len(positional) != 1 {
+			return errUsage
+		}
+		if *scope != "" {
+			query.Set("scope", *scope)
+		}
+		if *replace {
+			query.Set("replace", "true")
+		}
+		if *dryRun {
+			query.Set("preview", "true")
+		}
+		return importEnv(cli, project, positional[0], query)
+	default:
+		return errUsage
+	}
+}
+
+// listEnv prints the environment variables of a project
+func listEnv(cli *client, project *models.Project, reveal bool) error {
+	envVars, err := getEnv(cli, project, reveal)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tSCOPE\tVALUE")
+	for _, envVar := range envVars {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", envVar.Key, envVar.Scope, envVar.Value)
+	}
+	return w.Flush()
+}
+
+// setEnv creates or updates variables given as KEY=VALUE. Updated variables keep their scope
+// unless one is given.
+func setEnv(cli *client, project *models.Project, pairs []string, scope string, query url.Values) error {
+	existing, err := getEnv(cli, project, false)
+	if err != nil {
+		return err
+	}
+	ids := map[string]int64{}
+	for _, envVar := range existing {
+		ids[envVar.Key] = envVar.ID
+	}
+
+	for i, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("invalid variable %q, expected KEY=VALUE", pair)
+		}
+		body := map[string]string{"key": key, "value": value}
+		if scope != "" {
+			body["scope"] = scope
+		}
+
+		// Only the last change applies it, once
+		changeQuery := url.Values{}
+		if i == len(pairs)-1 {
+			changeQuery = query
+		}
+
+		var saved struct {
+			Message string `json:"message"`
+		}
+		if id, ok := ids[key]; ok {
+			err = cli.do("PATCH", fmt.Sprintf("/projects/%d/env/%d", project.ID, id), changeQuery, body, &saved)
+		} else {
+			err = cli.do("POST", fmt.Sprintf("/projects/%d/env", project.ID), changeQuery, body, &saved)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		fmt.Printf("%s: %s\n", key, saved.Message)
+	}
+	return nil
+}
+
+// unsetEnv deletes variables by key
+func unsetEnv(cli *client, project *models.Project, keys []string, query url.Values) error {
+	existing, err := getEnv(cli, project, false)
+	if err != nil {
+		return err
+	}
+	ids := map[string]int64{}
+	for _, envVar := range existing {
+		ids[envVar.Key] = envVar.ID
+	}
+	for _, key := range keys {
+		if _, ok := ids[key]; !ok {
+			return fmt.Errorf("%s is not set on %s", key, project.Subdomain)
+		}
+	}
+
+	for i, key := range keys {
+		changeQuery := url.Values{}
+		if i == len(keys)-1 {
+			changeQuery = query
+		}
+
+		var deleted struct {
+			Message string `json:"message"`
+		}
+		if err := cli.do("DELETE", fmt.Sprintf("/projects/%d/env/%d", project.ID, ids[key]), changeQuery, nil, &deleted); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		fmt.Printf("%s: %s\n", key, deleted.Message)
+	}
+	return nil
+}
+
+// importEnv imports a .env file, "-" reads it from standard input
+func importEnv(cli *client, project *models.Project, file string, query url.Values) error {
+	var data []byte
+	var err error
+	if file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", file, err)
+	}
+
+	var result struct {
+		Message string `json:"message"`
+		Changes []struct {
+			Key    string `json:"key"`
+			Action string `json:"action"`
+			Scope  string `json:"scope"`
+		} `json:"changes"`
+	}
+	if err := cli.do("POST", fmt.Sprintf("/projects/%d/env/import", project.ID), query, data, &result); err != nil {
+		return err
+	}
+
+	for _, change := range result.Changes {
+		if change.Action != "unchanged" {
+			fmt.Printf("%-8s %s (%s)\n", change.Action, change.Key, change.Scope)
+		}
+	}
+	fmt.Println(result.Message)
+	return nil
+}
+
+// getEnv returns the environment variables of a project
+func getEnv(cli *client, project *models.Project, reveal bool) ([]models.EnvironmentVariable, error) {
+	query := url.Values{}
+	if reveal {
+		query.Set("reveal", "true")
+	}
+	var envVars []models.EnvironmentVariable
+	err := cli.get(fmt.Sprintf("/projects/%d/env", project.ID), query, &envVars)
+	return envVars, err
+}
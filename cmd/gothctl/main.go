@@ -0,0 +1,140 @@
+// Command gothctl manages projects on a GoTH Deployer server through its JSON API, with an API
+// token stored by "gothctl login".
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const usage = `gothctl manages projects on a GoTH Deployer server.
+
+Usage:
+  gothctl login --server URL [--token TOKEN]   store the server and an API token
+  gothctl logout                               forget the stored token
+
+  gothctl projects list
+  gothctl projects create [--name NAME] [--repo URL] [--branch BRANCH] [--subdomain NAME]
+                          [--build CMD] [--start CMD] [--org ID]
+  gothctl projects delete PROJECT [--yes]
+
+  gothctl deploy [PROJECT] [--sha SHA] [--follow]
+  gothctl rollback [PROJECT] [DEPLOYMENT]
+  gothctl deployments [PROJECT] [--limit N]
+  gothctl logs [PROJECT] [--follow] [--tail N] [--since 15m] [--stream stderr] [--level error]
+               [--grep REGEXP]
+  gothctl logs --build DEPLOYMENT [--follow]
+  gothctl start|stop|restart [PROJECT]
+
+  gothctl env list [-p PROJECT] [--reveal]
+  gothctl env set [-p PROJECT] [--scope build|runtime|both] [--apply restart|redeploy] KEY=VALUE...
+  gothctl env unset [-p PROJECT] [--apply restart|redeploy] KEY...
+  gothctl env import [-p PROJECT] [--scope SCOPE] [--replace] [--dry-run] [--apply ...] FILE
+
+PROJECT is a project ID, name or subdomain. Inside a git checkout it defaults to the project
+deploying the checkout's remote repository.
+
+The server and token are stored in the user config directory, GOTHCTL_SERVER and
+GOTHCTL_TOKEN override them.
+`
+
+// errUsage is returned for invalid command lines, main prints the usage for it
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "help" || os.Args[1] == "-h" || os.Args[1] == "--help" {
+		fmt.Print(usage)
+		return
+	}
+
+	if err := run(os.Args[1], os.Args[2:]); err != nil {
+		if errors.Is(err, errUsage) {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(2)
+		}
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(os.Stderr, "gothctl: %v\n", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// run runs a command
+func run(name string, args []string) error {
+	switch name {
+	case "login":
+		return login(args)
+	case "logout":
+		return logout(args)
+	}
+
+	cli, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	switch name {
+	case "projects":
+		return projectsCommand(cli, args)
+	case "deploy":
+		return deploy(cli, args)
+	case "rollback":
+		return rollback(cli, args)
+	case "deployments":
+		return deployments(cli, args)
+	case "logs":
+		return logs(cli, args)
+	case "start", "stop", "restart":
+		return control(cli, name, args)
+	case "env":
+		return envCommand(cli, args)
+	default:
+		return fmt.Errorf("unknown command %q, run gothctl help for the available commands", name)
+	}
+}
+
+// parseFlags parses flags given before, between and after positional arguments, and returns
+// the positional arguments. Everything after "--" is positional.
+func parseFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				fmt.Fprintf(os.Stderr, "Usage of %s:\n", flags.Name())
+				flags.SetOutput(os.Stderr)
+				flags.PrintDefaults()
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w, run gothctl help for the usage", err)
+		}
+		rest := flags.Args()
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		if parsed := len(args) - len(rest); parsed > 0 && args[parsed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// newFlags creates the flag set of a command. Parse errors are printed by main, once.
+func newFlags(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet("gothctl "+name, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	return flags
+}
+
+// confirm asks a yes/no question on the terminal
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	var answer string
+	fmt.Scanln(&answer)
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
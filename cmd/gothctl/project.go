@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"goth-deploy/internal/models"
+)
+
+// listProjects returns all projects the token can see
+func (c *client) listProjects() ([]models.Project, error) {
+	var projects []models.Project
+	for page := 1; ; page++ {
+		var batch []models.Project
+		query := url.Values{"page": {strconv.Itoa(page)}, "per_page": {"100"}}
+		pages, err := c.list("/projects", query, &batch)
+		if err != nil {
+			return nil, err
+		}
+		projects = append(projects, batch...)
+		if page >= pages.TotalPages {
+			return projects, nil
+		}
+	}
+}
+
+// resolveProject finds the project named by an ID, name or subdomain, or without a name the
+// project deploying the git checkout in the working directory
+func (c *client) resolveProject(name string) (*models.Project, error) {
+	if id, err := strconv.ParseInt(name, 10, 64); err == nil {
+		var project struct {
+			Project models.Project `json:"project"`
+		}
+		if err := c.get(fmt.Sprintf("/projects/%d", id), nil, &project); err != nil {
+			return nil, err
+		}
+		return &project.Project, nil
+	}
+
+	projects, err := c.listProjects()
+	if err != nil {
+		return nil, err
+	}
+
+	if name != "" {
+		var matches []models.Project
+		for _, project := range projects {
+			if project.Subdomain == name {
+				return &project, nil
+			}
+			if strings.EqualFold(project.Name, name) {
+				matches = append(matches, project)
+			}
+		}
+		return pickProject(matches, fmt.Sprintf("named %q", name))
+	}
+
+	remotes, err := gitRemotes()
+	if err != nil {
+		return nil, fmt.Errorf("no project given and %w", err)
+	}
+	var matches []models.Project
+	for _, project := range projects {
+		if remotes[normalizeRepoURL(project.RepoURL)] {
+			matches = append(matches, project)
+		}
+	}
+
+	// Several projects may deploy branches of the same repository
+	if len(matches) > 1 {
+		if branch := gitBranch(); branch != "" {
+			var onBranch []models.Project
+			for _, project := range matches {
+				if project.Branch == branch {
+					onBranch = append(onBranch, project)
+				}
+			}
+			if len(onBranch) > 0 {
+				matches = onBranch
+			}
+		}
+	}
+	return pickProject(matches, "deploying this repository")
+}
+
+// pickProject returns the only project of matches
+func pickProject(matches []models.Project, description string) (*models.Project, error) {
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no project %s", description)
+	case 1:
+		return &matches[0], nil
+	}
+
+	names := make([]string, len(matches))
+	for i, project := range matches {
+		names[i] = fmt.Sprintf("%s (%d, branch %s)", project.Subdomain, project.ID, project.Branch)
+	}
+	return nil, fmt.Errorf("several projects %s, name one of: %s", description, strings.Join(names, ", "))
+}
+
+// gitRemotes returns the normalized URLs of the remotes of the git checkout in the working
+// directory
+func gitRemotes() (map[string]bool, error) {
+	out, err := exec.Command("git", "remote", "-v").Output()
+	if err != nil {
+		return nil, fmt.Errorf("the working directory is not a git checkout")
+	}
+
+	remotes := map[string]bool{}
+	for _, line := range strings.Split(string(out), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 {
+			remotes[normalizeRepoURL(fields[1])] = true
+		}
+	}
+	if len(remotes) == 0 {
+		return nil, fmt.Errorf("the git checkout has no remotes")
+	}
+	return remotes, nil
+}
+
+// gitRemoteURL returns the URL of the origin remote, or of the only remote
+func gitRemoteURL() (string, error) {
+	if out, err := exec.Command("git", "remote", "get-url", "origin").Output(); err == nil {
+		return strings.TrimSpace(string(out)), nil
+	}
+	remotes, err := gitRemotes()
+	if err != nil {
+		return "", err
+	}
+	if len(remotes) > 1 {
+		return "", fmt.Errorf("the git checkout has several remotes and none is called origin")
+	}
+	for remote := range remotes {
+		return "https://" + remote, nil
+	}
+	return "", nil
+}
+
+// gitBranch returns the branch checked out in the working directory, "" if unknown
+func gitBranch() string {
+	out, err := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	if branch := strings.TrimSpace(string(out)); branch != "HEAD" {
+		return branch
+	}
+	return ""
+}
+
+// normalizeRepoURL reduces the HTTPS and SSH forms of a repository URL to host/owner/repo, the
+// way the server matches push webhooks to projects
+func normalizeRepoURL(repoURL string) string {
+	u := strings.ToLower(strings.TrimSpace(repoURL))
+	for _, prefix := range []string{"https://", "http://", "git://", "ssh://", "git@"} {
+		u = strings.TrimPrefix(u, prefix)
+	}
+	u = strings.Replace(u, ":", "/", 1)
+	u = strings.TrimSuffix(u, "/")
+	return strings.TrimSuffix(u, ".git")
+}
@@ -0,0 +1,654 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"goth-deploy/internal/config"
+	"goth-deploy/internal/database"
+	"goth-deploy/internal/models"
+	"goth-deploy/internal/secrets"
+	"goth-deploy/internal/store"
+)
+
+// backupFormat is the layout version of backup archives, restore rejects other versions
+const backupFormat = 1
+
+// Entries of a backup archive. The manifest comes first so restore can check the archive
+// before it changes anything.
+const (
+	manifestEntry    = "manifest.json"
+	databaseEntry    = "app.db"
+	settingsEntry    = "settings.env"
+	masterKeyEntry   = "master.key"
+	deploymentsEntry = "deployments/"
+)
+
+// backupManifest describes the platform a backup archive was made of
+type backupManifest struct {
+	Format            int       `json:"format"`
+	CreatedAt         time.Time `json:"created_at"`
+	Hostname          string    `json:"hostname"`
+	SchemaVersion     int       `json:"schema_version"`
+	DeploymentRoot    string    `json:"deployment_root"`
+	DeploymentRootAbs string    `json:"deployment_root_abs"`
+	MasterKeyID       string    `json:"master_key_id,omitempty"`
+	IncludesSecrets   bool      `json:"includes_secrets"`
+}
+
+// backup writes the database, the release artifacts and the settings of the platform to a
+// single archive. It can run while the server is running.
+func backup(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	output := flags.String("o", "goth-deploy-backup-"+time.Now().UTC().Format("20060102-150405")+".tar.gz", "archive to write")
+	includeSecrets := flags.Bool("include-secrets", false, "also store the master key, session secret and GitHub secrets")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := database.New(cfg.DatabaseURL)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+	if db.Dialect != database.SQLite {
+		return fmt.Errorf("backups are only supported for SQLite, back up PostgreSQL with pg_dump")
+	}
+
+	version, err := database.SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if version != database.LatestVersion() {
+		return fmt.Errorf("the database is at schema version %d, run migrate up to bring it to version %d first", version, database.LatestVersion())
+	}
+
+	masterKey, keyID, err := loadMasterKey(cfg)
+	if err != nil {
+		return err
+	}
+
+	// Snapshot the database first, the server may keep writing to it
+	snapshot, err := os.CreateTemp("", "goth-deploy-*.db")
+	if err != nil {
+		return fmt.Errorf("failed to create database snapshot: %w", err)
+	}
+	snapshot.Close()
+	defer os.Remove(snapshot.Name())
+	if err := database.Backup(db, snapshot.Name()); err != nil {
+		return err
+	}
+
+	deploymentRootAbs, err := filepath.Abs(cfg.DeploymentRoot)
+	if err != nil {
+		return fmt.Errorf("failed to resolve deployment root: %w", err)
+	}
+	hostname, _ := os.Hostname()
+	manifest, err := json.MarshalIndent(backupManifest{
+		Format:            backupFormat,
+		CreatedAt:         time.Now().UTC(),
+		Hostname:          hostname,
+		SchemaVersion:     version,
+		DeploymentRoot:    filepath.Clean(cfg.DeploymentRoot),
+		DeploymentRootAbs: deploymentRootAbs,
+		MasterKeyID:       keyID,
+		IncludesSecrets:   *includeSecrets,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// The archive holds encrypted secrets, and plaintext ones with -include-secrets
+	file, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %w", err)
+	}
+	complete := false
+	defer func() {
+		if !complete {
+			file.Close()
+			os.Remove(*output)
+		}
+	}()
+
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+
+	if err := writeArchiveData(tw, manifestEntry, manifest); err != nil {
+		return err
+	}
+	if err := writeArchiveFile(tw, databaseEntry, snapshot.Name()); err != nil {
+		return err
+	}
+	settings := strings.Join(cfg.Settings(*includeSecrets), "\n") + "\n"
+	if err := writeArchiveData(tw, settingsEntry, []byte(settings)); err != nil {
+		return err
+	}
+	if *includeSecrets && cfg.MasterKey == "" && masterKey != nil {
+		if err := writeArchiveData(tw, masterKeyEntry, []byte(secrets.EncodeKey(masterKey)+"\n")); err != nil {
+			return err
+		}
+	}
+	files, err := writeDeploymentRoot(tw, cfg.DeploymentRoot)
+	if err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	complete = true
+
+	log.Printf("Backed up schema version %d and %d release files to %s", version, files, *output)
+	if !*includeSecrets && keyID != "" {
+		log.Printf("The archive does not include master key %s, restoring it needs that key, or back up with -include-secrets", keyID)
+	}
+	return nil
+}
+
+// loadMasterKey returns the configured master key and its ID, or nil if no key was created yet
+func loadMasterKey(cfg *config.Config) ([]byte, string, error) {
+	var key []byte
+	var err error
+	if cfg.MasterKey != "" {
+		key, err = secrets.DecodeKey(cfg.MasterKey)
+	} else {
+		key, err = secrets.ReadKeyFile(cfg.MasterKeyFile)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, "", nil
+		}
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load master key: %w", err)
+	}
+	keyring, err := secrets.NewKeyring(key)
+	if err != nil {
+		return nil, "", err
+	}
+	return key, keyring.KeyID(), nil
+}
+
+// writeArchiveData adds a file with the given content to an archive
+func writeArchiveData(tw *tar.Writer, name string, data []byte) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// writeArchiveFile adds a file on disk to an archive
+func writeArchiveFile(tw *tar.Writer, name, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if _, err := io.Copy(tw, file); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// writeDeploymentRoot adds the releases and logs of all projects to an archive and returns how
+// many files it added. Pidfiles are left out, they describe processes of this host.
+func writeDeploymentRoot(tw *tar.Writer, root string) (int, error) {
+	if _, err := os.Stat(root); errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+
+	files := 0
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if entry.IsDir() && strings.Count(rel, "/") == 1 && strings.HasSuffix(rel, "/run") {
+			return filepath.SkipDir
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		var link string
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		case !info.Mode().IsRegular() && !info.IsDir():
+			// Sockets and pipes of running applications
+			return nil
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = deploymentsEntry + rel
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if _, err := io.Copy(tw, file); err != nil {
+			return err
+		}
+		files++
+		return nil
+	})
+	if err != nil {
+		return files, fmt.Errorf("failed to back up deployment root: %w", err)
+	}
+	return files, nil
+}
+
+// restore rebuilds the platform from a backup archive. The server must not be running
+// meanwhile. The applications that were running are started again when the server starts.
+func restore(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	force := flags.Bool("force", false, "replace an existing database, deployment root and master key")
+	settingsPath := flags.String("settings", "restored.env", "file the archived settings are written to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: restore [-force] [-settings FILE] ARCHIVE")
+	}
+
+	if strings.HasPrefix(cfg.DatabaseURL, "postgres://") || strings.HasPrefix(cfg.DatabaseURL, "postgresql://") {
+		return fmt.Errorf("restoring is only supported for SQLite, restore PostgreSQL with pg_restore")
+	}
+	dbPath := strings.TrimPrefix(cfg.DatabaseURL, "sqlite://")
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+	tr := tar.NewReader(gz)
+
+	manifest, err := readManifest(tr)
+	if err != nil {
+		return err
+	}
+	if manifest.SchemaVersion != database.LatestVersion() {
+		return fmt.Errorf("the archive holds schema version %d but this binary is at version %d, restore it with the goth-deploy version that made it", manifest.SchemaVersion, database.LatestVersion())
+	}
+
+	// Nothing is changed until the archive was found usable and the targets free
+	if err := checkRestoreTarget(dbPath, cfg.DeploymentRoot, *settingsPath, *force); err != nil {
+		return err
+	}
+
+	// The archive is extracted next to the targets, and the master key and settings are held in
+	// memory. The platform is only replaced once the whole archive was read and its database
+	// checked, so a truncated or damaged archive leaves it as it was.
+	root := filepath.Clean(cfg.DeploymentRoot)
+	if err := os.MkdirAll(filepath.Dir(root), 0755); err != nil {
+		return fmt.Errorf("failed to create deployment root: %w", err)
+	}
+	staging, err := os.MkdirTemp(filepath.Dir(root), filepath.Base(root)+".restore-*")
+	if err != nil {
+		return fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer os.RemoveAll(staging)
+	if err := os.Chmod(staging, 0755); err != nil {
+		return fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
+		return fmt.Errorf("failed to create database directory: %w", err)
+	}
+
+	restoredDB := dbPath + ".restore"
+	defer removeDatabaseFiles(restoredDB)
+	var hasDatabase bool
+	var settings, masterKey []byte
+	var files int
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		switch {
+		case header.Name == databaseEntry:
+			if err := extractFile(tr, restoredDB, 0600); err != nil {
+				return err
+			}
+			hasDatabase = true
+		case header.Name == settingsEntry:
+			if settings, err = io.ReadAll(tr); err != nil {
+				return fmt.Errorf("failed to read settings: %w", err)
+			}
+		case header.Name == masterKeyEntry:
+			if masterKey, err = readMasterKey(cfg, tr, *force); err != nil {
+				return err
+			}
+		case strings.HasPrefix(header.Name, deploymentsEntry):
+			restored, err := extractDeploymentEntry(staging, root, header, tr)
+			if err != nil {
+				return err
+			}
+			if restored {
+				files++
+			}
+		default:
+			log.Printf("Skipping unknown archive entry %s", header.Name)
+		}
+	}
+	// Reading to the end verifies the checksum of the compressed stream
+	if _, err := io.Copy(io.Discard, gz); err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+	if !hasDatabase {
+		return fmt.Errorf("the archive holds no database")
+	}
+	if err := checkRestoredDatabase(restoredDB, manifest.SchemaVersion); err != nil {
+		return err
+	}
+
+	if err := replaceDir(staging, root); err != nil {
+		return fmt.Errorf("failed to restore deployment root: %w", err)
+	}
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(dbPath + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove %s: %w", dbPath+suffix, err)
+		}
+	}
+	if err := os.Rename(restoredDB, dbPath); err != nil {
+		return fmt.Errorf("failed to restore database: %w", err)
+	}
+	if masterKey != nil {
+		if err := secrets.WriteKeyFile(cfg.MasterKeyFile, masterKey); err != nil {
+			return err
+		}
+	}
+	if settings != nil {
+		if err := os.WriteFile(*settingsPath, settings, 0600); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", *settingsPath, err)
+		}
+	}
+
+	db, err := database.New(cfg.DatabaseURL)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+	if err := database.Migrate(db); err != nil {
+		return fmt.Errorf("failed to check restored schema: %w", err)
+	}
+
+	// Release directories are recorded with the deployment root of the old host
+	rebased := 0
+	for _, oldRoot := range []string{manifest.DeploymentRoot, manifest.DeploymentRootAbs} {
+		n, err := database.RebaseReleaseDirs(db, oldRoot, cfg.DeploymentRoot)
+		if err != nil {
+			return err
+		}
+		rebased += n
+	}
+
+	running, err := store.NewSQL(db).Projects.List(store.ProjectFilter{
+		Statuses: []string{models.ProjectStatusActive, models.ProjectStatusUnhealthy},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get projects: %w", err)
+	}
+
+	log.Printf("Restored the backup of %s from %s: schema version %d, %d release files, %d release directories moved to %s",
+		manifest.Hostname, manifest.CreatedAt.Local().Format(time.DateTime), manifest.SchemaVersion, files, rebased, cfg.DeploymentRoot)
+	log.Printf("The archived settings were written to %s, merge them into .env", *settingsPath)
+	checkRestoredMasterKey(cfg, manifest)
+	log.Printf("%d application(s) will be started again when the server starts", len(running))
+	return nil
+}
+
+// readManifest reads and checks the manifest, the first entry of a backup archive
+func readManifest(tr *tar.Reader) (*backupManifest, error) {
+	header, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+	if header.Name != manifestEntry {
+		return nil, fmt.Errorf("not a goth-deploy backup, the archive does not start with %s", manifestEntry)
+	}
+	var manifest backupManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	if manifest.Format != backupFormat {
+		return nil, fmt.Errorf("unsupported backup format %d, this binary reads format %d", manifest.Format, backupFormat)
+	}
+	return &manifest, nil
+}
+
+// checkRestoreTarget refuses to overwrite an existing platform unless force is set
+func checkRestoreTarget(dbPath, deploymentRoot, settingsPath string, force bool) error {
+	if force {
+		return nil
+	}
+	if _, err := os.Stat(dbPath); err == nil {
+		return fmt.Errorf("the database %s already exists, restore with -force to replace it", dbPath)
+	}
+	if entries, err := os.ReadDir(deploymentRoot); err == nil && len(entries) > 0 {
+		return fmt.Errorf("the deployment root %s is not empty, restore with -force to replace it", deploymentRoot)
+	}
+	if _, err := os.Stat(settingsPath); err == nil {
+		return fmt.Errorf("%s already exists, restore with -force to replace it or pick another file with -settings", settingsPath)
+	}
+	return nil
+}
+
+// extractFile writes the current archive entry to path
+func extractFile(r io.Reader, path string, mode fs.FileMode) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return fmt.Errorf("failed to restore %s: %w", path, err)
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to restore %s: %w", path, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to restore %s: %w", path, err)
+	}
+	return nil
+}
+
+// extractDeploymentEntry restores an entry of the deployment root into dir, where root is staged,
+// and reports whether it was a file. Entries that would end up outside the deployment root are
+// rejected.
+func extractDeploymentEntry(dir, root string, header *tar.Header, r io.Reader) (bool, error) {
+	rel := filepath.FromSlash(strings.TrimPrefix(header.Name, deploymentsEntry))
+	target := filepath.Join(dir, rel)
+	if !insideDir(dir, target) {
+		return false, fmt.Errorf("the archive entry %s points outside the deployment root", header.Name)
+	}
+	mode := header.FileInfo().Mode().Perm()
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(target, mode|0700); err != nil {
+			return false, fmt.Errorf("failed to restore %s: %w", target, err)
+		}
+	case tar.TypeReg:
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return false, fmt.Errorf("failed to restore %s: %w", target, err)
+		}
+		if err := extractFile(r, target, mode); err != nil {
+			return false, err
+		}
+		os.Chtimes(target, header.ModTime, header.ModTime)
+		return true, nil
+	case tar.TypeSymlink:
+		// Links may only point within the deployment root, later entries are written through them.
+		// Absolute links are made relative so they point into dir until it replaces the root.
+		link := header.Linkname
+		resolved := link
+		if !filepath.IsAbs(resolved) {
+			resolved = filepath.Join(filepath.Dir(filepath.Join(root, rel)), resolved)
+		}
+		if !insideDir(root, resolved) {
+			log.Printf("Skipping link %s to %s outside the deployment root", header.Name, header.Linkname)
+			return false, nil
+		}
+		if filepath.IsAbs(link) {
+			var err error
+			if link, err = filepath.Rel(filepath.Dir(filepath.Join(root, rel)), resolved); err != nil {
+				return false, fmt.Errorf("failed to restore %s: %w", target, err)
+			}
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return false, fmt.Errorf("failed to restore %s: %w", target, err)
+		}
+		if err := os.Symlink(link, target); err != nil {
+			return false, fmt.Errorf("failed to restore %s: %w", target, err)
+		}
+	}
+	return false, nil
+}
+
+// replaceDir moves dir into the place of target and removes what target held
+func replaceDir(dir, target string) error {
+	old := dir + ".old"
+	if err := os.Rename(target, old); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Rename(dir, target); err != nil {
+		os.Rename(old, target)
+		return err
+	}
+	return os.RemoveAll(old)
+}
+
+// checkRestoredDatabase makes sure the database extracted to path opens and is at the schema
+// version the manifest promised
+func checkRestoredDatabase(path string, schemaVersion int) error {
+	db, err := database.New(path)
+	if err != nil {
+		return fmt.Errorf("failed to open the archived database: %w", err)
+	}
+	defer db.Close()
+	version, err := database.SchemaVersion(db)
+	if err != nil {
+		return fmt.Errorf("failed to read the archived database: %w", err)
+	}
+	if version != schemaVersion {
+		return fmt.Errorf("the archived database is at schema version %d but the manifest says %d", version, schemaVersion)
+	}
+	return nil
+}
+
+// removeDatabaseFiles removes a SQLite database and its journal files
+func removeDatabaseFiles(path string) {
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		os.Remove(path + suffix)
+	}
+}
+
+// insideDir reports whether path is dir or lies below it
+func insideDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// readMasterKey reads the archived master key, to be installed as the master key file. It
+// returns nil if a configured MASTER_KEY takes precedence. A different existing key is only
+// replaced with force.
+func readMasterKey(cfg *config.Config, r io.Reader, force bool) ([]byte, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read master key: %w", err)
+	}
+	key, err := secrets.DecodeKey(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode archived master key: %w", err)
+	}
+	if cfg.MasterKey != "" {
+		log.Printf("MASTER_KEY is set, the archived master key was not installed")
+		return nil, nil
+	}
+	if existing, err := secrets.ReadKeyFile(cfg.MasterKeyFile); err == nil && !bytes.Equal(existing, key) && !force {
+		return nil, fmt.Errorf("%s holds a different master key, restore with -force to replace it", cfg.MasterKeyFile)
+	}
+	return key, nil
+}
+
+// checkRestoredMasterKey warns if the secrets of a restored database cannot be read with the
+// configured master key
+func checkRestoredMasterKey(cfg *config.Config, manifest *backupManifest) {
+	if manifest.MasterKeyID == "" {
+		return
+	}
+	_, keyID, err := loadMasterKey(cfg)
+	switch {
+	case err != nil:
+		log.Printf("⚠️  %v", err)
+	case keyID == "":
+		log.Printf("⚠️  No master key is configured, install master key %s as MASTER_KEY or at %s before starting the server", manifest.MasterKeyID, cfg.MasterKeyFile)
+	case keyID != manifest.MasterKeyID:
+		log.Printf("⚠️  The configured master key is %s but the secrets were encrypted with %s, configure that key before starting the server", keyID, manifest.MasterKeyID)
+	}
+}
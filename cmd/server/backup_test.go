@@ -0,0 +1,197 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"goth-deploy/internal/config"
+	"goth-deploy/internal/database"
+	"goth-deploy/internal/models"
+	"goth-deploy/internal/secrets"
+	"goth-deploy/internal/store"
+)
+
+// newTestPlatform sets up a platform in dir with a master key and one project whose active
+// release holds a file with the given content
+func newTestPlatform(t *testing.T, dir, content string) *config.Config {
+	t.Helper()
+	cfg := &config.Config{
+		DatabaseURL:    filepath.Join(dir, "app.db"),
+		DeploymentRoot: filepath.Join(dir, "deployments"),
+		MasterKeyFile:  filepath.Join(dir, "master.key"),
+	}
+
+	key, err := secrets.GenerateKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	if err := secrets.WriteKeyFile(cfg.MasterKeyFile, key); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+
+	db, err := database.New(cfg.DatabaseURL)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+	if err := database.Migrate(db); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	stores := store.NewSQL(db)
+	user := &models.User{GitHubID: 1, Username: "owner", AccessToken: "token"}
+	if err := stores.Users.Create(user); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	project := &models.Project{UserID: user.ID, Name: "app", GitHubRepoID: 7, RepoURL: "https://github.com/octo/app", Branch: "main", Subdomain: "app", Port: 8080}
+	if err := stores.Projects.Create(project); err != nil {
+		t.Fatalf("failed to create project: %v", err)
+	}
+	deployment := &models.Deployment{ProjectID: project.ID}
+	if err := stores.Deployments.Enqueue(deployment); err != nil {
+		t.Fatalf("failed to create deployment: %v", err)
+	}
+
+	releaseDir := filepath.Join(cfg.DeploymentRoot, "app", "releases", "1")
+	if err := os.MkdirAll(releaseDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(releaseDir, "app.txt"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join("releases", "1"), filepath.Join(cfg.DeploymentRoot, "app", "current")); err != nil {
+		t.Fatal(err)
+	}
+	if err := stores.Deployments.Activate(project.ID, deployment.ID, releaseDir, 8080); err != nil {
+		t.Fatalf("failed to activate deployment: %v", err)
+	}
+	return cfg
+}
+
+// backupTestPlatform writes a backup of cfg, including secrets, and returns the archive path
+func backupTestPlatform(t *testing.T, cfg *config.Config) string {
+	t.Helper()
+	archive := filepath.Join(t.TempDir(), "backup.tar.gz")
+	if err := backup(cfg, []string{"-o", archive, "-include-secrets"}); err != nil {
+		t.Fatalf("backup: %v", err)
+	}
+	return archive
+}
+
+// readTestFile returns the content of a file, failing the test if it cannot be read
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", path, err)
+	}
+	return string(data)
+}
+
+func TestBackupRestore(t *testing.T) {
+	source := newTestPlatform(t, t.TempDir(), "v1")
+	archive := backupTestPlatform(t, source)
+
+	dir := t.TempDir()
+	target := &config.Config{
+		DatabaseURL:    filepath.Join(dir, "data", "app.db"),
+		DeploymentRoot: filepath.Join(dir, "releases"),
+		MasterKeyFile:  filepath.Join(dir, "data", "master.key"),
+	}
+	settings := filepath.Join(dir, "restored.env")
+	if err := restore(target, []string{"-settings", settings, archive}); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+
+	if got := readTestFile(t, filepath.Join(target.DeploymentRoot, "app", "current", "app.txt")); got != "v1" {
+		t.Errorf("restored release file = %q, want v1", got)
+	}
+	if got, want := readTestFile(t, target.MasterKeyFile), readTestFile(t, source.MasterKeyFile); got != want {
+		t.Error("the restored master key differs from the archived one")
+	}
+	if _, err := os.Stat(settings); err != nil {
+		t.Errorf("settings were not restored: %v", err)
+	}
+
+	db, err := database.New(target.DatabaseURL)
+	if err != nil {
+		t.Fatalf("failed to open restored database: %v", err)
+	}
+	defer db.Close()
+	project, err := store.NewSQL(db).Projects.GetBySubdomain("app")
+	if err != nil {
+		t.Fatalf("restored project: %v", err)
+	}
+	deployment, err := store.NewSQL(db).Deployments.Get(*project.ActiveDeploymentID)
+	if err != nil {
+		t.Fatalf("restored deployment: %v", err)
+	}
+	if want := filepath.Join(target.DeploymentRoot, "app", "releases", "1"); deployment.ReleaseDir != want {
+		t.Errorf("release directory = %s, want %s", deployment.ReleaseDir, want)
+	}
+}
+
+func TestRestoreDamagedArchive(t *testing.T) {
+	source := newTestPlatform(t, t.TempDir(), "new")
+	archive := backupTestPlatform(t, source)
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An archive with a valid manifest but no database
+	var withoutDatabase bytes.Buffer
+	gz := gzip.NewWriter(&withoutDatabase)
+	tw := tar.NewWriter(gz)
+	manifest, _ := json.Marshal(backupManifest{Format: backupFormat, SchemaVersion: database.LatestVersion()})
+	if err := writeArchiveData(tw, manifestEntry, manifest); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+	gz.Close()
+
+	tests := []struct {
+		name    string
+		archive []byte
+	}{
+		{"truncated", data[:len(data)/2]},
+		{"without database", withoutDatabase.Bytes()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			target := newTestPlatform(t, dir, "old")
+			key := readTestFile(t, target.MasterKeyFile)
+			db := readTestFile(t, target.DatabaseURL)
+
+			path := filepath.Join(t.TempDir(), "damaged.tar.gz")
+			if err := os.WriteFile(path, tt.archive, 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := restore(target, []string{"-force", "-settings", filepath.Join(dir, "restored.env"), path}); err == nil {
+				t.Fatal("restore of a damaged archive succeeded")
+			}
+
+			if got := readTestFile(t, filepath.Join(target.DeploymentRoot, "app", "current", "app.txt")); got != "old" {
+				t.Errorf("release file = %q after a failed restore, want the existing old", got)
+			}
+			if readTestFile(t, target.MasterKeyFile) != key {
+				t.Error("the master key was replaced by a failed restore")
+			}
+			if readTestFile(t, target.DatabaseURL) != db {
+				t.Error("the database was replaced by a failed restore")
+			}
+			entries, _ := os.ReadDir(dir)
+			for _, entry := range entries {
+				if strings.Contains(entry.Name(), ".restore") {
+					t.Errorf("a failed restore left %s behind", entry.Name())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"goth-deploy/internal/config"
+	"goth-deploy/internal/database"
+	"goth-deploy/internal/secrets"
+)
+
+// runCommand runs a maintenance command given on the command line
+func runCommand(cfg *config.Config, name string, args []string) error {
+	switch name {
+	case "rotate-key":
+		return rotateKey(cfg, args)
+	case "migrate":
+		return migrate(cfg, args)
+	case "backup":
+		return backup(cfg, args)
+	case "restore":
+		return restore(cfg, args)
+	default:
+		return fmt.Errorf("unknown command %q, available commands: backup, migrate, restore, rotate-key", name)
+	}
+}
+
+// migrate shows, applies or reverts schema migrations
+func migrate(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate status|up|down [-to VERSION]")
+	}
+	flags := flag.NewFlagSet("migrate "+args[0], flag.ContinueOnError)
+	to := flags.Int("to", -1, "schema version to migrate to (default: latest for up, one version back for down)")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	db, err := database.New(cfg.DatabaseURL)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "status":
+		states, err := database.MigrationStatus(db)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, state := range states {
+			applied := "pending"
+			if state.AppliedAt != nil {
+				applied = state.AppliedAt.Local().Format(time.DateTime)
+			}
+			if state.Unknown {
+				applied += " (unknown to this binary)"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", state.Version, state.Name, applied)
+		}
+		return w.Flush()
+	case "up":
+		target := *to
+		if target < 0 {
+			target = database.LatestVersion()
+		}
+		applied, err := database.MigrateUp(db, target)
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			log.Printf("The schema is up to date")
+		}
+		return nil
+	case "down":
+		target := *to
+		if target < 0 {
+			states, err := database.MigrationStatus(db)
+			if err != nil {
+				return err
+			}
+			// One version back from the newest applied migration
+			current := 0
+			for _, state := range states {
+				if state.AppliedAt != nil {
+					target, current = current, state.Version
+				}
+			}
+			if current == 0 {
+				return fmt.Errorf("no migrations are applied")
+			}
+		}
+		reverted, err := database.MigrateDown(db, target)
+		if err != nil {
+			return err
+		}
+		if len(reverted) == 0 {
+			log.Printf("No migrations newer than version %d are applied", target)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q, available commands: status, up, down", args[0])
+	}
+}
+
+// rotateKey re-encrypts the data keys of all secrets with a new master key. The server must
+// not be running meanwhile.
+func rotateKey(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("rotate-key", flag.ContinueOnError)
+	newKeyFile := flags.String("new-key-file", "", "file holding the new master key (default: generate one)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := database.New(cfg.DatabaseURL)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+	if err := database.Migrate(db); err != nil {
+		return fmt.Errorf("failed to run migrations: %w", err)
+	}
+
+	keyring, err := secrets.Load(cfg.MasterKey, cfg.MasterKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load master key: %w", err)
+	}
+
+	var newKey []byte
+	if *newKeyFile != "" {
+		newKey, err = secrets.ReadKeyFile(*newKeyFile)
+	} else {
+		newKey, err = secrets.GenerateKey()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load new master key: %w", err)
+	}
+	rotated, err := keyring.WithPrimary(newKey)
+	if err != nil {
+		return err
+	}
+	if rotated.KeyID() == keyring.KeyID() {
+		return fmt.Errorf("the new master key is the current master key")
+	}
+
+	if cfg.MasterKey != "" {
+		rewrapped, err := database.RewrapSecrets(db, rotated)
+		if err != nil {
+			return fmt.Errorf("failed to re-encrypt secrets: %w", err)
+		}
+		log.Printf("Re-encrypted %d secrets with master key %s", rewrapped, rotated.KeyID())
+		if *newKeyFile != "" {
+			fmt.Printf("Set MASTER_KEY to the key in %s before starting the server\n", *newKeyFile)
+		} else {
+			fmt.Printf("Set MASTER_KEY to the new key before starting the server:\nMASTER_KEY=%s\n", secrets.EncodeKey(newKey))
+		}
+		return nil
+	}
+
+	// The current key is kept as <key file>.old until the secrets are re-encrypted, so they stay
+	// readable if the rotation is interrupted. Secrets left on an older key by an interrupted
+	// rotation are moved to the current key first, it is the only one kept.
+	if _, err := database.RewrapSecrets(db, keyring); err != nil {
+		return fmt.Errorf("failed to re-encrypt secrets: %w", err)
+	}
+	oldKeyFile := cfg.MasterKeyFile + ".old"
+	if err := os.Rename(cfg.MasterKeyFile, oldKeyFile); err != nil {
+		return fmt.Errorf("failed to keep the current master key: %w", err)
+	}
+	if err := secrets.WriteKeyFile(cfg.MasterKeyFile, newKey); err != nil {
+		os.Rename(oldKeyFile, cfg.MasterKeyFile)
+		return err
+	}
+
+	rewrapped, err := database.RewrapSecrets(db, rotated)
+	if err != nil {
+		os.Rename(oldKeyFile, cfg.MasterKeyFile)
+		return fmt.Errorf("failed to re-encrypt secrets: %w", err)
+	}
+
+	log.Printf("Re-encrypted %d secrets with master key %s, written to %s", rewrapped, rotated.KeyID(), cfg.MasterKeyFile)
+	log.Printf("The previous master key was kept at %s, delete it once no backups need it", oldKeyFile)
+	return nil
+}
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	"goth-deploy/internal/config"
 	"goth-deploy/internal/database"
 	"goth-deploy/internal/handlers"
+	"goth-deploy/internal/secrets"
+	"goth-deploy/internal/services"
 
 	"github.com/joho/godotenv"
 )
@@ -21,6 +28,14 @@ func main() {
 	// Initialize configuration
 	cfg := config.New()
 
+	// Maintenance commands run instead of the server
+	if len(os.Args) > 1 {
+		if err := runCommand(cfg, os.Args[1], os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// Initialize database
 	db, err := database.New(cfg.DatabaseURL)
 	if err != nil {
@@ -33,21 +48,74 @@ func main() {
 		log.Fatal("Failed to run migrations:", err)
 	}
 
+	// Load the master key and encrypt secrets still stored as plaintext
+	keyring, err := secrets.Load(cfg.MasterKey, cfg.MasterKeyFile)
+	if err != nil {
+		log.Fatal("Failed to load master key:", err)
+	}
+	if encrypted, err := database.EncryptSecrets(db, keyring); err != nil {
+		log.Fatal("Failed to encrypt secrets:", err)
+	} else if encrypted > 0 {
+		log.Printf("Encrypted %d plaintext secrets with master key %s", encrypted, keyring.KeyID())
+	}
+
 	// Initialize handlers
-	handler := handlers.New(db, cfg)
+	handler := handlers.New(db, cfg, keyring)
+
+	// Recover deployments interrupted by the last shutdown, bring deployed applications
+	// back up and start the deployment workers
+	if err := handler.Deployment.RecoverDeployments(); err != nil {
+		log.Fatal("Failed to recover deployments:", err)
+	}
+	if err := handler.Deployment.ReconcileApplications(); err != nil {
+		log.Fatal("Failed to reconcile applications:", err)
+	}
+
+	// Background work stops when the platform receives SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	handler.Deployment.StartWorkers(ctx)
+	handler.Deployment.StartHealthChecks(ctx)
+	services.NewBackupService(db, cfg).Start(ctx)
 
 	// Create a custom server that routes based on subdomains
-	server := &subdomainRouter{
-		mainHandler:  handler.Routes(),
-		proxyHandler: handler.Proxy,
-		baseDomain:   cfg.BaseDomain,
+	server := &http.Server{
+		Addr: ":" + cfg.Port,
+		Handler: &subdomainRouter{
+			mainHandler:  handler.Routes(),
+			proxyHandler: handler.Proxy,
+			baseDomain:   cfg.BaseDomain,
+		},
 	}
+	// Log streams never end on their own, close them so Shutdown can drain connections
+	server.RegisterOnShutdown(handler.Deployment.CloseLogStreams)
 
 	// Start server
-	log.Printf("Starting server on :%s", cfg.Port)
-	log.Printf("Main application: http://%s", cfg.BaseDomain)
-	log.Printf("Deployed apps: http://{subdomain}.%s", cfg.BaseDomain)
-	log.Fatal(http.ListenAndServe(":"+cfg.Port, server))
+	go func() {
+		log.Printf("Starting server on :%s", cfg.Port)
+		log.Printf("Main application: http://%s", cfg.BaseDomain)
+		log.Printf("Deployed apps: http://{subdomain}.%s", cfg.BaseDomain)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("Server failed:", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	log.Printf("Shutting down, waiting up to %v for requests and deployments to finish", cfg.ShutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	// Drain HTTP connections first, the deployed applications are still needed to answer
+	// in-flight proxy requests
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP server did not shut down cleanly: %v", err)
+	}
+	handler.Deployment.Shutdown(shutdownCtx)
+
+	log.Println("Shutdown complete")
 }
 
 // subdomainRouter routes requests based on subdomain
@@ -2,6 +2,9 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Config holds all configuration for the application
@@ -16,6 +19,18 @@ type Config struct {
 	BaseDomain          string
 	EnableHTTPS         bool
 	GitHubWebhookSecret string
+	DeployConcurrency   int
+	ReadinessTimeout    time.Duration
+	DrainTimeout        time.Duration
+	ReleaseRetention    int
+	ShutdownTimeout     time.Duration
+	StopTimeout         time.Duration
+	EnvPassthrough      []string
+	MasterKey           string
+	MasterKeyFile       string
+	BackupDir           string
+	BackupInterval      time.Duration
+	BackupRetention     int
 }
 
 // New creates a new configuration instance with values from environment variables
@@ -31,6 +46,18 @@ func New() *Config {
 		BaseDomain:          getEnv("BASE_DOMAIN", "localhost:8080"),
 		EnableHTTPS:         getEnv("ENABLE_HTTPS", "false") == "true",
 		GitHubWebhookSecret: getEnv("GITHUB_WEBHOOK_SECRET", ""),
+		DeployConcurrency:   getEnvInt("DEPLOY_CONCURRENCY", 2),
+		ReadinessTimeout:    getEnvDuration("READINESS_TIMEOUT", 60*time.Second),
+		DrainTimeout:        getEnvDuration("DRAIN_TIMEOUT", 10*time.Second),
+		ReleaseRetention:    getEnvInt("RELEASE_RETENTION", 3),
+		ShutdownTimeout:     getEnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
+		StopTimeout:         getEnvDuration("STOP_TIMEOUT", 10*time.Second),
+		EnvPassthrough:      getEnvList("ENV_PASSTHROUGH"),
+		MasterKey:           getEnv("MASTER_KEY", ""),
+		MasterKeyFile:       getEnv("MASTER_KEY_FILE", "./data/master.key"),
+		BackupDir:           getEnv("BACKUP_DIR", "./data/backups"),
+		BackupInterval:      getEnvDuration("BACKUP_INTERVAL", 24*time.Hour),
+		BackupRetention:     getEnvInt("BACKUP_RETENTION", 7),
 	}
 }
 
@@ -41,3 +68,77 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getEnvInt gets an integer environment variable with a fallback default value
+func getEnvInt(key string, defaultValue int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvDuration gets a duration environment variable (e.g. "30s") with a fallback default value
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvList gets a comma separated environment variable as a list, skipping empty entries
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// BaseURL returns the public URL of the main application
+func (c *Config) BaseURL() string {
+	scheme := "http"
+	if c.EnableHTTPS {
+		scheme = "https"
+	}
+	return scheme + "://" + c.BaseDomain
+}
+
+// WebhookURL returns the public URL that GitHub webhook deliveries are sent to
+func (c *Config) WebhookURL() string {
+	return c.BaseURL() + "/webhooks/github"
+}
+
+// Settings returns the settings that carry over to another host as KEY=value lines, in the
+// format of the .env file. Paths are left out, they belong to the host. Secrets are only
+// included if includeSecrets is set.
+func (c *Config) Settings(includeSecrets bool) []string {
+	settings := []string{
+		"PORT=" + c.Port,
+		"BASE_DOMAIN=" + c.BaseDomain,
+		"ENABLE_HTTPS=" + strconv.FormatBool(c.EnableHTTPS),
+		"GITHUB_CLIENT_ID=" + c.GitHubClientID,
+		"GITHUB_REDIRECT_URL=" + c.GitHubRedirectURL,
+		"DEPLOY_CONCURRENCY=" + strconv.Itoa(c.DeployConcurrency),
+		"READINESS_TIMEOUT=" + c.ReadinessTimeout.String(),
+		"DRAIN_TIMEOUT=" + c.DrainTimeout.String(),
+		"RELEASE_RETENTION=" + strconv.Itoa(c.ReleaseRetention),
+		"SHUTDOWN_TIMEOUT=" + c.ShutdownTimeout.String(),
+		"STOP_TIMEOUT=" + c.StopTimeout.String(),
+		"ENV_PASSTHROUGH=" + strings.Join(c.EnvPassthrough, ","),
+		"BACKUP_INTERVAL=" + c.BackupInterval.String(),
+		"BACKUP_RETENTION=" + strconv.Itoa(c.BackupRetention),
+	}
+	if includeSecrets {
+		settings = append(settings,
+			"SESSION_SECRET="+c.SessionSecret,
+			"GITHUB_CLIENT_SECRET="+c.GitHubClientSecret,
+			"GITHUB_WEBHOOK_SECRET="+c.GitHubWebhookSecret,
+		)
+		if c.MasterKey != "" {
+			settings = append(settings, "MASTER_KEY="+c.MasterKey)
+		}
+	}
+	return settings
+}
@@ -0,0 +1,76 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Backup writes a consistent copy of a SQLite database to path while it stays in use. The
+// copy is written next to path and renamed into place, so path never holds a partial backup.
+func Backup(db *DB, path string) error {
+	if db.Dialect != SQLite {
+		return fmt.Errorf("online backups are only supported for SQLite, back up PostgreSQL with pg_dump")
+	}
+	if err := ensureDir(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	if _, err := db.Exec("VACUUM INTO ?", tmp); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to back up database: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to back up database: %w", err)
+	}
+	return nil
+}
+
+// RebaseReleaseDirs moves the release directories recorded for deployments from oldRoot to
+// newRoot, after the deployment root was restored on another host or path, and returns how many
+// were changed. Directories outside oldRoot are left alone.
+func RebaseReleaseDirs(db *DB, oldRoot, newRoot string) (int, error) {
+	rows, err := db.Query("SELECT id, release_dir FROM deployments WHERE release_dir IS NOT NULL AND release_dir != ''")
+	if err != nil {
+		return 0, fmt.Errorf("failed to query release directories: %w", err)
+	}
+	moved := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var dir string
+		if err := rows.Scan(&id, &dir); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan release directory: %w", err)
+		}
+		rel, err := filepath.Rel(oldRoot, dir)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if rebased := filepath.Join(newRoot, rel); rebased != dir {
+			moved[id] = rebased
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to query release directories: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	for id, dir := range moved {
+		if _, err := tx.Exec("UPDATE deployments SET release_dir = ? WHERE id = ?", dir, id); err != nil {
+			return 0, fmt.Errorf("failed to update release directory: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit release directories: %w", err)
+	}
+	return len(moved), nil
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -17,46 +19,82 @@ func ensureDir(dir string) error {
 	return os.MkdirAll(dir, 0755)
 }
 
-// New creates a new database connection
-func New(databaseURL string) (*sql.DB, error) {
+// sqlitePragmas are set on every SQLite connection. WAL lets readers run alongside the writer,
+// the busy timeout makes a writer wait for the lock instead of failing with "database is
+// locked", and immediate transactions take the write lock up front so two transactions never
+// deadlock upgrading their read locks. Foreign keys make the declared ON DELETE actions apply.
+const sqlitePragmas = "_journal_mode=WAL&_busy_timeout=5000&_foreign_keys=on&_synchronous=NORMAL&_txlock=immediate"
+
+// Connection pool settings. SQLite serializes writers anyway, a few connections are enough for
+// concurrent readers; PostgreSQL connections are kept below the usual server limit.
+const (
+	sqliteMaxOpenConns   = 8
+	postgresMaxOpenConns = 20
+	maxIdleConns         = 4
+	connMaxIdleTime      = 5 * time.Minute
+	connMaxLifetime      = time.Hour
+)
+
+// New creates a new database connection. DATABASE_URL selects the dialect, postgres:// and
+// postgresql:// URLs connect to PostgreSQL and anything else is the path of a SQLite database,
+// optionally prefixed with sqlite://.
+func New(databaseURL string) (*DB, error) {
+	if strings.HasPrefix(databaseURL, "postgres://") || strings.HasPrefix(databaseURL, "postgresql://") {
+		if postgresDriver == "" {
+			return nil, fmt.Errorf("PostgreSQL support is not built in, build with -tags postgres")
+		}
+		db, err := sql.Open(postgresDriver, databaseURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open database: %w", err)
+		}
+		configurePool(db, postgresMaxOpenConns)
+		if err := db.Ping(); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to ping database: %w", err)
+		}
+		return &DB{DB: db, Dialect: Postgres}, nil
+	}
+
+	path := strings.TrimPrefix(databaseURL, "sqlite://")
+
 	// Ensure the directory exists
-	dir := filepath.Dir(databaseURL)
+	dir := filepath.Dir(path)
 	if err := ensureDir(dir); err != nil {
 		return nil, fmt.Errorf("failed to create database directory: %w", err)
 	}
 
-	db, err := sql.Open("sqlite3", databaseURL)
+	dsn := path + "?" + sqlitePragmas
+	if strings.Contains(path, "?") {
+		dsn = path + "&" + sqlitePragmas
+	}
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
+	configurePool(db, sqliteMaxOpenConns)
 
 	// Test the connection
 	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	return db, nil
+	return &DB{DB: db, Dialect: SQLite}, nil
 }
 
-// Migrate runs database migrations
-func Migrate(db *sql.DB) error {
-	migrations := []string{
-		createUsersTable,
-		createProjectsTable,
-		createDeploymentsTable,
-		createEnvironmentVariablesTable,
-		createIndexes,
-	}
-
-	for _, migration := range migrations {
-		if _, err := db.Exec(migration); err != nil {
-			return fmt.Errorf("failed to run migration: %w", err)
-		}
-	}
-
-	return nil
+// configurePool limits the open connections of a database and closes idle ones
+func configurePool(db *sql.DB, maxOpen int) {
+	db.SetMaxOpenConns(maxOpen)
+	db.SetMaxIdleConns(maxIdleConns)
+	db.SetConnMaxIdleTime(connMaxIdleTime)
+	db.SetConnMaxLifetime(connMaxLifetime)
 }
 
+// The tables of the initial schema, migration 1, and of the migrations that add tables. Each
+// holds the table as its migration created it, later columns are added by later migrations.
+// Tables are created IF NOT EXISTS so databases created before migrations were versioned can
+// adopt them, see adoptLegacySchema.
+
 const createUsersTable = `
 CREATE TABLE IF NOT EXISTS users (
 	id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -121,3 +159,112 @@ CREATE INDEX IF NOT EXISTS idx_environment_variables_project_id ON environment_v
 CREATE INDEX IF NOT EXISTS idx_projects_subdomain ON projects(subdomain);
 CREATE INDEX IF NOT EXISTS idx_deployments_status ON deployments(status);
 `
+
+const createWebhookDeliveriesTable = `
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	delivery_id TEXT PRIMARY KEY,
+	event TEXT NOT NULL,
+	received_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_received_at ON webhook_deliveries(received_at);`
+
+const createDeploymentJobsTable = `
+CREATE TABLE IF NOT EXISTS deployment_jobs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	deployment_id INTEGER UNIQUE NOT NULL,
+	project_id INTEGER NOT NULL,
+	status TEXT NOT NULL DEFAULT 'queued',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	started_at DATETIME,
+	finished_at DATETIME,
+	FOREIGN KEY (deployment_id) REFERENCES deployments (id) ON DELETE CASCADE,
+	FOREIGN KEY (project_id) REFERENCES projects (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_deployment_jobs_status ON deployment_jobs(status, project_id);`
+
+const createProcessExitsTable = `
+CREATE TABLE IF NOT EXISTS process_exits (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	project_id INTEGER NOT NULL,
+	deployment_id INTEGER,
+	exit_code INTEGER NOT NULL,
+	signal TEXT NOT NULL DEFAULT '',
+	crashed BOOLEAN NOT NULL DEFAULT 0,
+	exited_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (project_id) REFERENCES projects (id) ON DELETE CASCADE,
+	FOREIGN KEY (deployment_id) REFERENCES deployments (id) ON DELETE SET NULL
+);
+CREATE INDEX IF NOT EXISTS idx_process_exits_project_id ON process_exits(project_id, exited_at);`
+
+const createRuntimeLogsTable = `
+CREATE TABLE IF NOT EXISTS runtime_logs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	project_id INTEGER NOT NULL,
+	deployment_id INTEGER,
+	instance TEXT NOT NULL,
+	stream TEXT NOT NULL,
+	level TEXT NOT NULL DEFAULT '',
+	text TEXT NOT NULL,
+	fields TEXT,
+	logged_at DATETIME NOT NULL,
+	FOREIGN KEY (project_id) REFERENCES projects (id) ON DELETE CASCADE,
+	FOREIGN KEY (deployment_id) REFERENCES deployments (id) ON DELETE SET NULL
+);
+CREATE INDEX IF NOT EXISTS idx_runtime_logs_project_id ON runtime_logs(project_id, logged_at);
+CREATE INDEX IF NOT EXISTS idx_runtime_logs_level ON runtime_logs(project_id, level, logged_at);
+CREATE INDEX IF NOT EXISTS idx_runtime_logs_deployment_id ON runtime_logs(deployment_id);`
+
+const createOrganizationsTable = `
+CREATE TABLE IF NOT EXISTS organizations (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	slug TEXT UNIQUE NOT NULL,
+	github_org TEXT NOT NULL DEFAULT '',
+	github_sync_role TEXT NOT NULL DEFAULT 'viewer',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);`
+
+const createOrganizationMembersTable = `
+CREATE TABLE IF NOT EXISTS organization_members (
+	organization_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	role TEXT NOT NULL,
+	source TEXT NOT NULL DEFAULT 'manual',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (organization_id, user_id),
+	FOREIGN KEY (organization_id) REFERENCES organizations (id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_organization_members_user_id ON organization_members(user_id);`
+
+const createOrganizationInvitesTable = `
+CREATE TABLE IF NOT EXISTS organization_invites (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	organization_id INTEGER NOT NULL,
+	github_username TEXT NOT NULL COLLATE NOCASE,
+	role TEXT NOT NULL,
+	source TEXT NOT NULL DEFAULT 'manual',
+	invited_by INTEGER,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (organization_id) REFERENCES organizations (id) ON DELETE CASCADE,
+	FOREIGN KEY (invited_by) REFERENCES users (id) ON DELETE SET NULL,
+	UNIQUE(organization_id, github_username)
+);
+CREATE INDEX IF NOT EXISTS idx_organization_invites_username ON organization_invites(github_username);`
+
+const createAPITokensTable = `
+CREATE TABLE IF NOT EXISTS api_tokens (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	token_hash TEXT UNIQUE NOT NULL,
+	prefix TEXT NOT NULL,
+	scopes TEXT NOT NULL,
+	expires_at DATETIME NOT NULL,
+	last_used_at DATETIME,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens(user_id);`
@@ -0,0 +1,99 @@
+// Package dbtest opens databases for tests, once for each SQL dialect.
+//
+// SQLite databases are created in a temporary directory. PostgreSQL tests run against the
+// server named by the URL in GOTH_TEST_POSTGRES_URL, each in a schema of its own that is
+// dropped afterwards. They are skipped when the variable is not set or the binary was built
+// without the postgres tag.
+package dbtest
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"goth-deploy/internal/database"
+)
+
+// PostgresURLEnv names the environment variable with the URL of the PostgreSQL test server
+const PostgresURLEnv = "GOTH_TEST_POSTGRES_URL"
+
+// Dialects are the dialects tests run against
+var Dialects = []database.Dialect{database.SQLite, database.Postgres}
+
+// Each runs fn as a subtest for every dialect, each with a migrated database of its own
+func Each(t *testing.T, fn func(t *testing.T, db *database.DB)) {
+	EachEmpty(t, func(t *testing.T, db *database.DB) {
+		if err := database.Migrate(db); err != nil {
+			t.Fatalf("failed to migrate test database: %v", err)
+		}
+		fn(t, db)
+	})
+}
+
+// EachEmpty runs fn as a subtest for every dialect, each with an empty database of its own
+func EachEmpty(t *testing.T, fn func(t *testing.T, db *database.DB)) {
+	t.Helper()
+	for _, dialect := range Dialects {
+		t.Run(string(dialect), func(t *testing.T) {
+			fn(t, Open(t, dialect))
+		})
+	}
+}
+
+// Open returns an empty database of a dialect that is closed and removed when the test ends
+func Open(t *testing.T, dialect database.Dialect) *database.DB {
+	t.Helper()
+	if dialect == database.Postgres {
+		return openPostgres(t)
+	}
+
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// openPostgres creates a schema on the test server and returns a database using it
+func openPostgres(t *testing.T) *database.DB {
+	t.Helper()
+	url := os.Getenv(PostgresURLEnv)
+	if url == "" {
+		t.Skipf("set %s to test against PostgreSQL", PostgresURLEnv)
+	}
+	if !postgresBuilt {
+		t.Skip("PostgreSQL support is not built in, test with -tags postgres")
+	}
+
+	admin, err := database.New(url)
+	if err != nil {
+		t.Fatalf("failed to connect to PostgreSQL: %v", err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := fmt.Sprintf("test_%d_%d", time.Now().UnixNano(), rand.Intn(1000000))
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatalf("failed to create test schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Errorf("failed to drop test schema: %v", err)
+		}
+	})
+
+	separator := "?"
+	if strings.Contains(url, "?") {
+		separator = "&"
+	}
+	db, err := database.New(url + separator + "search_path=" + schema)
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
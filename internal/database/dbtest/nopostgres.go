@@ -0,0 +1,5 @@
+//go:build !postgres
+
+package dbtest
+
+const postgresBuilt = false
@@ -0,0 +1,185 @@
+package database
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// Dialect is the SQL dialect of a database
+type Dialect string
+
+const (
+	SQLite   Dialect = "sqlite"
+	Postgres Dialect = "postgres"
+)
+
+// postgresDriver is the database/sql driver used for PostgreSQL, registered by builds with the
+// postgres tag
+var postgresDriver string
+
+// DB is a database connection. Queries are written with ? placeholders, which it rewrites for
+// its dialect.
+type DB struct {
+	*sql.DB
+	Dialect Dialect
+}
+
+// Tx is a transaction of a DB
+type Tx struct {
+	*sql.Tx
+	dialect Dialect
+}
+
+// Exec executes a query without returning rows
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.Exec(db.Dialect.rebind(query), args...)
+}
+
+// Query executes a query that returns rows
+func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.Query(db.Dialect.rebind(query), args...)
+}
+
+// QueryRow executes a query that returns at most one row
+func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRow(db.Dialect.rebind(query), args...)
+}
+
+// Prepare creates a prepared statement
+func (db *DB) Prepare(query string) (*sql.Stmt, error) {
+	return db.DB.Prepare(db.Dialect.rebind(query))
+}
+
+// Begin starts a transaction
+func (db *DB) Begin() (*Tx, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx, dialect: db.Dialect}, nil
+}
+
+// Exec executes a query without returning rows
+func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.Exec(tx.dialect.rebind(query), args...)
+}
+
+// Query executes a query that returns rows
+func (tx *Tx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.dialect.rebind(query), args...)
+}
+
+// QueryRow executes a query that returns at most one row
+func (tx *Tx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRow(tx.dialect.rebind(query), args...)
+}
+
+// Prepare creates a prepared statement used within the transaction
+func (tx *Tx) Prepare(query string) (*sql.Stmt, error) {
+	return tx.Tx.Prepare(tx.dialect.rebind(query))
+}
+
+// rebind rewrites the ? placeholders of a query to the numbered $1, $2... of PostgreSQL.
+// Question marks in quoted strings and identifiers are left alone.
+func (d Dialect) rebind(query string) string {
+	if d != Postgres || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			// A doubled quote inside a string toggles out and back in, which is harmless
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '?':
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// postgresSchema translates the column types of SQLite schema statements to PostgreSQL. The
+// order matters, the first match at a position wins.
+var postgresSchema = strings.NewReplacer(
+	"INTEGER PRIMARY KEY AUTOINCREMENT", "BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY",
+	"INTEGER", "BIGINT",
+	"DATETIME", "TIMESTAMPTZ",
+	"BOOLEAN NOT NULL DEFAULT 0", "BOOLEAN NOT NULL DEFAULT FALSE",
+)
+
+// schema translates schema statements, written for SQLite, to the dialect. COLLATE NOCASE
+// needs no translation, PostgreSQL databases get a case-insensitive collation of that name.
+func (d Dialect) schema(statements string) string {
+	if d == Postgres {
+		return postgresSchema.Replace(statements)
+	}
+	return statements
+}
+
+// setup holds the statements run before migrations to prepare the database for the schema
+func (d Dialect) setup() string {
+	if d == Postgres {
+		return "CREATE COLLATION IF NOT EXISTS nocase (provider = icu, locale = 'und-u-ks-level2', deterministic = false)"
+	}
+	return ""
+}
+
+// JSONValue returns an expression for the value at a dotted path of a JSON text column, as
+// text, with the arguments it takes. Booleans read as 1 and 0 in both dialects, as SQLite's
+// json_extract reports them.
+func (db *DB) JSONValue(column, path string) (string, []interface{}) {
+	if db.Dialect == Postgres {
+		keys := strings.Split(path, ".")
+		value := "(" + column + "::jsonb #> ?)"
+		return "(CASE " + value + " WHEN 'true'::jsonb THEN '1' WHEN 'false'::jsonb THEN '0' ELSE " + column + "::jsonb #>> ? END)", []interface{}{keys, keys}
+	}
+	return "CAST(json_extract(" + column + ", ?) AS TEXT)", []interface{}{"$." + path}
+}
+
+// tableExists reports whether a table exists
+func (db *DB) tableExists(table string) (bool, error) {
+	query := "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?"
+	if db.Dialect == Postgres {
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?"
+	}
+	var count int
+	if err := db.QueryRow(query, table).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// columnExists reports whether a table has a column
+func (db *DB) columnExists(table, column string) (bool, error) {
+	query := "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?"
+	if db.Dialect == Postgres {
+		query = "SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?"
+	}
+	var count int
+	if err := db.QueryRow(query, table, column).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// schemaHas reports whether a table, or a column given as table.column, exists
+func (db *DB) schemaHas(name string) (bool, error) {
+	if table, column, ok := strings.Cut(name, "."); ok {
+		return db.columnExists(table, column)
+	}
+	return db.tableExists(name)
+}
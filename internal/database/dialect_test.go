@@ -0,0 +1,31 @@
+package database
+
+import "testing"
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT 1", "SELECT 1"},
+		{"SELECT * FROM t WHERE a = ? AND b = ?", "SELECT * FROM t WHERE a = $1 AND b = $2"},
+		{"SELECT '?' FROM t WHERE a = ?", "SELECT '?' FROM t WHERE a = $1"},
+		{`SELECT "a?" FROM t WHERE a = ? AND b = 'it''s ?'`, `SELECT "a?" FROM t WHERE a = $1 AND b = 'it''s ?'`},
+	}
+	for _, test := range tests {
+		if got := Postgres.rebind(test.query); got != test.want {
+			t.Errorf("rebind(%q) = %q, want %q", test.query, got, test.want)
+		}
+		if got := SQLite.rebind(test.query); got != test.query {
+			t.Errorf("SQLite rebind(%q) = %q, want it unchanged", test.query, got)
+		}
+	}
+}
+
+func TestPostgresSchema(t *testing.T) {
+	got := Postgres.schema("id INTEGER PRIMARY KEY AUTOINCREMENT, n INTEGER, at DATETIME, ok BOOLEAN NOT NULL DEFAULT 0")
+	want := "id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY, n BIGINT, at TIMESTAMPTZ, ok BOOLEAN NOT NULL DEFAULT FALSE"
+	if got != want {
+		t.Errorf("schema = %q, want %q", got, want)
+	}
+}
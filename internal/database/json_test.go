@@ -0,0 +1,40 @@
+package database_test
+
+import (
+	"database/sql"
+	"testing"
+
+	"goth-deploy/internal/database"
+	"goth-deploy/internal/database/dbtest"
+)
+
+func TestJSONValue(t *testing.T) {
+	const document = `{"http": {"status": 500, "path": "/api"}, "ok": true, "cached": false, "msg": "hi"}`
+	tests := []struct {
+		path  string
+		want  string
+		found bool
+	}{
+		{"http.status", "500", true},
+		{"http.path", "/api", true},
+		{"ok", "1", true},
+		{"cached", "0", true},
+		{"msg", "hi", true},
+		{"missing", "", false},
+		{"msg.nested", "", false},
+	}
+
+	dbtest.EachEmpty(t, func(t *testing.T, db *database.DB) {
+		for _, test := range tests {
+			expr, args := db.JSONValue("fields", test.path)
+			var value sql.NullString
+			err := db.QueryRow("SELECT "+expr+" FROM (SELECT CAST(? AS TEXT) AS fields) t", append(args, document)...).Scan(&value)
+			if err != nil {
+				t.Fatalf("JSONValue(%q): %v", test.path, err)
+			}
+			if value.Valid != test.found || value.String != test.want {
+				t.Errorf("JSONValue(%q) = %q (found %v), want %q (found %v)", test.path, value.String, value.Valid, test.want, test.found)
+			}
+		}
+	})
+}
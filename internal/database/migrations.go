@@ -0,0 +1,446 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Migration is a numbered schema change. Up and Down hold SQL statements run in a single
+// transaction, so a migration is applied or reverted completely or not at all. They are
+// written for SQLite and translated for PostgreSQL, see Dialect.schema.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+	// Marker is the table, or table.column, created last by Up. A database that has it without
+	// a history entry made the change before migrations were versioned, see adoptLegacySchema.
+	Marker string
+}
+
+// MigrationState is a migration and when it was applied, nil while it is pending
+type MigrationState struct {
+	Migration
+	AppliedAt *time.Time
+	// Unknown is set for migrations applied by a newer binary
+	Unknown bool
+}
+
+// ErrSchemaTooNew is returned when the database was migrated by a newer binary
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary")
+
+// migrations lists every schema change in order. Versions are never reused and applied
+// migrations are never edited, changes to the schema go into a new migration.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial schema",
+		Up: strings.Join([]string{
+			createUsersTable,
+			createProjectsTable,
+			createDeploymentsTable,
+			createEnvironmentVariablesTable,
+			createIndexes,
+		}, "\n"),
+		Down: `
+DROP TABLE IF EXISTS environment_variables;
+DROP TABLE IF EXISTS deployments;
+DROP TABLE IF EXISTS projects;
+DROP TABLE IF EXISTS users;
+`,
+		Marker: "users",
+	},
+	{
+		Version: 2,
+		Name:    "webhook deliveries",
+		Up:      createWebhookDeliveriesTable,
+		Down:    "DROP TABLE IF EXISTS webhook_deliveries;",
+		Marker:  "webhook_deliveries",
+	},
+	{
+		Version: 3,
+		Name:    "project webhooks",
+		Up:      "ALTER TABLE projects ADD COLUMN webhook_id INTEGER;",
+		Down:    "ALTER TABLE projects DROP COLUMN webhook_id;",
+		Marker:  "projects.webhook_id",
+	},
+	{
+		Version: 4,
+		Name:    "deployment jobs",
+		Up:      createDeploymentJobsTable,
+		Down:    "DROP TABLE IF EXISTS deployment_jobs;",
+		Marker:  "deployment_jobs",
+	},
+	{
+		Version: 5,
+		Name:    "releases",
+		Up: `
+ALTER TABLE projects ADD COLUMN active_deployment_id INTEGER;
+ALTER TABLE deployments ADD COLUMN release_dir TEXT;
+ALTER TABLE deployments ADD COLUMN port INTEGER;
+`,
+		Down: `
+ALTER TABLE deployments DROP COLUMN port;
+ALTER TABLE deployments DROP COLUMN release_dir;
+ALTER TABLE projects DROP COLUMN active_deployment_id;
+`,
+		Marker: "deployments.port",
+	},
+	{
+		Version: 6,
+		Name:    "rollbacks",
+		Up: `
+ALTER TABLE deployments ADD COLUMN rollback_of INTEGER REFERENCES deployments(id) ON DELETE SET NULL;
+ALTER TABLE deployments ADD COLUMN env_snapshot TEXT;
+`,
+		Down: `
+ALTER TABLE deployments DROP COLUMN env_snapshot;
+ALTER TABLE deployments DROP COLUMN rollback_of;
+`,
+		Marker: "deployments.env_snapshot",
+	},
+	{
+		Version: 7,
+		Name:    "health checks",
+		Up: `
+ALTER TABLE projects ADD COLUMN health_check_path TEXT NOT NULL DEFAULT '';
+ALTER TABLE projects ADD COLUMN health_check_status INTEGER NOT NULL DEFAULT 200;
+ALTER TABLE projects ADD COLUMN health_check_interval INTEGER NOT NULL DEFAULT 30;
+ALTER TABLE projects ADD COLUMN health_check_timeout INTEGER NOT NULL DEFAULT 5;
+ALTER TABLE projects ADD COLUMN health_check_threshold INTEGER NOT NULL DEFAULT 3;
+ALTER TABLE projects ADD COLUMN health_check_restart BOOLEAN NOT NULL DEFAULT 0;
+`,
+		Down: `
+ALTER TABLE projects DROP COLUMN health_check_restart;
+ALTER TABLE projects DROP COLUMN health_check_threshold;
+ALTER TABLE projects DROP COLUMN health_check_timeout;
+ALTER TABLE projects DROP COLUMN health_check_interval;
+ALTER TABLE projects DROP COLUMN health_check_status;
+ALTER TABLE projects DROP COLUMN health_check_path;
+`,
+		Marker: "projects.health_check_restart",
+	},
+	{
+		Version: 8,
+		Name:    "restart policies",
+		Up: createProcessExitsTable + `
+ALTER TABLE projects ADD COLUMN restart_policy TEXT NOT NULL DEFAULT 'on-failure';
+ALTER TABLE projects ADD COLUMN restart_max INTEGER NOT NULL DEFAULT 5;
+ALTER TABLE projects ADD COLUMN restart_window INTEGER NOT NULL DEFAULT 300;
+`,
+		Down: `
+ALTER TABLE projects DROP COLUMN restart_window;
+ALTER TABLE projects DROP COLUMN restart_max;
+ALTER TABLE projects DROP COLUMN restart_policy;
+DROP TABLE IF EXISTS process_exits;
+`,
+		Marker: "projects.restart_window",
+	},
+	{
+		Version: 9,
+		Name:    "stop timeouts",
+		Up: `
+ALTER TABLE projects ADD COLUMN stop_timeout INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE process_exits ADD COLUMN reason TEXT NOT NULL DEFAULT '';
+`,
+		Down: `
+ALTER TABLE process_exits DROP COLUMN reason;
+ALTER TABLE projects DROP COLUMN stop_timeout;
+`,
+		Marker: "process_exits.reason",
+	},
+	{
+		Version: 10,
+		Name:    "log retention",
+		Up: `
+ALTER TABLE projects ADD COLUMN log_max_size INTEGER NOT NULL DEFAULT 10;
+ALTER TABLE projects ADD COLUMN log_max_files INTEGER NOT NULL DEFAULT 5;
+ALTER TABLE projects ADD COLUMN log_max_age INTEGER NOT NULL DEFAULT 7;
+`,
+		Down: `
+ALTER TABLE projects DROP COLUMN log_max_age;
+ALTER TABLE projects DROP COLUMN log_max_files;
+ALTER TABLE projects DROP COLUMN log_max_size;
+`,
+		Marker: "projects.log_max_age",
+	},
+	{
+		Version: 11,
+		Name:    "runtime logs",
+		Up:      createRuntimeLogsTable,
+		Down:    "DROP TABLE IF EXISTS runtime_logs;",
+		Marker:  "runtime_logs",
+	},
+	{
+		Version: 12,
+		Name:    "environment variable scopes",
+		Up:      "ALTER TABLE environment_variables ADD COLUMN scope TEXT NOT NULL DEFAULT 'both';",
+		Down:    "ALTER TABLE environment_variables DROP COLUMN scope;",
+		Marker:  "environment_variables.scope",
+	},
+	{
+		Version: 13,
+		Name:    "organizations",
+		Up: strings.Join([]string{
+			createOrganizationsTable,
+			createOrganizationMembersTable,
+			createOrganizationInvitesTable,
+			"ALTER TABLE projects ADD COLUMN organization_id INTEGER REFERENCES organizations(id) ON DELETE RESTRICT;",
+		}, "\n"),
+		Down: `
+ALTER TABLE projects DROP COLUMN organization_id;
+DROP TABLE IF EXISTS organization_invites;
+DROP TABLE IF EXISTS organization_members;
+DROP TABLE IF EXISTS organizations;
+`,
+		Marker: "projects.organization_id",
+	},
+	{
+		Version: 14,
+		Name:    "api tokens",
+		Up:      createAPITokensTable,
+		Down:    "DROP TABLE IF EXISTS api_tokens;",
+		Marker:  "api_tokens",
+	},
+}
+
+const createSchemaMigrationsTable = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);`
+
+// LatestVersion returns the schema version this binary migrates to
+func LatestVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// Migrate applies all pending migrations. It refuses to run against a schema migrated by a
+// newer binary.
+func Migrate(db *DB) error {
+	_, err := MigrateUp(db, LatestVersion())
+	return err
+}
+
+// MigrateUp applies the pending migrations up to and including version target and returns
+// them
+func MigrateUp(db *DB, target int) ([]Migration, error) {
+	if target < 0 || target > LatestVersion() {
+		return nil, fmt.Errorf("unknown schema version %d, the latest is %d", target, LatestVersion())
+	}
+	applied, err := prepareMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, migration := range migrations {
+		if migration.Version > target || applied[migration.Version] {
+			continue
+		}
+		err := inTransaction(db, func(tx *Tx) error {
+			if _, err := tx.Exec(db.Dialect.schema(migration.Up)); err != nil {
+				return err
+			}
+			_, err := tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", migration.Version, migration.Name)
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("failed to apply migration %d (%s): %w", migration.Version, migration.Name, err)
+		}
+		log.Printf("Applied migration %d (%s)", migration.Version, migration.Name)
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// MigrateDown reverts the applied migrations newer than version target, newest first, and
+// returns them. Target 0 reverts every migration.
+func MigrateDown(db *DB, target int) ([]Migration, error) {
+	if target < 0 || target > LatestVersion() {
+		return nil, fmt.Errorf("unknown schema version %d, the latest is %d", target, LatestVersion())
+	}
+	applied, err := prepareMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(migrations) - 1; i >= 0; i-- {
+		migration := migrations[i]
+		if migration.Version <= target || !applied[migration.Version] {
+			continue
+		}
+		err := inTransaction(db, func(tx *Tx) error {
+			if _, err := tx.Exec(db.Dialect.schema(migration.Down)); err != nil {
+				return err
+			}
+			_, err := tx.Exec("DELETE FROM schema_migrations WHERE version = ?", migration.Version)
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("failed to revert migration %d (%s): %w", migration.Version, migration.Name, err)
+		}
+		log.Printf("Reverted migration %d (%s)", migration.Version, migration.Name)
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// SchemaVersion returns the newest migration applied to the database, 0 if there is none
+func SchemaVersion(db *DB) (int, error) {
+	states, err := MigrationStatus(db)
+	if err != nil {
+		return 0, err
+	}
+	version := 0
+	for _, state := range states {
+		if state.AppliedAt != nil {
+			version = max(version, state.Version)
+		}
+	}
+	return version, nil
+}
+
+// MigrationStatus returns every known migration with when it was applied, followed by the
+// migrations applied by a newer binary
+func MigrationStatus(db *DB) ([]MigrationState, error) {
+	if _, err := prepareMigrations(db); err != nil && !errors.Is(err, ErrSchemaTooNew) {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT version, name, applied_at FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query schema migrations: %w", err)
+	}
+	defer rows.Close()
+
+	appliedAt := map[int]time.Time{}
+	var unknown []MigrationState
+	for rows.Next() {
+		var state MigrationState
+		var at time.Time
+		if err := rows.Scan(&state.Version, &state.Name, &at); err != nil {
+			return nil, fmt.Errorf("failed to scan schema migration: %w", err)
+		}
+		appliedAt[state.Version] = at
+		if state.Version > LatestVersion() {
+			state.AppliedAt = &at
+			state.Unknown = true
+			unknown = append(unknown, state)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query schema migrations: %w", err)
+	}
+
+	states := make([]MigrationState, 0, len(migrations)+len(unknown))
+	for _, migration := range migrations {
+		state := MigrationState{Migration: migration}
+		if at, ok := appliedAt[migration.Version]; ok {
+			state.AppliedAt = &at
+		}
+		states = append(states, state)
+	}
+	return append(states, unknown...), nil
+}
+
+// prepareMigrations creates the migration history, adopts a legacy schema and returns the
+// applied versions. It fails with ErrSchemaTooNew when a newer binary migrated the database.
+func prepareMigrations(db *DB) (map[int]bool, error) {
+	if setup := db.Dialect.setup(); setup != "" {
+		if _, err := db.Exec(setup); err != nil {
+			return nil, fmt.Errorf("failed to prepare database: %w", err)
+		}
+	}
+	if _, err := db.Exec(db.Dialect.schema(createSchemaMigrationsTable)); err != nil {
+		return nil, fmt.Errorf("failed to create schema migrations table: %w", err)
+	}
+
+	rows, err := db.Query("SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query schema migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := map[int]bool{}
+	newest := 0
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, fmt.Errorf("failed to scan schema migration: %w", err)
+		}
+		applied[version] = true
+		newest = max(newest, version)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query schema migrations: %w", err)
+	}
+	rows.Close()
+
+	if newest > LatestVersion() {
+		return applied, fmt.Errorf("%w: the database is at version %d but this binary only knows versions up to %d, upgrade goth-deploy", ErrSchemaTooNew, newest, LatestVersion())
+	}
+	if err := adoptLegacySchema(db, applied); err != nil {
+		return nil, fmt.Errorf("failed to adopt existing schema: %w", err)
+	}
+	return applied, nil
+}
+
+// adoptLegacySchema records the pending migrations whose changes the database already has and
+// adds them to applied. Databases created before migrations were versioned have tables but no
+// history, and early builds created the whole schema as migration 1. Migrations are checked in
+// order by their Marker, the first one missing and every later one stay pending.
+func adoptLegacySchema(db *DB, applied map[int]bool) error {
+	var adopted []Migration
+	for _, migration := range migrations {
+		if applied[migration.Version] {
+			continue
+		}
+		present, err := db.schemaHas(migration.Marker)
+		if err != nil {
+			return err
+		}
+		if !present {
+			break
+		}
+		adopted = append(adopted, migration)
+	}
+	if len(adopted) == 0 {
+		return nil
+	}
+
+	err := inTransaction(db, func(tx *Tx) error {
+		for _, migration := range adopted {
+			if _, err := tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", migration.Version, migration.Name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, migration := range adopted {
+		applied[migration.Version] = true
+		log.Printf("Adopted the existing database schema as migration %d (%s)", migration.Version, migration.Name)
+	}
+	return nil
+}
+
+// inTransaction runs fn in a transaction, committed if fn succeeds and rolled back otherwise
+func inTransaction(db *DB, fn func(tx *Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
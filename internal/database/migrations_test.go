@@ -0,0 +1,157 @@
+package database_test
+
+import (
+	"errors"
+	"testing"
+
+	"goth-deploy/internal/database"
+	"goth-deploy/internal/database/dbtest"
+)
+
+// schemaVersion returns the schema version of a database, failing the test on errors
+func schemaVersion(t *testing.T, db *database.DB) int {
+	t.Helper()
+	version, err := database.SchemaVersion(db)
+	if err != nil {
+		t.Fatalf("SchemaVersion: %v", err)
+	}
+	return version
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	dbtest.EachEmpty(t, func(t *testing.T, db *database.DB) {
+		if version := schemaVersion(t, db); version != 0 {
+			t.Fatalf("empty database is at version %d, want 0", version)
+		}
+
+		if err := database.Migrate(db); err != nil {
+			t.Fatalf("Migrate: %v", err)
+		}
+		if version := schemaVersion(t, db); version != database.LatestVersion() {
+			t.Fatalf("migrated database is at version %d, want %d", version, database.LatestVersion())
+		}
+
+		// Migrating again is a no-op
+		applied, err := database.MigrateUp(db, database.LatestVersion())
+		if err != nil || len(applied) != 0 {
+			t.Fatalf("second MigrateUp applied %d migrations, err %v", len(applied), err)
+		}
+
+		reverted, err := database.MigrateDown(db, 0)
+		if err != nil {
+			t.Fatalf("MigrateDown: %v", err)
+		}
+		if len(reverted) != database.LatestVersion() {
+			t.Errorf("MigrateDown reverted %d migrations, want %d", len(reverted), database.LatestVersion())
+		}
+		if version := schemaVersion(t, db); version != 0 {
+			t.Fatalf("reverted database is at version %d, want 0", version)
+		}
+
+		// The down steps leave nothing behind that keeps the up steps from running again
+		if err := database.Migrate(db); err != nil {
+			t.Fatalf("Migrate after reverting: %v", err)
+		}
+	})
+}
+
+func TestMigrateOneVersionAtATime(t *testing.T) {
+	dbtest.EachEmpty(t, func(t *testing.T, db *database.DB) {
+		for version := 1; version <= database.LatestVersion(); version++ {
+			applied, err := database.MigrateUp(db, version)
+			if err != nil {
+				t.Fatalf("MigrateUp(%d): %v", version, err)
+			}
+			if len(applied) != 1 || applied[0].Version != version {
+				t.Fatalf("MigrateUp(%d) applied %v", version, applied)
+			}
+		}
+		for version := database.LatestVersion() - 1; version >= 0; version-- {
+			reverted, err := database.MigrateDown(db, version)
+			if err != nil {
+				t.Fatalf("MigrateDown(%d): %v", version, err)
+			}
+			if len(reverted) != 1 || reverted[0].Version != version+1 {
+				t.Fatalf("MigrateDown(%d) reverted %v", version, reverted)
+			}
+		}
+	})
+}
+
+func TestMigrationStatus(t *testing.T) {
+	dbtest.EachEmpty(t, func(t *testing.T, db *database.DB) {
+		if _, err := database.MigrateUp(db, 2); err != nil {
+			t.Fatalf("MigrateUp: %v", err)
+		}
+
+		states, err := database.MigrationStatus(db)
+		if err != nil {
+			t.Fatalf("MigrationStatus: %v", err)
+		}
+		if len(states) != database.LatestVersion() {
+			t.Fatalf("MigrationStatus returned %d migrations, want %d", len(states), database.LatestVersion())
+		}
+		for _, state := range states {
+			if applied := state.AppliedAt != nil; applied != (state.Version <= 2) {
+				t.Errorf("migration %d applied = %v", state.Version, applied)
+			}
+		}
+	})
+}
+
+func TestMigrateAdoptsExistingSchema(t *testing.T) {
+	dbtest.Each(t, func(t *testing.T, db *database.DB) {
+		// A database whose whole schema was created as migration 1
+		if _, err := db.Exec("DELETE FROM schema_migrations WHERE version > 1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := database.Migrate(db); err != nil {
+			t.Fatalf("Migrate with the schema of later migrations: %v", err)
+		}
+		if version := schemaVersion(t, db); version != database.LatestVersion() {
+			t.Fatalf("database is at version %d, want %d", version, database.LatestVersion())
+		}
+
+		// A database created before migrations were versioned, up to migration 6
+		if _, err := database.MigrateDown(db, 6); err != nil {
+			t.Fatalf("MigrateDown: %v", err)
+		}
+		if _, err := db.Exec("DELETE FROM schema_migrations"); err != nil {
+			t.Fatal(err)
+		}
+		if version := schemaVersion(t, db); version != 6 {
+			t.Fatalf("legacy database was adopted at version %d, want 6", version)
+		}
+		if err := database.Migrate(db); err != nil {
+			t.Fatalf("Migrate of a legacy database: %v", err)
+		}
+		if version := schemaVersion(t, db); version != database.LatestVersion() {
+			t.Fatalf("database is at version %d, want %d", version, database.LatestVersion())
+		}
+	})
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	dbtest.Each(t, func(t *testing.T, db *database.DB) {
+		newer := database.LatestVersion() + 1
+		if _, err := db.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", newer, "from the future"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := database.Migrate(db); !errors.Is(err, database.ErrSchemaTooNew) {
+			t.Fatalf("Migrate = %v, want ErrSchemaTooNew", err)
+		}
+		if _, err := database.MigrateDown(db, 0); !errors.Is(err, database.ErrSchemaTooNew) {
+			t.Fatalf("MigrateDown = %v, want ErrSchemaTooNew", err)
+		}
+
+		states, err := database.MigrationStatus(db)
+		if err != nil {
+			t.Fatalf("MigrationStatus: %v", err)
+		}
+		last := states[len(states)-1]
+		if last.Version != newer || !last.Unknown {
+			t.Errorf("last migration is %d (unknown %v), want the unknown migration %d", last.Version, last.Unknown, newer)
+		}
+	})
+}
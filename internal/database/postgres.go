@@ -0,0 +1,11 @@
+//go:build postgres
+
+package database
+
+import (
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+func init() {
+	postgresDriver = "pgx"
+}
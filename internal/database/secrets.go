@@ -0,0 +1,98 @@
+package database
+
+import (
+	"fmt"
+
+	"goth-deploy/internal/secrets"
+)
+
+// encryptedColumns lists the columns whose values are encrypted with the master key
+var encryptedColumns = []struct {
+	table  string
+	column string
+}{
+	{"users", "access_token"},
+	{"environment_variables", "value"},
+	{"deployments", "env_snapshot"},
+}
+
+// EncryptSecrets encrypts the values of encrypted columns that are still stored as plaintext
+// and returns how many were encrypted. It fails if a value was encrypted with a master key
+// that is not loaded.
+func EncryptSecrets(db *DB, keyring *secrets.Keyring) (int, error) {
+	return rewriteSecrets(db, func(value string) (string, bool, error) {
+		if !keyring.CanDecrypt(value) {
+			return "", false, secrets.ErrUnknownKey
+		}
+		if secrets.IsEncrypted(value) {
+			return value, false, nil
+		}
+		encrypted, err := keyring.Encrypt(value)
+		return encrypted, true, err
+	})
+}
+
+// RewrapSecrets re-encrypts the data keys of all encrypted values with the primary master key
+// of keyring and returns how many values were rewritten
+func RewrapSecrets(db *DB, keyring *secrets.Keyring) (int, error) {
+	return rewriteSecrets(db, func(value string) (string, bool, error) {
+		rewrapped, err := keyring.Rewrap(value)
+		return rewrapped, true, err
+	})
+}
+
+// rewriteSecrets passes every value of the encrypted columns through rewrite and saves the
+// values it changed, all in one transaction
+func rewriteSecrets(db *DB, rewrite func(value string) (string, bool, error)) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rewritten := 0
+	for _, c := range encryptedColumns {
+		type update struct {
+			rowID int64
+			value string
+		}
+		var updates []update
+
+		rows, err := tx.Query(fmt.Sprintf("SELECT id, %s FROM %s WHERE %s IS NOT NULL", c.column, c.table, c.column))
+		if err != nil {
+			return 0, fmt.Errorf("failed to read %s.%s: %w", c.table, c.column, err)
+		}
+		for rows.Next() {
+			var rowID int64
+			var value string
+			if err := rows.Scan(&rowID, &value); err != nil {
+				rows.Close()
+				return 0, fmt.Errorf("failed to read %s.%s: %w", c.table, c.column, err)
+			}
+			newValue, changed, err := rewrite(value)
+			if err != nil {
+				rows.Close()
+				return 0, fmt.Errorf("failed to rewrite %s.%s of row %d: %w", c.table, c.column, rowID, err)
+			}
+			if changed {
+				updates = append(updates, update{rowID, newValue})
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, fmt.Errorf("failed to read %s.%s: %w", c.table, c.column, err)
+		}
+
+		for _, u := range updates {
+			if _, err := tx.Exec(fmt.Sprintf("UPDATE %s SET %s = ? WHERE id = ?", c.table, c.column), u.value, u.rowID); err != nil {
+				return 0, fmt.Errorf("failed to update %s.%s: %w", c.table, c.column, err)
+			}
+		}
+		rewritten += len(updates)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit: %w", err)
+	}
+	return rewritten, nil
+}
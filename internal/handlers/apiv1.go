@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"goth-deploy/internal/models"
+	"goth-deploy/internal/services"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Pagination defaults of the versioned API
+const (
+	apiDefaultPerPage = 20
+	apiMaxPerPage     = 100
+)
+
+// apiErrorCodes maps HTTP status codes to the codes of API error bodies
+var apiErrorCodes = map[int]string{
+	http.StatusBadRequest:          "bad_request",
+	http.StatusUnauthorized:        "unauthorized",
+	http.StatusForbidden:           "forbidden",
+	http.StatusNotFound:            "not_found",
+	http.StatusMethodNotAllowed:    "method_not_allowed",
+	http.StatusConflict:            "conflict",
+	http.StatusInternalServerError: "internal_error",
+}
+
+// apiError is the body of every error response of the versioned API
+type apiError struct {
+	Error struct {
+		Status  int    `json:"status"`
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// apiPage describes the page of a paginated API response
+type apiPage struct {
+	Page       int `json:"page"`
+	PerPage    int `json:"per_page"`
+	Total      int `json:"total"`
+	TotalPages int `json:"total_pages"`
+}
+
+// apiV1Routes sets up the versioned JSON API. It accepts API tokens as well as the session.
+func (h *Handler) apiV1Routes(r chi.Router) {
+	r.Use(h.APIAuthMiddleware)
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, "Not found")
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	})
+
+	read := h.RequireScope(models.TokenScopeRead)
+	deploy := h.RequireScope(models.TokenScopeDeploy)
+	env := h.RequireScope(models.TokenScopeEnv)
+	manage := h.RequireScope(models.TokenScopeManage)
+
+	r.With(read).Get("/user", h.APIUserHandler)
+
+	r.Route("/projects", func(r chi.Router) {
+		r.With(read).Get("/", h.APIProjectsHandler)
+		r.With(manage).Post("/", h.APICreateProjectHandler)
+
+		r.Route("/{projectId}", func(r chi.Router) {
+			r.Use(h.ProjectAccess("projectId"))
+
+			r.With(read).Get("/", h.APIProjectHandler)
+			r.With(manage, h.RequireRole(models.RoleOwner)).Delete("/", h.APIDeleteProjectHandler)
+
+			r.With(read).Get("/deployments", h.APIDeploymentsHandler)
+			r.With(read).Get("/logs", h.APIApplicationLogsHandler)
+			r.With(read).Get("/logs/stream", h.ApplicationLogStreamHandler)
+
+			// Process control
+			r.Group(func(r chi.Router) {
+				r.Use(deploy, h.RequireRole(models.RoleDeployer))
+				r.Post("/deployments", h.APIDeployHandler)
+				r.Post("/start", h.APIStartProjectHandler)
+				r.Post("/stop", h.APIStopProjectHandler)
+				r.Post("/restart", h.APIRestartProjectHandler)
+			})
+
+			// Environment variables. Names only need the read scope, values the env scope.
+			r.Route("/env", func(r chi.Router) {
+				r.With(read).Get("/", h.APIEnvironmentVariablesHandler)
+				r.Group(func(r chi.Router) {
+					r.Use(env, h.RequireRole(models.RoleAdmin))
+					r.Post("/", h.APICreateEnvironmentVariableHandler)
+					r.Post("/import", h.ImportEnvironmentVariablesHandler)
+					r.With(h.EnvVarAccess("envId")).Patch("/{envId}", h.APIUpdateEnvironmentVariableHandler)
+					r.With(h.EnvVarAccess("envId")).Delete("/{envId}", h.APIDeleteEnvironmentVariableHandler)
+				})
+			})
+		})
+	})
+
+	r.Route("/deployments/{deploymentId}", func(r chi.Router) {
+		r.Use(h.DeploymentAccess("deploymentId"))
+		r.With(read).Get("/", h.APIDeploymentHandler)
+		r.With(read).Get("/logs", h.APIDeploymentLogsHandler)
+		r.With(read).Get("/logs/stream", h.BuildLogStreamHandler)
+		r.With(deploy, h.RequireRole(models.RoleDeployer)).Post("/rollback", h.APIRollbackHandler)
+	})
+}
+
+// APIAuthMiddleware authenticates requests to the versioned API with an API token sent as
+// "Authorization: Bearer <token>", or with the session cookie
+func (h *Handler) APIAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), apiContextKey, true)
+
+		var user *models.User
+		if authorization := r.Header.Get("Authorization"); authorization != "" {
+			scheme, secret, _ := strings.Cut(authorization, " ")
+			if !strings.EqualFold(scheme, "Bearer") {
+				apiUnauthorized(w, "Authorization must be a Bearer token")
+				return
+			}
+
+			token, err := h.Tokens.AuthenticateToken(strings.TrimSpace(secret))
+			if errors.Is(err, services.ErrTokenInvalid) {
+				apiUnauthorized(w, "Invalid or expired API token")
+				return
+			}
+			if err != nil {
+				log.Printf("Error authenticating API token: %v", err)
+				writeAPIError(w, http.StatusInternalServerError, "Internal Server Error")
+				return
+			}
+
+			user = h.loadUser(token.UserID)
+			ctx = context.WithValue(ctx, tokenContextKey, token)
+		} else {
+			user = h.getCurrentUser(r)
+		}
+
+		if user == nil {
+			apiUnauthorized(w, "Authentication required")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, userContextKey, user)))
+	})
+}
+
+// RequireScope lets requests authenticated with an API token through only if the token has
+// the scope. Requests authenticated with the session are not limited by scopes.
+func (h *Handler) RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token := requestToken(r); token != nil && !services.HasScope(token, scope) {
+				writeAPIError(w, http.StatusForbidden, fmt.Sprintf("Forbidden: the API token lacks the %s scope", scope))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// isAPIRequest reports whether a request goes to the versioned API
+func isAPIRequest(r *http.Request) bool {
+	api, _ := r.Context().Value(apiContextKey).(bool)
+	return api
+}
+
+// requestToken returns the API token a request was authenticated with, nil for the session
+func requestToken(r *http.Request) *models.APIToken {
+	token, _ := r.Context().Value(tokenContextKey).(*models.APIToken)
+	return token
+}
+
+// requestAllows reports whether the current user's role and API token allow an action
+func requestAllows(r *http.Request, role, scope string) bool {
+	token := requestToken(r)
+	return services.RoleAtLeast(requestRole(r), role) && (token == nil || services.HasScope(token, scope))
+}
+
+// apiUnauthorized writes a 401 API error asking for a bearer token
+func apiUnauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="goth-deploy"`)
+	writeAPIError(w, http.StatusUnauthorized, message)
+}
+
+// writeAPIError writes an error in the format of the versioned API
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	var body apiError
+	body.Error.Status = status
+	body.Error.Code = apiErrorCodes[status]
+	if body.Error.Code == "" {
+		body.Error.Code = strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+	}
+	body.Error.Message = message
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeAPIData writes a successful response of the versioned API
+func writeAPIData(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+// writeAPIPage writes a page of a paginated list
+func writeAPIPage(w http.ResponseWriter, data interface{}, page apiPage) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data":       data,
+		"pagination": page,
+	})
+}
+
+// parseAPIPage reads the page and per_page parameters of a paginated list, writing an error
+// response if they are invalid
+func parseAPIPage(w http.ResponseWriter, r *http.Request) (apiPage, bool) {
+	page := apiPage{Page: 1, PerPage: apiDefaultPerPage}
+	params := r.URL.Query()
+
+	if value := params.Get("page"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			writeAPIError(w, http.StatusBadRequest, "page must be a positive number")
+			return page, false
+		}
+		page.Page = n
+	}
+	if value := params.Get("per_page"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > apiMaxPerPage {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("per_page must be between 1 and %d", apiMaxPerPage))
+			return page, false
+		}
+		page.PerPage = n
+	}
+
+	return page, true
+}
+
+// offset returns the number of items before the page
+func (p apiPage) offset() int {
+	return (p.Page - 1) * p.PerPage
+}
+
+// withTotal returns the page with the total number of items set
+func (p apiPage) withTotal(total int) apiPage {
+	p.Total = total
+	p.TotalPages = (total + p.PerPage - 1) / p.PerPage
+	return p
+}
+
+// decodeAPIBody decodes a JSON request body, writing an error response if it is invalid
+func decodeAPIBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Invalid request body")
+		return false
+	}
+	return true
+}
+
+// APIUserHandler returns the current user and, for token requests, the token
+func (h *Handler) APIUserHandler(w http.ResponseWriter, r *http.Request) {
+	writeAPIData(w, http.StatusOK, map[string]interface{}{
+		"user":  h.getCurrentUser(r),
+		"token": requestToken(r),
+	})
+}
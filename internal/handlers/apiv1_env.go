@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"goth-deploy/internal/models"
+	"goth-deploy/internal/services"
+)
+
+// APIEnvironmentVariablesHandler lists the environment variables of a project. Values are
+// masked unless ?reveal=true is given, which requires the admin role and the env scope.
+func (h *Handler) APIEnvironmentVariablesHandler(w http.ResponseWriter, r *http.Request) {
+	reveal := revealValues(r)
+	if reveal && !requestAllows(r, models.RoleAdmin, models.TokenScopeEnv) {
+		writeAPIError(w, http.StatusForbidden, "Forbidden: revealing values requires the admin role and the env scope")
+		return
+	}
+
+	envVars, err := h.Deployment.ListEnvironmentVariables(requestProjectID(r))
+	if err != nil {
+		log.Printf("Error getting environment variables: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "Failed to fetch environment variables")
+		return
+	}
+
+	response := make([]models.EnvironmentVariable, 0, len(envVars))
+	for _, envVar := range envVars {
+		response = append(response, maskEnvVar(envVar, reveal))
+	}
+	writeAPIData(w, http.StatusOK, response)
+}
+
+// APICreateEnvironmentVariableHandler creates an environment variable. The scope defaults to
+// both. It takes ?apply like CreateEnvironmentVariableHandler.
+func (h *Handler) APICreateEnvironmentVariableHandler(w http.ResponseWriter, r *http.Request) {
+	projectID := requestProjectID(r)
+	apply, ok := envApply(w, r)
+	if !ok {
+		return
+	}
+
+	envVar := models.EnvironmentVariable{Scope: models.EnvScopeBoth}
+	if !decodeAPIBody(w, r, &envVar) {
+		return
+	}
+	envVar.ID = 0
+	envVar.ProjectID = projectID
+
+	if err := services.ValidateEnvironmentVariable(envVar); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := h.Deployment.CreateEnvironmentVariable(&envVar); err != nil {
+		h.envVarError(w, r, err)
+		return
+	}
+
+	log.Printf("Created environment variable %s for project %d", envVar.Key, projectID)
+
+	h.apiEnvVarSaved(w, r, http.StatusCreated, envVar, envChangeRequires(envVar.Scope), apply)
+}
+
+// APIUpdateEnvironmentVariableHandler updates an environment variable. Fields missing from
+// the request body keep their current value.
+func (h *Handler) APIUpdateEnvironmentVariableHandler(w http.ResponseWriter, r *http.Request) {
+	projectID := requestProjectID(r)
+	envVarID := requestEnvVarID(r)
+	apply, ok := envApply(w, r)
+	if !ok {
+		return
+	}
+
+	envVar, err := h.Deployment.GetEnvironmentVariable(projectID, envVarID)
+	if err != nil {
+		h.envVarError(w, r, err)
+		return
+	}
+	previous := *envVar
+
+	if !decodeAPIBody(w, r, envVar) {
+		return
+	}
+	envVar.ID = envVarID
+	envVar.ProjectID = projectID
+	if envVar.Value == envValueMask {
+		envVar.Value = previous.Value
+	}
+
+	if err := services.ValidateEnvironmentVariable(*envVar); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := h.Deployment.UpdateEnvironmentVariable(envVar); err != nil {
+		h.envVarError(w, r, err)
+		return
+	}
+
+	log.Printf("Updated environment variable %d for project %d", envVarID, projectID)
+
+	requires := envChangeRequires(envVar.Scope)
+	if envChangeRequires(previous.Scope) == envApplyRedeploy {
+		requires = envApplyRedeploy
+	}
+	h.apiEnvVarSaved(w, r, http.StatusOK, *envVar, requires, apply)
+}
+
+// APIDeleteEnvironmentVariableHandler deletes an environment variable
+func (h *Handler) APIDeleteEnvironmentVariableHandler(w http.ResponseWriter, r *http.Request) {
+	projectID := requestProjectID(r)
+	envVarID := requestEnvVarID(r)
+	apply, ok := envApply(w, r)
+	if !ok {
+		return
+	}
+
+	envVar, err := h.Deployment.GetEnvironmentVariable(projectID, envVarID)
+	if err != nil {
+		h.envVarError(w, r, err)
+		return
+	}
+	if err := h.Deployment.DeleteEnvironmentVariable(projectID, envVarID); err != nil {
+		h.envVarError(w, r, err)
+		return
+	}
+
+	log.Printf("Deleted environment variable %s of project %d", envVar.Key, projectID)
+
+	requires := envChangeRequires(envVar.Scope)
+	writeAPIData(w, http.StatusOK, map[string]interface{}{
+		"message":  "Environment variable deleted" + h.applyEnvChange(projectID, requires, apply),
+		"requires": requires,
+	})
+}
+
+// apiEnvVarSaved writes the response for a created or updated variable and applies the change
+func (h *Handler) apiEnvVarSaved(w http.ResponseWriter, r *http.Request, status int, envVar models.EnvironmentVariable, requires, apply string) {
+	message := h.applyEnvChange(envVar.ProjectID, requires, apply)
+	writeAPIData(w, status, map[string]interface{}{
+		"environment_variable": maskEnvVar(envVar, revealValues(r)),
+		"message":              "Environment variable saved" + message,
+		"requires":             requires,
+	})
+}
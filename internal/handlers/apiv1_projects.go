@@ -0,0 +1,330 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"goth-deploy/internal/models"
+	"goth-deploy/internal/services"
+	"goth-deploy/internal/store"
+)
+
+// APIProjectsHandler lists the projects the user can access
+func (h *Handler) APIProjectsHandler(w http.ResponseWriter, r *http.Request) {
+	user := h.getCurrentUser(r)
+	page, ok := parseAPIPage(w, r)
+	if !ok {
+		return
+	}
+
+	access, err := h.userAccess(user.ID)
+	if err != nil {
+		log.Printf("Error getting organizations: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "Failed to fetch projects")
+		return
+	}
+
+	total, err := h.Stores.Projects.Count(store.ProjectFilter{Access: access})
+	if err != nil {
+		log.Printf("Error counting projects: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "Failed to fetch projects")
+		return
+	}
+
+	projects, err := h.Stores.Projects.List(store.ProjectFilter{Access: access, Limit: page.PerPage, Offset: page.offset()})
+	if err != nil {
+		log.Printf("Error getting projects: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "Failed to fetch projects")
+		return
+	}
+
+	writeAPIPage(w, projects, page.withTotal(total))
+}
+
+// APICreateProjectHandler creates a project from a JSON body and starts its first deployment.
+// The branch and commands default to main, "go build -o main ." and "./main", the subdomain is
+// generated from the name and github_repo_id is looked up on GitHub if they are missing.
+func (h *Handler) APICreateProjectHandler(w http.ResponseWriter, r *http.Request) {
+	user := h.getCurrentUser(r)
+
+	project := models.Project{
+		Branch:       "main",
+		BuildCommand: "go build -o main .",
+		StartCommand: "./main",
+	}
+	if !decodeAPIBody(w, r, &project) {
+		return
+	}
+	project.Name = strings.TrimSpace(project.Name)
+	project.RepoURL = strings.TrimSpace(project.RepoURL)
+	project.Subdomain = strings.TrimSpace(project.Subdomain)
+
+	if project.Name == "" || project.RepoURL == "" {
+		writeAPIError(w, http.StatusBadRequest, "name and repo_url are required")
+		return
+	}
+	if project.Branch == "" || project.BuildCommand == "" || project.StartCommand == "" {
+		writeAPIError(w, http.StatusBadRequest, "branch, build_command and start_command must not be empty")
+		return
+	}
+	if project.GitHubRepoID == 0 {
+		fullName, err := services.RepoFullNameFromURL(project.RepoURL)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		repo, err := h.GitHub.GetRepository(r.Context(), user.AccessToken, fullName)
+		if errors.Is(err, services.ErrRepoNotFound) {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("Repository %s not found on GitHub", fullName))
+			return
+		}
+		if err != nil {
+			log.Printf("Error getting repository: %v", err)
+			writeAPIError(w, http.StatusInternalServerError, "Failed to look up the repository on GitHub")
+			return
+		}
+		project.GitHubRepoID = repo.ID
+	}
+	if project.Subdomain == "" {
+		subdomain, err := h.generateSubdomain(project.Name)
+		if err != nil {
+			log.Printf("Error generating subdomain: %v", err)
+			writeAPIError(w, http.StatusInternalServerError, "Failed to create project")
+			return
+		}
+		project.Subdomain = subdomain
+	}
+
+	deployment, err := h.createProject(r.Context(), user, &project)
+	if err != nil {
+		var invalid *projectError
+		if errors.As(err, &invalid) {
+			writeAPIError(w, invalid.status, invalid.message)
+			return
+		}
+		log.Printf("Error creating project: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "Failed to create project")
+		return
+	}
+
+	writeAPIData(w, http.StatusCreated, map[string]interface{}{
+		"project":    h.apiProject(project.ID, &project),
+		"deployment": deployment,
+	})
+}
+
+// APIProjectHandler returns a project
+func (h *Handler) APIProjectHandler(w http.ResponseWriter, r *http.Request) {
+	project, err := h.Stores.Projects.Get(requestProjectID(r))
+	if err != nil {
+		log.Printf("Error getting project: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "Failed to fetch project")
+		return
+	}
+
+	writeAPIData(w, http.StatusOK, map[string]interface{}{
+		"project": project,
+		"role":    requestRole(r),
+		"running": h.Deployment.IsProjectRunning(project.Subdomain),
+	})
+}
+
+// APIDeleteProjectHandler stops and deletes a project
+func (h *Handler) APIDeleteProjectHandler(w http.ResponseWriter, r *http.Request) {
+	user := h.getCurrentUser(r)
+	projectID := requestProjectID(r)
+
+	if err := h.Webhooks.RemoveProjectHook(r.Context(), projectID, user.AccessToken); err != nil {
+		log.Printf("Error removing webhook for project %d: %v", projectID, err)
+	}
+	if err := h.Deployment.DeleteProject(projectID); err != nil {
+		log.Printf("Error deleting project: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "Failed to delete project")
+		return
+	}
+
+	log.Printf("Deleted project %d for user %s", projectID, user.Username)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// APIDeploymentsHandler lists the deployments of a project, newest first
+func (h *Handler) APIDeploymentsHandler(w http.ResponseWriter, r *http.Request) {
+	projectID := requestProjectID(r)
+	page, ok := parseAPIPage(w, r)
+	if !ok {
+		return
+	}
+
+	total, err := h.Stores.Deployments.Count(store.DeploymentFilter{ProjectID: projectID})
+	if err != nil {
+		log.Printf("Error counting deployments: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "Failed to fetch deployments")
+		return
+	}
+
+	deployments, err := h.Stores.Deployments.List(store.DeploymentFilter{ProjectID: projectID, Limit: page.PerPage, Offset: page.offset()})
+	if err != nil {
+		log.Printf("Error getting deployments: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "Failed to fetch deployments")
+		return
+	}
+
+	writeAPIPage(w, deployments, page.withTotal(total))
+}
+
+// APIDeployHandler deploys a project, at the commit_sha of the optional JSON body or the
+// latest commit of its branch
+func (h *Handler) APIDeployHandler(w http.ResponseWriter, r *http.Request) {
+	projectID := requestProjectID(r)
+
+	var body struct {
+		CommitSHA string `json:"commit_sha"`
+	}
+	if r.ContentLength != 0 && !decodeAPIBody(w, r, &body) {
+		return
+	}
+
+	deployment, err := h.Deployment.DeployProject(projectID, strings.TrimSpace(body.CommitSHA))
+	if err != nil {
+		log.Printf("Error deploying project: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "Failed to deploy project")
+		return
+	}
+
+	log.Printf("Started deployment %d for project %d from the API", deployment.ID, projectID)
+	writeAPIData(w, http.StatusAccepted, deployment)
+}
+
+// APIStartProjectHandler starts the active release of a stopped project
+func (h *Handler) APIStartProjectHandler(w http.ResponseWriter, r *http.Request) {
+	project := requestProject(r)
+	if h.Deployment.IsProjectRunning(project.Subdomain) {
+		writeAPIError(w, http.StatusConflict, "The application is already running")
+		return
+	}
+	h.apiRestart(w, project, "starting")
+}
+
+// APIRestartProjectHandler restarts the application of a project
+func (h *Handler) APIRestartProjectHandler(w http.ResponseWriter, r *http.Request) {
+	h.apiRestart(w, requestProject(r), "restarting")
+}
+
+// APIStopProjectHandler stops the application of a project
+func (h *Handler) APIStopProjectHandler(w http.ResponseWriter, r *http.Request) {
+	projectID := requestProjectID(r)
+	if err := h.Deployment.StopProject(projectID); err != nil {
+		log.Printf("Error stopping project %d: %v", projectID, err)
+		writeAPIError(w, http.StatusInternalServerError, "Failed to stop project")
+		return
+	}
+	writeAPIData(w, http.StatusOK, map[string]interface{}{"project_id": projectID, "status": models.ProjectStatusInactive})
+}
+
+// apiRestart (re)starts the active release of a project in the background. Starting waits
+// for the application to become ready, so the response does not.
+func (h *Handler) apiRestart(w http.ResponseWriter, project *models.Project, action string) {
+	go func() {
+		if err := h.Deployment.RestartProject(project.ID); err != nil {
+			log.Printf("Error restarting project %d: %v", project.ID, err)
+		}
+	}()
+	writeAPIData(w, http.StatusAccepted, map[string]interface{}{"project_id": project.ID, "status": action})
+}
+
+// APIApplicationLogsHandler returns runtime log lines of a project. It takes the query
+// parameters of ApplicationLogsHandler.
+func (h *Handler) APIApplicationLogsHandler(w http.ResponseWriter, r *http.Request) {
+	query, err := parseLogQuery(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	lines, err := h.Deployment.GetApplicationLogs(requestProjectID(r), query)
+	if err != nil {
+		log.Printf("Error getting application logs: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "Failed to fetch application logs")
+		return
+	}
+	if lines == nil {
+		lines = []models.RuntimeLogLine{}
+	}
+
+	writeAPIData(w, http.StatusOK, lines)
+}
+
+// APIDeploymentHandler returns a deployment without its build log
+func (h *Handler) APIDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	deployment, err := h.Stores.Deployments.Get(requestDeploymentID(r))
+	if err != nil {
+		log.Printf("Error getting deployment: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "Failed to fetch deployment")
+		return
+	}
+	writeAPIData(w, http.StatusOK, deployment)
+}
+
+// APIDeploymentLogsHandler returns the build log of a deployment, as far as it got
+func (h *Handler) APIDeploymentLogsHandler(w http.ResponseWriter, r *http.Request) {
+	deploymentID := requestDeploymentID(r)
+
+	deployment, err := h.Stores.Deployments.Get(deploymentID)
+	if err != nil {
+		log.Printf("Error getting deployment: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "Failed to fetch deployment logs")
+		return
+	}
+	buildLog, err := h.Deployment.GetDeploymentLogs(deploymentID)
+	if err != nil {
+		log.Printf("Error getting deployment logs: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "Failed to fetch deployment logs")
+		return
+	}
+
+	writeAPIData(w, http.StatusOK, map[string]interface{}{
+		"deployment_id": deploymentID,
+		"status":        deployment.Status,
+		"log":           buildLog,
+	})
+}
+
+// APIRollbackHandler rolls the deployment's project back to it
+func (h *Handler) APIRollbackHandler(w http.ResponseWriter, r *http.Request) {
+	projectID := requestProjectID(r)
+	deploymentID := requestDeploymentID(r)
+
+	target, err := h.Stores.Deployments.Get(deploymentID)
+	if err != nil {
+		log.Printf("Error getting deployment: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "Failed to roll back project")
+		return
+	}
+	if target.Status != models.StatusSuccess {
+		writeAPIError(w, http.StatusConflict, "Only successful deployments can be rolled back to")
+		return
+	}
+
+	deployment, err := h.Deployment.RollbackProject(projectID, deploymentID)
+	if err != nil {
+		log.Printf("Error rolling back project: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "Failed to roll back project")
+		return
+	}
+
+	log.Printf("Started rollback deployment %d for project %d from the API", deployment.ID, projectID)
+	writeAPIData(w, http.StatusAccepted, deployment)
+}
+
+// apiProject reloads a project after a change, falling back to what is known if that fails
+func (h *Handler) apiProject(projectID int64, fallback *models.Project) *models.Project {
+	project, err := h.Stores.Projects.Get(projectID)
+	if err != nil {
+		log.Printf("Error getting project %d: %v", projectID, err)
+		return fallback
+	}
+	return project
+}
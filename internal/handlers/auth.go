@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"log"
@@ -81,7 +82,7 @@ func (h *Handler) GitHubCallbackHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Create or update user in database
-	if err := h.GitHub.CreateOrUpdateUser(h.DB, user); err != nil {
+	if err := h.GitHub.CreateOrUpdateUser(h.Stores.Users, h.Secrets, user); err != nil {
 		log.Printf("Error creating/updating user: %v", err)
 		http.Error(w, "Failed to save user", http.StatusInternalServerError)
 		return
@@ -122,7 +123,7 @@ func (h *Handler) LogoutHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-// AuthMiddleware ensures the user is authenticated
+// AuthMiddleware ensures the user is authenticated and keeps the user in the request context
 func (h *Handler) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := h.getCurrentUser(r)
@@ -138,7 +139,7 @@ func (h *Handler) AuthMiddleware(next http.Handler) http.Handler {
 			http.Redirect(w, r, "/", http.StatusSeeOther)
 			return
 		}
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey, user)))
 	})
 }
 
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"goth-deploy/internal/models"
+	"goth-deploy/internal/services"
+	"goth-deploy/internal/store"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// contextKey is the type of the request context keys set by the auth middlewares
+type contextKey string
+
+const (
+	userContextKey       contextKey = "user"
+	projectContextKey    contextKey = "project"
+	deploymentContextKey contextKey = "deployment"
+	envVarContextKey     contextKey = "env-var"
+	orgContextKey        contextKey = "organization"
+	roleContextKey       contextKey = "role"
+	apiContextKey        contextKey = "api"
+	tokenContextKey      contextKey = "api-token"
+)
+
+// userAccess returns what a user can access: personal projects of the user and projects of the
+// user's organizations
+func (h *Handler) userAccess(userID int64) (*store.Access, error) {
+	orgs, err := h.Orgs.ListOrganizations(userID)
+	if err != nil {
+		return nil, err
+	}
+	access := &store.Access{UserID: userID}
+	for _, org := range orgs {
+		access.OrganizationIDs = append(access.OrganizationIDs, org.ID)
+	}
+	return access, nil
+}
+
+// ProjectAccess resolves the project named by the URL parameter param and lets the request
+// through only if the current user has a role on it, see RequireRole. Projects the user cannot
+// access are reported as not found, so project IDs do not leak.
+func (h *Handler) ProjectAccess(param string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			projectID, err := strconv.ParseInt(chi.URLParam(r, param), 10, 64)
+			if err != nil {
+				writeError(w, r, "Project not found", http.StatusNotFound)
+				return
+			}
+
+			project, err := h.Stores.Projects.Get(projectID)
+			var role string
+			if err == nil {
+				role, err = h.projectRole(r, project)
+			}
+			if err != nil && !errors.Is(err, store.ErrNotFound) {
+				log.Printf("Error checking project access: %v", err)
+				writeError(w, r, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if err != nil || role == "" {
+				writeError(w, r, "Project not found", http.StatusNotFound)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), projectContextKey, project)
+			ctx = context.WithValue(ctx, roleContextKey, role)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// DeploymentAccess resolves the deployment named by the URL parameter param and lets the
+// request through only if the current user has a role on its project. Under a project route the
+// deployment must also belong to that project.
+func (h *Handler) DeploymentAccess(param string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			deploymentID, err := strconv.ParseInt(chi.URLParam(r, param), 10, 64)
+			if err != nil {
+				writeError(w, r, "Deployment not found", http.StatusNotFound)
+				return
+			}
+
+			var project *models.Project
+			deployment, err := h.Stores.Deployments.Get(deploymentID)
+			if err == nil {
+				project, err = h.Stores.Projects.Get(deployment.ProjectID)
+			}
+			var role string
+			if err == nil {
+				role, err = h.projectRole(r, project)
+			}
+			if err != nil && !errors.Is(err, store.ErrNotFound) {
+				log.Printf("Error checking deployment access: %v", err)
+				writeError(w, r, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if parent := requestProject(r); err == nil && parent != nil && parent.ID != project.ID {
+				err = store.ErrNotFound
+			}
+			if err != nil || role == "" {
+				writeError(w, r, "Deployment not found", http.StatusNotFound)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), projectContextKey, project)
+			ctx = context.WithValue(ctx, roleContextKey, role)
+			ctx = context.WithValue(ctx, deploymentContextKey, deploymentID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// EnvVarAccess resolves the environment variable named by the URL parameter param. It must be
+// used under ProjectAccess and lets the request through only if the variable belongs to the
+// request's project.
+func (h *Handler) EnvVarAccess(param string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			project := requestProject(r)
+			envVarID, err := strconv.ParseInt(chi.URLParam(r, param), 10, 64)
+			if err != nil || project == nil {
+				writeError(w, r, "Environment variable not found", http.StatusNotFound)
+				return
+			}
+
+			_, err = h.Stores.Env.Get(project.ID, envVarID)
+			if err != nil && !errors.Is(err, store.ErrNotFound) {
+				log.Printf("Error checking environment variable access: %v", err)
+				writeError(w, r, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if err != nil {
+				writeError(w, r, "Environment variable not found", http.StatusNotFound)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), envVarContextKey, envVarID)))
+		})
+	}
+}
+
+// OrganizationAccess resolves the organization named by the URL parameter param and lets the
+// request through only if the current user is a member. Other organizations are reported as
+// not found.
+func (h *Handler) OrganizationAccess(param string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			orgID, err := strconv.ParseInt(chi.URLParam(r, param), 10, 64)
+			if err != nil {
+				writeError(w, r, "Organization not found", http.StatusNotFound)
+				return
+			}
+
+			org, err := h.Orgs.GetOrganization(orgID)
+			if err == nil {
+				org.Role, err = h.Orgs.MemberRole(orgID, h.getCurrentUser(r).ID)
+			}
+			if err != nil && !errors.Is(err, services.ErrOrgNotFound) {
+				log.Printf("Error checking organization access: %v", err)
+				writeError(w, r, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if err != nil || org.Role == "" {
+				writeError(w, r, "Organization not found", http.StatusNotFound)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), orgContextKey, org)
+			ctx = context.WithValue(ctx, roleContextKey, org.Role)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequireRole lets the request through only if the role the current user was given by
+// ProjectAccess, DeploymentAccess or OrganizationAccess is at least role. The resource was
+// already found accessible, so a missing role is reported as forbidden.
+func (h *Handler) RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !services.RoleAtLeast(requestRole(r), role) {
+				writeError(w, r, fmt.Sprintf("Forbidden: requires the %s role", role), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// writeError writes an error response, in the API error format for requests to the versioned API
+func writeError(w http.ResponseWriter, r *http.Request, message string, status int) {
+	if isAPIRequest(r) {
+		writeAPIError(w, status, message)
+		return
+	}
+	http.Error(w, message, status)
+}
+
+// projectRole returns the current user's role on a project, "" without access
+func (h *Handler) projectRole(r *http.Request, project *models.Project) (string, error) {
+	user := h.getCurrentUser(r)
+	if user == nil {
+		return "", nil
+	}
+	return h.Orgs.ProjectRole(user.ID, project)
+}
+
+// requestProject returns the project resolved by ProjectAccess or DeploymentAccess
+func requestProject(r *http.Request) *models.Project {
+	project, _ := r.Context().Value(projectContextKey).(*models.Project)
+	return project
+}
+
+// requestProjectID returns the ID of the project resolved by ProjectAccess or DeploymentAccess
+func requestProjectID(r *http.Request) int64 {
+	if project := requestProject(r); project != nil {
+		return project.ID
+	}
+	return 0
+}
+
+// requestDeploymentID returns the ID of the deployment resolved by DeploymentAccess
+func requestDeploymentID(r *http.Request) int64 {
+	deploymentID, _ := r.Context().Value(deploymentContextKey).(int64)
+	return deploymentID
+}
+
+// requestOrganization returns the organization resolved by OrganizationAccess
+func requestOrganization(r *http.Request) *models.Organization {
+	org, _ := r.Context().Value(orgContextKey).(*models.Organization)
+	return org
+}
+
+// requestRole returns the current user's role on the request's project or organization
+func requestRole(r *http.Request) string {
+	role, _ := r.Context().Value(roleContextKey).(string)
+	return role
+}
+
+// requestEnvVarID returns the ID of the environment variable resolved by EnvVarAccess
+func requestEnvVarID(r *http.Request) int64 {
+	envVarID, _ := r.Context().Value(envVarContextKey).(int64)
+	return envVarID
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"goth-deploy/internal/models"
+	"goth-deploy/internal/services"
+	"goth-deploy/internal/store"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/sessions"
+)
+
+// testResources are the records of one user created by newTestResources
+type testResources struct {
+	user       *models.User
+	project    *models.Project
+	deployment *models.Deployment
+	envVar     *models.EnvironmentVariable
+}
+
+// newTestResources creates a user with a project, a deployment and an environment variable
+func newTestResources(t *testing.T, stores *store.Stores, githubID int64, name string) testResources {
+	t.Helper()
+	var res testResources
+	res.user = &models.User{GitHubID: githubID, Username: name, AccessToken: "token"}
+	if err := stores.Users.Create(res.user); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	res.project = &models.Project{UserID: res.user.ID, Name: name, RepoURL: "https://github.com/" + name + "/app", Branch: "main", Subdomain: name, Port: 8080}
+	if err := stores.Projects.Create(res.project); err != nil {
+		t.Fatalf("failed to create project: %v", err)
+	}
+	res.deployment = &models.Deployment{ProjectID: res.project.ID}
+	if err := stores.Deployments.Enqueue(res.deployment); err != nil {
+		t.Fatalf("failed to create deployment: %v", err)
+	}
+	res.envVar = &models.EnvironmentVariable{ProjectID: res.project.ID, Key: "KEY", Value: "value", Scope: models.EnvScopeBoth}
+	if err := stores.Env.Create(res.envVar); err != nil {
+		t.Fatalf("failed to create environment variable: %v", err)
+	}
+	return res
+}
+
+// newAuthzRouter mounts the access middlewares the way the routes do. Requests that get through
+// are answered with the resolved role, project, deployment and variable.
+func newAuthzRouter(h *Handler) http.Handler {
+	resolved := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %d %d %d", requestRole(r), requestProjectID(r), requestDeploymentID(r), requestEnvVarID(r))
+	}
+
+	r := chi.NewRouter()
+	r.Route("/projects/{projectID}", func(r chi.Router) {
+		r.Use(h.ProjectAccess("projectID"))
+		r.Get("/", resolved)
+		r.With(h.DeploymentAccess("deploymentID")).Get("/deployments/{deploymentID}", resolved)
+		r.With(h.EnvVarAccess("envVarID")).Get("/env/{envVarID}", resolved)
+	})
+	r.With(h.DeploymentAccess("deploymentID")).Get("/deployments/{deploymentID}", resolved)
+	return r
+}
+
+func TestAccessMiddlewares(t *testing.T) {
+	stores := store.NewMemory()
+	h := &Handler{
+		Stores: stores,
+		Store:  sessions.NewCookieStore([]byte("test-secret")),
+		Orgs:   services.NewOrganizationService(nil, stores, nil),
+	}
+	router := newAuthzRouter(h)
+
+	own := newTestResources(t, stores, 1, "alice")
+	other := newTestResources(t, stores, 2, "bob")
+
+	tests := []struct {
+		name   string
+		user   *models.User
+		path   string
+		status int
+		body   string
+	}{
+		{"own project", own.user, fmt.Sprintf("/projects/%d/", own.project.ID), http.StatusOK,
+			fmt.Sprintf("owner %d 0 0", own.project.ID)},
+		{"other project", own.user, fmt.Sprintf("/projects/%d/", other.project.ID), http.StatusNotFound, ""},
+		{"unknown project", own.user, "/projects/999999/", http.StatusNotFound, ""},
+		{"invalid project ID", own.user, "/projects/abc/", http.StatusNotFound, ""},
+		{"signed out", nil, fmt.Sprintf("/projects/%d/", own.project.ID), http.StatusNotFound, ""},
+		{"own deployment", own.user, fmt.Sprintf("/projects/%d/deployments/%d", own.project.ID, own.deployment.ID), http.StatusOK,
+			fmt.Sprintf("owner %d %d 0", own.project.ID, own.deployment.ID)},
+		{"deployment of another project", own.user, fmt.Sprintf("/projects/%d/deployments/%d", own.project.ID, other.deployment.ID), http.StatusNotFound, ""},
+		{"own deployment without project", own.user, fmt.Sprintf("/deployments/%d", own.deployment.ID), http.StatusOK,
+			fmt.Sprintf("owner %d %d 0", own.project.ID, own.deployment.ID)},
+		{"other deployment without project", own.user, fmt.Sprintf("/deployments/%d", other.deployment.ID), http.StatusNotFound, ""},
+		{"own variable", own.user, fmt.Sprintf("/projects/%d/env/%d", own.project.ID, own.envVar.ID), http.StatusOK,
+			fmt.Sprintf("owner %d 0 %d", own.project.ID, own.envVar.ID)},
+		{"variable of another project", own.user, fmt.Sprintf("/projects/%d/env/%d", own.project.ID, other.envVar.ID), http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.user != nil {
+				req = req.WithContext(context.WithValue(req.Context(), userContextKey, tt.user))
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (%s)", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.body != "" && rec.Body.String() != tt.body {
+				t.Errorf("resolved %q, want %q", rec.Body.String(), tt.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,376 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"goth-deploy/internal/models"
+	"goth-deploy/internal/services"
+)
+
+// envValueMask replaces environment variable values in responses unless they are revealed
+const envValueMask = "********"
+
+// maxDotEnvSize limits the size of an imported .env file
+const maxDotEnvSize = 1 << 20
+
+// Ways a change of environment variables is applied, see applyEnvChange
+const (
+	envApplyRestart  = "restart"
+	envApplyRedeploy = "redeploy"
+)
+
+// GetEnvironmentVariablesHandler returns environment variables for a project. Values are masked
+// unless ?reveal=true is given, which requires the admin role.
+func (h *Handler) GetEnvironmentVariablesHandler(w http.ResponseWriter, r *http.Request) {
+	projectID := requestProjectID(r)
+
+	reveal := revealValues(r)
+	if reveal && !services.RoleAtLeast(requestRole(r), models.RoleAdmin) {
+		http.Error(w, "Forbidden: revealing values requires the admin role", http.StatusForbidden)
+		return
+	}
+
+	envVars, err := h.Deployment.ListEnvironmentVariables(projectID)
+	if err != nil {
+		log.Printf("Error getting environment variables: %v", err)
+		http.Error(w, "Failed to fetch environment variables", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]models.EnvironmentVariable, 0, len(envVars))
+	for _, envVar := range envVars {
+		response = append(response, maskEnvVar(envVar, reveal))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// CreateEnvironmentVariableHandler creates a new environment variable. The scope defaults to
+// both. ?apply=restart or ?apply=redeploy applies the change right away.
+func (h *Handler) CreateEnvironmentVariableHandler(w http.ResponseWriter, r *http.Request) {
+	projectID := requestProjectID(r)
+	apply, ok := envApply(w, r)
+	if !ok {
+		return
+	}
+
+	envVar := models.EnvironmentVariable{Scope: models.EnvScopeBoth}
+	if err := json.NewDecoder(r.Body).Decode(&envVar); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	envVar.ID = 0
+	envVar.ProjectID = projectID
+
+	if err := services.ValidateEnvironmentVariable(envVar); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.Deployment.CreateEnvironmentVariable(&envVar); err != nil {
+		h.envVarError(w, r, err)
+		return
+	}
+
+	log.Printf("Created environment variable %s for project %d", envVar.Key, projectID)
+
+	h.envVarSaved(w, r, projectID, envVar, "Environment variable created", envChangeRequires(envVar.Scope), apply)
+}
+
+// UpdateEnvironmentVariableHandler updates an environment variable. Fields missing from the
+// request body keep their current value, and so does the value if the mask is sent back.
+func (h *Handler) UpdateEnvironmentVariableHandler(w http.ResponseWriter, r *http.Request) {
+	projectID := requestProjectID(r)
+	envVarID := requestEnvVarID(r)
+	apply, ok := envApply(w, r)
+	if !ok {
+		return
+	}
+
+	envVar, err := h.Deployment.GetEnvironmentVariable(projectID, envVarID)
+	if err != nil {
+		h.envVarError(w, r, err)
+		return
+	}
+	previous := *envVar
+
+	if err := json.NewDecoder(r.Body).Decode(envVar); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	envVar.ID = envVarID
+	envVar.ProjectID = projectID
+	if envVar.Value == envValueMask {
+		envVar.Value = previous.Value
+	}
+
+	if err := services.ValidateEnvironmentVariable(*envVar); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.Deployment.UpdateEnvironmentVariable(envVar); err != nil {
+		h.envVarError(w, r, err)
+		return
+	}
+
+	log.Printf("Updated environment variable %d for project %d", envVarID, projectID)
+
+	// Moving a variable out of the build needs a redeploy as much as moving it in
+	requires := envChangeRequires(envVar.Scope)
+	if envChangeRequires(previous.Scope) == envApplyRedeploy {
+		requires = envApplyRedeploy
+	}
+	h.envVarSaved(w, r, projectID, *envVar, "Environment variable updated", requires, apply)
+}
+
+// DeleteEnvironmentVariableHandler deletes an environment variable
+func (h *Handler) DeleteEnvironmentVariableHandler(w http.ResponseWriter, r *http.Request) {
+	projectID := requestProjectID(r)
+	envVarID := requestEnvVarID(r)
+	apply, ok := envApply(w, r)
+	if !ok {
+		return
+	}
+
+	envVar, err := h.Deployment.GetEnvironmentVariable(projectID, envVarID)
+	if err != nil {
+		h.envVarError(w, r, err)
+		return
+	}
+	if err := h.Deployment.DeleteEnvironmentVariable(projectID, envVarID); err != nil {
+		h.envVarError(w, r, err)
+		return
+	}
+
+	log.Printf("Deleted environment variable %s of project %d", envVar.Key, projectID)
+
+	requires := envChangeRequires(envVar.Scope)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"message":  "Environment variable deleted" + h.applyEnvChange(projectID, requires, apply),
+		"requires": requires,
+	})
+}
+
+// ExportEnvironmentVariablesHandler returns a project's environment variables in .env format,
+// optionally only those applying to ?scope=build or ?scope=runtime
+func (h *Handler) ExportEnvironmentVariablesHandler(w http.ResponseWriter, r *http.Request) {
+	projectID := requestProjectID(r)
+
+	scope := r.URL.Query().Get("scope")
+	if scope != "" && scope != models.EnvScopeBuild && scope != models.EnvScopeRuntime {
+		http.Error(w, "scope must be build or runtime", http.StatusBadRequest)
+		return
+	}
+
+	envVars, err := h.Deployment.ListEnvironmentVariables(projectID)
+	if err != nil {
+		log.Printf("Error getting environment variables: %v", err)
+		http.Error(w, "Failed to fetch environment variables", http.StatusInternalServerError)
+		return
+	}
+
+	var entries []services.EnvEntry
+	for _, envVar := range envVars {
+		if scope == "" || envVar.Scope == scope || envVar.Scope == models.EnvScopeBoth {
+			entries = append(entries, services.EnvEntry{Key: envVar.Key, Value: envVar.Value})
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename=".env"`)
+	w.Write([]byte(services.FormatDotEnv(entries)))
+}
+
+// ImportEnvironmentVariablesHandler imports variables from a .env file sent as the request
+// body. ?preview=true only returns the changes the import would make. New variables get
+// ?scope (default both), existing ones keep their scope. With ?replace=true, variables missing
+// from the file are deleted. It also serves the versioned API.
+func (h *Handler) ImportEnvironmentVariablesHandler(w http.ResponseWriter, r *http.Request) {
+	projectID := requestProjectID(r)
+	apply, ok := envApply(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	preview := query.Get("preview") == "true"
+	replace := query.Get("replace") == "true"
+	scope := query.Get("scope")
+	if scope == "" {
+		scope = models.EnvScopeBoth
+	}
+	if err := services.ValidateEnvScope(scope); err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxDotEnvSize+1))
+	if err != nil {
+		writeError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxDotEnvSize {
+		writeError(w, r, ".env file is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	entries, err := services.ParseDotEnv(string(body))
+	if err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, entry := range entries {
+		if err := services.ValidateEnvironmentVariable(models.EnvironmentVariable{Key: entry.Key, Value: entry.Value, Scope: scope}); err != nil {
+			writeError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var changes []services.EnvChange
+	if preview {
+		changes, err = h.Deployment.DiffEnvironmentVariables(projectID, entries, scope, replace)
+	} else {
+		changes, err = h.Deployment.ImportEnvironmentVariables(projectID, entries, scope, replace)
+	}
+	if err != nil {
+		h.envVarError(w, r, err)
+		return
+	}
+
+	requires := ""
+	counts := map[string]int{}
+	reveal := revealValues(r)
+	for i := range changes {
+		counts[changes[i].Action]++
+		if changes[i].Action != services.EnvChangeUnchanged && requires != envApplyRedeploy {
+			requires = envChangeRequires(changes[i].Scope)
+		}
+		if !reveal {
+			changes[i].OldValue, changes[i].NewValue = "", ""
+		}
+	}
+	if changes == nil {
+		changes = []services.EnvChange{}
+	}
+
+	summary := fmt.Sprintf("%d added, %d changed, %d removed", counts[services.EnvChangeAdded], counts[services.EnvChangeChanged], counts[services.EnvChangeRemoved])
+	message := "Preview: " + summary
+	if !preview {
+		log.Printf("Imported environment variables for project %d: %s", projectID, summary)
+		message = "Imported environment variables: " + summary
+		if requires != "" {
+			message += h.applyEnvChange(projectID, requires, apply)
+		}
+	}
+
+	result := map[string]interface{}{
+		"preview":  preview,
+		"message":  message,
+		"changes":  changes,
+		"requires": requires,
+	}
+	if isAPIRequest(r) {
+		writeAPIData(w, http.StatusOK, result)
+		return
+	}
+	result["success"] = true
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// envVarSaved writes the response for a created or updated variable and applies the change
+func (h *Handler) envVarSaved(w http.ResponseWriter, r *http.Request, projectID int64, envVar models.EnvironmentVariable, message, requires, apply string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":              true,
+		"message":              message + h.applyEnvChange(projectID, requires, apply),
+		"environment_variable": maskEnvVar(envVar, revealValues(r)),
+		"requires":             requires,
+	})
+}
+
+// applyEnvChange restarts or redeploys a project after its variables changed, if requested,
+// and returns the end of the response message. Build variables only take effect on a redeploy,
+// a restart is enough for runtime variables.
+func (h *Handler) applyEnvChange(projectID int64, requires, apply string) string {
+	switch apply {
+	case envApplyRedeploy:
+		deployment, err := h.Deployment.DeployProject(projectID, "")
+		if err != nil {
+			log.Printf("Error redeploying project %d: %v", projectID, err)
+			return ", but the redeploy failed"
+		}
+		return fmt.Sprintf(", redeploying (deployment #%d)", deployment.ID)
+
+	case envApplyRestart:
+		project, err := h.Stores.Projects.Get(projectID)
+		if err != nil || !h.Deployment.IsProjectRunning(project.Subdomain) {
+			return ", the application is not running and picks up the change when it starts"
+		}
+		go func() {
+			if err := h.Deployment.RestartProject(projectID); err != nil {
+				log.Printf("Error restarting project %d: %v", projectID, err)
+			}
+		}()
+		if requires == envApplyRedeploy {
+			return ", restarting the application (build variables need a redeploy)"
+		}
+		return ", restarting the application"
+	}
+
+	if requires == envApplyRedeploy {
+		return ". Redeploy to apply the change"
+	}
+	return ". Restart or redeploy to apply the change"
+}
+
+// envChangeRequires returns what applies a change to a variable of the given scope
+func envChangeRequires(scope string) string {
+	if scope == models.EnvScopeRuntime {
+		return envApplyRestart
+	}
+	return envApplyRedeploy
+}
+
+// envApply parses the apply query parameter, writing an error response if it is invalid
+func envApply(w http.ResponseWriter, r *http.Request) (string, bool) {
+	apply := r.URL.Query().Get("apply")
+	switch apply {
+	case "", envApplyRestart, envApplyRedeploy:
+		return apply, true
+	}
+	writeError(w, r, "apply must be restart or redeploy", http.StatusBadRequest)
+	return "", false
+}
+
+// envVarError writes the response for an error of an environment variable operation
+func (h *Handler) envVarError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, services.ErrEnvVarNotFound):
+		writeError(w, r, "Environment variable not found", http.StatusNotFound)
+	case errors.Is(err, services.ErrEnvVarExists):
+		writeError(w, r, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("Error saving environment variables: %v", err)
+		writeError(w, r, "Failed to save environment variables", http.StatusInternalServerError)
+	}
+}
+
+// revealValues reports whether a request asked for unmasked values
+func revealValues(r *http.Request) bool {
+	return r.URL.Query().Get("reveal") == "true"
+}
+
+// maskEnvVar hides the value of a variable unless it is revealed
+func maskEnvVar(envVar models.EnvironmentVariable, reveal bool) models.EnvironmentVariable {
+	if !reveal {
+		envVar.Value = envValueMask
+	}
+	return envVar
+}
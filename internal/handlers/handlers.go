@@ -1,13 +1,16 @@
 package handlers
 
 import (
-	"database/sql"
 	"log"
 	"net/http"
+	"time"
 
 	"goth-deploy/internal/config"
+	"goth-deploy/internal/database"
 	"goth-deploy/internal/models"
+	"goth-deploy/internal/secrets"
 	"goth-deploy/internal/services"
+	"goth-deploy/internal/store"
 	"goth-deploy/web/templates"
 
 	"github.com/go-chi/chi/v5"
@@ -18,31 +21,42 @@ import (
 
 // Handler holds the dependencies for HTTP handlers
 type Handler struct {
-	DB         *sql.DB
+	Stores     *store.Stores
 	Config     *config.Config
+	Secrets    *secrets.Keyring
 	Store      *sessions.CookieStore
 	GitHub     *services.GitHubService
 	Deployment *services.DeploymentService
 	Proxy      *services.ProxyService
+	Webhooks   *services.WebhookService
+	Orgs       *services.OrganizationService
+	Tokens     *services.TokenService
 }
 
 // New creates a new handler instance
-func New(db *sql.DB, cfg *config.Config) *Handler {
-	store := sessions.NewCookieStore([]byte(cfg.SessionSecret))
+func New(db *database.DB, cfg *config.Config, keyring *secrets.Keyring) *Handler {
+	cookieStore := sessions.NewCookieStore([]byte(cfg.SessionSecret))
+	stores := store.NewSQL(db)
 	githubService := services.NewGitHubService(cfg.GitHubClientID, cfg.GitHubClientSecret, cfg.GitHubRedirectURL)
-	deploymentService := services.NewDeploymentService(db, cfg)
-	proxyService := services.NewProxyService(db, cfg)
+	deploymentService := services.NewDeploymentService(db, stores, cfg, keyring)
+	proxyService := services.NewProxyService(db, stores, cfg)
+	webhookService := services.NewWebhookService(db, stores, cfg, deploymentService, githubService)
+	orgService := services.NewOrganizationService(db, stores, githubService)
 
 	// Wire up the services - proxy service needs reference to deployment service
 	proxyService.SetDeploymentService(deploymentService)
 
 	return &Handler{
-		DB:         db,
+		Stores:     stores,
 		Config:     cfg,
-		Store:      store,
+		Secrets:    keyring,
+		Store:      cookieStore,
 		GitHub:     githubService,
 		Deployment: deploymentService,
 		Proxy:      proxyService,
+		Webhooks:   webhookService,
+		Orgs:       orgService,
+		Tokens:     services.NewTokenService(db),
 	}
 }
 
@@ -58,7 +72,7 @@ func (h *Handler) Routes() http.Handler {
 	// CORS middleware
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"*"}, // Be more restrictive in production
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
 		ExposedHeaders:   []string{"Link"},
 		AllowCredentials: true,
@@ -69,6 +83,12 @@ func (h *Handler) Routes() http.Handler {
 	r.Get("/", h.HomeHandler)
 	r.Get("/health", h.HealthHandler)
 
+	// GitHub webhooks (authenticated by signature)
+	r.Post("/webhooks/github", h.GitHubWebhookHandler)
+
+	// Versioned JSON API, authenticated by API token or session
+	r.Route("/api/v1", h.apiV1Routes)
+
 	// Auth routes
 	r.Route("/auth", func(r chi.Router) {
 		r.Get("/github", h.GitHubAuthHandler)
@@ -83,36 +103,120 @@ func (h *Handler) Routes() http.Handler {
 		// Dashboard
 		r.Get("/dashboard", h.DashboardHandler)
 
-		// Projects
+		// Projects. Routes under a project, deployment or environment variable ID only run once
+		// the access middlewares resolved it and checked the user's role on the project.
 		r.Route("/projects", func(r chi.Router) {
 			r.Get("/", h.ProjectsListHandler)
 			r.Get("/new", h.NewProjectHandler)
 			r.Post("/", h.CreateProjectHandler)
-			r.Get("/{id}", h.ProjectDetailsHandler)
-			r.Put("/{id}", h.UpdateProjectHandler)
-			r.Delete("/{id}", h.DeleteProjectHandler)
-			r.Post("/{id}/deploy", h.DeployProjectHandler)
+			r.Route("/{id}", func(r chi.Router) {
+				r.Use(h.ProjectAccess("id"))
+				r.Get("/", h.ProjectDetailsHandler)
+				r.Get("/logs", h.ProjectLogsHandler)
+				r.Group(func(r chi.Router) {
+					r.Use(h.RequireRole(models.RoleDeployer))
+					r.Post("/deploy", h.DeployProjectHandler)
+					r.With(h.DeploymentAccess("deploymentId")).Post("/deployments/{deploymentId}/rollback", h.RollbackDeploymentHandler)
+				})
+				r.Group(func(r chi.Router) {
+					r.Use(h.RequireRole(models.RoleAdmin))
+					r.Put("/", h.UpdateProjectHandler)
+					r.Post("/webhook/sync", h.SyncProjectWebhookHandler)
+				})
+				r.With(h.RequireRole(models.RoleOwner)).Delete("/", h.DeleteProjectHandler)
+			})
 		})
 
 		// Deployments
 		r.Route("/deployments", func(r chi.Router) {
 			r.Get("/", h.DeploymentsListHandler)
-			r.Get("/{id}", h.DeploymentDetailsHandler)
+			r.With(h.DeploymentAccess("id")).Get("/{id}", h.DeploymentDetailsHandler)
+		})
+
+		// Project API
+		r.Route("/api/projects/{projectId}", func(r chi.Router) {
+			r.Use(h.ProjectAccess("projectId"))
+
+			// Environment variables. Viewers only see the names.
+			r.Route("/env", func(r chi.Router) {
+				r.Get("/", h.GetEnvironmentVariablesHandler)
+				r.Group(func(r chi.Router) {
+					r.Use(h.RequireRole(models.RoleAdmin))
+					r.Post("/", h.CreateEnvironmentVariableHandler)
+					r.Get("/export", h.ExportEnvironmentVariablesHandler)
+					r.Post("/import", h.ImportEnvironmentVariablesHandler)
+					r.With(h.EnvVarAccess("id")).Put("/{id}", h.UpdateEnvironmentVariableHandler)
+					r.With(h.EnvVarAccess("id")).Delete("/{id}", h.DeleteEnvironmentVariableHandler)
+				})
+			})
+
+			// Health check settings
+			r.Get("/health", h.GetHealthCheckHandler)
+			r.With(h.RequireRole(models.RoleAdmin)).Put("/health", h.UpdateHealthCheckHandler)
+
+			// Restart policy and crash history
+			r.Get("/restart-policy", h.GetRestartPolicyHandler)
+			r.With(h.RequireRole(models.RoleAdmin)).Put("/restart-policy", h.UpdateRestartPolicyHandler)
+			r.Get("/exits", h.ProcessExitsHandler)
+
+			// Runtime logs
+			r.Get("/logs", h.ApplicationLogsHandler)
+			r.Get("/logs/stream", h.ApplicationLogStreamHandler)
+			r.Get("/log-retention", h.GetLogRetentionHandler)
+			r.With(h.RequireRole(models.RoleAdmin)).Put("/log-retention", h.UpdateLogRetentionHandler)
+
+			// Ownership
+			r.With(h.RequireRole(models.RoleOwner)).Put("/organization", h.TransferProjectHandler)
+		})
+
+		// Organizations
+		r.Route("/api/orgs", func(r chi.Router) {
+			r.Get("/", h.OrganizationsListHandler)
+			r.Post("/", h.CreateOrganizationHandler)
+			r.Route("/{orgId}", func(r chi.Router) {
+				r.Use(h.OrganizationAccess("orgId"))
+				r.Get("/", h.OrganizationDetailsHandler)
+				r.Get("/members", h.OrganizationMembersHandler)
+				r.Delete("/members/{userId}", h.RemoveOrganizationMemberHandler) // members may leave
+				r.Group(func(r chi.Router) {
+					r.Use(h.RequireRole(models.RoleAdmin))
+					r.Put("/members/{userId}", h.UpdateOrganizationMemberHandler)
+					r.Get("/invites", h.OrganizationInvitesHandler)
+					r.Post("/invites", h.CreateOrganizationInviteHandler)
+					r.Delete("/invites/{inviteId}", h.DeleteOrganizationInviteHandler)
+					r.Post("/sync", h.SyncOrganizationHandler)
+				})
+				r.Group(func(r chi.Router) {
+					r.Use(h.RequireRole(models.RoleOwner))
+					r.Put("/", h.UpdateOrganizationHandler)
+					r.Delete("/", h.DeleteOrganizationHandler)
+				})
+			})
 		})
 
-		// Environment Variables API
-		r.Route("/api/projects/{projectId}/env", func(r chi.Router) {
-			r.Get("/", h.GetEnvironmentVariablesHandler)
-			r.Post("/", h.CreateEnvironmentVariableHandler)
-			r.Put("/{id}", h.UpdateEnvironmentVariableHandler)
-			r.Delete("/{id}", h.DeleteEnvironmentVariableHandler)
+		// Invites addressed to the current user
+		r.Route("/api/invites", func(r chi.Router) {
+			r.Get("/", h.UserInvitesHandler)
+			r.Post("/{inviteId}/accept", h.AcceptInviteHandler)
+			r.Delete("/{inviteId}", h.DeclineInviteHandler)
+		})
+
+		// API tokens, managed with the session only
+		r.Route("/api/tokens", func(r chi.Router) {
+			r.Get("/", h.APITokensHandler)
+			r.Post("/", h.CreateAPITokenHandler)
+			r.Delete("/{tokenId}", h.RevokeAPITokenHandler)
 		})
 
 		// GitHub repos API
 		r.Get("/api/github/repos", h.GitHubReposHandler)
 
 		// Build logs
-		r.Get("/api/deployments/{id}/logs", h.BuildLogsHandler)
+		r.Route("/api/deployments/{id}", func(r chi.Router) {
+			r.Use(h.DeploymentAccess("id"))
+			r.Get("/logs", h.BuildLogsHandler)
+			r.Get("/logs/stream", h.BuildLogStreamHandler)
+		})
 	})
 
 	return r
@@ -174,45 +278,39 @@ func (h *Handler) getDashboardData(userID int64) (templates.DashboardData, error
 		BaseDomain: h.Config.BaseDomain,
 	}
 
+	access, err := h.userAccess(userID)
+	if err != nil {
+		return data, err
+	}
+
 	// Get projects count
-	err := h.DB.QueryRow("SELECT COUNT(*) FROM projects WHERE user_id = ?", userID).Scan(&data.TotalProjects)
+	data.TotalProjects, err = h.Stores.Projects.Count(store.ProjectFilter{Access: access})
 	if err != nil {
 		return data, err
 	}
 
 	// Get active projects count
-	err = h.DB.QueryRow("SELECT COUNT(*) FROM projects WHERE user_id = ? AND status = 'active'", userID).Scan(&data.ActiveProjects)
+	data.ActiveProjects, err = h.Stores.Projects.Count(store.ProjectFilter{Access: access, Statuses: []string{models.ProjectStatusActive}})
 	if err != nil {
 		return data, err
 	}
 
 	// Get deployments today count
-	err = h.DB.QueryRow(`
-		SELECT COUNT(*) FROM deployments d 
-		JOIN projects p ON d.project_id = p.id 
-		WHERE p.user_id = ? AND DATE(d.created_at) = DATE('now')
-	`, userID).Scan(&data.DeployedToday)
+	year, month, day := time.Now().Date()
+	today := time.Date(year, month, day, 0, 0, 0, 0, time.Local)
+	data.DeployedToday, err = h.Stores.Deployments.Count(store.DeploymentFilter{Access: access, Since: today})
 	if err != nil {
 		return data, err
 	}
 
 	// Calculate success rate
-	var totalDeployments, successfulDeployments int
-	err = h.DB.QueryRow(`
-		SELECT COUNT(*) FROM deployments d 
-		JOIN projects p ON d.project_id = p.id 
-		WHERE p.user_id = ?
-	`, userID).Scan(&totalDeployments)
+	totalDeployments, err := h.Stores.Deployments.Count(store.DeploymentFilter{Access: access})
 	if err != nil {
 		return data, err
 	}
 
 	if totalDeployments > 0 {
-		err = h.DB.QueryRow(`
-			SELECT COUNT(*) FROM deployments d 
-			JOIN projects p ON d.project_id = p.id 
-			WHERE p.user_id = ? AND d.status = 'success'
-		`, userID).Scan(&successfulDeployments)
+		successfulDeployments, err := h.Stores.Deployments.Count(store.DeploymentFilter{Access: access, Status: models.StatusSuccess})
 		if err != nil {
 			return data, err
 		}
@@ -222,40 +320,21 @@ func (h *Handler) getDashboardData(userID int64) (templates.DashboardData, error
 	}
 
 	// Get recent projects
-	rows, err := h.DB.Query(`
-		SELECT id, name, repo_url, branch, subdomain, status, last_deploy 
-		FROM projects 
-		WHERE user_id = ? 
-		ORDER BY created_at DESC 
-		LIMIT 10
-	`, userID)
+	data.Projects, err = h.Stores.Projects.List(store.ProjectFilter{Access: access, Limit: 10})
 	if err != nil {
 		return data, err
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var project models.Project
-		err := rows.Scan(
-			&project.ID,
-			&project.Name,
-			&project.RepoURL,
-			&project.Branch,
-			&project.Subdomain,
-			&project.Status,
-			&project.LastDeploy,
-		)
-		if err != nil {
-			return data, err
-		}
-		data.Projects = append(data.Projects, project)
-	}
 
 	return data, nil
 }
 
-// getCurrentUser retrieves the current user from session
+// getCurrentUser retrieves the current user from the request context set by AuthMiddleware or
+// APIAuthMiddleware, or from the session
 func (h *Handler) getCurrentUser(r *http.Request) *models.User {
+	if user, ok := r.Context().Value(userContextKey).(*models.User); ok {
+		return user
+	}
+
 	session, err := h.Store.Get(r, "goth-session")
 	if err != nil {
 		return nil
@@ -266,23 +345,21 @@ func (h *Handler) getCurrentUser(r *http.Request) *models.User {
 		return nil
 	}
 
-	var user models.User
-	err = h.DB.QueryRow(`
-		SELECT id, github_id, username, email, avatar_url, access_token, created_at, updated_at 
-		FROM users WHERE id = ?
-	`, userID).Scan(
-		&user.ID,
-		&user.GitHubID,
-		&user.Username,
-		&user.Email,
-		&user.AvatarURL,
-		&user.AccessToken,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+	return h.loadUser(userID)
+}
+
+// loadUser loads a user with the decrypted access token, or returns nil if the user cannot be
+// loaded
+func (h *Handler) loadUser(userID int64) *models.User {
+	user, err := h.Stores.Users.Get(userID)
 	if err != nil {
 		return nil
 	}
 
-	return &user
+	if user.AccessToken, err = h.Secrets.Decrypt(user.AccessToken); err != nil {
+		log.Printf("Error decrypting access token of user %d: %v", user.ID, err)
+		return nil
+	}
+
+	return user
 }
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// GetHealthCheckHandler returns the health check settings of a project
+func (h *Handler) GetHealthCheckHandler(w http.ResponseWriter, r *http.Request) {
+	projectID := requestProjectID(r)
+
+	hc, err := h.Deployment.GetHealthCheck(projectID)
+	if err != nil {
+		log.Printf("Error getting health check: %v", err)
+		http.Error(w, "Failed to fetch health check", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hc)
+}
+
+// UpdateHealthCheckHandler updates the health check settings of a project. Fields missing
+// from the request body keep their current value.
+func (h *Handler) UpdateHealthCheckHandler(w http.ResponseWriter, r *http.Request) {
+	projectID := requestProjectID(r)
+
+	hc, err := h.Deployment.GetHealthCheck(projectID)
+	if err != nil {
+		log.Printf("Error getting health check: %v", err)
+		http.Error(w, "Failed to fetch health check", http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&hc); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Deployment.UpdateHealthCheck(projectID, hc); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("Updated health check for project %d", projectID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":      true,
+		"message":      "Health check updated",
+		"health_check": hc,
+	})
+}
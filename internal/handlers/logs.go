@@ -0,0 +1,585 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"html/template"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"goth-deploy/internal/models"
+	"goth-deploy/internal/services"
+)
+
+// logStreamPollInterval is how often a stream checks whether a queued deployment has started
+const logStreamPollInterval = time.Second
+
+// logStreamHeartbeat keeps idle streams from being closed by proxies
+const logStreamHeartbeat = 15 * time.Second
+
+// logFieldPath matches the dotted field paths runtime logs can be filtered by
+var logFieldPath = regexp.MustCompile(`^[A-Za-z0-9_]+(\.[A-Za-z0-9_]+)*$`)
+
+// DeploymentDetailsHandler shows a deployment and tails its build log while it runs
+func (h *Handler) DeploymentDetailsHandler(w http.ResponseWriter, r *http.Request) {
+	deploymentID := requestDeploymentID(r)
+
+	deployment, err := h.Stores.Deployments.Get(deploymentID)
+	if err != nil {
+		log.Printf("Error getting deployment: %v", err)
+		http.Error(w, "Failed to fetch deployment", http.StatusInternalServerError)
+		return
+	}
+
+	commit := deployment.CommitSHA
+	if commit == "" {
+		commit = "latest"
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, `
+		<div class="max-w-4xl mx-auto py-8">
+			<h2 class="text-2xl font-bold mb-6">Deployment #%d</h2>
+			<dl class="grid grid-cols-2 gap-2 mb-6 text-sm text-gray-600">
+				<dt class="font-medium">Project</dt><dd>%s</dd>
+				<dt class="font-medium">Commit</dt><dd class="font-mono">%s</dd>
+				<dt class="font-medium">Started</dt><dd>%s</dd>
+				<dt class="font-medium">Status</dt><dd id="deployment-status">%s</dd>
+			</dl>
+			<pre id="build-log" class="bg-gray-900 text-gray-100 text-xs p-4 rounded overflow-auto" style="max-height: 32rem"></pre>
+			<a href="/dashboard" class="text-purple-600 hover:text-purple-500">← Back to Dashboard</a>
+		</div>
+		<script>
+			(function () {
+				var logEl = document.getElementById("build-log");
+				var statusEl = document.getElementById("deployment-status");
+				var source = new EventSource("/api/deployments/%d/logs/stream");
+				source.onmessage = function (e) {
+					var follow = logEl.scrollTop + logEl.clientHeight >= logEl.scrollHeight - 4;
+					logEl.appendChild(document.createTextNode(e.data + "\n"));
+					if (follow) {
+						logEl.scrollTop = logEl.scrollHeight;
+					}
+				};
+				source.addEventListener("done", function (e) {
+					statusEl.textContent = e.data;
+					source.close();
+				});
+			})();
+		</script>
+	`,
+		deploymentID,
+		html.EscapeString(requestProject(r).Name),
+		html.EscapeString(commit),
+		deployment.StartedAt.Format(time.RFC3339),
+		html.EscapeString(deployment.Status),
+		deploymentID,
+	)
+}
+
+// BuildLogStreamHandler streams the build log of a deployment as Server-Sent Events. Every
+// line is sent as a message with its line number as event ID, so a reconnecting client
+// resumes after the last line it received. A "done" event carrying the final deployment
+// status ends the stream.
+func (h *Handler) BuildLogStreamHandler(w http.ResponseWriter, r *http.Request) {
+	deploymentID := requestDeploymentID(r)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// Resume after the last line the client received
+	after := 0
+	if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+		after, _ = strconv.Atoi(lastID)
+	} else if param := r.URL.Query().Get("after"); param != "" {
+		after, _ = strconv.Atoi(param)
+	}
+	if after < 0 {
+		after = 0
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(logStreamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		backlog, lines, unsubscribe, live := h.Deployment.SubscribeBuildLog(deploymentID, after)
+		if !live {
+			deployment, err := h.Stores.Deployments.Get(deploymentID)
+			if err != nil {
+				log.Printf("Error getting deployment logs: %v", err)
+				return
+			}
+			status := deployment.Status
+
+			// A finished deployment is served from the saved log
+			if services.DeploymentFinished(status) {
+				buildLog, err := h.Stores.Deployments.BuildLog(deploymentID)
+				if err != nil {
+					log.Printf("Error getting deployment logs: %v", err)
+					return
+				}
+				for _, line := range services.BuildLogLines(buildLog, after) {
+					writeLogLine(w, line)
+				}
+				fmt.Fprintf(w, "event: done\ndata: %s\n\n", status)
+				flusher.Flush()
+				return
+			}
+
+			// Still queued, wait for a worker to pick it up
+			select {
+			case <-r.Context().Done():
+				return
+			case <-h.Deployment.LogStreamsClosed():
+				return
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": ping\n\n")
+				flusher.Flush()
+			case <-time.After(logStreamPollInterval):
+			}
+			continue
+		}
+
+		for _, line := range backlog {
+			writeLogLine(w, line)
+			after = line.Number
+		}
+		flusher.Flush()
+
+		if !h.followBuildLog(w, r, flusher, lines, heartbeat, &after) {
+			unsubscribe()
+			return
+		}
+		// The deployment finished or the client fell behind, either way pick up from the
+		// last line sent
+		unsubscribe()
+	}
+}
+
+// followBuildLog sends lines as they are published until the channel closes, and reports
+// false if the client went away or the platform is shutting down
+func (h *Handler) followBuildLog(w http.ResponseWriter, r *http.Request, flusher http.Flusher, lines <-chan services.LogLine, heartbeat *time.Ticker, after *int) bool {
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return true
+			}
+			writeLogLine(w, line)
+			*after = line.Number
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return false
+		case <-h.Deployment.LogStreamsClosed():
+			return false
+		}
+	}
+}
+
+// writeLogLine writes a log line as a Server-Sent Event. Carriage returns end an SSE line,
+// so only the text after the last one is sent, which is what a terminal would show.
+func writeLogLine(w http.ResponseWriter, line services.LogLine) {
+	text := line.Text
+	if i := strings.LastIndexByte(strings.TrimRight(text, "\r"), '\r'); i >= 0 {
+		text = text[i+1:]
+	}
+	text = strings.TrimRight(text, "\r")
+	fmt.Fprintf(w, "id: %d\ndata: %s\n\n", line.Number, text)
+}
+
+// ProjectLogsHandler shows the runtime logs of a project with filters, and follows new lines
+func (h *Handler) ProjectLogsHandler(w http.ResponseWriter, r *http.Request) {
+	project := requestProject(r)
+	projectID, projectName := project.ID, project.Name
+
+	query, err := parseLogQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lines, err := h.Deployment.GetApplicationLogs(projectID, query)
+	if err != nil {
+		log.Printf("Error getting application logs: %v", err)
+		http.Error(w, "Failed to fetch application logs", http.StatusInternalServerError)
+		return
+	}
+
+	params := r.URL.Query()
+	selected := func(param, value string) string {
+		if params.Get(param) == value {
+			return " selected"
+		}
+		return ""
+	}
+
+	// Follow new lines with the same filters, the time range only applies to the table
+	follow := r.URL.Query()
+	follow.Del("tail")
+	follow.Del("since")
+	follow.Del("until")
+	streamURL := fmt.Sprintf("/api/projects/%d/logs/stream?%s", projectID, follow.Encode())
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, `
+		<div class="max-w-6xl mx-auto py-8">
+			<h2 class="text-2xl font-bold mb-6">Logs of %s</h2>
+			<form class="flex flex-wrap gap-2 mb-4 text-sm" hx-get="/projects/%d/logs" hx-target="#main-content" hx-push-url="true">
+				<select name="stream" class="border rounded px-2 py-1">
+					<option value="">All streams</option>
+					<option value="stdout"%s>stdout</option>
+					<option value="stderr"%s>stderr</option>
+				</select>
+				<input name="level" value="%s" placeholder="Levels, e.g. warn,error" class="border rounded px-2 py-1">
+				<input name="grep" value="%s" placeholder="Pattern" class="border rounded px-2 py-1">
+				<input name="since" value="%s" placeholder="Since, e.g. 1h" class="border rounded px-2 py-1 w-32">
+				<input name="tail" value="%s" placeholder="Lines" class="border rounded px-2 py-1 w-20">
+				<button type="submit" class="bg-purple-600 text-white rounded px-3 py-1">Filter</button>
+			</form>
+			<div class="bg-gray-900 text-gray-100 text-xs rounded overflow-auto" style="max-height: 40rem" id="runtime-log">
+				<table class="w-full font-mono"><tbody id="runtime-log-lines">
+	`,
+		html.EscapeString(projectName),
+		projectID,
+		selected("stream", models.LogStreamStdout),
+		selected("stream", models.LogStreamStderr),
+		html.EscapeString(params.Get("level")),
+		html.EscapeString(params.Get("grep")),
+		html.EscapeString(params.Get("since")),
+		html.EscapeString(params.Get("tail")),
+	)
+	for _, line := range lines {
+		deployment := ""
+		if line.DeploymentID != nil {
+			deployment = fmt.Sprintf("#%d", *line.DeploymentID)
+		}
+		fmt.Fprintf(w, `<tr class="align-top"><td class="px-2 text-gray-400 whitespace-nowrap">%s</td><td class="px-2 text-gray-400">%s</td><td class="px-2">%s</td><td class="px-2 text-gray-400" title="instance %s">%s</td><td class="px-2 whitespace-pre-wrap">%s</td></tr>`,
+			line.Time.Format("2006-01-02 15:04:05.000"),
+			line.Stream,
+			html.EscapeString(line.Level),
+			html.EscapeString(line.Instance),
+			deployment,
+			html.EscapeString(line.Text),
+		)
+	}
+	fmt.Fprintf(w, `
+				</tbody></table>
+			</div>
+			<a href="/projects/%d" class="text-purple-600 hover:text-purple-500">← Back to Project</a>
+		</div>
+		<script>
+			(function () {
+				var container = document.getElementById("runtime-log");
+				var body = document.getElementById("runtime-log-lines");
+				container.scrollTop = container.scrollHeight;
+				var source = new EventSource("%s");
+				document.body.addEventListener("htmx:beforeSwap", function () { source.close(); }, { once: true });
+				source.onmessage = function (e) {
+					var line = JSON.parse(e.data);
+					var row = document.createElement("tr");
+					row.className = "align-top";
+					var cells = [
+						line.time.replace("T", " ").slice(0, 23),
+						line.stream,
+						line.level,
+						line.deployment_id ? "#" + line.deployment_id : "",
+						line.text
+					];
+					cells.forEach(function (text, i) {
+						var cell = document.createElement("td");
+						cell.className = i == 4 ? "px-2 whitespace-pre-wrap" : "px-2 text-gray-400";
+						cell.textContent = text;
+						row.appendChild(cell);
+					});
+					var follow = container.scrollTop + container.clientHeight >= container.scrollHeight - 4;
+					body.appendChild(row);
+					if (follow) {
+						container.scrollTop = container.scrollHeight;
+					}
+				};
+			})();
+		</script>
+	`, projectID, template.JSEscapeString(streamURL))
+}
+
+// ApplicationLogsHandler returns runtime log lines of a project. Query parameters:
+// stream (stdout or stderr, default both), level (comma separated), deployment, instance,
+// field.<path>=<value> (fields of JSON lines), tail (last N lines), since and until (RFC 3339
+// time or a duration like 15m meaning that long ago) and grep (regular expression).
+func (h *Handler) ApplicationLogsHandler(w http.ResponseWriter, r *http.Request) {
+	projectID := requestProjectID(r)
+
+	query, err := parseLogQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lines, err := h.Deployment.GetApplicationLogs(projectID, query)
+	if err != nil {
+		log.Printf("Error getting application logs: %v", err)
+		http.Error(w, "Failed to fetch application logs", http.StatusInternalServerError)
+		return
+	}
+	if lines == nil {
+		lines = []models.RuntimeLogLine{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lines)
+}
+
+// ApplicationLogStreamHandler follows the runtime logs of a project as Server-Sent Events.
+// It takes the filters of ApplicationLogsHandler except the time range, and tail to send the
+// last N lines first. Every line is sent as JSON with its time as event ID, a reconnecting
+// client resumes after Last-Event-ID. Both stream handlers also serve the versioned API.
+func (h *Handler) ApplicationLogStreamHandler(w http.ResponseWriter, r *http.Request) {
+	projectID := requestProjectID(r)
+
+	query, err := parseLogQuery(r)
+	if err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var lastSent time.Time
+	if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+		if lastSent, err = time.Parse(time.RFC3339Nano, lastID); err != nil {
+			writeError(w, r, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// Subscribe before reading the backlog so no line falls in between
+	lines, unsubscribe, err := h.Deployment.SubscribeApplicationLogs(projectID)
+	if err != nil {
+		log.Printf("Error following application logs: %v", err)
+		writeError(w, r, "Failed to follow application logs", http.StatusInternalServerError)
+		return
+	}
+	defer unsubscribe()
+
+	// Live lines are not filtered by time
+	live := query
+	live.Since, live.Until = time.Time{}, time.Time{}
+
+	var backlog []models.RuntimeLogLine
+	if !lastSent.IsZero() || query.Tail > 0 {
+		backlogQuery := query
+		if !lastSent.IsZero() {
+			backlogQuery.Since = lastSent
+		}
+		if backlog, err = h.Deployment.GetApplicationLogs(projectID, backlogQuery); err != nil {
+			log.Printf("Error getting application logs: %v", err)
+			writeError(w, r, "Failed to fetch application logs", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	for _, line := range backlog {
+		if line.Time.After(lastSent) {
+			writeRuntimeLogLine(w, line)
+			lastSent = line.Time
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(logStreamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				// Fell behind or the project was deleted, the client reconnects if it still can
+				return
+			}
+			if !line.Time.After(lastSent) || !live.Matches(line) {
+				continue
+			}
+			writeRuntimeLogLine(w, line)
+			lastSent = line.Time
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-h.Deployment.LogStreamsClosed():
+			return
+		}
+	}
+}
+
+// writeRuntimeLogLine writes a runtime log line as a Server-Sent Event
+func writeRuntimeLogLine(w http.ResponseWriter, line models.RuntimeLogLine) {
+	data, err := json.Marshal(line)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "id: %s\ndata: %s\n\n", line.Time.Format(time.RFC3339Nano), data)
+}
+
+// parseLogQuery reads a runtime log query from the request parameters
+func parseLogQuery(r *http.Request) (services.LogQuery, error) {
+	params := r.URL.Query()
+	var query services.LogQuery
+
+	switch stream := params.Get("stream"); stream {
+	case "", "all":
+	case models.LogStreamStdout, models.LogStreamStderr:
+		query.Stream = stream
+	default:
+		return query, fmt.Errorf("stream must be stdout, stderr or all")
+	}
+
+	if levels := params.Get("level"); levels != "" {
+		for _, level := range strings.Split(levels, ",") {
+			if level = strings.ToLower(strings.TrimSpace(level)); level != "" {
+				query.Levels = append(query.Levels, level)
+			}
+		}
+	}
+
+	if deployment := params.Get("deployment"); deployment != "" {
+		id, err := strconv.ParseInt(deployment, 10, 64)
+		if err != nil || id <= 0 {
+			return query, fmt.Errorf("deployment must be a deployment ID")
+		}
+		query.DeploymentID = id
+	}
+
+	query.Instance = params.Get("instance")
+
+	for key, values := range params {
+		path, ok := strings.CutPrefix(key, "field.")
+		if !ok {
+			continue
+		}
+		if !logFieldPath.MatchString(path) {
+			return query, fmt.Errorf("invalid field %q", path)
+		}
+		if query.Fields == nil {
+			query.Fields = map[string]string{}
+		}
+		query.Fields[path] = values[0]
+	}
+
+	if tail := params.Get("tail"); tail != "" {
+		n, err := strconv.Atoi(tail)
+		if err != nil || n < 0 {
+			return query, fmt.Errorf("tail must be a non-negative number")
+		}
+		query.Tail = n
+	}
+
+	var err error
+	if query.Since, err = parseLogTime(params.Get("since")); err != nil {
+		return query, fmt.Errorf("invalid since: %w", err)
+	}
+	if query.Until, err = parseLogTime(params.Get("until")); err != nil {
+		return query, fmt.Errorf("invalid until: %w", err)
+	}
+
+	if pattern := params.Get("grep"); pattern != "" {
+		if query.Grep, err = regexp.Compile(pattern); err != nil {
+			return query, fmt.Errorf("invalid grep pattern: %w", err)
+		}
+	}
+
+	return query, nil
+}
+
+// parseLogTime parses an RFC 3339 time, or a duration meaning that long ago
+func parseLogTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if ago, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-ago), nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// GetLogRetentionHandler returns the log retention settings of a project
+func (h *Handler) GetLogRetentionHandler(w http.ResponseWriter, r *http.Request) {
+	projectID := requestProjectID(r)
+
+	retention, err := h.Deployment.GetLogRetention(projectID)
+	if err != nil {
+		log.Printf("Error getting log retention: %v", err)
+		http.Error(w, "Failed to fetch log retention", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(retention)
+}
+
+// UpdateLogRetentionHandler updates the log retention settings of a project. Fields missing
+// from the request body keep their current value.
+func (h *Handler) UpdateLogRetentionHandler(w http.ResponseWriter, r *http.Request) {
+	projectID := requestProjectID(r)
+
+	retention, err := h.Deployment.GetLogRetention(projectID)
+	if err != nil {
+		log.Printf("Error getting log retention: %v", err)
+		http.Error(w, "Failed to fetch log retention", http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&retention); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := services.ValidateLogRetention(retention); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Deployment.UpdateLogRetention(projectID, retention); err != nil {
+		log.Printf("Error updating log retention: %v", err)
+		http.Error(w, "Failed to update log retention", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Updated log retention for project %d", projectID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":       true,
+		"message":       "Log retention updated",
+		"log_retention": retention,
+	})
+}
@@ -0,0 +1,472 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"goth-deploy/internal/models"
+	"goth-deploy/internal/services"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// OrganizationsListHandler returns the organizations of the current user with the user's role
+func (h *Handler) OrganizationsListHandler(w http.ResponseWriter, r *http.Request) {
+	user := h.getCurrentUser(r)
+
+	orgs, err := h.Orgs.ListOrganizations(user.ID)
+	if err != nil {
+		log.Printf("Error getting organizations: %v", err)
+		http.Error(w, "Failed to fetch organizations", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(orgs)
+}
+
+// CreateOrganizationHandler creates an organization owned by the current user. The slug is
+// derived from the name if it is missing, members synced from GitHub default to viewers.
+func (h *Handler) CreateOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	user := h.getCurrentUser(r)
+
+	org := models.Organization{GitHubSyncRole: models.RoleViewer}
+	if err := json.NewDecoder(r.Body).Decode(&org); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	org.ID = 0
+	org.Name = strings.TrimSpace(org.Name)
+	if org.Slug == "" {
+		org.Slug = slugify(org.Name)
+	}
+
+	if err := services.ValidateOrganization(org); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.Orgs.CreateOrganization(&org, user.ID); err != nil {
+		h.orgError(w, err)
+		return
+	}
+
+	log.Printf("Created organization %d (%s) for user %s", org.ID, org.Slug, user.Username)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":      true,
+		"message":      "Organization created",
+		"organization": org,
+	})
+}
+
+// OrganizationDetailsHandler returns an organization with the current user's role
+func (h *Handler) OrganizationDetailsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(requestOrganization(r))
+}
+
+// UpdateOrganizationHandler updates an organization's settings. Fields missing from the
+// request body keep their current value.
+func (h *Handler) UpdateOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	org := *requestOrganization(r)
+
+	if err := json.NewDecoder(r.Body).Decode(&org); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	org.ID = requestOrganization(r).ID
+	org.Role = requestRole(r)
+	org.Name = strings.TrimSpace(org.Name)
+
+	if err := services.ValidateOrganization(org); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.Orgs.UpdateOrganization(&org); err != nil {
+		h.orgError(w, err)
+		return
+	}
+
+	log.Printf("Updated organization %d", org.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":      true,
+		"message":      "Organization updated",
+		"organization": org,
+	})
+}
+
+// DeleteOrganizationHandler deletes an organization that no longer owns projects
+func (h *Handler) DeleteOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	org := requestOrganization(r)
+
+	if err := h.Orgs.DeleteOrganization(org.ID); err != nil {
+		h.orgError(w, err)
+		return
+	}
+
+	log.Printf("Deleted organization %d (%s)", org.ID, org.Slug)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Organization deleted",
+	})
+}
+
+// OrganizationMembersHandler returns the members of an organization
+func (h *Handler) OrganizationMembersHandler(w http.ResponseWriter, r *http.Request) {
+	members, err := h.Orgs.ListMembers(requestOrganization(r).ID)
+	if err != nil {
+		log.Printf("Error getting organization members: %v", err)
+		http.Error(w, "Failed to fetch members", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
+
+// UpdateOrganizationMemberHandler changes the role of a member. Only owners may make other
+// members owners or change the role of an owner.
+func (h *Handler) UpdateOrganizationMemberHandler(w http.ResponseWriter, r *http.Request) {
+	org := requestOrganization(r)
+	userID, current, ok := h.orgMember(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := services.ValidateRole(body.Role); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if (body.Role == models.RoleOwner || current == models.RoleOwner) && requestRole(r) != models.RoleOwner {
+		http.Error(w, "Forbidden: only owners can manage owners", http.StatusForbidden)
+		return
+	}
+
+	if err := h.Orgs.SetMemberRole(org.ID, userID, body.Role); err != nil {
+		h.orgError(w, err)
+		return
+	}
+
+	log.Printf("Changed role of user %d in organization %d from %s to %s", userID, org.ID, current, body.Role)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": fmt.Sprintf("Role changed to %s", body.Role),
+		"role":    body.Role,
+	})
+}
+
+// RemoveOrganizationMemberHandler removes a member from an organization. Every member may
+// leave, removing others requires the admin role, and removing an owner the owner role.
+func (h *Handler) RemoveOrganizationMemberHandler(w http.ResponseWriter, r *http.Request) {
+	org := requestOrganization(r)
+	userID, current, ok := h.orgMember(w, r)
+	if !ok {
+		return
+	}
+
+	if userID != h.getCurrentUser(r).ID {
+		required := models.RoleAdmin
+		if current == models.RoleOwner {
+			required = models.RoleOwner
+		}
+		if !services.RoleAtLeast(requestRole(r), required) {
+			http.Error(w, fmt.Sprintf("Forbidden: requires the %s role", required), http.StatusForbidden)
+			return
+		}
+	}
+
+	if err := h.Orgs.RemoveMember(org.ID, userID); err != nil {
+		h.orgError(w, err)
+		return
+	}
+
+	log.Printf("Removed user %d from organization %d", userID, org.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Member removed",
+	})
+}
+
+// OrganizationInvitesHandler returns the pending invites of an organization
+func (h *Handler) OrganizationInvitesHandler(w http.ResponseWriter, r *http.Request) {
+	invites, err := h.Orgs.ListInvites(requestOrganization(r).ID)
+	if err != nil {
+		log.Printf("Error getting organization invites: %v", err)
+		http.Error(w, "Failed to fetch invites", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invites)
+}
+
+// CreateOrganizationInviteHandler invites a GitHub user to an organization. The role defaults
+// to viewer, only owners may invite owners.
+func (h *Handler) CreateOrganizationInviteHandler(w http.ResponseWriter, r *http.Request) {
+	user := h.getCurrentUser(r)
+	org := requestOrganization(r)
+
+	invite := models.OrganizationInvite{Role: models.RoleViewer}
+	if err := json.NewDecoder(r.Body).Decode(&invite); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	invite.ID = 0
+	invite.OrganizationID = org.ID
+	invite.OrganizationName = org.Name
+	invite.Username = strings.TrimPrefix(strings.TrimSpace(invite.Username), "@")
+	invite.Source = models.MemberSourceManual
+	invite.InvitedBy = &user.ID
+
+	if err := services.ValidateGitHubUsername(invite.Username); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := services.ValidateRole(invite.Role); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if invite.Role == models.RoleOwner && requestRole(r) != models.RoleOwner {
+		http.Error(w, "Forbidden: only owners can invite owners", http.StatusForbidden)
+		return
+	}
+
+	if err := h.Orgs.CreateInvite(&invite); err != nil {
+		h.orgError(w, err)
+		return
+	}
+
+	log.Printf("User %s invited %s to organization %d as %s", user.Username, invite.Username, org.ID, invite.Role)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": fmt.Sprintf("Invited %s, the invite is accepted after signing in with GitHub", invite.Username),
+		"invite":  invite,
+	})
+}
+
+// DeleteOrganizationInviteHandler withdraws a pending invite
+func (h *Handler) DeleteOrganizationInviteHandler(w http.ResponseWriter, r *http.Request) {
+	inviteID, err := strconv.ParseInt(chi.URLParam(r, "inviteId"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invite not found", http.StatusNotFound)
+		return
+	}
+
+	if err := h.Orgs.DeleteInvite(requestOrganization(r).ID, inviteID); err != nil {
+		h.orgError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Invite withdrawn",
+	})
+}
+
+// SyncOrganizationHandler syncs the members of an organization with its GitHub organization,
+// using the current user's GitHub access
+func (h *Handler) SyncOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	user := h.getCurrentUser(r)
+	org := requestOrganization(r)
+
+	result, err := h.Orgs.SyncGitHubMembers(r.Context(), org.ID, user.AccessToken)
+	if err != nil {
+		h.orgError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": fmt.Sprintf("Synced with %s: %d added, %d removed, %d invited",
+			org.GitHubOrg, len(result.Added), len(result.Removed), len(result.Invited)),
+		"result": result,
+	})
+}
+
+// UserInvitesHandler returns the pending invites addressed to the current user
+func (h *Handler) UserInvitesHandler(w http.ResponseWriter, r *http.Request) {
+	invites, err := h.Orgs.ListUserInvites(h.getCurrentUser(r).Username)
+	if err != nil {
+		log.Printf("Error getting invites: %v", err)
+		http.Error(w, "Failed to fetch invites", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invites)
+}
+
+// AcceptInviteHandler accepts an invite addressed to the current user
+func (h *Handler) AcceptInviteHandler(w http.ResponseWriter, r *http.Request) {
+	user := h.getCurrentUser(r)
+	inviteID, err := strconv.ParseInt(chi.URLParam(r, "inviteId"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invite not found", http.StatusNotFound)
+		return
+	}
+
+	invite, err := h.Orgs.AcceptInvite(inviteID, user)
+	if err != nil {
+		h.orgError(w, err)
+		return
+	}
+
+	log.Printf("User %s joined organization %d", user.Username, invite.OrganizationID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":         true,
+		"message":         fmt.Sprintf("Joined %s", invite.OrganizationName),
+		"organization_id": invite.OrganizationID,
+	})
+}
+
+// DeclineInviteHandler declines an invite addressed to the current user
+func (h *Handler) DeclineInviteHandler(w http.ResponseWriter, r *http.Request) {
+	inviteID, err := strconv.ParseInt(chi.URLParam(r, "inviteId"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invite not found", http.StatusNotFound)
+		return
+	}
+
+	if err := h.Orgs.DeclineInvite(inviteID, h.getCurrentUser(r)); err != nil {
+		h.orgError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Invite declined",
+	})
+}
+
+// TransferProjectHandler moves a project into one of the current user's organizations, or
+// makes it a personal project of the current user if organization_id is null. Moving a
+// project into an organization requires the admin role there.
+func (h *Handler) TransferProjectHandler(w http.ResponseWriter, r *http.Request) {
+	user := h.getCurrentUser(r)
+	projectID := requestProjectID(r)
+
+	var body struct {
+		OrganizationID *int64 `json:"organization_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	message := "Project is now a personal project"
+	if body.OrganizationID != nil {
+		role, err := h.Orgs.MemberRole(*body.OrganizationID, user.ID)
+		if err != nil {
+			h.orgError(w, err)
+			return
+		}
+		if role == "" {
+			http.Error(w, "Organization not found", http.StatusNotFound)
+			return
+		}
+		if !services.RoleAtLeast(role, models.RoleAdmin) {
+			http.Error(w, "Forbidden: requires the admin role in the organization", http.StatusForbidden)
+			return
+		}
+		message = "Project transferred to the organization"
+	}
+
+	if err := h.Orgs.TransferProject(projectID, body.OrganizationID, user.ID); err != nil {
+		h.orgError(w, err)
+		return
+	}
+
+	log.Printf("User %s transferred project %d to organization %v", user.Username, projectID, body.OrganizationID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":         true,
+		"message":         message,
+		"organization_id": body.OrganizationID,
+	})
+}
+
+// orgMember resolves the member named by the userId URL parameter and returns the member's
+// role, writing an error response if the user is not a member
+func (h *Handler) orgMember(w http.ResponseWriter, r *http.Request) (int64, string, bool) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+	if err != nil {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return 0, "", false
+	}
+
+	role, err := h.Orgs.MemberRole(requestOrganization(r).ID, userID)
+	if err != nil {
+		h.orgError(w, err)
+		return 0, "", false
+	}
+	if role == "" {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return 0, "", false
+	}
+	return userID, role, true
+}
+
+// orgError writes the response for an error of an organization operation
+func (h *Handler) orgError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrOrgNotFound):
+		http.Error(w, "Organization not found", http.StatusNotFound)
+	case errors.Is(err, services.ErrMemberNotFound):
+		http.Error(w, "Member not found", http.StatusNotFound)
+	case errors.Is(err, services.ErrInviteNotFound):
+		http.Error(w, "Invite not found", http.StatusNotFound)
+	case errors.Is(err, services.ErrOrgExists), errors.Is(err, services.ErrOrgHasProjects),
+		errors.Is(err, services.ErrMemberExists), errors.Is(err, services.ErrInviteExists),
+		errors.Is(err, services.ErrLastOwner):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, services.ErrGitHubSyncDisabled):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("Error managing organization: %v", err)
+		http.Error(w, "Failed to update organization", http.StatusInternalServerError)
+	}
+}
+
+// slugNonAlphanumeric matches runs of characters not allowed in slugs
+var slugNonAlphanumeric = regexp.MustCompile(`[^a-z0-9]+`)
+
+// slugify derives a slug from a name
+func slugify(name string) string {
+	slug := strings.Trim(slugNonAlphanumeric.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if len(slug) > 63 {
+		slug = strings.TrimRight(slug[:63], "-")
+	}
+	return slug
+}
@@ -1,9 +1,10 @@
 package handlers
 
 import (
+	"context"
 	"crypto/rand"
-	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math/big"
@@ -11,8 +12,10 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
-	"time"
 
+	"goth-deploy/internal/models"
+	"goth-deploy/internal/services"
+	"goth-deploy/internal/store"
 	"goth-deploy/web/templates"
 
 	"github.com/go-chi/chi/v5"
@@ -146,62 +149,36 @@ func (h *Handler) CreateProjectHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate subdomain format
-	if !isValidSubdomain(subdomain) {
-		http.Error(w, "Invalid subdomain format", http.StatusBadRequest)
-		return
-	}
-
-	// Check if subdomain is already taken
-	if exists, err := h.subdomainExists(subdomain); err != nil {
-		log.Printf("Error checking subdomain: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
-	} else if exists {
-		http.Error(w, "Subdomain already exists", http.StatusConflict)
-		return
+	project := &models.Project{
+		Name:         name,
+		GitHubRepoID: githubRepoID,
+		RepoURL:      repoURL,
+		Branch:       branch,
+		Subdomain:    subdomain,
+		BuildCommand: buildCommand,
+		StartCommand: startCommand,
+		Port:         port,
 	}
-
-	// Generate a unique port for this project
-	projectPort, err := h.generateUniquePort()
-	if err != nil {
-		log.Printf("Error generating unique port: %v", err)
-		// Fall back to provided port if generation fails
-		projectPort = port
+	if orgIDStr := r.FormValue("organization_id"); orgIDStr != "" {
+		orgID, err := strconv.ParseInt(orgIDStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid organization ID", http.StatusBadRequest)
+			return
+		}
+		project.OrganizationID = &orgID
 	}
 
-	// Create project in database
-	result, err := h.DB.Exec(`
-		INSERT INTO projects (
-			user_id, name, github_repo_id, repo_url, branch, subdomain, 
-			build_command, start_command, port, status, created_at, updated_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, 'inactive', ?, ?)
-	`, user.ID, name, githubRepoID, repoURL, branch, subdomain, buildCommand, startCommand, projectPort, time.Now(), time.Now())
-
-	if err != nil {
+	if _, err := h.createProject(r.Context(), user, project); err != nil {
+		var invalid *projectError
+		if errors.As(err, &invalid) {
+			http.Error(w, invalid.message, invalid.status)
+			return
+		}
 		log.Printf("Error creating project: %v", err)
 		http.Error(w, "Failed to create project", http.StatusInternalServerError)
 		return
 	}
 
-	projectID, err := result.LastInsertId()
-	if err != nil {
-		log.Printf("Error getting project ID: %v", err)
-		http.Error(w, "Failed to create project", http.StatusInternalServerError)
-		return
-	}
-
-	log.Printf("Created project %d for user %s: %s", projectID, user.Username, name)
-
-	// Trigger initial deployment
-	deployment, err := h.Deployment.DeployProject(projectID, "")
-	if err != nil {
-		log.Printf("Error starting initial deployment: %v", err)
-		// Don't return error here, project was created successfully
-	} else {
-		log.Printf("Started initial deployment %d for project %d", deployment.ID, projectID)
-	}
-
 	// Return success response
 	if r.Header.Get("HX-Request") == "true" {
 		w.Header().Set("Content-Type", "application/json")
@@ -209,7 +186,7 @@ func (h *Handler) CreateProjectHandler(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"success":    true,
 			"message":    "Project created successfully",
-			"project_id": projectID,
+			"project_id": project.ID,
 		})
 		return
 	}
@@ -263,30 +240,12 @@ func (h *Handler) DeleteProjectHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	projectIDStr := chi.URLParam(r, "id")
-	projectID, err := strconv.ParseInt(projectIDStr, 10, 64)
-	if err != nil {
-		log.Printf("Invalid project ID: %v", err)
-		http.Error(w, "Invalid project ID", http.StatusBadRequest)
-		return
-	}
-
-	// Verify user owns this project
-	var ownerID int64
-	err = h.DB.QueryRow("SELECT user_id FROM projects WHERE id = ?", projectID).Scan(&ownerID)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "Project not found", http.StatusNotFound)
-		} else {
-			log.Printf("Error checking project ownership: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		}
-		return
-	}
+	projectID := requestProjectID(r)
 
-	if ownerID != user.ID {
-		http.Error(w, "Forbidden", http.StatusForbidden)
-		return
+	// Remove the push webhook before the project row (and its hook ID) is gone
+	if err := h.Webhooks.RemoveProjectHook(r.Context(), projectID, user.AccessToken); err != nil {
+		log.Printf("Error removing webhook for project %d: %v", projectID, err)
+		// Don't block deletion on GitHub being unreachable
 	}
 
 	// Delete the project using deployment service
@@ -320,43 +279,70 @@ func (h *Handler) DeployProjectHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	projectIDStr := chi.URLParam(r, "id")
-	projectID, err := strconv.ParseInt(projectIDStr, 10, 64)
+	projectID := requestProjectID(r)
+
+	log.Printf("Deploy project request for project %d from user %s", projectID, user.Username)
+
+	// Trigger deployment (use latest commit)
+	deployment, err := h.Deployment.DeployProject(projectID, "")
 	if err != nil {
-		log.Printf("Invalid project ID: %v", err)
-		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		log.Printf("Error deploying project: %v", err)
+		http.Error(w, "Failed to deploy project", http.StatusInternalServerError)
 		return
 	}
 
-	// Verify user owns this project
-	var ownerID int64
-	err = h.DB.QueryRow("SELECT user_id FROM projects WHERE id = ?", projectID).Scan(&ownerID)
+	log.Printf("Started deployment %d for project %d", deployment.ID, projectID)
+
+	// For HTMX requests, return success message
+	if r.Header.Get("HX-Request") == "true" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":       true,
+			"message":       "Deployment started",
+			"deployment_id": deployment.ID,
+		})
+		return
+	}
+
+	// For regular requests, redirect to dashboard
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+// RollbackDeploymentHandler rolls a project back to one of its earlier successful deployments
+func (h *Handler) RollbackDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	user := h.getCurrentUser(r)
+	if user == nil {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	projectID := requestProjectID(r)
+	deploymentID := requestDeploymentID(r)
+
+	// Only successful deployments can be rolled back to
+	target, err := h.Stores.Deployments.Get(deploymentID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "Project not found", http.StatusNotFound)
-		} else {
-			log.Printf("Error checking project ownership: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		}
+		log.Printf("Error getting deployment: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	if ownerID != user.ID {
-		http.Error(w, "Forbidden", http.StatusForbidden)
+	if target.Status != models.StatusSuccess {
+		http.Error(w, "Only successful deployments can be rolled back to", http.StatusConflict)
 		return
 	}
 
-	log.Printf("Deploy project request for project %d from user %s", projectID, user.Username)
+	log.Printf("Rollback request for project %d to deployment %d from user %s", projectID, deploymentID, user.Username)
 
-	// Trigger deployment (use latest commit)
-	deployment, err := h.Deployment.DeployProject(projectID, "")
+	deployment, err := h.Deployment.RollbackProject(projectID, deploymentID)
 	if err != nil {
-		log.Printf("Error deploying project: %v", err)
-		http.Error(w, "Failed to deploy project", http.StatusInternalServerError)
+		log.Printf("Error rolling back project: %v", err)
+		http.Error(w, "Failed to roll back project", http.StatusInternalServerError)
 		return
 	}
 
-	log.Printf("Started deployment %d for project %d", deployment.ID, projectID)
+	log.Printf("Started rollback deployment %d for project %d", deployment.ID, projectID)
 
 	// For HTMX requests, return success message
 	if r.Header.Get("HX-Request") == "true" {
@@ -364,16 +350,95 @@ func (h *Handler) DeployProjectHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"success":       true,
-			"message":       "Deployment started",
+			"message":       fmt.Sprintf("Rolling back to deployment #%d", deploymentID),
 			"deployment_id": deployment.ID,
 		})
 		return
 	}
 
-	// For regular requests, redirect to dashboard
 	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
 }
 
+// projectError is a reason a project cannot be created that the user has to fix
+type projectError struct {
+	status  int
+	message string
+}
+
+func (e *projectError) Error() string {
+	return e.message
+}
+
+// createProject validates and saves a new project of the user, registers its push webhook and
+// starts the initial deployment, which is returned unless it failed to start. Invalid projects
+// are reported as a *projectError.
+func (h *Handler) createProject(ctx context.Context, user *models.User, project *models.Project) (*models.Deployment, error) {
+	// Projects created in an organization need the admin role there
+	if project.OrganizationID != nil {
+		role, err := h.Orgs.MemberRole(*project.OrganizationID, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if role == "" {
+			return nil, &projectError{http.StatusNotFound, "Organization not found"}
+		}
+		if !services.RoleAtLeast(role, models.RoleAdmin) {
+			return nil, &projectError{http.StatusForbidden, "Forbidden: creating organization projects requires the admin role"}
+		}
+	}
+
+	// Projects of the same repository share a webhook, so the repository must be known
+	if project.GitHubRepoID <= 0 {
+		return nil, &projectError{http.StatusBadRequest, "Invalid GitHub repository ID"}
+	}
+
+	// Validate subdomain format
+	if !isValidSubdomain(project.Subdomain) {
+		return nil, &projectError{http.StatusBadRequest, "Invalid subdomain format"}
+	}
+
+	// Check if subdomain is already taken
+	if exists, err := h.subdomainExists(project.Subdomain); err != nil {
+		return nil, fmt.Errorf("failed to check subdomain: %w", err)
+	} else if exists {
+		return nil, &projectError{http.StatusConflict, "Subdomain already exists"}
+	}
+
+	// Generate a unique port for this project
+	projectPort, err := h.generateUniquePort()
+	if err != nil {
+		log.Printf("Error generating unique port: %v", err)
+		// Fall back to provided port if generation fails
+		projectPort = project.Port
+	}
+	project.Port = projectPort
+
+	// Create project in database
+	project.UserID = user.ID
+	if err := h.Stores.Projects.Create(project); err != nil {
+		return nil, fmt.Errorf("failed to create project: %w", err)
+	}
+
+	log.Printf("Created project %d for user %s: %s", project.ID, user.Username, project.Name)
+
+	// Register the push webhook so future commits deploy automatically
+	if err := h.Webhooks.RegisterProjectHook(ctx, project.ID, user.AccessToken); err != nil {
+		log.Printf("Error registering webhook for project %d: %v", project.ID, err)
+		// Don't return error here, the webhook can be re-synced later
+	}
+
+	// Trigger initial deployment
+	deployment, err := h.Deployment.DeployProject(project.ID, "")
+	if err != nil {
+		log.Printf("Error starting initial deployment: %v", err)
+		// Don't return error here, project was created successfully
+		return nil, nil
+	}
+	log.Printf("Started initial deployment %d for project %d", deployment.ID, project.ID)
+
+	return deployment, nil
+}
+
 // Helper functions
 
 // isValidSubdomain checks if a subdomain is valid (alphanumeric and hyphens only)
@@ -388,20 +453,21 @@ func isValidSubdomain(subdomain string) bool {
 
 // subdomainExists checks if a subdomain is already taken
 func (h *Handler) subdomainExists(subdomain string) (bool, error) {
-	var count int
-	err := h.DB.QueryRow("SELECT COUNT(*) FROM projects WHERE subdomain = ?", subdomain).Scan(&count)
+	_, err := h.Stores.Projects.GetBySubdomain(subdomain)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
 	if err != nil {
 		return false, err
 	}
-	return count > 0, nil
+	return true, nil
 }
 
 // generateUniquePort generates a unique port for a project
 func (h *Handler) generateUniquePort() (int, error) {
 	// Start from port 8081 and find the next available port
 	for port := 8081; port <= 9000; port++ {
-		var count int
-		err := h.DB.QueryRow("SELECT COUNT(*) FROM projects WHERE port = ?", port).Scan(&count)
+		count, err := h.Stores.Projects.Count(store.ProjectFilter{Port: port})
 		if err != nil {
 			return 0, err
 		}
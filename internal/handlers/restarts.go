@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// processExitsLimit is how many process exits the exits API returns
+const processExitsLimit = 50
+
+// GetRestartPolicyHandler returns the restart policy of a project
+func (h *Handler) GetRestartPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	projectID := requestProjectID(r)
+
+	policy, err := h.Deployment.GetRestartPolicy(projectID)
+	if err != nil {
+		log.Printf("Error getting restart policy: %v", err)
+		http.Error(w, "Failed to fetch restart policy", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policy)
+}
+
+// UpdateRestartPolicyHandler updates the restart policy of a project. Fields missing from
+// the request body keep their current value.
+func (h *Handler) UpdateRestartPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	projectID := requestProjectID(r)
+
+	policy, err := h.Deployment.GetRestartPolicy(projectID)
+	if err != nil {
+		log.Printf("Error getting restart policy: %v", err)
+		http.Error(w, "Failed to fetch restart policy", http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Deployment.UpdateRestartPolicy(projectID, policy); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("Updated restart policy for project %d", projectID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":        true,
+		"message":        "Restart policy updated",
+		"restart_policy": policy,
+	})
+}
+
+// ProcessExitsHandler returns the most recent exits and crashes of a project's application
+func (h *Handler) ProcessExitsHandler(w http.ResponseWriter, r *http.Request) {
+	projectID := requestProjectID(r)
+
+	exits, err := h.Deployment.GetProcessExits(projectID, processExitsLimit)
+	if err != nil {
+		log.Printf("Error getting process exits: %v", err)
+		http.Error(w, "Failed to fetch process exits", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(exits)
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"goth-deploy/internal/models"
+	"goth-deploy/internal/services"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// defaultTokenLifetime is how long API tokens stay valid unless another lifetime is requested
+const defaultTokenLifetime = 30 * 24 * time.Hour
+
+// APITokensHandler returns the API tokens of the current user, without their secrets
+func (h *Handler) APITokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := h.getCurrentUser(r)
+
+	tokens, err := h.Tokens.ListTokens(user.ID)
+	if err != nil {
+		log.Printf("Error getting API tokens: %v", err)
+		http.Error(w, "Failed to fetch API tokens", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// CreateAPITokenHandler creates an API token for the current user from a body of name, scopes
+// and expires_in_days (default 30). The token is only returned in this response.
+func (h *Handler) CreateAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	user := h.getCurrentUser(r)
+
+	var body struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays *int     `json:"expires_in_days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	lifetime := defaultTokenLifetime
+	if body.ExpiresInDays != nil {
+		lifetime = time.Duration(*body.ExpiresInDays) * 24 * time.Hour
+	}
+
+	token := models.APIToken{UserID: user.ID, Name: strings.TrimSpace(body.Name), Scopes: body.Scopes}
+	if err := services.ValidateToken(token, lifetime); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	secret, err := h.Tokens.CreateToken(&token, lifetime)
+	if err != nil {
+		log.Printf("Error creating API token: %v", err)
+		http.Error(w, "Failed to create API token", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Created API token %d (%s) for user %s", token.ID, token.Prefix, user.Username)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"message":   "API token created. Copy it now, it is not shown again",
+		"api_token": token,
+		"token":     secret,
+	})
+}
+
+// RevokeAPITokenHandler revokes an API token of the current user
+func (h *Handler) RevokeAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	user := h.getCurrentUser(r)
+
+	tokenID, err := strconv.ParseInt(chi.URLParam(r, "tokenId"), 10, 64)
+	if err != nil {
+		http.Error(w, "API token not found", http.StatusNotFound)
+		return
+	}
+
+	if err := h.Tokens.RevokeToken(user.ID, tokenID); err != nil {
+		if errors.Is(err, services.ErrTokenNotFound) {
+			http.Error(w, "API token not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error revoking API token: %v", err)
+		http.Error(w, "Failed to revoke API token", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Revoked API token %d of user %s", tokenID, user.Username)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "API token revoked",
+	})
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"mime"
+	"net/http"
+
+	"github.com/google/go-github/v66/github"
+)
+
+// maxWebhookPayload is the largest webhook body GitHub will send (25 MB)
+const maxWebhookPayload = 25 << 20
+
+// GitHubWebhookHandler receives GitHub webhook deliveries and deploys matching projects on push
+func (h *Handler) GitHubWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if h.Config.GitHubWebhookSecret == "" {
+		log.Printf("Rejected webhook delivery: GITHUB_WEBHOOK_SECRET is not configured")
+		http.Error(w, "Webhooks are not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	signature := r.Header.Get(github.SHA256SignatureHeader)
+	if signature == "" {
+		http.Error(w, "Missing signature", http.StatusUnauthorized)
+		return
+	}
+
+	contentType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		http.Error(w, "Invalid content type", http.StatusBadRequest)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxWebhookPayload)
+	payload, err := github.ValidatePayloadFromBody(contentType, r.Body, signature, []byte(h.Config.GitHubWebhookSecret))
+	if err != nil {
+		log.Printf("Rejected webhook delivery: %v", err)
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	eventType := github.WebHookType(r)
+	deliveryID := github.DeliveryID(r)
+	if deliveryID == "" {
+		http.Error(w, "Missing delivery ID", http.StatusBadRequest)
+		return
+	}
+
+	duplicate, err := h.Webhooks.RecordDelivery(deliveryID, eventType)
+	if err != nil {
+		log.Printf("Error recording webhook delivery: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if duplicate {
+		log.Printf("Ignoring duplicate webhook delivery %s", deliveryID)
+		writeWebhookResponse(w, http.StatusOK, map[string]interface{}{"status": "duplicate"})
+		return
+	}
+
+	switch eventType {
+	case "ping":
+		writeWebhookResponse(w, http.StatusOK, map[string]interface{}{"status": "pong"})
+
+	case "push":
+		event, err := github.ParseWebHook(eventType, payload)
+		if err != nil {
+			h.Webhooks.ForgetDelivery(deliveryID)
+			http.Error(w, "Invalid payload", http.StatusBadRequest)
+			return
+		}
+
+		deployments, err := h.Webhooks.HandlePush(event.(*github.PushEvent))
+		if err != nil {
+			log.Printf("Error handling push delivery %s: %v", deliveryID, err)
+			// Allow GitHub to redeliver so the projects that failed are deployed on retry
+			h.Webhooks.ForgetDelivery(deliveryID)
+			http.Error(w, "Failed to trigger deployments", http.StatusInternalServerError)
+			return
+		}
+
+		deploymentIDs := make([]int64, 0, len(deployments))
+		for _, deployment := range deployments {
+			deploymentIDs = append(deploymentIDs, deployment.ID)
+		}
+
+		writeWebhookResponse(w, http.StatusAccepted, map[string]interface{}{
+			"status":      "accepted",
+			"deployments": deploymentIDs,
+		})
+
+	default:
+		writeWebhookResponse(w, http.StatusOK, map[string]interface{}{"status": "ignored", "event": eventType})
+	}
+}
+
+// writeWebhookResponse writes a JSON response for a webhook delivery
+func writeWebhookResponse(w http.ResponseWriter, status int, body map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// SyncProjectWebhookHandler re-creates or repairs the GitHub webhook of a project
+func (h *Handler) SyncProjectWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	user := h.getCurrentUser(r)
+	if user == nil {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	projectID := requestProjectID(r)
+
+	hookID, err := h.Webhooks.SyncProjectHook(r.Context(), projectID, user.AccessToken)
+	if err != nil {
+		log.Printf("Error syncing webhook for project %d: %v", projectID, err)
+		http.Error(w, "Failed to sync webhook", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"message":    "Webhook synced",
+		"webhook_id": hookID,
+	})
+}
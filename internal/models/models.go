@@ -28,23 +28,46 @@ type Project struct {
 	BuildCommand string     `json:"build_command" db:"build_command"`
 	StartCommand string     `json:"start_command" db:"start_command"`
 	Port         int        `json:"port" db:"port"`
-	Status       string     `json:"status" db:"status"` // active, inactive, building, failed
+	Status       string     `json:"status" db:"status"` // active, inactive, building, failed, unhealthy
 	LastDeploy   *time.Time `json:"last_deploy" db:"last_deploy"`
-	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
-	UpdatedAt    time.Time  `json:"updated_at" db:"updated_at"`
+	WebhookID    *int64     `json:"webhook_id" db:"webhook_id"` // GitHub push hook, shared by projects on the same repository
+	// OrganizationID is set for projects owned by an organization; UserID is then only the creator
+	OrganizationID *int64 `json:"organization_id" db:"organization_id"`
+	// ActiveDeploymentID is the release currently serving traffic; Port is that release's port
+	ActiveDeploymentID *int64        `json:"active_deployment_id" db:"active_deployment_id"`
+	HealthCheck        HealthCheck   `json:"health_check"`
+	RestartPolicy      RestartPolicy `json:"restart_policy"`
+	LogRetention       LogRetention  `json:"log_retention"`
+	CreatedAt          time.Time     `json:"created_at" db:"created_at"`
+	UpdatedAt          time.Time     `json:"updated_at" db:"updated_at"`
+}
+
+// HealthCheck configures how a project's application is probed for readiness and liveness.
+// An empty Path only checks that the application accepts TCP connections.
+type HealthCheck struct {
+	Path             string `json:"path" db:"health_check_path"`
+	ExpectedStatus   int    `json:"expected_status" db:"health_check_status"`
+	Interval         int    `json:"interval" db:"health_check_interval"` // seconds between liveness checks
+	Timeout          int    `json:"timeout" db:"health_check_timeout"`   // seconds per check
+	FailureThreshold int    `json:"failure_threshold" db:"health_check_threshold"`
+	RestartOnFailure bool   `json:"restart_on_failure" db:"health_check_restart"`
 }
 
 // Deployment represents a single deployment of a project
 type Deployment struct {
-	ID         int64      `json:"id" db:"id"`
-	ProjectID  int64      `json:"project_id" db:"project_id"`
-	CommitSHA  string     `json:"commit_sha" db:"commit_sha"`
-	Status     string     `json:"status" db:"status"` // pending, building, success, failed
-	BuildLog   string     `json:"build_log" db:"build_log"`
-	ErrorMsg   string     `json:"error_msg" db:"error_msg"`
-	StartedAt  time.Time  `json:"started_at" db:"started_at"`
-	FinishedAt *time.Time `json:"finished_at" db:"finished_at"`
-	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	ID          int64      `json:"id" db:"id"`
+	ProjectID   int64      `json:"project_id" db:"project_id"`
+	CommitSHA   string     `json:"commit_sha" db:"commit_sha"`
+	Status      string     `json:"status" db:"status"` // pending, building, success, failed, superseded
+	BuildLog    string     `json:"build_log,omitempty" db:"build_log"`
+	ErrorMsg    string     `json:"error_msg" db:"error_msg"`
+	ReleaseDir  string     `json:"release_dir" db:"release_dir"` // directory the release was built into
+	Port        int        `json:"port" db:"port"`               // port the release listens on
+	RollbackOf  *int64     `json:"rollback_of" db:"rollback_of"` // deployment this one rolled back to
+	EnvSnapshot []string   `json:"-" db:"env_snapshot"`          // environment the release was started with
+	StartedAt   time.Time  `json:"started_at" db:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at" db:"finished_at"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
 }
 
 // EnvironmentVariable represents an environment variable for a project
@@ -53,24 +76,180 @@ type EnvironmentVariable struct {
 	ProjectID int64     `json:"project_id" db:"project_id"`
 	Key       string    `json:"key" db:"key"`
 	Value     string    `json:"value" db:"value"`
+	Scope     string    `json:"scope" db:"scope"` // build, runtime or both
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
 }
 
+// Organization is a team that owns projects together. Members are given roles.
+type Organization struct {
+	ID        int64  `json:"id" db:"id"`
+	Name      string `json:"name" db:"name"`
+	Slug      string `json:"slug" db:"slug"`
+	GitHubOrg string `json:"github_org" db:"github_org"` // GitHub organization whose members are synced, empty to not sync
+	// GitHubSyncRole is the role given to members added by the GitHub sync
+	GitHubSyncRole string    `json:"github_sync_role" db:"github_sync_role"`
+	Role           string    `json:"role,omitempty"` // role of the user the organization was loaded for
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// OrganizationMember is a user's membership of an organization
+type OrganizationMember struct {
+	OrganizationID int64     `json:"organization_id" db:"organization_id"`
+	UserID         int64     `json:"user_id" db:"user_id"`
+	Username       string    `json:"username" db:"username"`
+	AvatarURL      string    `json:"avatar_url" db:"avatar_url"`
+	Role           string    `json:"role" db:"role"`
+	Source         string    `json:"source" db:"source"` // how the member joined: manual or github
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+}
+
+// OrganizationInvite invites a GitHub user to an organization. It is accepted by the user with
+// that GitHub username.
+type OrganizationInvite struct {
+	ID               int64     `json:"id" db:"id"`
+	OrganizationID   int64     `json:"organization_id" db:"organization_id"`
+	OrganizationName string    `json:"organization_name"`
+	Username         string    `json:"username" db:"github_username"`
+	Role             string    `json:"role" db:"role"`
+	Source           string    `json:"source" db:"source"` // manual, or github if the GitHub sync invited the user
+	InvitedBy        *int64    `json:"invited_by" db:"invited_by"`
+	CreatedAt        time.Time `json:"created_at" db:"created_at"`
+}
+
+// APIToken is a personal access token for the API. Only a hash of the token is stored.
+type APIToken struct {
+	ID         int64      `json:"id" db:"id"`
+	UserID     int64      `json:"user_id" db:"user_id"`
+	Name       string     `json:"name" db:"name"`
+	Prefix     string     `json:"prefix" db:"prefix"` // start of the token, to recognize it
+	Scopes     []string   `json:"scopes" db:"scopes"`
+	ExpiresAt  time.Time  `json:"expires_at" db:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at" db:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+}
+
 // DeploymentStatus constants
 const (
-	StatusPending  = "pending"
-	StatusBuilding = "building"
-	StatusSuccess  = "success"
-	StatusFailed   = "failed"
+	StatusPending    = "pending"
+	StatusBuilding   = "building"
+	StatusSuccess    = "success"
+	StatusFailed     = "failed"
+	StatusSuperseded = "superseded" // replaced by a newer queued deployment before it ran
+)
+
+// DeploymentJob status constants
+const (
+	JobStatusQueued     = "queued"
+	JobStatusRunning    = "running"
+	JobStatusDone       = "done"
+	JobStatusSuperseded = "superseded"
 )
 
+// RestartPolicy configures when the supervisor restarts an application that exited and how
+// long the application gets to stop gracefully
+type RestartPolicy struct {
+	Policy      string `json:"policy" db:"restart_policy"`     // never, on-failure, always
+	MaxRestarts int    `json:"max_restarts" db:"restart_max"`  // restarts allowed within Window
+	Window      int    `json:"window" db:"restart_window"`     // seconds
+	StopTimeout int    `json:"stop_timeout" db:"stop_timeout"` // seconds between SIGTERM and SIGKILL, 0 = STOP_TIMEOUT
+}
+
+// LogRetention configures rotation and retention of a project's runtime logs
+type LogRetention struct {
+	MaxSize  int `json:"max_size" db:"log_max_size"`   // megabytes a log file may grow to before it is rotated
+	MaxFiles int `json:"max_files" db:"log_max_files"` // rotated files kept per stream
+	MaxAge   int `json:"max_age" db:"log_max_age"`     // days rotated files are kept
+}
+
+// RuntimeLogLine is a line written by a deployed application
+type RuntimeLogLine struct {
+	ID           int64                  `json:"id,omitempty" db:"id"`
+	ProjectID    int64                  `json:"project_id" db:"project_id"`
+	DeploymentID *int64                 `json:"deployment_id" db:"deployment_id"`
+	Instance     string                 `json:"instance" db:"instance"` // process that wrote the line, new on every start
+	Stream       string                 `json:"stream" db:"stream"`     // stdout or stderr
+	Level        string                 `json:"level" db:"level"`       // from JSON lines, empty otherwise
+	Text         string                 `json:"text" db:"text"`
+	Fields       map[string]interface{} `json:"fields,omitempty" db:"fields"` // parsed JSON line
+	Time         time.Time              `json:"time" db:"logged_at"`
+}
+
+// ProcessExit records an application process exiting
+type ProcessExit struct {
+	ID           int64     `json:"id" db:"id"`
+	ProjectID    int64     `json:"project_id" db:"project_id"`
+	DeploymentID *int64    `json:"deployment_id" db:"deployment_id"`
+	ExitCode     int       `json:"exit_code" db:"exit_code"` // -1 if the process was killed by a signal
+	Signal       string    `json:"signal" db:"signal"`
+	Crashed      bool      `json:"crashed" db:"crashed"` // exited on its own with an error
+	Reason       string    `json:"reason" db:"reason"`   // why the process stopped
+	ExitedAt     time.Time `json:"exited_at" db:"exited_at"`
+}
+
+// DeploymentJob is a queued unit of deployment work, processed one per project at a time
+type DeploymentJob struct {
+	ID           int64      `json:"id" db:"id"`
+	DeploymentID int64      `json:"deployment_id" db:"deployment_id"`
+	ProjectID    int64      `json:"project_id" db:"project_id"`
+	Status       string     `json:"status" db:"status"` // queued, running, done, superseded
+	Attempts     int        `json:"attempts" db:"attempts"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	StartedAt    *time.Time `json:"started_at" db:"started_at"`
+	FinishedAt   *time.Time `json:"finished_at" db:"finished_at"`
+}
+
 // ProjectStatus constants
 const (
-	ProjectStatusActive   = "active"
-	ProjectStatusInactive = "inactive"
-	ProjectStatusBuilding = "building"
-	ProjectStatusFailed   = "failed"
+	ProjectStatusActive    = "active"
+	ProjectStatusInactive  = "inactive"
+	ProjectStatusBuilding  = "building"
+	ProjectStatusFailed    = "failed"
+	ProjectStatusUnhealthy = "unhealthy"
+)
+
+// RestartPolicy constants
+const (
+	RestartPolicyNever     = "never"
+	RestartPolicyOnFailure = "on-failure"
+	RestartPolicyAlways    = "always"
+)
+
+// EnvironmentVariable scope constants
+const (
+	EnvScopeBuild   = "build"   // only passed to the build command
+	EnvScopeRuntime = "runtime" // only passed to the running application
+	EnvScopeBoth    = "both"
+)
+
+// Organization role constants, from most to least privileged. Every role may do what the
+// roles below it may do. The creator of a personal project is its owner.
+const (
+	RoleOwner    = "owner"    // delete projects, manage owners and the organization itself
+	RoleAdmin    = "admin"    // edit environment variables and project settings, manage members
+	RoleDeployer = "deployer" // deploy and roll back
+	RoleViewer   = "viewer"   // see projects, deployments and logs
+)
+
+// OrganizationMember source constants
+const (
+	MemberSourceManual = "manual" // created the organization, accepted an invite or was given a role
+	MemberSourceGitHub = "github" // added by the GitHub organization sync
+)
+
+// APIToken scope constants. Scopes limit what a token may do on top of the user's roles.
+const (
+	TokenScopeRead   = "read"   // read projects, deployments, logs and variable names
+	TokenScopeDeploy = "deploy" // deploy, roll back, start, stop and restart
+	TokenScopeEnv    = "env"    // read and change environment variable values
+	TokenScopeManage = "manage" // create and delete projects
+)
+
+// Runtime log stream constants
+const (
+	LogStreamStdout = "stdout"
+	LogStreamStderr = "stderr"
 )
 
 // GitHubRepo represents a repository from GitHub API
@@ -0,0 +1,307 @@
+// Package secrets encrypts values stored in the database with envelope encryption. Every value
+// is encrypted with its own random data key, and the data key is encrypted with the master key.
+// Rotating the master key only re-encrypts the data keys.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// KeySize is the size of master and data keys in bytes (AES-256)
+const KeySize = 32
+
+// prefix marks encrypted values: enc:v1:<master key ID>:<encrypted data key>:<encrypted value>
+const prefix = "enc:v1:"
+
+// ErrUnknownKey is returned for values encrypted with a master key that is not loaded
+var ErrUnknownKey = errors.New("value is encrypted with an unknown master key")
+
+// masterKey is a loaded master key
+type masterKey struct {
+	id   string
+	raw  []byte
+	aead cipher.AEAD
+}
+
+// Keyring encrypts values with its primary master key and decrypts values encrypted with any
+// of its keys
+type Keyring struct {
+	primary *masterKey
+	keys    map[string]*masterKey
+}
+
+// NewKeyring creates a keyring encrypting with primary. Values encrypted with one of the
+// previous keys can still be decrypted.
+func NewKeyring(primary []byte, previous ...[]byte) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]*masterKey)}
+	for i, raw := range append([][]byte{primary}, previous...) {
+		key, err := newMasterKey(raw)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			k.primary = key
+		}
+		if _, ok := k.keys[key.id]; !ok {
+			k.keys[key.id] = key
+		}
+	}
+	return k, nil
+}
+
+// newMasterKey prepares a master key for use
+func newMasterKey(raw []byte) (*masterKey, error) {
+	aead, err := newAEAD(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key: %w", err)
+	}
+	sum := sha256.Sum256(raw)
+	return &masterKey{id: hex.EncodeToString(sum[:4]), raw: raw, aead: aead}, nil
+}
+
+// newAEAD returns AES-GCM for a key
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Load loads the master key. An encoded key (MASTER_KEY) takes precedence over the key file
+// (MASTER_KEY_FILE), which is generated if it does not exist. The key a file held before the
+// last rotation (<keyFile>.old) is loaded too so values are readable if a rotation was
+// interrupted.
+func Load(encodedKey, keyFile string) (*Keyring, error) {
+	if encodedKey != "" {
+		key, err := DecodeKey(encodedKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode MASTER_KEY: %w", err)
+		}
+		return NewKeyring(key)
+	}
+
+	key, err := ReadKeyFile(keyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		if key, err = GenerateKey(); err != nil {
+			return nil, err
+		}
+		if err := WriteKeyFile(keyFile, key); err != nil {
+			return nil, err
+		}
+		log.Printf("🔑 [SECRETS] Generated a new master key at %s, back it up, secrets cannot be read without it", keyFile)
+	} else if err != nil {
+		return nil, err
+	}
+
+	var previous [][]byte
+	if old, err := ReadKeyFile(keyFile + ".old"); err == nil {
+		previous = append(previous, old)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	return NewKeyring(key, previous...)
+}
+
+// GenerateKey returns a new random master key
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return key, nil
+}
+
+// EncodeKey encodes a master key as base64, the format of MASTER_KEY and key files
+func EncodeKey(key []byte) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// DecodeKey decodes a base64 master key
+func DecodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", KeySize, len(key))
+	}
+	return key, nil
+}
+
+// ReadKeyFile reads a master key file
+func ReadKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := DecodeKey(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode key file %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// WriteKeyFile writes a master key file readable only by its owner. The file is replaced
+// atomically.
+func WriteKeyFile(path string, key []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create key directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(EncodeKey(key)+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	return nil
+}
+
+// KeyID identifies the primary master key without revealing it
+func (k *Keyring) KeyID() string {
+	return k.primary.id
+}
+
+// WithPrimary returns a keyring encrypting with key that still decrypts values encrypted
+// with the keys of k
+func (k *Keyring) WithPrimary(key []byte) (*Keyring, error) {
+	previous := [][]byte{k.primary.raw}
+	for _, old := range k.keys {
+		previous = append(previous, old.raw)
+	}
+	return NewKeyring(key, previous...)
+}
+
+// IsEncrypted reports whether a stored value is encrypted
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// Encrypt encrypts a value with a new data key
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	dataKey := make([]byte, KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", fmt.Errorf("failed to generate data key: %w", err)
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	wrappedKey, err := seal(k.primary.aead, dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(dataAEAD, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+
+	return prefix + k.primary.id + ":" + wrappedKey + ":" + ciphertext, nil
+}
+
+// Decrypt decrypts a stored value. Values that are not encrypted are returned unchanged, they
+// are plaintext rows that have not been migrated yet.
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+
+	dataKey, ciphertext, err := k.openDataKey(value)
+	if err != nil {
+		return "", err
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dataAEAD, ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// Rewrap re-encrypts the data key of a stored value with the primary master key, the value
+// itself is not decrypted. Values that are not encrypted are encrypted.
+func (k *Keyring) Rewrap(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return k.Encrypt(value)
+	}
+
+	dataKey, ciphertext, err := k.openDataKey(value)
+	if err != nil {
+		return "", err
+	}
+	wrappedKey, err := seal(k.primary.aead, dataKey)
+	if err != nil {
+		return "", err
+	}
+	return prefix + k.primary.id + ":" + wrappedKey + ":" + ciphertext, nil
+}
+
+// CanDecrypt reports whether the master key of a stored value is loaded. Values that are not
+// encrypted can always be read.
+func (k *Keyring) CanDecrypt(value string) bool {
+	if !IsEncrypted(value) {
+		return true
+	}
+	id, _, _ := strings.Cut(strings.TrimPrefix(value, prefix), ":")
+	_, ok := k.keys[id]
+	return ok
+}
+
+// openDataKey decrypts the data key of a stored value and returns it with the encrypted value
+func (k *Keyring) openDataKey(value string) ([]byte, string, error) {
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		return nil, "", fmt.Errorf("malformed encrypted value")
+	}
+	key, ok := k.keys[parts[0]]
+	if !ok {
+		return nil, "", fmt.Errorf("%w %s", ErrUnknownKey, parts[0])
+	}
+	dataKey, err := open(key.aead, parts[1])
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decrypt data key: %w", err)
+	}
+	return dataKey, parts[2], nil
+}
+
+// seal encrypts data with a random nonce and returns nonce and ciphertext as base64
+func seal(aead cipher.AEAD, data []byte) (string, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return base64.RawStdEncoding.EncodeToString(aead.Seal(nonce, nonce, data, nil)), nil
+}
+
+// open reverses seal
+func open(aead cipher.AEAD, encoded string) ([]byte, error) {
+	data, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+}
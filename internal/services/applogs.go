@@ -0,0 +1,498 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"goth-deploy/internal/models"
+)
+
+// appLogRotateInterval is how long a log file is written to before it is rotated, however
+// small it stays
+const appLogRotateInterval = 24 * time.Hour
+
+// appLogMaxLineLength splits runaway lines so a single line cannot exhaust memory
+const appLogMaxLineLength = 64 * 1024
+
+// appLogSubscriberBuffer is how many lines a follower may fall behind before it is dropped
+const appLogSubscriberBuffer = 256
+
+// defaultLogTail is how many lines a log query returns when neither a tail nor a time range
+// is given
+const defaultLogTail = 100
+
+// maxLogQueryLines caps how many lines a single log query returns
+const maxLogQueryLines = 10000
+
+// maxLogQueryScan caps how many stored lines a log query with a grep pattern reads, so a
+// pattern that rarely matches cannot scan the whole retention window
+const maxLogQueryScan = 100000
+
+// appLogTimeFormat prefixes every line in the log files
+const appLogTimeFormat = time.RFC3339Nano
+
+// rotatedLogTimeFormat suffixes rotated log files, it sorts chronologically
+const rotatedLogTimeFormat = "20060102T150405.000000000Z"
+
+// appLog writes the runtime logs of a project. All releases of the project write to the same
+// files, one per stream, which are rotated by size and age. Every line is prefixed with the
+// time it was written. Lines are also kept in the log store, stamped with the deployment and
+// process instance that wrote them, where they are queried.
+type appLog struct {
+	projectID int64
+	dir       string
+	store     *logStore
+
+	mutex       sync.Mutex
+	retention   models.LogRetention
+	files       map[string]*appLogFile // stream -> open log file
+	writers     int                    // open process streams, files are closed when none are left
+	subscribers map[chan models.RuntimeLogLine]bool
+}
+
+// appLogFile is an open log file of a stream
+type appLogFile struct {
+	file    *os.File
+	size    int64
+	created time.Time
+}
+
+// LogQuery selects runtime log lines
+type LogQuery struct {
+	Stream       string            // stdout, stderr or empty for both
+	Levels       []string          // any of these levels, empty = all
+	DeploymentID int64             // 0 = all deployments
+	Instance     string            // empty = all process instances
+	Fields       map[string]string // dotted path into JSON lines -> required value
+	Tail         int               // last N matching lines, 0 = default
+	Since        time.Time         // zero = no lower bound
+	Until        time.Time         // zero = no upper bound
+	Grep         *regexp.Regexp    // nil = all lines
+}
+
+// Matches reports whether a line is selected by the query
+func (q LogQuery) Matches(line models.RuntimeLogLine) bool {
+	if q.Stream != "" && line.Stream != q.Stream {
+		return false
+	}
+	if len(q.Levels) > 0 && !slices.Contains(q.Levels, line.Level) {
+		return false
+	}
+	if q.DeploymentID != 0 && (line.DeploymentID == nil || *line.DeploymentID != q.DeploymentID) {
+		return false
+	}
+	if q.Instance != "" && line.Instance != q.Instance {
+		return false
+	}
+	for path, want := range q.Fields {
+		if value, ok := fieldValue(line.Fields, path); !ok || value != want {
+			return false
+		}
+	}
+	if !q.Since.IsZero() && line.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && line.Time.After(q.Until) {
+		return false
+	}
+	return q.Grep == nil || q.Grep.MatchString(line.Text)
+}
+
+// limit returns how many lines the query returns at most
+func (q LogQuery) limit() int {
+	switch {
+	case q.Tail > 0 && q.Tail < maxLogQueryLines:
+		return q.Tail
+	case q.Tail > 0 || !q.Since.IsZero() || !q.Until.IsZero():
+		return maxLogQueryLines
+	default:
+		return defaultLogTail
+	}
+}
+
+// appLogFor returns the runtime log of a project, loading its retention settings on first use
+func (d *DeploymentService) appLogFor(projectID int64, subdomain string) (*appLog, error) {
+	d.appLogsMutex.Lock()
+	defer d.appLogsMutex.Unlock()
+
+	if appLog, ok := d.appLogs[subdomain]; ok {
+		return appLog, nil
+	}
+
+	retention, err := d.GetLogRetention(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	appLog := &appLog{
+		projectID:   projectID,
+		dir:         d.logDir(subdomain),
+		store:       d.logStore,
+		retention:   retention,
+		files:       make(map[string]*appLogFile),
+		subscribers: make(map[chan models.RuntimeLogLine]bool),
+	}
+	d.appLogs[subdomain] = appLog
+	return appLog, nil
+}
+
+// forgetAppLog closes the runtime log of a deleted project
+func (d *DeploymentService) forgetAppLog(subdomain string) {
+	d.appLogsMutex.Lock()
+	appLog, ok := d.appLogs[subdomain]
+	delete(d.appLogs, subdomain)
+	d.appLogsMutex.Unlock()
+	if !ok {
+		return
+	}
+
+	appLog.mutex.Lock()
+	defer appLog.mutex.Unlock()
+	appLog.closeFiles()
+	for ch := range appLog.subscribers {
+		delete(appLog.subscribers, ch)
+		close(ch)
+	}
+}
+
+// openStreams returns the stdout and stderr writers of a new application process. Both must
+// be closed once the process has exited.
+func (l *appLog) openStreams(deploymentID int64, instance string) (stdout, stderr *appLogWriter) {
+	l.mutex.Lock()
+	l.writers += 2
+	l.mutex.Unlock()
+
+	var deployment *int64
+	if deploymentID != 0 {
+		deployment = &deploymentID
+	}
+	stdout = &appLogWriter{log: l, deploymentID: deployment, instance: instance, stream: models.LogStreamStdout}
+	stderr = &appLogWriter{log: l, deploymentID: deployment, instance: instance, stream: models.LogStreamStderr}
+	return stdout, stderr
+}
+
+// release closes the log files once no process writes to them anymore
+func (l *appLog) release() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.writers--
+	if l.writers == 0 {
+		l.closeFiles()
+	}
+}
+
+// closeFiles closes the open log files, l.mutex must be held
+func (l *appLog) closeFiles() {
+	for stream, f := range l.files {
+		f.file.Close()
+		delete(l.files, stream)
+	}
+}
+
+// writeLine appends a line to a stream, stores it and publishes it to followers
+func (l *appLog) writeLine(line models.RuntimeLogLine) {
+	line = structuredLine(line)
+	l.store.add(line)
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if err := l.write(line); err != nil {
+		log.Printf("⚠️  [LOGS] Failed to write %s log in %s: %v", line.Stream, l.dir, err)
+	}
+
+	for ch := range l.subscribers {
+		select {
+		case ch <- line:
+		default:
+			// Too far behind, the follower reconnects and resumes from the last line it got
+			delete(l.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// write appends a line to the stream's log file, rotating it first if it is full or old,
+// l.mutex must be held
+func (l *appLog) write(line models.RuntimeLogLine) error {
+	entry := line.Time.Format(appLogTimeFormat) + " " + line.Text + "\n"
+
+	f, err := l.open(line.Stream)
+	if err != nil {
+		return err
+	}
+
+	maxSize := int64(l.retention.MaxSize) * 1024 * 1024
+	if f.size > 0 && (f.size+int64(len(entry)) > maxSize || line.Time.Sub(f.created) >= appLogRotateInterval) {
+		if err := l.rotate(line.Stream); err != nil {
+			return err
+		}
+		if f, err = l.open(line.Stream); err != nil {
+			return err
+		}
+	}
+
+	n, err := f.file.WriteString(entry)
+	f.size += int64(n)
+	return err
+}
+
+// open returns the open log file of a stream, opening it if necessary, l.mutex must be held
+func (l *appLog) open(stream string) (*appLogFile, error) {
+	if f, ok := l.files[stream]; ok {
+		return f, nil
+	}
+
+	if err := os.MkdirAll(l.dir, 0755); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(l.dir, stream+".log")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	f := &appLogFile{file: file, size: info.Size(), created: time.Now().UTC()}
+	if f.size > 0 {
+		// Keep the age of a file that was written before, e.g. by the previous platform run
+		if first, ok := firstLogTime(path); ok {
+			f.created = first
+		}
+	}
+	l.files[stream] = f
+	return f, nil
+}
+
+// rotate moves the current log file of a stream aside and prunes old rotated files,
+// l.mutex must be held
+func (l *appLog) rotate(stream string) error {
+	if f, ok := l.files[stream]; ok {
+		f.file.Close()
+		delete(l.files, stream)
+	}
+
+	path := filepath.Join(l.dir, stream+".log")
+	rotated := path + "." + time.Now().UTC().Format(rotatedLogTimeFormat)
+	if err := os.Rename(path, rotated); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	l.prune(stream)
+	return nil
+}
+
+// prune removes rotated log files of a stream beyond the retention limits, l.mutex must be held
+func (l *appLog) prune(stream string) {
+	rotated := rotatedLogFiles(l.dir, stream)
+	maxAge := time.Duration(l.retention.MaxAge) * 24 * time.Hour
+
+	keep := len(rotated) - l.retention.MaxFiles
+	for i, path := range rotated {
+		expired := false
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > maxAge {
+			expired = true
+		}
+		if i < keep || expired {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				log.Printf("⚠️  [LOGS] Failed to remove rotated log %s: %v", path, err)
+			}
+		}
+	}
+}
+
+// rotatedLogFiles returns the rotated log files of a stream, oldest first
+func rotatedLogFiles(dir, stream string) []string {
+	rotated, _ := filepath.Glob(filepath.Join(dir, stream+".log.*"))
+	sort.Strings(rotated)
+	return rotated
+}
+
+// firstLogTime returns the time of the first line of a log file
+func firstLogTime(path string) (time.Time, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	first, err := reader.ReadString('\n')
+	if err != nil && first == "" {
+		return time.Time{}, false
+	}
+	line := parseLogLine(strings.TrimSuffix(first, "\n"), "")
+	return line.Time, !line.Time.IsZero()
+}
+
+// parseLogLine splits a log file line into its time and text. Lines written before runtime
+// logs were timestamped have a zero time.
+func parseLogLine(entry, stream string) models.RuntimeLogLine {
+	line := models.RuntimeLogLine{Stream: stream, Text: entry}
+	if prefix, text, ok := strings.Cut(entry, " "); ok {
+		if t, err := time.Parse(appLogTimeFormat, prefix); err == nil {
+			line.Time = t
+			line.Text = text
+		}
+	}
+	return line
+}
+
+// appLogWriter splits the output stream of an application process into lines for its
+// project's log
+type appLogWriter struct {
+	log          *appLog
+	deploymentID *int64
+	instance     string
+	stream       string
+	partial      []byte
+}
+
+// Write appends process output, complete lines are written to the log immediately
+func (w *appLogWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.writeLine(strings.TrimSuffix(string(w.partial[:i]), "\r"))
+		w.partial = w.partial[i+1:]
+	}
+
+	for len(w.partial) >= appLogMaxLineLength {
+		w.writeLine(string(w.partial[:appLogMaxLineLength]))
+		w.partial = w.partial[appLogMaxLineLength:]
+	}
+
+	return len(p), nil
+}
+
+// Close writes the last unterminated line and releases the log files
+func (w *appLogWriter) Close() error {
+	if len(w.partial) > 0 {
+		w.writeLine(string(w.partial))
+		w.partial = nil
+	}
+	w.log.release()
+	return nil
+}
+
+// writeLine stamps a line with where and when it was written
+func (w *appLogWriter) writeLine(text string) {
+	w.log.writeLine(models.RuntimeLogLine{
+		ProjectID:    w.log.projectID,
+		DeploymentID: w.deploymentID,
+		Instance:     w.instance,
+		Stream:       w.stream,
+		Text:         text,
+		Time:         time.Now().UTC(),
+	})
+}
+
+// GetApplicationLogs returns the runtime log lines of a project selected by the query, oldest
+// first. Lines of both streams and all process instances are merged in the order they were
+// written.
+func (d *DeploymentService) GetApplicationLogs(projectID int64, query LogQuery) ([]models.RuntimeLogLine, error) {
+	// Include lines that are still waiting to be stored
+	d.logStore.flush()
+	return d.logStore.query(projectID, query)
+}
+
+// SubscribeApplicationLogs follows the runtime logs of a project. Lines written from now on
+// are sent to the returned channel, which is closed if the follower falls too far behind or
+// the project is deleted. The caller must call unsubscribe.
+func (d *DeploymentService) SubscribeApplicationLogs(projectID int64) (lines <-chan models.RuntimeLogLine, unsubscribe func(), err error) {
+	project, err := d.Stores.Projects.Get(projectID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get project: %w", err)
+	}
+
+	appLog, err := d.appLogFor(projectID, project.Subdomain)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ch := make(chan models.RuntimeLogLine, appLogSubscriberBuffer)
+	appLog.mutex.Lock()
+	appLog.subscribers[ch] = true
+	appLog.mutex.Unlock()
+
+	unsubscribe = func() {
+		appLog.mutex.Lock()
+		defer appLog.mutex.Unlock()
+		if appLog.subscribers[ch] {
+			delete(appLog.subscribers, ch)
+			close(ch)
+		}
+	}
+	return ch, unsubscribe, nil
+}
+
+// GetLogRetention loads the log retention settings of a project
+func (d *DeploymentService) GetLogRetention(projectID int64) (models.LogRetention, error) {
+	project, err := d.Stores.Projects.Get(projectID)
+	if err != nil {
+		return models.LogRetention{}, fmt.Errorf("failed to get log retention: %w", err)
+	}
+	return project.LogRetention, nil
+}
+
+// UpdateLogRetention validates and saves the log retention settings of a project. They apply
+// immediately, rotated files beyond the new limits are removed.
+func (d *DeploymentService) UpdateLogRetention(projectID int64, retention models.LogRetention) error {
+	if err := ValidateLogRetention(retention); err != nil {
+		return err
+	}
+
+	project, err := d.Stores.Projects.Get(projectID)
+	if err != nil {
+		return fmt.Errorf("failed to get project: %w", err)
+	}
+
+	if err := d.Stores.Projects.UpdateLogRetention(projectID, retention); err != nil {
+		return fmt.Errorf("failed to update log retention: %w", err)
+	}
+
+	appLog, err := d.appLogFor(projectID, project.Subdomain)
+	if err != nil {
+		return err
+	}
+	appLog.mutex.Lock()
+	appLog.retention = retention
+	appLog.prune(models.LogStreamStdout)
+	appLog.prune(models.LogStreamStderr)
+	appLog.mutex.Unlock()
+
+	return nil
+}
+
+// ValidateLogRetention reports whether log retention settings are usable
+func ValidateLogRetention(retention models.LogRetention) error {
+	if retention.MaxSize < 1 {
+		return fmt.Errorf("max size must be at least 1 MB")
+	}
+	if retention.MaxFiles < 0 {
+		return fmt.Errorf("max files must not be negative")
+	}
+	if retention.MaxAge < 1 {
+		return fmt.Errorf("max age must be at least 1 day")
+	}
+	return nil
+}
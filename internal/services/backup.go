@@ -0,0 +1,110 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"goth-deploy/internal/config"
+	"goth-deploy/internal/database"
+)
+
+// backupFilePrefix and backupFileSuffix name the scheduled backups, the time of the backup goes
+// in between so the names sort chronologically
+const (
+	backupFilePrefix = "app-"
+	backupFileSuffix = ".db"
+	backupTimeFormat = "20060102-150405"
+)
+
+// BackupService writes scheduled online backups of the database
+type BackupService struct {
+	DB     *database.DB
+	Config *config.Config
+}
+
+// NewBackupService creates a new backup service
+func NewBackupService(db *database.DB, cfg *config.Config) *BackupService {
+	return &BackupService{
+		DB:     db,
+		Config: cfg,
+	}
+}
+
+// Start backs up the database every BACKUP_INTERVAL until ctx is done. Backups are disabled if
+// the interval is 0 and only supported for SQLite.
+func (b *BackupService) Start(ctx context.Context) {
+	if b.Config.BackupInterval <= 0 {
+		return
+	}
+	if b.DB.Dialect != database.SQLite {
+		log.Printf("💾 [BACKUP] Scheduled backups are only supported for SQLite, back up PostgreSQL with pg_dump")
+		return
+	}
+	log.Printf("💾 [BACKUP] Backing up the database to %s every %v, keeping %d", b.Config.BackupDir, b.Config.BackupInterval, b.Config.BackupRetention)
+
+	go func() {
+		ticker := time.NewTicker(b.Config.BackupInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := b.Backup(); err != nil {
+					log.Printf("❌ [BACKUP] %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Backup writes a backup of the database to BACKUP_DIR, removes backups beyond
+// BACKUP_RETENTION and returns the path of the new backup
+func (b *BackupService) Backup() (string, error) {
+	started := time.Now()
+	path := filepath.Join(b.Config.BackupDir, backupFilePrefix+started.UTC().Format(backupTimeFormat)+backupFileSuffix)
+	if err := database.Backup(b.DB, path); err != nil {
+		return "", err
+	}
+	log.Printf("💾 [BACKUP] Backed up the database to %s in %v", path, time.Since(started).Round(time.Millisecond))
+
+	if err := b.prune(); err != nil {
+		log.Printf("⚠️  [BACKUP] Failed to remove old backups: %v", err)
+	}
+	return path, nil
+}
+
+// prune removes the oldest scheduled backups beyond BACKUP_RETENTION
+func (b *BackupService) prune() error {
+	if b.Config.BackupRetention <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(b.Config.BackupDir)
+	if err != nil {
+		return fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, backupFilePrefix) && strings.HasSuffix(name, backupFileSuffix) {
+			backups = append(backups, name)
+		}
+	}
+	sort.Strings(backups)
+
+	for len(backups) > b.Config.BackupRetention {
+		if err := os.Remove(filepath.Join(b.Config.BackupDir, backups[0])); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package services
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"goth-deploy/internal/models"
+)
+
+// buildLogFlushInterval is how often the build log of a running deployment is saved
+const buildLogFlushInterval = time.Second
+
+// buildLogSubscriberBuffer is how many lines a slow subscriber may fall behind before it is
+// dropped; it subscribes again and resumes from the last line it received
+const buildLogSubscriberBuffer = 256
+
+// LogLine is a single line of a build log. Lines are numbered from 1 so a client can resume
+// a stream after the last line it received.
+type LogLine struct {
+	Number int
+	Text   string
+}
+
+// deploymentLog collects the build log of a running deployment. Complete lines are published
+// to subscribers as they are written and the log is saved to the database periodically, so
+// it can be followed while the deployment runs.
+type deploymentLog struct {
+	d            *DeploymentService
+	deploymentID int64
+
+	mutex        sync.Mutex
+	lines        []string // complete lines
+	partial      []byte   // text after the last newline
+	subscribers  map[chan LogLine]bool
+	flushPending bool
+	finished     bool // the final log is saved with the deployment status, flushes stop
+	closed       bool
+
+	// saving is held while a flush saves the log, so no flush can overwrite the final log
+	saving sync.Mutex
+}
+
+// openBuildLog starts the live build log of a deployment
+func (d *DeploymentService) openBuildLog(deploymentID int64) *deploymentLog {
+	buildLog := &deploymentLog{
+		d:            d,
+		deploymentID: deploymentID,
+		subscribers:  make(map[chan LogLine]bool),
+	}
+
+	d.buildLogsMutex.Lock()
+	d.buildLogs[deploymentID] = buildLog
+	d.buildLogsMutex.Unlock()
+
+	return buildLog
+}
+
+// Write appends command output to the log
+func (l *deploymentLog) Write(p []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.closed {
+		return len(p), nil
+	}
+
+	l.partial = append(l.partial, p...)
+	for {
+		i := bytes.IndexByte(l.partial, '\n')
+		if i < 0 {
+			break
+		}
+		l.publish(strings.TrimSuffix(string(l.partial[:i]), "\r"))
+		l.partial = l.partial[i+1:]
+	}
+
+	if !l.flushPending {
+		l.flushPending = true
+		time.AfterFunc(buildLogFlushInterval, l.flush)
+	}
+
+	return len(p), nil
+}
+
+// WriteString appends text to the log
+func (l *deploymentLog) WriteString(s string) (int, error) {
+	return l.Write([]byte(s))
+}
+
+// String returns the whole log
+func (l *deploymentLog) String() string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.text()
+}
+
+// text joins the log, l.mutex must be held
+func (l *deploymentLog) text() string {
+	var text strings.Builder
+	for _, line := range l.lines {
+		text.WriteString(line)
+		text.WriteByte('\n')
+	}
+	text.Write(l.partial)
+	return text.String()
+}
+
+// publish records a complete line and sends it to the subscribers, l.mutex must be held
+func (l *deploymentLog) publish(text string) {
+	l.lines = append(l.lines, text)
+	line := LogLine{Number: len(l.lines), Text: text}
+
+	for ch := range l.subscribers {
+		select {
+		case ch <- line:
+		default:
+			// Too far behind, the subscriber catches up from the saved log when it reconnects
+			delete(l.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// flush saves the log of the running deployment
+func (l *deploymentLog) flush() {
+	l.saving.Lock()
+	defer l.saving.Unlock()
+
+	l.mutex.Lock()
+	l.flushPending = false
+	if l.finished {
+		l.mutex.Unlock()
+		return
+	}
+	text := l.text()
+	l.mutex.Unlock()
+
+	if err := l.d.Stores.Deployments.SetBuildLog(l.deploymentID, text); err != nil {
+		log.Printf("⚠️  [DEPLOY-%d] Failed to save build log: %v", l.deploymentID, err)
+	}
+}
+
+// finish stops the periodic saves and returns the whole log, to be saved with the final
+// deployment status. A flush that is already saving completes first.
+func (l *deploymentLog) finish() string {
+	l.saving.Lock()
+	defer l.saving.Unlock()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.finished = true
+	return l.text()
+}
+
+// close ends the live log once the final log has been saved with the deployment status.
+// The last unterminated line is published and subscribers are disconnected.
+func (l *deploymentLog) close() {
+	l.d.buildLogsMutex.Lock()
+	delete(l.d.buildLogs, l.deploymentID)
+	l.d.buildLogsMutex.Unlock()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if len(l.partial) > 0 {
+		l.publish(string(l.partial))
+		l.partial = nil
+	}
+	l.closed = true
+	for ch := range l.subscribers {
+		delete(l.subscribers, ch)
+		close(ch)
+	}
+}
+
+// SubscribeBuildLog follows the build log of a running deployment. It returns the lines after
+// line number after that were already written and a channel receiving the following lines,
+// which is closed when the deployment finishes or the subscriber falls too far behind. ok is
+// false if the deployment is not running on this instance. The caller must call unsubscribe.
+func (d *DeploymentService) SubscribeBuildLog(deploymentID int64, after int) (backlog []LogLine, lines <-chan LogLine, unsubscribe func(), ok bool) {
+	d.buildLogsMutex.Lock()
+	buildLog, ok := d.buildLogs[deploymentID]
+	d.buildLogsMutex.Unlock()
+	if !ok {
+		return nil, nil, nil, false
+	}
+
+	buildLog.mutex.Lock()
+	defer buildLog.mutex.Unlock()
+	if buildLog.closed {
+		return nil, nil, nil, false
+	}
+
+	for i := after; i < len(buildLog.lines); i++ {
+		backlog = append(backlog, LogLine{Number: i + 1, Text: buildLog.lines[i]})
+	}
+
+	ch := make(chan LogLine, buildLogSubscriberBuffer)
+	buildLog.subscribers[ch] = true
+	unsubscribe = func() {
+		buildLog.mutex.Lock()
+		defer buildLog.mutex.Unlock()
+		if buildLog.subscribers[ch] {
+			delete(buildLog.subscribers, ch)
+			close(ch)
+		}
+	}
+
+	return backlog, ch, unsubscribe, true
+}
+
+// BuildLogLines splits a saved build log into numbered lines, skipping the lines up to and
+// including line number after
+func BuildLogLines(buildLog string, after int) []LogLine {
+	buildLog = strings.TrimSuffix(buildLog, "\n")
+	if buildLog == "" {
+		return nil
+	}
+
+	var lines []LogLine
+	for i, text := range strings.Split(buildLog, "\n") {
+		if i >= after {
+			lines = append(lines, LogLine{Number: i + 1, Text: strings.TrimSuffix(text, "\r")})
+		}
+	}
+	return lines
+}
+
+// DeploymentFinished reports whether a deployment status is final
+func DeploymentFinished(status string) bool {
+	return status == models.StatusSuccess || status == models.StatusFailed || status == models.StatusSuperseded
+}
+
+// LogStreamsClosed is closed when the platform shuts down, log streams must end then so the
+// HTTP server can drain its connections
+func (d *DeploymentService) LogStreamsClosed() <-chan struct{} {
+	return d.logStreams.Done()
+}
+
+// CloseLogStreams ends all log streams
+func (d *DeploymentService) CloseLogStreams() {
+	d.closeLogStreams()
+}
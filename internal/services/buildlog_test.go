@@ -0,0 +1,35 @@
+package services
+
+import (
+	"testing"
+
+	"goth-deploy/internal/models"
+)
+
+func TestBuildLogFlushAfterFinish(t *testing.T) {
+	d, project := newTestService(t)
+	deployment := &models.Deployment{ProjectID: project.ID}
+	if err := d.Stores.Deployments.Enqueue(deployment); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	buildLog := d.openBuildLog(deployment.ID)
+	buildLog.WriteString("building\n")
+	buildLog.flush()
+	if saved, _ := d.Stores.Deployments.BuildLog(deployment.ID); saved != "building\n" {
+		t.Fatalf("log saved by flush = %q, want %q", saved, "building\n")
+	}
+
+	buildLog.WriteString("done")
+	final := buildLog.finish()
+	if err := d.Stores.Deployments.SetBuildLog(deployment.ID, final); err != nil {
+		t.Fatalf("SetBuildLog: %v", err)
+	}
+
+	// A timer that fires after the final save must not replace it
+	buildLog.flush()
+	if saved, _ := d.Stores.Deployments.BuildLog(deployment.ID); saved != "building\ndone" {
+		t.Errorf("log after a late flush = %q, want %q", saved, "building\ndone")
+	}
+	buildLog.close()
+}
@@ -1,7 +1,7 @@
 package services
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -9,26 +9,82 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"goth-deploy/internal/config"
+	"goth-deploy/internal/database"
 	"goth-deploy/internal/models"
+	"goth-deploy/internal/secrets"
+	"goth-deploy/internal/store"
 )
 
 // DeploymentService handles project deployments
 type DeploymentService struct {
-	DB        *sql.DB
+	DB        *database.DB
+	Stores    *store.Stores
 	Config    *config.Config
-	processes map[string]*exec.Cmd
+	Secrets   *secrets.Keyring
+	processes map[string]*appProcess // subdomain -> live release
 	mutex     sync.RWMutex
+
+	// Deployment queue state, see queue.go
+	wake        chan struct{}
+	runningJobs map[int64]bool // project ID -> a job is running
+	jobsMutex   sync.Mutex
+
+	// Liveness check state, see health.go
+	health      map[string]*healthState // subdomain -> checks of the live release
+	healthMutex sync.Mutex
+
+	// Restart supervision state, see supervisor.go
+	restarts        map[string]*restartState // subdomain -> supervised restarts
+	supervisorMutex sync.Mutex
+
+	// Shutdown state, see shutdown.go
+	children             map[*appProcess]bool // every application process that has not exited
+	shuttingDown         atomic.Bool
+	interrupt            context.Context // cancelled to abort running deployments
+	interruptDeployments context.CancelFunc
+	runningDeployments   sync.WaitGroup
+
+	// Live build logs, see buildlog.go
+	buildLogs       map[int64]*deploymentLog // deployment ID -> log of a running deployment
+	buildLogsMutex  sync.Mutex
+	logStreams      context.Context // cancelled to end log streams on shutdown
+	closeLogStreams context.CancelFunc
+
+	// Runtime logs, see applogs.go and logstore.go
+	appLogs      map[string]*appLog // subdomain -> runtime log
+	appLogsMutex sync.Mutex
+	logStore     *logStore
 }
 
 // NewDeploymentService creates a new deployment service
-func NewDeploymentService(db *sql.DB, cfg *config.Config) *DeploymentService {
+func NewDeploymentService(db *database.DB, stores *store.Stores, cfg *config.Config, keyring *secrets.Keyring) *DeploymentService {
+	interrupt, interruptDeployments := context.WithCancel(context.Background())
+	logStreams, closeLogStreams := context.WithCancel(context.Background())
 	return &DeploymentService{
-		DB:        db,
-		Config:    cfg,
-		processes: make(map[string]*exec.Cmd),
+		DB:          db,
+		Stores:      stores,
+		Config:      cfg,
+		Secrets:     keyring,
+		processes:   make(map[string]*appProcess),
+		wake:        make(chan struct{}, 1),
+		runningJobs: make(map[int64]bool),
+		health:      make(map[string]*healthState),
+		restarts:    make(map[string]*restartState),
+		children:    make(map[*appProcess]bool),
+
+		interrupt:            interrupt,
+		interruptDeployments: interruptDeployments,
+
+		buildLogs:       make(map[int64]*deploymentLog),
+		logStreams:      logStreams,
+		closeLogStreams: closeLogStreams,
+
+		appLogs:  make(map[string]*appLog),
+		logStore: &logStore{db: db, projects: stores.Projects},
 	}
 }
 
@@ -42,21 +98,7 @@ func (d *DeploymentService) DeployProject(projectID int64, commitSHA string) (*m
 	}
 
 	// Get project details
-	var project models.Project
-	err := d.DB.QueryRow(`
-		SELECT id, user_id, name, repo_url, branch, subdomain, build_command, start_command, port
-		FROM projects WHERE id = ?
-	`, projectID).Scan(
-		&project.ID,
-		&project.UserID,
-		&project.Name,
-		&project.RepoURL,
-		&project.Branch,
-		&project.Subdomain,
-		&project.BuildCommand,
-		&project.StartCommand,
-		&project.Port,
-	)
+	project, err := d.Stores.Projects.Get(projectID)
 	if err != nil {
 		log.Printf("❌ [DEPLOY] Failed to get project details for ID %d: %v", projectID, err)
 		return nil, fmt.Errorf("failed to get project: %w", err)
@@ -68,45 +110,26 @@ func (d *DeploymentService) DeployProject(projectID int64, commitSHA string) (*m
 	log.Printf("▶️  [DEPLOY] Start command: %s", project.StartCommand)
 	log.Printf("🌐 [DEPLOY] Port: %d", project.Port)
 
-	// Create deployment record
+	// Create deployment record and queue it
 	log.Printf("💾 [DEPLOY] Creating deployment record...")
-	result, err := d.DB.Exec(`
-		INSERT INTO deployments (project_id, commit_sha, status, started_at, created_at)
-		VALUES (?, ?, 'pending', ?, ?)
-	`, projectID, commitSHA, time.Now(), time.Now())
+	deployment, err := d.enqueueDeployment(projectID, commitSHA, nil)
 	if err != nil {
-		log.Printf("❌ [DEPLOY] Failed to create deployment record: %v", err)
-		return nil, fmt.Errorf("failed to create deployment: %w", err)
+		log.Printf("❌ [DEPLOY] Failed to queue deployment: %v", err)
+		return nil, err
 	}
 
-	deploymentID, err := result.LastInsertId()
-	if err != nil {
-		log.Printf("❌ [DEPLOY] Failed to get deployment ID: %v", err)
-		return nil, fmt.Errorf("failed to get deployment ID: %w", err)
-	}
-
-	deployment := &models.Deployment{
-		ID:        deploymentID,
-		ProjectID: projectID,
-		CommitSHA: commitSHA,
-		Status:    models.StatusPending,
-		StartedAt: time.Now(),
-		CreatedAt: time.Now(),
-	}
-
-	log.Printf("✅ [DEPLOY] Deployment record created with ID %d", deploymentID)
+	log.Printf("✅ [DEPLOY] Deployment record created with ID %d", deployment.ID)
 
 	// Update project status
 	log.Printf("🔄 [DEPLOY] Updating project status to 'building'...")
-	_, err = d.DB.Exec("UPDATE projects SET status = 'building' WHERE id = ?", projectID)
-	if err != nil {
+	if err := d.Stores.Projects.SetStatus(projectID, models.ProjectStatusBuilding); err != nil {
 		log.Printf("⚠️  [DEPLOY] Failed to update project status: %v", err)
 		return deployment, fmt.Errorf("failed to update project status: %w", err)
 	}
 
-	// Start deployment in background
-	log.Printf("🎯 [DEPLOY] Starting deployment process in background...")
-	go d.performDeployment(deployment, &project)
+	// Hand the deployment to the worker pool
+	log.Printf("🎯 [DEPLOY] Deployment queued, waking workers...")
+	d.wakeWorkers()
 
 	return deployment, nil
 }
@@ -116,7 +139,10 @@ func (d *DeploymentService) performDeployment(deployment *models.Deployment, pro
 	startTime := time.Now()
 	log.Printf("🎬 [DEPLOY-%d] Starting deployment process for project '%s'", deployment.ID, project.Name)
 
-	var buildLog strings.Builder
+	// The build log is saved and streamed to followers as it is written
+	buildLog := d.openBuildLog(deployment.ID)
+	defer buildLog.close()
+
 	var err error
 	var startDuration time.Duration
 
@@ -126,12 +152,18 @@ func (d *DeploymentService) performDeployment(deployment *models.Deployment, pro
 	buildLog.WriteString(fmt.Sprintf("Repository: %s\n", project.RepoURL))
 	buildLog.WriteString(fmt.Sprintf("Branch: %s\n", project.Branch))
 	buildLog.WriteString(fmt.Sprintf("Subdomain: %s\n", project.Subdomain))
+	if deployment.RollbackOf != nil {
+		buildLog.WriteString(fmt.Sprintf("Rollback of: deployment #%d\n", *deployment.RollbackOf))
+	}
 	buildLog.WriteString("===========================================\n\n")
 
 	// Update deployment status to building
 	log.Printf("📝 [DEPLOY-%d] Updating deployment status to 'building'", deployment.ID)
 	d.updateDeploymentStatus(deployment.ID, models.StatusBuilding, "", "")
 
+	// Each deployment is built into its own release directory so the live release keeps serving
+	releaseDir := d.releaseDir(project.Subdomain, deployment.ID)
+
 	defer func() {
 		duration := time.Since(startTime)
 		if err != nil {
@@ -139,35 +171,162 @@ func (d *DeploymentService) performDeployment(deployment *models.Deployment, pro
 			buildLog.WriteString(fmt.Sprintf("\n=== DEPLOYMENT FAILED ===\n"))
 			buildLog.WriteString(fmt.Sprintf("Duration: %v\n", duration))
 			buildLog.WriteString(fmt.Sprintf("Error: %v\n", err))
-			d.updateDeploymentStatus(deployment.ID, models.StatusFailed, buildLog.String(), err.Error())
-			d.updateProjectStatus(project.ID, models.ProjectStatusFailed)
+			if d.interrupt.Err() != nil {
+				buildLog.WriteString("⚠️  Interrupted by platform shutdown, the deployment will be retried on the next start\n")
+			}
+			os.RemoveAll(releaseDir)
+			// A failed deploy leaves the current release serving
+			stillServing := d.IsProjectRunning(project.Subdomain)
+			if stillServing {
+				buildLog.WriteString("ℹ️  The previous release is still serving traffic\n")
+			}
+			d.updateDeploymentStatus(deployment.ID, models.StatusFailed, buildLog.finish(), err.Error())
+			if stillServing {
+				d.updateProjectStatus(project.ID, models.ProjectStatusActive)
+			} else {
+				d.updateProjectStatus(project.ID, models.ProjectStatusFailed)
+			}
 		} else {
 			log.Printf("🎉 [DEPLOY-%d] Deployment SUCCESSFUL in %v", deployment.ID, duration)
 			buildLog.WriteString(fmt.Sprintf("\n=== DEPLOYMENT SUCCESSFUL ===\n"))
 			buildLog.WriteString(fmt.Sprintf("Duration: %v\n", duration))
 			buildLog.WriteString(fmt.Sprintf("Application available at: http://%s.%s\n", project.Subdomain, d.Config.BaseDomain))
-			d.updateDeploymentStatus(deployment.ID, models.StatusSuccess, buildLog.String(), "")
+			d.updateDeploymentStatus(deployment.ID, models.StatusSuccess, buildLog.finish(), "")
 			d.updateProjectStatus(project.ID, models.ProjectStatusActive)
 			// Update last deploy time
-			d.DB.Exec("UPDATE projects SET last_deploy = ? WHERE id = ?", time.Now(), project.ID)
+			d.Stores.Projects.SetLastDeploy(project.ID, time.Now())
 		}
 	}()
 
-	// Stop any existing process for this project
-	log.Printf("🛑 [DEPLOY-%d] Stopping any existing processes for subdomain '%s'", deployment.ID, project.Subdomain)
-	buildLog.WriteString("🛑 Stopping existing processes...\n")
-	d.stopProjectProcess(project.Subdomain)
-	buildLog.WriteString("✅ Existing processes stopped\n\n")
+	// Load runtime environment variables (a rollback reuses the snapshot of the release it restores)
+	var envVars []string
+	var deployDir string
+	if deployment.RollbackOf != nil {
+		var source *models.Deployment
+		if source, err = d.getRollbackSource(deployment); err != nil {
+			buildLog.WriteString(fmt.Sprintf("❌ %v\n", err))
+			return
+		}
+		envVars = source.EnvSnapshot
+		if envVars == nil {
+			log.Printf("ℹ️  [DEPLOY-%d] Deployment #%d has no environment snapshot, using current variables", deployment.ID, source.ID)
+			buildLog.WriteString(fmt.Sprintf("ℹ️  Deployment #%d has no environment snapshot, using current variables\n", source.ID))
+			if envVars, err = d.getProjectEnvironmentVariables(project.ID, models.EnvScopeRuntime); err != nil {
+				log.Printf("❌ [DEPLOY-%d] %v", deployment.ID, err)
+				buildLog.WriteString(fmt.Sprintf("❌ %v\n", err))
+				return
+			}
+		} else {
+			buildLog.WriteString(fmt.Sprintf("🔧 Restored %d environment variables from deployment #%d\n", len(envVars), source.ID))
+		}
 
-	// Create deployment directory
-	deployDir := filepath.Join(d.Config.DeploymentRoot, project.Subdomain)
-	log.Printf("📁 [DEPLOY-%d] Setting up deployment directory: %s", deployment.ID, deployDir)
-	buildLog.WriteString(fmt.Sprintf("📁 Setting up deployment directory: %s\n", deployDir))
+		if source.ReleaseDir != "" {
+			if _, statErr := os.Stat(source.ReleaseDir); statErr == nil {
+				deployDir = source.ReleaseDir
+				log.Printf("♻️  [DEPLOY-%d] Reusing build artifact of deployment #%d: %s", deployment.ID, source.ID, deployDir)
+				buildLog.WriteString(fmt.Sprintf("♻️  Reusing build artifact of deployment #%d (%s)\n\n", source.ID, source.CommitSHA))
+			}
+		}
+		if deployDir == "" {
+			log.Printf("ℹ️  [DEPLOY-%d] Artifact of deployment #%d is gone, rebuilding %s", deployment.ID, source.ID, deployment.CommitSHA)
+			buildLog.WriteString(fmt.Sprintf("ℹ️  Build artifact of deployment #%d is no longer on disk, rebuilding commit %s\n\n", source.ID, deployment.CommitSHA))
+		}
+	} else {
+		log.Printf("🔧 [DEPLOY-%d] Loading environment variables for project", deployment.ID)
+		if envVars, err = d.getProjectEnvironmentVariables(project.ID, models.EnvScopeRuntime); err != nil {
+			log.Printf("❌ [DEPLOY-%d] %v", deployment.ID, err)
+			buildLog.WriteString(fmt.Sprintf("❌ %v\n", err))
+			return
+		}
+		log.Printf("ℹ️  [DEPLOY-%d] Loaded %d runtime environment variables", deployment.ID, len(envVars))
+		buildLog.WriteString(fmt.Sprintf("🔧 Loaded %d runtime environment variables\n", len(envVars)))
+	}
+
+	if deployDir == "" {
+		// Builds always use the current build variables, rollbacks included
+		var buildVars []string
+		if buildVars, err = d.getProjectEnvironmentVariables(project.ID, models.EnvScopeBuild); err != nil {
+			log.Printf("❌ [DEPLOY-%d] %v", deployment.ID, err)
+			buildLog.WriteString(fmt.Sprintf("❌ %v\n", err))
+			return
+		}
+		log.Printf("ℹ️  [DEPLOY-%d] Loaded %d build environment variables", deployment.ID, len(buildVars))
+		buildLog.WriteString(fmt.Sprintf("🔧 Loaded %d build environment variables\n\n", len(buildVars)))
+
+		deployDir = releaseDir
+		if err = d.buildRelease(deployment, project, deployDir, buildVars, buildLog); err != nil {
+			return
+		}
+	}
+
+	// Remember what this release was started with so it can be rolled back to
+	d.saveEnvSnapshot(deployment.ID, envVars)
+
+	healthCheck, hcErr := d.GetHealthCheck(project.ID)
+	if hcErr != nil {
+		log.Printf("❌ [DEPLOY-%d] Failed to load health check: %v", deployment.ID, hcErr)
+		buildLog.WriteString(fmt.Sprintf("❌ Failed to load health check: %v\n", hcErr))
+		err = hcErr
+		return
+	}
+
+	// Start the new release next to the live one, on a fresh port
+	port, portErr := allocatePort()
+	if portErr != nil {
+		log.Printf("❌ [DEPLOY-%d] Failed to allocate a port: %v", deployment.ID, portErr)
+		buildLog.WriteString(fmt.Sprintf("❌ Failed to allocate a port: %v\n", portErr))
+		err = fmt.Errorf("failed to allocate port: %w", portErr)
+		return
+	}
+
+	log.Printf("🚀 [DEPLOY-%d] Starting application with command: %s (port %d)", deployment.ID, project.StartCommand, port)
+	buildLog.WriteString(fmt.Sprintf("🚀 Starting application with command: %s on port %d...\n", project.StartCommand, port))
+
+	appStartTime := time.Now()
+	proc, startErr := d.startApplication(project, deployment.ID, deployDir, port, envVars)
+	if startErr != nil {
+		log.Printf("❌ [DEPLOY-%d] Failed to start application: %v", deployment.ID, startErr)
+		err = fmt.Errorf("failed to start application: %w", startErr)
+		buildLog.WriteString(fmt.Sprintf("❌ Failed to start application: %v\n", startErr))
+		return
+	}
+
+	// Only switch traffic once the new release is ready
+	buildLog.WriteString(fmt.Sprintf("⏳ Waiting for the application to become ready (%s)...\n", describeHealthCheck(healthCheck)))
+	if readyErr := d.waitForReady(proc, healthCheck, d.Config.ReadinessTimeout); readyErr != nil {
+		log.Printf("❌ [DEPLOY-%d] Application failed readiness check: %v", deployment.ID, readyErr)
+		d.stopProcess(proc, stopReasonNotReady)
+		err = fmt.Errorf("application failed readiness check: %w", readyErr)
+		buildLog.WriteString(fmt.Sprintf("❌ Application failed readiness check: %v\n", readyErr))
+		return
+	}
+	startDuration = time.Since(appStartTime)
+
+	if activateErr := d.activateRelease(project, proc); activateErr != nil {
+		log.Printf("❌ [DEPLOY-%d] Failed to activate release: %v", deployment.ID, activateErr)
+		d.stopProcess(proc, stopReasonActivate)
+		err = fmt.Errorf("failed to activate release: %w", activateErr)
+		buildLog.WriteString(fmt.Sprintf("❌ Failed to activate release: %v\n", activateErr))
+		return
+	}
+	d.pruneReleases(project)
+
+	log.Printf("🎉 [DEPLOY-%d] Application started successfully on port %d in %v", deployment.ID, port, startDuration)
+	log.Printf("🌐 [DEPLOY-%d] Project available at: http://%s.%s", deployment.ID, project.Subdomain, d.Config.BaseDomain)
+	buildLog.WriteString(fmt.Sprintf("🎉 Application started successfully on port %d in %v!\n", port, startDuration))
+	buildLog.WriteString(fmt.Sprintf("🌐 Project is now available at: http://%s.%s\n", project.Subdomain, d.Config.BaseDomain))
+}
+
+// buildRelease clones the repository into deployDir, checks out the deployment's commit
+// and runs the build command
+func (d *DeploymentService) buildRelease(deployment *models.Deployment, project *models.Project, deployDir string, envVars []string, buildLog *deploymentLog) (err error) {
+	// Create release directory
+	log.Printf("📁 [DEPLOY-%d] Setting up release directory: %s", deployment.ID, deployDir)
+	buildLog.WriteString(fmt.Sprintf("📁 Setting up release directory: %s\n", deployDir))
 
 	if err = os.RemoveAll(deployDir); err != nil {
-		log.Printf("⚠️  [DEPLOY-%d] Warning: Failed to remove existing directory: %v", deployment.ID, err)
-		buildLog.WriteString(fmt.Sprintf("⚠️  Warning: Failed to remove existing directory: %v\n", err))
-		// Continue anyway - this might be the first deployment
+		log.Printf("⚠️  [DEPLOY-%d] Warning: Failed to remove stale release directory: %v", deployment.ID, err)
+		buildLog.WriteString(fmt.Sprintf("⚠️  Warning: Failed to remove stale release directory: %v\n", err))
 	}
 
 	if err = os.MkdirAll(deployDir, 0755); err != nil {
@@ -183,11 +342,12 @@ func (d *DeploymentService) performDeployment(deployment *models.Deployment, pro
 	buildLog.WriteString(fmt.Sprintf("📥 Cloning repository %s (branch: %s)...\n", project.RepoURL, project.Branch))
 
 	cloneStart := time.Now()
-	cloneCmd := exec.Command("git", "clone", "--branch", project.Branch, "--single-branch", project.RepoURL, deployDir)
-	cloneOutput, cloneErr := cloneCmd.CombinedOutput()
+	cloneCmd := exec.CommandContext(d.interrupt, "git", "clone", "--branch", project.Branch, "--single-branch", project.RepoURL, deployDir)
+	cloneCmd.Stdout = buildLog
+	cloneCmd.Stderr = buildLog
+	cloneErr := cloneCmd.Run()
 	cloneDuration := time.Since(cloneStart)
 
-	buildLog.WriteString(string(cloneOutput))
 	if cloneErr != nil {
 		log.Printf("❌ [DEPLOY-%d] Git clone failed after %v: %v", deployment.ID, cloneDuration, cloneErr)
 		buildLog.WriteString(fmt.Sprintf("❌ Git clone failed: %v\n", cloneErr))
@@ -214,12 +374,13 @@ func (d *DeploymentService) performDeployment(deployment *models.Deployment, pro
 		buildLog.WriteString(fmt.Sprintf("🔄 Checking out commit %s...\n", deployment.CommitSHA))
 
 		checkoutStart := time.Now()
-		checkoutCmd := exec.Command("git", "checkout", deployment.CommitSHA)
+		checkoutCmd := exec.CommandContext(d.interrupt, "git", "checkout", deployment.CommitSHA)
 		checkoutCmd.Dir = deployDir
-		checkoutOutput, checkoutErr := checkoutCmd.CombinedOutput()
+		checkoutCmd.Stdout = buildLog
+		checkoutCmd.Stderr = buildLog
+		checkoutErr := checkoutCmd.Run()
 		checkoutDuration := time.Since(checkoutStart)
 
-		buildLog.WriteString(string(checkoutOutput))
 		if checkoutErr != nil {
 			log.Printf("❌ [DEPLOY-%d] Git checkout failed after %v: %v", deployment.ID, checkoutDuration, checkoutErr)
 			buildLog.WriteString(fmt.Sprintf("❌ Git checkout failed: %v\n", checkoutErr))
@@ -233,11 +394,17 @@ func (d *DeploymentService) performDeployment(deployment *models.Deployment, pro
 		buildLog.WriteString(fmt.Sprintf("ℹ️  Using latest commit from branch %s\n\n", project.Branch))
 	}
 
-	// Load environment variables
-	log.Printf("🔧 [DEPLOY-%d] Loading environment variables for project", deployment.ID)
-	envVars := d.getProjectEnvironmentVariables(project.ID)
-	log.Printf("ℹ️  [DEPLOY-%d] Loaded %d environment variables", deployment.ID, len(envVars))
-	buildLog.WriteString(fmt.Sprintf("🔧 Loaded %d environment variables\n\n", len(envVars)))
+	// Record the exact commit so the release can be rebuilt later
+	revParseCmd := exec.CommandContext(d.interrupt, "git", "rev-parse", "HEAD")
+	revParseCmd.Dir = deployDir
+	if revOutput, revErr := revParseCmd.Output(); revErr == nil {
+		deployment.CommitSHA = strings.TrimSpace(string(revOutput))
+		if dbErr := d.Stores.Deployments.SetCommitSHA(deployment.ID, deployment.CommitSHA); dbErr != nil {
+			log.Printf("⚠️  [DEPLOY-%d] Failed to record commit SHA: %v", deployment.ID, dbErr)
+		}
+	} else {
+		log.Printf("⚠️  [DEPLOY-%d] Failed to resolve commit SHA: %v", deployment.ID, revErr)
+	}
 
 	// Build the project
 	log.Printf("🔨 [DEPLOY-%d] Starting build with command: %s", deployment.ID, project.BuildCommand)
@@ -252,15 +419,16 @@ func (d *DeploymentService) performDeployment(deployment *models.Deployment, pro
 	}
 
 	buildStart := time.Now()
-	buildCmd := exec.Command(buildParts[0], buildParts[1:]...)
-	buildCmd.Env = append(os.Environ(), envVars...)
+	buildCmd := exec.CommandContext(d.interrupt, buildParts[0], buildParts[1:]...)
+	buildCmd.Env = append(d.baseEnvironment(), envVars...)
 	buildCmd.Dir = deployDir
+	buildCmd.Stdout = buildLog
+	buildCmd.Stderr = buildLog
 
 	log.Printf("📋 [DEPLOY-%d] Build environment: %d total env vars", deployment.ID, len(buildCmd.Env))
-	buildOutput, buildErr := buildCmd.CombinedOutput()
+	buildErr := buildCmd.Run()
 	buildDuration := time.Since(buildStart)
 
-	buildLog.WriteString(string(buildOutput))
 	if buildErr != nil {
 		log.Printf("❌ [DEPLOY-%d] Build failed after %v: %v", deployment.ID, buildDuration, buildErr)
 		buildLog.WriteString(fmt.Sprintf("❌ Build failed after %v: %v\n", buildDuration, buildErr))
@@ -271,82 +439,86 @@ func (d *DeploymentService) performDeployment(deployment *models.Deployment, pro
 	log.Printf("✅ [DEPLOY-%d] Build completed successfully in %v", deployment.ID, buildDuration)
 	buildLog.WriteString(fmt.Sprintf("✅ Build completed successfully in %v!\n\n", buildDuration))
 
-	// Start the application
-	log.Printf("🚀 [DEPLOY-%d] Starting application with command: %s", deployment.ID, project.StartCommand)
-	buildLog.WriteString(fmt.Sprintf("🚀 Starting application with command: %s...\n", project.StartCommand))
-
-	appStartTime := time.Now()
-	if startErr := d.startApplication(project, deployDir, envVars); startErr != nil {
-		log.Printf("❌ [DEPLOY-%d] Failed to start application: %v", deployment.ID, startErr)
-		err = fmt.Errorf("failed to start application: %w", startErr)
-		buildLog.WriteString(fmt.Sprintf("❌ Failed to start application: %v\n", startErr))
-		return
-	}
-	startDuration = time.Since(appStartTime)
-
-	log.Printf("🎉 [DEPLOY-%d] Application started successfully on port %d in %v", deployment.ID, project.Port, startDuration)
-	log.Printf("🌐 [DEPLOY-%d] Project available at: http://%s.%s", deployment.ID, project.Subdomain, d.Config.BaseDomain)
-	buildLog.WriteString(fmt.Sprintf("🎉 Application started successfully on port %d in %v!\n", project.Port, startDuration))
-	buildLog.WriteString(fmt.Sprintf("🌐 Project is now available at: http://%s.%s\n", project.Subdomain, d.Config.BaseDomain))
+	return nil
 }
 
-// startApplication starts the application process
-func (d *DeploymentService) startApplication(project *models.Project, deployDir string, envVars []string) error {
-	d.mutex.Lock()
-	defer d.mutex.Unlock()
-
+// startApplication starts a release of the application on the given port. The process is not
+// registered as the project's live release; see activateRelease.
+func (d *DeploymentService) startApplication(project *models.Project, deploymentID int64, deployDir string, port int, envVars []string) (*appProcess, error) {
 	// Parse start command
 	startParts := strings.Fields(project.StartCommand)
 	if len(startParts) == 0 {
-		return fmt.Errorf("empty start command")
+		return nil, fmt.Errorf("empty start command")
 	}
 
 	// Create command
 	cmd := exec.Command(startParts[0], startParts[1:]...)
 	cmd.Dir = deployDir
 
-	// Set environment variables
-	cmd.Env = append(os.Environ(), envVars...)
-	cmd.Env = append(cmd.Env, fmt.Sprintf("PORT=%d", project.Port))
-
-	// Create log files for stdout/stderr
-	logDir := filepath.Join(deployDir, "logs")
-	os.MkdirAll(logDir, 0755)
+	// Set environment variables, the platform's own environment is not passed on
+	cmd.Env = append(d.baseEnvironment(), envVars...)
+	cmd.Env = append(cmd.Env, fmt.Sprintf("PORT=%d", port))
 
-	stdoutFile, err := os.Create(filepath.Join(logDir, "stdout.log"))
+	// Write stdout/stderr to the project's runtime log, shared by all releases of the project
+	appLog, err := d.appLogFor(project.ID, project.Subdomain)
 	if err != nil {
-		return fmt.Errorf("failed to create stdout log: %w", err)
+		return nil, fmt.Errorf("failed to open runtime log: %w", err)
 	}
+	instance := newInstanceID()
+	stdoutLog, stderrLog := appLog.openStreams(deploymentID, instance)
 
-	stderrFile, err := os.Create(filepath.Join(logDir, "stderr.log"))
-	if err != nil {
-		stdoutFile.Close()
-		return fmt.Errorf("failed to create stderr log: %w", err)
-	}
+	cmd.Stdout = stdoutLog
+	cmd.Stderr = stderrLog
+	// Don't wait for processes that inherited the output pipes once the application exited
+	cmd.WaitDelay = time.Second
 
-	cmd.Stdout = stdoutFile
-	cmd.Stderr = stderrFile
+	// Run the application in its own process group so it can be stopped as a whole
+	setProcessGroup(cmd)
 
 	// Start the process
 	if err := cmd.Start(); err != nil {
-		stdoutFile.Close()
-		stderrFile.Close()
-		return fmt.Errorf("failed to start process: %w", err)
+		stdoutLog.Close()
+		stderrLog.Close()
+		return nil, fmt.Errorf("failed to start process: %w", err)
 	}
 
-	// Store the process
-	d.processes[project.Subdomain] = cmd
+	proc := &appProcess{
+		cmd:          cmd,
+		projectID:    project.ID,
+		deploymentID: deploymentID,
+		instance:     instance,
+		port:         port,
+		releaseDir:   deployDir,
+		done:         make(chan struct{}),
+	}
+	d.writePidFile(project.Subdomain, proc)
+
+	d.mutex.Lock()
+	d.children[proc] = true
+	d.mutex.Unlock()
 
 	// Monitor the process in a goroutine
 	go func() {
-		defer stdoutFile.Close()
-		defer stderrFile.Close()
+		defer stdoutLog.Close()
+		defer stderrLog.Close()
 
 		err := cmd.Wait()
+		close(proc.done)
+		d.removePidFile(project.Subdomain, cmd.Process.Pid)
+		crashed := d.recordExit(proc, err)
 
+		// Releases that were replaced or never activated exit quietly, and so does
+		// everything when the platform shuts down
 		d.mutex.Lock()
-		delete(d.processes, project.Subdomain)
+		delete(d.children, proc)
+		live := d.processes[project.Subdomain] == proc
+		if live {
+			delete(d.processes, project.Subdomain)
+		}
 		d.mutex.Unlock()
+		if !live || d.shuttingDown.Load() {
+			return
+		}
 
 		if err != nil {
 			// Application crashed, update project status
@@ -357,29 +529,24 @@ func (d *DeploymentService) startApplication(project *models.Project, deployDir
 			d.updateProjectStatus(project.ID, models.ProjectStatusInactive)
 			fmt.Printf("Application %s stopped\n", project.Subdomain)
 		}
-	}()
-
-	// Wait a moment for the app to start
-	time.Sleep(2 * time.Second)
 
-	// Check if the process is still running
-	if cmd.Process == nil || cmd.ProcessState != nil {
-		return fmt.Errorf("application failed to start")
-	}
+		d.superviseExit(project, crashed)
+	}()
 
-	return nil
+	return proc, nil
 }
 
 // stopProjectProcess stops a running project process
-func (d *DeploymentService) stopProjectProcess(subdomain string) {
+func (d *DeploymentService) stopProjectProcess(subdomain, reason string) {
+	d.cancelRestart(subdomain)
+
 	d.mutex.Lock()
-	defer d.mutex.Unlock()
+	proc, exists := d.processes[subdomain]
+	delete(d.processes, subdomain)
+	d.mutex.Unlock()
 
-	if cmd, exists := d.processes[subdomain]; exists {
-		if cmd.Process != nil {
-			cmd.Process.Kill()
-		}
-		delete(d.processes, subdomain)
+	if exists {
+		d.stopProcess(proc, reason)
 	}
 }
 
@@ -388,45 +555,28 @@ func (d *DeploymentService) IsProjectRunning(subdomain string) bool {
 	d.mutex.RLock()
 	defer d.mutex.RUnlock()
 
-	cmd, exists := d.processes[subdomain]
+	proc, exists := d.processes[subdomain]
 	if !exists {
 		return false
 	}
 
 	// Check if process is still alive
-	return cmd.Process != nil && cmd.ProcessState == nil
+	return proc.running()
 }
 
 // RestartProject restarts a project's application
 func (d *DeploymentService) RestartProject(projectID int64) error {
 	// Get project details
-	var project models.Project
-	err := d.DB.QueryRow(`
-		SELECT id, user_id, name, repo_url, branch, subdomain, build_command, start_command, port
-		FROM projects WHERE id = ?
-	`, projectID).Scan(
-		&project.ID,
-		&project.UserID,
-		&project.Name,
-		&project.RepoURL,
-		&project.Branch,
-		&project.Subdomain,
-		&project.BuildCommand,
-		&project.StartCommand,
-		&project.Port,
-	)
+	project, err := d.Stores.Projects.Get(projectID)
 	if err != nil {
 		return fmt.Errorf("failed to get project: %w", err)
 	}
 
 	// Stop current process
-	d.stopProjectProcess(project.Subdomain)
+	d.stopProjectProcess(project.Subdomain, stopReasonRestart)
 
-	// Start the application
-	deployDir := filepath.Join(d.Config.DeploymentRoot, project.Subdomain)
-	envVars := d.getProjectEnvironmentVariables(project.ID)
-
-	if err := d.startApplication(&project, deployDir, envVars); err != nil {
+	// Start the active release again
+	if err := d.startActiveRelease(project); err != nil {
 		d.updateProjectStatus(project.ID, models.ProjectStatusFailed)
 		return err
 	}
@@ -435,99 +585,77 @@ func (d *DeploymentService) RestartProject(projectID int64) error {
 	return nil
 }
 
-// updateDeploymentStatus updates the deployment status in the database
-func (d *DeploymentService) updateDeploymentStatus(deploymentID int64, status, buildLog, errorMsg string) {
-	finishedAt := time.Now()
-	_, err := d.DB.Exec(`
-		UPDATE deployments 
-		SET status = ?, build_log = ?, error_msg = ?, finished_at = ?
-		WHERE id = ?
-	`, status, buildLog, errorMsg, finishedAt, deploymentID)
+// startActiveRelease starts the release a project serves on the project's port and makes it
+// the live process once it is ready
+func (d *DeploymentService) startActiveRelease(project *models.Project) error {
+	deploymentID, deployDir, err := d.activeRelease(project)
 	if err != nil {
-		fmt.Printf("Failed to update deployment status: %v\n", err)
+		return err
 	}
-}
-
-// updateProjectStatus updates the project status in the database
-func (d *DeploymentService) updateProjectStatus(projectID int64, status string) {
-	_, err := d.DB.Exec("UPDATE projects SET status = ? WHERE id = ?", status, projectID)
+	envVars, err := d.getProjectEnvironmentVariables(project.ID, models.EnvScopeRuntime)
 	if err != nil {
-		fmt.Printf("Failed to update project status: %v\n", err)
+		return err
 	}
-}
-
-// getProjectEnvironmentVariables retrieves environment variables for a project
-func (d *DeploymentService) getProjectEnvironmentVariables(projectID int64) []string {
-	rows, err := d.DB.Query("SELECT key, value FROM environment_variables WHERE project_id = ?", projectID)
+	healthCheck, err := d.GetHealthCheck(project.ID)
 	if err != nil {
-		return nil
+		return err
 	}
-	defer rows.Close()
 
-	var envVars []string
-	for rows.Next() {
-		var key, value string
-		if err := rows.Scan(&key, &value); err != nil {
-			continue
-		}
-		envVars = append(envVars, fmt.Sprintf("%s=%s", key, value))
+	proc, err := d.startApplication(project, deploymentID, deployDir, project.Port, envVars)
+	if err != nil {
+		return err
 	}
+	if err := d.waitForReady(proc, healthCheck, d.Config.ReadinessTimeout); err != nil {
+		d.stopProcess(proc, stopReasonNotReady)
+		return err
+	}
+
+	d.mutex.Lock()
+	d.processes[project.Subdomain] = proc
+	d.mutex.Unlock()
 
-	return envVars
+	return nil
 }
 
-// GetDeploymentLogs retrieves build logs for a deployment
-func (d *DeploymentService) GetDeploymentLogs(deploymentID int64) (string, error) {
-	var buildLog string
-	err := d.DB.QueryRow("SELECT build_log FROM deployments WHERE id = ?", deploymentID).Scan(&buildLog)
-	if err != nil {
-		return "", fmt.Errorf("failed to get deployment logs: %w", err)
+// updateDeploymentStatus updates the deployment status in the database
+func (d *DeploymentService) updateDeploymentStatus(deploymentID int64, status, buildLog, errorMsg string) {
+	if err := d.Stores.Deployments.UpdateStatus(deploymentID, status, buildLog, errorMsg, time.Now()); err != nil {
+		log.Printf("❌ [DEPLOY-%d] Failed to update deployment status: %v", deploymentID, err)
 	}
-	return buildLog, nil
 }
 
-// GetApplicationLogs retrieves runtime logs for a project
-func (d *DeploymentService) GetApplicationLogs(projectID int64) (stdout, stderr string, err error) {
-	// Get project subdomain
-	var subdomain string
-	err = d.DB.QueryRow("SELECT subdomain FROM projects WHERE id = ?", projectID).Scan(&subdomain)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to get project: %w", err)
+// updateProjectStatus updates the project status in the database
+func (d *DeploymentService) updateProjectStatus(projectID int64, status string) {
+	if err := d.Stores.Projects.SetStatus(projectID, status); err != nil {
+		log.Printf("❌ [DEPLOY] Failed to update status of project %d to %s: %v", projectID, status, err)
 	}
+}
 
-	deployDir := filepath.Join(d.Config.DeploymentRoot, subdomain)
-	logDir := filepath.Join(deployDir, "logs")
-
-	// Read stdout log
-	stdoutBytes, err := os.ReadFile(filepath.Join(logDir, "stdout.log"))
-	if err != nil {
-		stdout = "No stdout log available"
-	} else {
-		stdout = string(stdoutBytes)
+// GetDeploymentLogs retrieves build logs for a deployment
+func (d *DeploymentService) GetDeploymentLogs(deploymentID int64) (string, error) {
+	d.buildLogsMutex.Lock()
+	live, ok := d.buildLogs[deploymentID]
+	d.buildLogsMutex.Unlock()
+	if ok {
+		return live.String(), nil
 	}
 
-	// Read stderr log
-	stderrBytes, err := os.ReadFile(filepath.Join(logDir, "stderr.log"))
+	buildLog, err := d.Stores.Deployments.BuildLog(deploymentID)
 	if err != nil {
-		stderr = "No stderr log available"
-	} else {
-		stderr = string(stderrBytes)
+		return "", fmt.Errorf("failed to get deployment logs: %w", err)
 	}
-
-	return stdout, stderr, nil
+	return buildLog, nil
 }
 
 // StopProject stops a running project
 func (d *DeploymentService) StopProject(projectID int64) error {
-	// Get project subdomain
-	var subdomain string
-	err := d.DB.QueryRow("SELECT subdomain FROM projects WHERE id = ?", projectID).Scan(&subdomain)
+	project, err := d.Stores.Projects.Get(projectID)
 	if err != nil {
 		return fmt.Errorf("failed to get project: %w", err)
 	}
 
 	// Stop the process
-	d.stopProjectProcess(subdomain)
+	d.stopProjectProcess(project.Subdomain, stopReasonUser)
 
 	// Update project status to inactive
 	d.updateProjectStatus(projectID, models.ProjectStatusInactive)
@@ -538,22 +666,24 @@ func (d *DeploymentService) StopProject(projectID int64) error {
 // DeleteProject removes a project and its deployments
 func (d *DeploymentService) DeleteProject(projectID int64) error {
 	// Get project details for cleanup
-	var subdomain string
-	err := d.DB.QueryRow("SELECT subdomain FROM projects WHERE id = ?", projectID).Scan(&subdomain)
+	project, err := d.Stores.Projects.Get(projectID)
 	if err != nil {
 		return fmt.Errorf("failed to get project details: %w", err)
 	}
 
 	// Stop the process
-	d.stopProjectProcess(subdomain)
+	d.stopProjectProcess(project.Subdomain, stopReasonDelete)
 
-	// Remove deployment directory
-	deployDir := filepath.Join(d.Config.DeploymentRoot, subdomain)
+	// Remove deployment directory and runtime logs
+	d.forgetAppLog(project.Subdomain)
+	if err := d.logStore.deleteProject(projectID); err != nil {
+		log.Printf("⚠️  [LOGS] Failed to delete runtime logs of project %d: %v", projectID, err)
+	}
+	deployDir := filepath.Join(d.Config.DeploymentRoot, project.Subdomain)
 	os.RemoveAll(deployDir)
 
 	// Delete from database (cascades to deployments and env vars)
-	_, err = d.DB.Exec("DELETE FROM projects WHERE id = ?", projectID)
-	if err != nil {
+	if err := d.Stores.Projects.Delete(projectID); err != nil {
 		return fmt.Errorf("failed to delete project: %w", err)
 	}
 
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// EnvEntry is a variable read from a .env file
+type EnvEntry struct {
+	Key   string
+	Value string
+}
+
+// dotEnvPlainValue matches values that can be written to a .env file without quotes
+var dotEnvPlainValue = regexp.MustCompile(`^[A-Za-z0-9_./:@%+,=-]*$`)
+
+// ParseDotEnv parses variables in .env format: KEY=VALUE lines, optionally prefixed with
+// "export". Values may be single quoted (taken literally) or double quoted (\n, \r, \t, \"
+// and \\ escapes). Unquoted values end at " #". Blank lines and lines starting with # are
+// skipped. Keys are validated and must not repeat, ignoring case.
+func ParseDotEnv(text string) ([]EnvEntry, error) {
+	var entries []EnvEntry
+	seen := make(map[string]int)
+
+	for i, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		number := i + 1
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", number)
+		}
+		key = strings.TrimSpace(key)
+		if err := ValidateEnvKey(key); err != nil {
+			return nil, fmt.Errorf("line %d: %w", number, err)
+		}
+		if previous, ok := seen[strings.ToUpper(key)]; ok {
+			return nil, fmt.Errorf("line %d: %s is already set on line %d", number, key, previous)
+		}
+		seen[strings.ToUpper(key)] = number
+
+		value, err := parseDotEnvValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", number, err)
+		}
+		if strings.ContainsRune(value, 0) {
+			return nil, fmt.Errorf("line %d: value of %s contains a NUL byte", number, key)
+		}
+
+		entries = append(entries, EnvEntry{Key: key, Value: value})
+	}
+
+	return entries, nil
+}
+
+// parseDotEnvValue unquotes a .env value
+func parseDotEnvValue(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "'"):
+		end := strings.Index(value[1:], "'")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated single quoted value")
+		}
+		if rest := strings.TrimSpace(value[end+2:]); rest != "" && !strings.HasPrefix(rest, "#") {
+			return "", fmt.Errorf("unexpected text after quoted value")
+		}
+		return value[1 : end+1], nil
+
+	case strings.HasPrefix(value, `"`):
+		var unquoted strings.Builder
+		for i := 1; i < len(value); i++ {
+			c := value[i]
+			switch {
+			case c == '"':
+				if rest := strings.TrimSpace(value[i+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+					return "", fmt.Errorf("unexpected text after quoted value")
+				}
+				return unquoted.String(), nil
+			case c == '\\' && i+1 < len(value):
+				i++
+				switch value[i] {
+				case 'n':
+					unquoted.WriteByte('\n')
+				case 'r':
+					unquoted.WriteByte('\r')
+				case 't':
+					unquoted.WriteByte('\t')
+				default:
+					unquoted.WriteByte(value[i])
+				}
+			default:
+				unquoted.WriteByte(c)
+			}
+		}
+		return "", fmt.Errorf("unterminated double quoted value")
+
+	default:
+		if i := strings.Index(value, " #"); i >= 0 {
+			value = value[:i]
+		}
+		return strings.TrimSpace(value), nil
+	}
+}
+
+// FormatDotEnv writes variables in .env format, quoting values where needed so ParseDotEnv
+// reads them back unchanged
+func FormatDotEnv(entries []EnvEntry) string {
+	var text strings.Builder
+	for _, entry := range entries {
+		text.WriteString(entry.Key)
+		text.WriteByte('=')
+		switch {
+		case dotEnvPlainValue.MatchString(entry.Value):
+			text.WriteString(entry.Value)
+		case !strings.ContainsAny(entry.Value, "'\n\r"):
+			text.WriteString("'" + entry.Value + "'")
+		default:
+			replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+			text.WriteString(`"` + replacer.Replace(entry.Value) + `"`)
+		}
+		text.WriteByte('\n')
+	}
+	return text.String()
+}
@@ -0,0 +1,335 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"goth-deploy/internal/models"
+	"goth-deploy/internal/store"
+)
+
+// baseEnvironmentKeys are the variables of the platform's own environment that builds and
+// applications receive. Everything else, the platform's secrets included, is withheld;
+// ENV_PASSTHROUGH adds further names.
+var baseEnvironmentKeys = []string{
+	"PATH", "HOME", "USER", "LOGNAME", "SHELL", "LANG", "LC_ALL", "TZ", "TMPDIR",
+	"GOROOT", "GOPATH", "GOCACHE", "GOMODCACHE", "GOPROXY", "GOPRIVATE", "GONOSUMDB", "GOFLAGS", "GOTOOLCHAIN",
+	"SSL_CERT_FILE", "SSL_CERT_DIR", "HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY",
+	// Windows
+	"SYSTEMROOT", "COMSPEC", "PATHEXT", "TEMP", "TMP", "USERPROFILE", "APPDATA", "LOCALAPPDATA",
+}
+
+// baseEnvironment returns the allow-listed part of the platform's environment
+func (d *DeploymentService) baseEnvironment() []string {
+	var env []string
+	for _, keys := range [][]string{baseEnvironmentKeys, d.Config.EnvPassthrough} {
+		for _, key := range keys {
+			if value, ok := os.LookupEnv(key); ok {
+				env = append(env, key+"="+value)
+			}
+		}
+	}
+	return env
+}
+
+// getProjectEnvironmentVariables retrieves the environment variables of a project that apply to
+// scope (models.EnvScopeBuild or models.EnvScopeRuntime), including those scoped to both
+func (d *DeploymentService) getProjectEnvironmentVariables(projectID int64, scope string) ([]string, error) {
+	stored, err := d.Stores.Env.List(projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load environment variables: %w", err)
+	}
+
+	var envVars []string
+	for _, envVar := range stored {
+		if envVar.Scope != scope && envVar.Scope != models.EnvScopeBoth {
+			continue
+		}
+		value, err := d.Secrets.Decrypt(envVar.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt environment variable %s: %w", envVar.Key, err)
+		}
+		envVars = append(envVars, fmt.Sprintf("%s=%s", envVar.Key, value))
+	}
+
+	return envVars, nil
+}
+
+// envKeyPattern matches POSIX environment variable names
+var envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// reservedEnvKeys are set by the platform and cannot be overridden by a project
+var reservedEnvKeys = map[string]bool{
+	"PORT": true,
+}
+
+// maxEnvValueSize is the largest value a variable may hold, well below the kernel's limit for
+// a single environment string
+const maxEnvValueSize = 64 * 1024
+
+// ErrEnvVarNotFound is returned for environment variables that do not belong to the project
+var ErrEnvVarNotFound = errors.New("environment variable not found")
+
+// ErrEnvVarExists is returned when a project already has a variable with the same name. Names
+// that differ only in case collide, they are the same variable on Windows.
+var ErrEnvVarExists = errors.New("environment variable already exists")
+
+// EnvChange actions
+const (
+	EnvChangeAdded     = "added"
+	EnvChangeChanged   = "changed"
+	EnvChangeRemoved   = "removed"
+	EnvChangeUnchanged = "unchanged"
+)
+
+// EnvChange describes what an import does to a variable
+type EnvChange struct {
+	Key      string `json:"key"`
+	Action   string `json:"action"`
+	Scope    string `json:"scope"`
+	OldValue string `json:"old_value,omitempty"`
+	NewValue string `json:"new_value,omitempty"`
+
+	id int64 // existing variable, 0 for added ones
+}
+
+// ValidateEnvKey reports whether key is a valid POSIX variable name a project may set
+func ValidateEnvKey(key string) error {
+	if !envKeyPattern.MatchString(key) {
+		return fmt.Errorf("invalid name %q, names consist of letters, digits and underscores and do not start with a digit", key)
+	}
+	if reservedEnvKeys[strings.ToUpper(key)] {
+		return fmt.Errorf("%s is set by the platform and cannot be overridden", key)
+	}
+	return nil
+}
+
+// ValidateEnvironmentVariable reports whether an environment variable can be saved
+func ValidateEnvironmentVariable(envVar models.EnvironmentVariable) error {
+	if err := ValidateEnvKey(envVar.Key); err != nil {
+		return err
+	}
+	if err := ValidateEnvScope(envVar.Scope); err != nil {
+		return err
+	}
+	if len(envVar.Value) > maxEnvValueSize {
+		return fmt.Errorf("value of %s exceeds %d bytes", envVar.Key, maxEnvValueSize)
+	}
+	if strings.ContainsRune(envVar.Value, 0) {
+		return fmt.Errorf("value of %s contains a NUL byte", envVar.Key)
+	}
+	return nil
+}
+
+// ValidateEnvScope reports whether scope is a valid environment variable scope
+func ValidateEnvScope(scope string) error {
+	switch scope {
+	case models.EnvScopeBuild, models.EnvScopeRuntime, models.EnvScopeBoth:
+		return nil
+	}
+	return fmt.Errorf("scope must be one of build, runtime or both")
+}
+
+// ListEnvironmentVariables returns the decrypted environment variables of a project
+func (d *DeploymentService) ListEnvironmentVariables(projectID int64) ([]models.EnvironmentVariable, error) {
+	envVars, err := d.Stores.Env.List(projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get environment variables: %w", err)
+	}
+	for i := range envVars {
+		if err := d.decryptEnvironmentVariable(&envVars[i]); err != nil {
+			return nil, err
+		}
+	}
+	return envVars, nil
+}
+
+// GetEnvironmentVariable returns a decrypted environment variable of a project
+func (d *DeploymentService) GetEnvironmentVariable(projectID, id int64) (*models.EnvironmentVariable, error) {
+	envVar, err := d.Stores.Env.Get(projectID, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrEnvVarNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get environment variable: %w", err)
+	}
+	if err := d.decryptEnvironmentVariable(envVar); err != nil {
+		return nil, err
+	}
+	return envVar, nil
+}
+
+// decryptEnvironmentVariable decrypts the value of a stored environment variable
+func (d *DeploymentService) decryptEnvironmentVariable(envVar *models.EnvironmentVariable) error {
+	value, err := d.Secrets.Decrypt(envVar.Value)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt environment variable %s: %w", envVar.Key, err)
+	}
+	envVar.Value = value
+	return nil
+}
+
+// CreateEnvironmentVariable adds an environment variable to a project
+func (d *DeploymentService) CreateEnvironmentVariable(envVar *models.EnvironmentVariable) error {
+	if err := ValidateEnvironmentVariable(*envVar); err != nil {
+		return err
+	}
+	if err := d.checkEnvKeyCollision(envVar.ProjectID, envVar.ID, envVar.Key); err != nil {
+		return err
+	}
+
+	value, err := d.Secrets.Encrypt(envVar.Value)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt environment variable: %w", err)
+	}
+
+	stored := *envVar
+	stored.Value = value
+	if err := d.Stores.Env.Create(&stored); err != nil {
+		return fmt.Errorf("failed to create environment variable: %w", err)
+	}
+	envVar.ID, envVar.CreatedAt, envVar.UpdatedAt = stored.ID, stored.CreatedAt, stored.UpdatedAt
+	return nil
+}
+
+// UpdateEnvironmentVariable saves the name, value and scope of an environment variable
+func (d *DeploymentService) UpdateEnvironmentVariable(envVar *models.EnvironmentVariable) error {
+	if err := ValidateEnvironmentVariable(*envVar); err != nil {
+		return err
+	}
+	if err := d.checkEnvKeyCollision(envVar.ProjectID, envVar.ID, envVar.Key); err != nil {
+		return err
+	}
+
+	value, err := d.Secrets.Encrypt(envVar.Value)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt environment variable: %w", err)
+	}
+
+	stored := *envVar
+	stored.Value = value
+	err = d.Stores.Env.Update(&stored)
+	if errors.Is(err, store.ErrNotFound) {
+		return ErrEnvVarNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update environment variable: %w", err)
+	}
+	envVar.UpdatedAt = stored.UpdatedAt
+	return nil
+}
+
+// DeleteEnvironmentVariable removes an environment variable from a project
+func (d *DeploymentService) DeleteEnvironmentVariable(projectID, id int64) error {
+	err := d.Stores.Env.Delete(projectID, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return ErrEnvVarNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete environment variable: %w", err)
+	}
+	return nil
+}
+
+// checkEnvKeyCollision returns ErrEnvVarExists if another variable of the project has the
+// same name, ignoring case
+func (d *DeploymentService) checkEnvKeyCollision(projectID, id int64, key string) error {
+	existing, err := d.Stores.Env.FindKey(projectID, key, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check environment variable names: %w", err)
+	}
+	return fmt.Errorf("%w: %s", ErrEnvVarExists, existing.Key)
+}
+
+// DiffEnvironmentVariables compares variables read from a .env file with a project's
+// variables. New variables get scope, existing ones keep theirs. With replace, variables
+// missing from the file are removed.
+func (d *DeploymentService) DiffEnvironmentVariables(projectID int64, entries []EnvEntry, scope string, replace bool) ([]EnvChange, error) {
+	if err := ValidateEnvScope(scope); err != nil {
+		return nil, err
+	}
+
+	current, err := d.ListEnvironmentVariables(projectID)
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]models.EnvironmentVariable)
+	for _, envVar := range current {
+		existing[strings.ToUpper(envVar.Key)] = envVar
+	}
+
+	var changes []EnvChange
+	imported := make(map[string]bool)
+	for _, entry := range entries {
+		if err := ValidateEnvironmentVariable(models.EnvironmentVariable{Key: entry.Key, Value: entry.Value, Scope: scope}); err != nil {
+			return nil, err
+		}
+		upper := strings.ToUpper(entry.Key)
+		if imported[upper] {
+			return nil, fmt.Errorf("%w: %s is set more than once", ErrEnvVarExists, entry.Key)
+		}
+		imported[upper] = true
+
+		envVar, ok := existing[upper]
+		switch {
+		case !ok:
+			changes = append(changes, EnvChange{Key: entry.Key, Action: EnvChangeAdded, Scope: scope, NewValue: entry.Value})
+		case envVar.Key != entry.Key:
+			return nil, fmt.Errorf("%w: %s collides with %s", ErrEnvVarExists, entry.Key, envVar.Key)
+		case envVar.Value != entry.Value:
+			changes = append(changes, EnvChange{Key: entry.Key, Action: EnvChangeChanged, Scope: envVar.Scope, OldValue: envVar.Value, NewValue: entry.Value, id: envVar.ID})
+		default:
+			changes = append(changes, EnvChange{Key: entry.Key, Action: EnvChangeUnchanged, Scope: envVar.Scope, id: envVar.ID})
+		}
+	}
+
+	if replace {
+		for _, envVar := range current {
+			if !imported[strings.ToUpper(envVar.Key)] {
+				changes = append(changes, EnvChange{Key: envVar.Key, Action: EnvChangeRemoved, Scope: envVar.Scope, OldValue: envVar.Value, id: envVar.ID})
+			}
+		}
+	}
+
+	return changes, nil
+}
+
+// ImportEnvironmentVariables applies the changes of DiffEnvironmentVariables in one
+// transaction and returns them
+func (d *DeploymentService) ImportEnvironmentVariables(projectID int64, entries []EnvEntry, scope string, replace bool) ([]EnvChange, error) {
+	changes, err := d.DiffEnvironmentVariables(projectID, entries, scope, replace)
+	if err != nil {
+		return nil, err
+	}
+
+	var batch store.EnvBatch
+	for _, change := range changes {
+		envVar := models.EnvironmentVariable{ID: change.id, ProjectID: projectID, Key: change.Key, Scope: change.Scope}
+		switch change.Action {
+		case EnvChangeAdded, EnvChangeChanged:
+			value, err := d.Secrets.Encrypt(change.NewValue)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encrypt environment variable: %w", err)
+			}
+			envVar.Value = value
+			if change.Action == EnvChangeAdded {
+				batch.Create = append(batch.Create, envVar)
+			} else {
+				batch.Update = append(batch.Update, envVar)
+			}
+		case EnvChangeRemoved:
+			batch.Delete = append(batch.Delete, envVar)
+		}
+	}
+
+	if err := d.Stores.Env.Apply(projectID, batch); err != nil {
+		return nil, fmt.Errorf("failed to import environment variables: %w", err)
+	}
+	return changes, nil
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"goth-deploy/internal/config"
+	"goth-deploy/internal/models"
+	"goth-deploy/internal/secrets"
+	"goth-deploy/internal/store"
+)
+
+// newTestService returns a deployment service backed by in-memory stores, with one user
+// owning one project
+func newTestService(t *testing.T) (*DeploymentService, *models.Project) {
+	t.Helper()
+	key, err := secrets.GenerateKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	keyring, err := secrets.NewKeyring(key)
+	if err != nil {
+		t.Fatalf("failed to create keyring: %v", err)
+	}
+
+	stores := store.NewMemory()
+	user := &models.User{GitHubID: 1, Username: "owner", AccessToken: "token"}
+	if err := stores.Users.Create(user); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	project := &models.Project{UserID: user.ID, Name: "app", GitHubRepoID: 7, RepoURL: "https://github.com/octo/app", Branch: "main", Subdomain: "app", Port: 8080}
+	if err := stores.Projects.Create(project); err != nil {
+		t.Fatalf("failed to create project: %v", err)
+	}
+
+	return NewDeploymentService(nil, stores, &config.Config{}, keyring), project
+}
+
+// createEnv adds a variable to a project through the service
+func createEnv(t *testing.T, d *DeploymentService, projectID int64, key, value, scope string) *models.EnvironmentVariable {
+	t.Helper()
+	envVar := &models.EnvironmentVariable{ProjectID: projectID, Key: key, Value: value, Scope: scope}
+	if err := d.CreateEnvironmentVariable(envVar); err != nil {
+		t.Fatalf("failed to create %s: %v", key, err)
+	}
+	return envVar
+}
+
+func TestEnvironmentVariablesAreEncrypted(t *testing.T) {
+	d, project := newTestService(t)
+	envVar := createEnv(t, d, project.ID, "API_KEY", "hunter2", models.EnvScopeBoth)
+
+	stored, err := d.Stores.Env.Get(project.ID, envVar.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !secrets.IsEncrypted(stored.Value) {
+		t.Fatalf("stored value %q is not encrypted", stored.Value)
+	}
+
+	envVars, err := d.ListEnvironmentVariables(project.ID)
+	if err != nil {
+		t.Fatalf("ListEnvironmentVariables: %v", err)
+	}
+	if len(envVars) != 1 || envVars[0].Value != "hunter2" {
+		t.Fatalf("ListEnvironmentVariables = %+v, want the decrypted value", envVars)
+	}
+
+	got, err := d.GetEnvironmentVariable(project.ID, envVar.ID)
+	if err != nil {
+		t.Fatalf("GetEnvironmentVariable: %v", err)
+	}
+	if got.Value != "hunter2" {
+		t.Errorf("GetEnvironmentVariable value = %q, want hunter2", got.Value)
+	}
+}
+
+func TestEnvironmentVariableErrors(t *testing.T) {
+	d, project := newTestService(t)
+	envVar := createEnv(t, d, project.ID, "API_KEY", "a", models.EnvScopeBoth)
+
+	duplicate := &models.EnvironmentVariable{ProjectID: project.ID, Key: "api_key", Value: "b", Scope: models.EnvScopeBoth}
+	if err := d.CreateEnvironmentVariable(duplicate); !errors.Is(err, ErrEnvVarExists) {
+		t.Errorf("creating a name differing in case: err = %v, want ErrEnvVarExists", err)
+	}
+
+	reserved := &models.EnvironmentVariable{ProjectID: project.ID, Key: "PORT", Value: "1", Scope: models.EnvScopeBoth}
+	if err := d.CreateEnvironmentVariable(reserved); err == nil {
+		t.Error("creating PORT succeeded")
+	}
+
+	missing := &models.EnvironmentVariable{ID: envVar.ID + 100, ProjectID: project.ID, Key: "OTHER", Value: "c", Scope: models.EnvScopeBoth}
+	if err := d.UpdateEnvironmentVariable(missing); !errors.Is(err, ErrEnvVarNotFound) {
+		t.Errorf("updating a missing variable: err = %v, want ErrEnvVarNotFound", err)
+	}
+	if err := d.DeleteEnvironmentVariable(project.ID, envVar.ID+100); !errors.Is(err, ErrEnvVarNotFound) {
+		t.Errorf("deleting a missing variable: err = %v, want ErrEnvVarNotFound", err)
+	}
+	if _, err := d.GetEnvironmentVariable(project.ID+100, envVar.ID); !errors.Is(err, ErrEnvVarNotFound) {
+		t.Errorf("getting a variable of another project: err = %v, want ErrEnvVarNotFound", err)
+	}
+}
+
+func TestImportEnvironmentVariables(t *testing.T) {
+	d, project := newTestService(t)
+	createEnv(t, d, project.ID, "KEEP", "same", models.EnvScopeRuntime)
+	createEnv(t, d, project.ID, "CHANGE", "old", models.EnvScopeBuild)
+	createEnv(t, d, project.ID, "REMOVE", "gone", models.EnvScopeBoth)
+
+	entries := []EnvEntry{{Key: "KEEP", Value: "same"}, {Key: "CHANGE", Value: "new"}, {Key: "ADD", Value: "added"}}
+	changes, err := d.ImportEnvironmentVariables(project.ID, entries, models.EnvScopeBoth, true)
+	if err != nil {
+		t.Fatalf("ImportEnvironmentVariables: %v", err)
+	}
+
+	actions := make(map[string]string)
+	for _, change := range changes {
+		actions[change.Key] = change.Action
+	}
+	want := map[string]string{"KEEP": EnvChangeUnchanged, "CHANGE": EnvChangeChanged, "ADD": EnvChangeAdded, "REMOVE": EnvChangeRemoved}
+	if !reflect.DeepEqual(actions, want) {
+		t.Errorf("changes = %v, want %v", actions, want)
+	}
+
+	envVars, err := d.ListEnvironmentVariables(project.ID)
+	if err != nil {
+		t.Fatalf("ListEnvironmentVariables: %v", err)
+	}
+	got := make(map[string]string)
+	for _, envVar := range envVars {
+		got[envVar.Key] = envVar.Value + "/" + envVar.Scope
+	}
+	wantVars := map[string]string{"KEEP": "same/runtime", "CHANGE": "new/build", "ADD": "added/both"}
+	if !reflect.DeepEqual(got, wantVars) {
+		t.Errorf("variables after import = %v, want %v", got, wantVars)
+	}
+
+	if _, err := d.ImportEnvironmentVariables(project.ID, []EnvEntry{{Key: "keep", Value: "x"}}, models.EnvScopeBoth, false); !errors.Is(err, ErrEnvVarExists) {
+		t.Errorf("importing a name differing in case: err = %v, want ErrEnvVarExists", err)
+	}
+}
+
+func TestProjectEnvironmentVariableScopes(t *testing.T) {
+	d, project := newTestService(t)
+	createEnv(t, d, project.ID, "BUILD_ONLY", "b", models.EnvScopeBuild)
+	createEnv(t, d, project.ID, "RUNTIME_ONLY", "r", models.EnvScopeRuntime)
+	createEnv(t, d, project.ID, "SHARED", "s", models.EnvScopeBoth)
+
+	tests := []struct {
+		scope string
+		want  []string
+	}{
+		{models.EnvScopeBuild, []string{"BUILD_ONLY=b", "SHARED=s"}},
+		{models.EnvScopeRuntime, []string{"RUNTIME_ONLY=r", "SHARED=s"}},
+	}
+	for _, tt := range tests {
+		got, err := d.getProjectEnvironmentVariables(project.ID, tt.scope)
+		if err != nil {
+			t.Fatalf("getProjectEnvironmentVariables(%s): %v", tt.scope, err)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("getProjectEnvironmentVariables(%s) = %v, want %v", tt.scope, got, tt.want)
+		}
+	}
+}
@@ -2,19 +2,25 @@ package services
 
 import (
 	"context"
-	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"strings"
 	"time"
 
 	"goth-deploy/internal/models"
+	"goth-deploy/internal/secrets"
+	"goth-deploy/internal/store"
 
 	"github.com/google/go-github/v66/github"
 	"golang.org/x/oauth2"
 	githubOAuth "golang.org/x/oauth2/github"
 )
 
+// ErrRepoNotFound is returned for repositories that do not exist or the user cannot see
+var ErrRepoNotFound = errors.New("repository not found on GitHub")
+
 // GitHubService handles GitHub OAuth and API interactions
 type GitHubService struct {
 	Config *oauth2.Config
@@ -26,7 +32,7 @@ func NewGitHubService(clientID, clientSecret, redirectURL string) *GitHubService
 		ClientID:     clientID,
 		ClientSecret: clientSecret,
 		RedirectURL:  redirectURL,
-		Scopes:       []string{"read:user", "user:email", "repo"},
+		Scopes:       []string{"read:user", "user:email", "repo", "read:org"},
 		Endpoint:     githubOAuth.Endpoint,
 	}
 
@@ -140,70 +146,173 @@ func (g *GitHubService) GetUserRepositories(ctx context.Context, accessToken str
 	return allRepos, nil
 }
 
-// CreateOrUpdateUser creates or updates a user in the database
-func (g *GitHubService) CreateOrUpdateUser(db *sql.DB, user *models.User) error {
-	// Check if user exists
-	var existingID int64
-	err := db.QueryRow("SELECT id FROM users WHERE github_id = ?", user.GitHubID).Scan(&existingID)
+// GetRepository retrieves a repository by its owner/repo name
+func (g *GitHubService) GetRepository(ctx context.Context, accessToken, repoFullName string) (*models.GitHubRepo, error) {
+	owner, name, err := splitRepoFullName(repoFullName)
+	if err != nil {
+		return nil, err
+	}
 
-	if err == sql.ErrNoRows {
-		// Create new user
-		result, err := db.Exec(`
-			INSERT INTO users (github_id, username, email, avatar_url, access_token, created_at, updated_at)
-			VALUES (?, ?, ?, ?, ?, ?, ?)
-		`, user.GitHubID, user.Username, user.Email, user.AvatarURL, user.AccessToken, user.CreatedAt, user.UpdatedAt)
-		if err != nil {
-			return fmt.Errorf("failed to create user: %w", err)
+	repo, resp, err := g.newClient(ctx, accessToken).Repositories.Get(ctx, owner, name)
+	if err != nil {
+		if isNotFound(resp) {
+			return nil, ErrRepoNotFound
 		}
+		return nil, fmt.Errorf("failed to get repository %s: %w", repoFullName, err)
+	}
 
-		id, err := result.LastInsertId()
+	return &models.GitHubRepo{
+		ID:            repo.GetID(),
+		Name:          repo.GetName(),
+		FullName:      repo.GetFullName(),
+		CloneURL:      repo.GetCloneURL(),
+		HTMLURL:       repo.GetHTMLURL(),
+		Description:   repo.GetDescription(),
+		Private:       repo.GetPrivate(),
+		Language:      repo.GetLanguage(),
+		DefaultBranch: repo.GetDefaultBranch(),
+	}, nil
+}
+
+// ListOrgMembers returns the logins of the members of a GitHub organization. Private members
+// are only listed if the token's user is a member too.
+func (g *GitHubService) ListOrgMembers(ctx context.Context, accessToken, org string) ([]string, error) {
+	githubClient := g.newClient(ctx, accessToken)
+
+	var logins []string
+	opts := &github.ListMembersOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		members, resp, err := githubClient.Organizations.ListMembers(ctx, org, opts)
 		if err != nil {
-			return fmt.Errorf("failed to get user ID: %w", err)
+			return nil, fmt.Errorf("failed to list members of %s: %w", org, err)
+		}
+		for _, member := range members {
+			logins = append(logins, member.GetLogin())
+		}
+
+		if resp.NextPage == 0 {
+			break
 		}
-		user.ID = id
+		opts.Page = resp.NextPage
+	}
+
+	return logins, nil
+}
+
+// CreateOrUpdateUser creates or updates a user in the database. The access token is stored
+// encrypted.
+func (g *GitHubService) CreateOrUpdateUser(users store.UserStore, keyring *secrets.Keyring, user *models.User) error {
+	accessToken, err := keyring.Encrypt(user.AccessToken)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt access token: %w", err)
+	}
+
+	stored := *user
+	stored.AccessToken = accessToken
+
+	// Check if user exists
+	existing, err := users.GetByGitHubID(user.GitHubID)
+	if errors.Is(err, store.ErrNotFound) {
+		// Create new user
+		if err := users.Create(&stored); err != nil {
+			return fmt.Errorf("failed to create user: %w", err)
+		}
+		user.ID, user.CreatedAt = stored.ID, stored.CreatedAt
 	} else if err != nil {
 		return fmt.Errorf("failed to check existing user: %w", err)
 	} else {
 		// Update existing user
-		user.ID = existingID
-		user.UpdatedAt = time.Now()
-		_, err = db.Exec(`
-			UPDATE users 
-			SET username = ?, email = ?, avatar_url = ?, access_token = ?, updated_at = ?
-			WHERE github_id = ?
-		`, user.Username, user.Email, user.AvatarURL, user.AccessToken, user.UpdatedAt, user.GitHubID)
-		if err != nil {
+		stored.ID = existing.ID
+		if err := users.Update(&stored); err != nil {
 			return fmt.Errorf("failed to update user: %w", err)
 		}
+		user.ID, user.CreatedAt = existing.ID, existing.CreatedAt
 	}
+	user.UpdatedAt = stored.UpdatedAt
 
 	return nil
 }
 
-// CreateWebhook creates a webhook for the given repository
-func (g *GitHubService) CreateWebhook(ctx context.Context, accessToken, repoFullName, webhookURL, secret string) error {
-	token := &oauth2.Token{AccessToken: accessToken}
-	client := g.Config.Client(ctx, token)
-	githubClient := github.NewClient(client)
+// CreateWebhook creates a push webhook for the given repository and returns its ID
+func (g *GitHubService) CreateWebhook(ctx context.Context, accessToken, repoFullName, webhookURL, secret string) (int64, error) {
+	githubClient := g.newClient(ctx, accessToken)
+
+	owner, repo, err := splitRepoFullName(repoFullName)
+	if err != nil {
+		return 0, err
+	}
+
+	hook, _, err := githubClient.Repositories.CreateHook(ctx, owner, repo, newPushHook(webhookURL, secret))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create webhook: %w", err)
+	}
+
+	return hook.GetID(), nil
+}
+
+// DeleteWebhook deletes a webhook from the given repository; a hook that is already gone is not an error
+func (g *GitHubService) DeleteWebhook(ctx context.Context, accessToken, repoFullName string, hookID int64) error {
+	githubClient := g.newClient(ctx, accessToken)
+
+	owner, repo, err := splitRepoFullName(repoFullName)
+	if err != nil {
+		return err
+	}
+
+	resp, err := githubClient.Repositories.DeleteHook(ctx, owner, repo, hookID)
+	if err != nil && !isNotFound(resp) {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+
+	return nil
+}
+
+// SyncWebhook restores a repository webhook to the expected configuration, recreating it
+// if it was deleted on GitHub, and returns the ID of the hook now in place
+func (g *GitHubService) SyncWebhook(ctx context.Context, accessToken, repoFullName string, hookID int64, webhookURL, secret string) (int64, error) {
+	githubClient := g.newClient(ctx, accessToken)
 
-	// Parse owner and repo from full name
-	var owner, repo string
-	if err := json.Unmarshal([]byte(fmt.Sprintf(`"%s"`, repoFullName)), &repoFullName); err == nil {
-		parts := []rune(repoFullName)
-		for i, char := range parts {
-			if char == '/' {
-				owner = string(parts[:i])
-				repo = string(parts[i+1:])
-				break
+	owner, repo, err := splitRepoFullName(repoFullName)
+	if err != nil {
+		return 0, err
+	}
+
+	if hookID != 0 {
+		_, resp, err := githubClient.Repositories.GetHook(ctx, owner, repo, hookID)
+		if err == nil {
+			hook, _, err := githubClient.Repositories.EditHook(ctx, owner, repo, hookID, newPushHook(webhookURL, secret))
+			if err != nil {
+				return 0, fmt.Errorf("failed to update webhook: %w", err)
 			}
+			return hook.GetID(), nil
+		}
+		if !isNotFound(resp) {
+			return 0, fmt.Errorf("failed to get webhook: %w", err)
 		}
+		log.Printf("Webhook %d no longer exists on %s, recreating it", hookID, repoFullName)
 	}
 
-	if owner == "" || repo == "" {
-		return fmt.Errorf("invalid repository full name: %s", repoFullName)
+	return g.CreateWebhook(ctx, accessToken, repoFullName, webhookURL, secret)
+}
+
+// RepoFullNameFromURL extracts the owner/repo name from a GitHub repository URL
+func RepoFullNameFromURL(repoURL string) (string, error) {
+	parts := strings.Split(normalizeRepoURL(repoURL), "/")
+	if len(parts) != 3 || parts[1] == "" || parts[2] == "" {
+		return "", fmt.Errorf("invalid repository URL: %s", repoURL)
 	}
+	return parts[1] + "/" + parts[2], nil
+}
+
+// newClient creates a GitHub API client authenticated with the given access token
+func (g *GitHubService) newClient(ctx context.Context, accessToken string) *github.Client {
+	token := &oauth2.Token{AccessToken: accessToken}
+	return github.NewClient(g.Config.Client(ctx, token))
+}
 
-	hook := &github.Hook{
+// newPushHook builds the webhook definition used for push-triggered deployments
+func newPushHook(webhookURL, secret string) *github.Hook {
+	return &github.Hook{
 		Name:   github.String("web"),
 		Active: github.Bool(true),
 		Events: []string{"push"},
@@ -213,11 +322,18 @@ func (g *GitHubService) CreateWebhook(ctx context.Context, accessToken, repoFull
 			Secret:      github.String(secret),
 		},
 	}
+}
 
-	_, _, err := githubClient.Repositories.CreateHook(ctx, owner, repo, hook)
-	if err != nil {
-		return fmt.Errorf("failed to create webhook: %w", err)
+// splitRepoFullName splits an owner/repo name into its parts
+func splitRepoFullName(repoFullName string) (string, string, error) {
+	owner, repo, ok := strings.Cut(repoFullName, "/")
+	if !ok || owner == "" || repo == "" {
+		return "", "", fmt.Errorf("invalid repository full name: %s", repoFullName)
 	}
+	return owner, repo, nil
+}
 
-	return nil
+// isNotFound reports whether a GitHub API response is a 404
+func isNotFound(resp *github.Response) bool {
+	return resp != nil && resp.StatusCode == http.StatusNotFound
 }
@@ -0,0 +1,282 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"goth-deploy/internal/models"
+)
+
+// readinessPollInterval is how often a starting release is probed
+const readinessPollInterval = 250 * time.Millisecond
+
+// healthTickInterval is how often the health checker looks for checks that are due
+const healthTickInterval = time.Second
+
+// healthState tracks liveness checks of a single running release
+type healthState struct {
+	proc      *appProcess
+	nextCheck time.Time
+	failures  int
+	checking  bool
+}
+
+// getHealthCheck loads the health check settings of a project
+func (d *DeploymentService) GetHealthCheck(projectID int64) (models.HealthCheck, error) {
+	project, err := d.Stores.Projects.Get(projectID)
+	if err != nil {
+		return models.HealthCheck{}, fmt.Errorf("failed to get health check: %w", err)
+	}
+	return project.HealthCheck, nil
+}
+
+// UpdateHealthCheck validates and saves the health check settings of a project
+func (d *DeploymentService) UpdateHealthCheck(projectID int64, hc models.HealthCheck) error {
+	if err := ValidateHealthCheck(hc); err != nil {
+		return err
+	}
+
+	err := d.Stores.Projects.UpdateHealthCheck(projectID, hc)
+	if err != nil {
+		return fmt.Errorf("failed to update health check: %w", err)
+	}
+
+	// Apply the new interval from the next check on
+	d.healthMutex.Lock()
+	for _, state := range d.health {
+		if state.proc.projectID == projectID {
+			state.nextCheck = time.Now()
+		}
+	}
+	d.healthMutex.Unlock()
+
+	return nil
+}
+
+// ValidateHealthCheck reports whether health check settings are usable
+func ValidateHealthCheck(hc models.HealthCheck) error {
+	if hc.Path != "" && !strings.HasPrefix(hc.Path, "/") {
+		return fmt.Errorf("health check path must start with /")
+	}
+	if hc.ExpectedStatus < 100 || hc.ExpectedStatus > 599 {
+		return fmt.Errorf("expected status must be a valid HTTP status code")
+	}
+	if hc.Interval < 1 {
+		return fmt.Errorf("interval must be at least 1 second")
+	}
+	if hc.Timeout < 1 {
+		return fmt.Errorf("timeout must be at least 1 second")
+	}
+	if hc.Timeout > hc.Interval {
+		return fmt.Errorf("timeout must not be longer than the interval")
+	}
+	if hc.FailureThreshold < 1 {
+		return fmt.Errorf("failure threshold must be at least 1")
+	}
+	return nil
+}
+
+// describeHealthCheck returns a short description of what a health check probes
+func describeHealthCheck(hc models.HealthCheck) string {
+	if hc.Path == "" {
+		return "TCP connect"
+	}
+	return fmt.Sprintf("GET %s expecting %d", hc.Path, hc.ExpectedStatus)
+}
+
+// checkHealth probes a release once
+func checkHealth(proc *appProcess, hc models.HealthCheck) error {
+	timeout := time.Duration(hc.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+
+	if hc.Path == "" {
+		conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", proc.port), timeout)
+		if err != nil {
+			return fmt.Errorf("not accepting connections on port %d", proc.port)
+		}
+		conn.Close()
+		return nil
+	}
+
+	client := &http.Client{
+		Timeout: timeout,
+		// A redirect is a response in its own right, not something to follow
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(fmt.Sprintf("http://127.0.0.1:%d%s", proc.port, hc.Path))
+	if err != nil {
+		return fmt.Errorf("GET %s failed: %w", hc.Path, err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != hc.ExpectedStatus {
+		return fmt.Errorf("GET %s returned %d, expected %d", hc.Path, resp.StatusCode, hc.ExpectedStatus)
+	}
+	return nil
+}
+
+// waitForReady waits until the release passes its health check
+func (d *DeploymentService) waitForReady(proc *appProcess, hc models.HealthCheck, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		err := checkHealth(proc, hc)
+		if err == nil {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("not ready after %v: %w", timeout, err)
+		}
+
+		select {
+		case <-proc.done:
+			return fmt.Errorf("process exited during startup: %v", proc.cmd.ProcessState)
+		case <-d.interrupt.Done():
+			return fmt.Errorf("interrupted by platform shutdown")
+		case <-time.After(readinessPollInterval):
+		}
+	}
+}
+
+// StartHealthChecks runs liveness checks against every live release until ctx is cancelled.
+// Projects failing FailureThreshold checks in a row are marked unhealthy and, if configured,
+// restarted. They are marked active again once a check passes.
+func (d *DeploymentService) StartHealthChecks(ctx context.Context) {
+	log.Printf("🩺 [HEALTH] Starting health checker")
+
+	go func() {
+		ticker := time.NewTicker(healthTickInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				d.runDueHealthChecks()
+			}
+		}
+	}()
+}
+
+// runDueHealthChecks starts the liveness checks whose interval has elapsed
+func (d *DeploymentService) runDueHealthChecks() {
+	d.mutex.RLock()
+	live := make(map[string]*appProcess, len(d.processes))
+	for subdomain, proc := range d.processes {
+		live[subdomain] = proc
+	}
+	d.mutex.RUnlock()
+
+	now := time.Now()
+
+	d.healthMutex.Lock()
+	defer d.healthMutex.Unlock()
+
+	for subdomain, state := range d.health {
+		if live[subdomain] != state.proc {
+			delete(d.health, subdomain)
+		}
+	}
+
+	for subdomain, proc := range live {
+		state, ok := d.health[subdomain]
+		if !ok {
+			// A new release has just passed readiness, give it one interval before probing
+			hc, err := d.GetHealthCheck(proc.projectID)
+			if err != nil {
+				continue
+			}
+			d.health[subdomain] = &healthState{
+				proc:      proc,
+				nextCheck: now.Add(time.Duration(hc.Interval) * time.Second),
+			}
+			continue
+		}
+
+		if state.checking || now.Before(state.nextCheck) {
+			continue
+		}
+		state.checking = true
+		go d.runHealthCheck(subdomain, state)
+	}
+}
+
+// runHealthCheck probes a release once and acts on the result
+func (d *DeploymentService) runHealthCheck(subdomain string, state *healthState) {
+	proc := state.proc
+
+	hc, err := d.GetHealthCheck(proc.projectID)
+	if err != nil {
+		log.Printf("⚠️  [HEALTH] %v", err)
+		d.healthMutex.Lock()
+		state.checking = false
+		state.nextCheck = time.Now().Add(healthTickInterval)
+		d.healthMutex.Unlock()
+		return
+	}
+
+	checkErr := checkHealth(proc, hc)
+	if !proc.running() {
+		// The release exited or was replaced while being probed
+		return
+	}
+
+	d.healthMutex.Lock()
+	state.checking = false
+	state.nextCheck = time.Now().Add(time.Duration(hc.Interval) * time.Second)
+	previousFailures := state.failures
+	if checkErr == nil {
+		state.failures = 0
+	} else {
+		state.failures++
+	}
+	failures := state.failures
+	d.healthMutex.Unlock()
+
+	if checkErr == nil {
+		if previousFailures >= hc.FailureThreshold {
+			log.Printf("💚 [HEALTH] %s is healthy again", subdomain)
+			d.setProjectStatusIf(proc.projectID, models.ProjectStatusUnhealthy, models.ProjectStatusActive)
+		}
+		return
+	}
+
+	log.Printf("💔 [HEALTH] %s failed liveness check (%d/%d): %v", subdomain, failures, hc.FailureThreshold, checkErr)
+	if failures < hc.FailureThreshold {
+		return
+	}
+
+	if !d.setProjectStatusIf(proc.projectID, models.ProjectStatusActive, models.ProjectStatusUnhealthy) {
+		// A deployment or another status change is in progress
+		return
+	}
+	log.Printf("🚑 [HEALTH] Marked %s unhealthy", subdomain)
+
+	if hc.RestartOnFailure && !d.shuttingDown.Load() {
+		log.Printf("🔄 [HEALTH] Restarting %s", subdomain)
+		if err := d.RestartProject(proc.projectID); err != nil {
+			log.Printf("❌ [HEALTH] Failed to restart %s: %v", subdomain, err)
+		}
+	}
+}
+
+// setProjectStatusIf changes a project's status only if it currently has the expected one
+func (d *DeploymentService) setProjectStatusIf(projectID int64, from, to string) bool {
+	changed, err := d.Stores.Projects.SetStatusIf(projectID, from, to)
+	if err != nil {
+		log.Printf("Failed to update project status: %v", err)
+		return false
+	}
+	return changed
+}
@@ -0,0 +1,307 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"goth-deploy/internal/database"
+	"goth-deploy/internal/models"
+	"goth-deploy/internal/store"
+)
+
+// logStoreFlushInterval is how often buffered runtime log lines are written to the database
+const logStoreFlushInterval = 500 * time.Millisecond
+
+// logStoreMaxPending caps the lines waiting to be written, further lines are dropped until
+// the next flush so a runaway application cannot exhaust memory
+const logStoreMaxPending = 10000
+
+// logStorePruneInterval is how often lines older than their project's retention are deleted
+const logStorePruneInterval = time.Hour
+
+// logStore keeps runtime log lines of all projects in the runtime_logs table. Lines are
+// buffered and written in batches.
+type logStore struct {
+	db       *database.DB
+	projects store.ProjectStore
+
+	mutex        sync.Mutex
+	pending      []models.RuntimeLogLine
+	dropped      int
+	flushPending bool
+	lastPrune    time.Time
+}
+
+// add queues a line to be written
+func (s *logStore) add(line models.RuntimeLogLine) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.pending) >= logStoreMaxPending {
+		s.dropped++
+		return
+	}
+	s.pending = append(s.pending, line)
+
+	if !s.flushPending {
+		s.flushPending = true
+		time.AfterFunc(logStoreFlushInterval, s.flush)
+	}
+}
+
+// flush writes the queued lines, and deletes expired lines when that is due
+func (s *logStore) flush() {
+	s.mutex.Lock()
+	lines, dropped := s.pending, s.dropped
+	s.pending, s.dropped = nil, 0
+	s.flushPending = false
+	prune := time.Since(s.lastPrune) >= logStorePruneInterval
+	if prune {
+		s.lastPrune = time.Now()
+	}
+	s.mutex.Unlock()
+
+	if dropped > 0 {
+		log.Printf("⚠️  [LOGS] Dropped %d runtime log lines, applications are logging faster than they can be stored", dropped)
+	}
+	if len(lines) > 0 {
+		if err := s.insert(lines); err != nil {
+			log.Printf("⚠️  [LOGS] Failed to store %d runtime log lines: %v", len(lines), err)
+		}
+	}
+	if prune {
+		s.prune()
+	}
+}
+
+// insert writes lines in a single transaction
+func (s *logStore) insert(lines []models.RuntimeLogLine) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO runtime_logs (project_id, deployment_id, instance, stream, level, text, fields, logged_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, line := range lines {
+		var fields sql.NullString
+		if line.Fields != nil {
+			if data, err := json.Marshal(line.Fields); err == nil {
+				fields = sql.NullString{String: string(data), Valid: true}
+			}
+		}
+		if _, err := stmt.Exec(line.ProjectID, line.DeploymentID, line.Instance, line.Stream, line.Level, line.Text, fields, line.Time.UTC()); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// prune deletes lines older than their project's log retention
+func (s *logStore) prune() {
+	projects, err := s.projects.List(store.ProjectFilter{})
+	if err != nil {
+		log.Printf("⚠️  [LOGS] Failed to prune runtime logs: %v", err)
+		return
+	}
+
+	for _, project := range projects {
+		cutoff := time.Now().UTC().Add(-time.Duration(project.LogRetention.MaxAge) * 24 * time.Hour)
+		if _, err := s.db.Exec("DELETE FROM runtime_logs WHERE project_id = ? AND logged_at < ?", project.ID, cutoff); err != nil {
+			log.Printf("⚠️  [LOGS] Failed to prune runtime logs of project %d: %v", project.ID, err)
+		}
+	}
+}
+
+// deleteProject removes all lines of a project, including those still waiting to be written
+func (s *logStore) deleteProject(projectID int64) error {
+	s.mutex.Lock()
+	pending := s.pending[:0]
+	for _, line := range s.pending {
+		if line.ProjectID != projectID {
+			pending = append(pending, line)
+		}
+	}
+	s.pending = pending
+	s.mutex.Unlock()
+
+	if _, err := s.db.Exec("DELETE FROM runtime_logs WHERE project_id = ?", projectID); err != nil {
+		return fmt.Errorf("failed to delete runtime logs: %w", err)
+	}
+	return nil
+}
+
+// query returns the lines of a project selected by the query, oldest first
+func (s *logStore) query(projectID int64, query LogQuery) ([]models.RuntimeLogLine, error) {
+	where := []string{"project_id = ?"}
+	args := []interface{}{projectID}
+
+	if query.Stream != "" {
+		where = append(where, "stream = ?")
+		args = append(args, query.Stream)
+	}
+	if len(query.Levels) > 0 {
+		where = append(where, "level IN (?"+strings.Repeat(", ?", len(query.Levels)-1)+")")
+		for _, level := range query.Levels {
+			args = append(args, level)
+		}
+	}
+	if query.DeploymentID != 0 {
+		where = append(where, "deployment_id = ?")
+		args = append(args, query.DeploymentID)
+	}
+	if query.Instance != "" {
+		where = append(where, "instance = ?")
+		args = append(args, query.Instance)
+	}
+	if !query.Since.IsZero() {
+		where = append(where, "logged_at >= ?")
+		args = append(args, query.Since.UTC())
+	}
+	if !query.Until.IsZero() {
+		where = append(where, "logged_at <= ?")
+		args = append(args, query.Until.UTC())
+	}
+	for path, value := range query.Fields {
+		expr, exprArgs := s.db.JSONValue("fields", path)
+		where = append(where, expr+" = ?")
+		args = append(append(args, exprArgs...), value)
+	}
+
+	// Newest first so reading stops as soon as enough lines matched the pattern. The pattern is
+	// matched here, so with one the database returns up to maxLogQueryScan lines to search.
+	limit := query.limit()
+	scan := limit
+	if query.Grep != nil {
+		scan = maxLogQueryScan
+	}
+	rows, err := s.db.Query(`
+		SELECT id, project_id, deployment_id, instance, stream, level, text, fields, logged_at
+		FROM runtime_logs WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY id DESC LIMIT ?
+	`, append(args, scan)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query runtime logs: %w", err)
+	}
+	defer rows.Close()
+
+	var lines []models.RuntimeLogLine
+	for rows.Next() && len(lines) < limit {
+		var line models.RuntimeLogLine
+		var fields sql.NullString
+		if err := rows.Scan(&line.ID, &line.ProjectID, &line.DeploymentID, &line.Instance, &line.Stream, &line.Level, &line.Text, &fields, &line.Time); err != nil {
+			return nil, fmt.Errorf("failed to scan runtime log line: %w", err)
+		}
+		if query.Grep != nil && !query.Grep.MatchString(line.Text) {
+			continue
+		}
+		if fields.Valid {
+			json.Unmarshal([]byte(fields.String), &line.Fields)
+		}
+		lines = append(lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query runtime logs: %w", err)
+	}
+
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return lines, nil
+}
+
+// structuredLine fills in the level and fields of a line that is a JSON object
+func structuredLine(line models.RuntimeLogLine) models.RuntimeLogLine {
+	text := strings.TrimSpace(line.Text)
+	if !strings.HasPrefix(text, "{") {
+		return line
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(text), &fields); err != nil {
+		return line
+	}
+	line.Fields = fields
+
+	for _, key := range []string{"level", "lvl", "severity"} {
+		if level, ok := fields[key]; ok {
+			line.Level = normalizeLevel(level)
+			break
+		}
+	}
+	return line
+}
+
+// normalizeLevel turns a level field into a lowercase name. Numeric levels follow the
+// bunyan/pino convention.
+func normalizeLevel(level interface{}) string {
+	switch level := level.(type) {
+	case string:
+		return strings.ToLower(level)
+	case float64:
+		switch {
+		case level >= 60:
+			return "fatal"
+		case level >= 50:
+			return "error"
+		case level >= 40:
+			return "warn"
+		case level >= 30:
+			return "info"
+		case level >= 20:
+			return "debug"
+		default:
+			return "trace"
+		}
+	}
+	return ""
+}
+
+// fieldValue looks up a dotted path in the fields of a JSON line
+func fieldValue(fields map[string]interface{}, path string) (string, bool) {
+	var value interface{} = fields
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if value, ok = object[key]; !ok {
+			return "", false
+		}
+	}
+
+	switch value := value.(type) {
+	case string:
+		return value, true
+	case bool:
+		// Matches how database.DB.JSONValue reports booleans
+		if value {
+			return "1", true
+		}
+		return "0", true
+	case nil:
+		return "", false
+	case map[string]interface{}, []interface{}:
+		data, _ := json.Marshal(value)
+		return string(data), true
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), true
+	default:
+		return fmt.Sprint(value), true
+	}
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"goth-deploy/internal/database"
+	"goth-deploy/internal/database/dbtest"
+	"goth-deploy/internal/models"
+	"goth-deploy/internal/store"
+)
+
+// newTestLogStore returns the log store of a database with one project
+func newTestLogStore(t *testing.T, db *database.DB) (*logStore, *models.Project) {
+	t.Helper()
+	stores := store.NewSQL(db)
+	user := &models.User{GitHubID: 1, Username: "owner", AccessToken: "token"}
+	if err := stores.Users.Create(user); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	project := &models.Project{UserID: user.ID, Name: "app", RepoURL: "https://github.com/octo/app", Branch: "main", Subdomain: "app", Port: 8080}
+	if err := stores.Projects.Create(project); err != nil {
+		t.Fatalf("failed to create project: %v", err)
+	}
+	return &logStore{db: db, projects: stores.Projects}, project
+}
+
+func TestLogStoreQuery(t *testing.T) {
+	dbtest.Each(t, func(t *testing.T, db *database.DB) {
+		s, project := newTestLogStore(t, db)
+
+		start := time.Now().UTC().Truncate(time.Second)
+		texts := []string{
+			`starting`,
+			`{"level":"info","msg":"request","http":{"status":200,"path":"/"},"cached":true}`,
+			`{"level":"error","msg":"request","http":{"status":500,"path":"/api"},"cached":false}`,
+			`{"level":"warn","msg":"slow request","http":{"status":200,"path":"/api"}}`,
+			`panic: oops`,
+		}
+		var lines []models.RuntimeLogLine
+		for i, text := range texts {
+			stream := "stdout"
+			if i == len(texts)-1 {
+				stream = "stderr"
+			}
+			lines = append(lines, structuredLine(models.RuntimeLogLine{
+				ProjectID: project.ID,
+				Instance:  "a",
+				Stream:    stream,
+				Text:      text,
+				Time:      start.Add(time.Duration(i) * time.Second),
+			}))
+		}
+		if err := s.insert(lines); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+
+		tests := []struct {
+			name  string
+			query LogQuery
+			want  []string
+		}{
+			{"all", LogQuery{}, texts},
+			{"tail", LogQuery{Tail: 2}, texts[3:]},
+			{"stream", LogQuery{Stream: "stderr"}, texts[4:]},
+			{"levels", LogQuery{Levels: []string{"warn", "error"}}, texts[2:4]},
+			{"number field", LogQuery{Fields: map[string]string{"http.status": "200"}}, []string{texts[1], texts[3]}},
+			{"string field", LogQuery{Fields: map[string]string{"http.path": "/api", "level": "error"}}, texts[2:3]},
+			{"boolean field", LogQuery{Fields: map[string]string{"cached": "0"}}, texts[2:3]},
+			{"grep", LogQuery{Grep: regexp.MustCompile(`request`)}, texts[1:4]},
+			{"grep and tail", LogQuery{Grep: regexp.MustCompile(`request`), Tail: 1}, texts[3:4]},
+			{"time range", LogQuery{Since: start.Add(time.Second), Until: start.Add(2 * time.Second)}, texts[1:3]},
+			{"other instance", LogQuery{Instance: "b"}, nil},
+		}
+		for _, test := range tests {
+			got, err := s.query(project.ID, test.query)
+			if err != nil {
+				t.Fatalf("%s: query: %v", test.name, err)
+			}
+			var gotTexts []string
+			for _, line := range got {
+				gotTexts = append(gotTexts, line.Text)
+			}
+			if len(gotTexts) != len(test.want) {
+				t.Errorf("%s: query = %q, want %q", test.name, gotTexts, test.want)
+				continue
+			}
+			for i := range gotTexts {
+				if gotTexts[i] != test.want[i] {
+					t.Errorf("%s: query = %q, want %q", test.name, gotTexts, test.want)
+					break
+				}
+			}
+		}
+
+		// Lines keep their parsed fields
+		got, _ := s.query(project.ID, LogQuery{Levels: []string{"error"}})
+		if len(got) != 1 {
+			t.Fatalf("query returned %d error lines, want 1", len(got))
+		}
+		if value, ok := fieldValue(got[0].Fields, "http.status"); !ok || value != "500" {
+			t.Errorf("http.status field = %q, %v", value, ok)
+		}
+	})
+}
+
+func TestLogStoreDeleteProject(t *testing.T) {
+	dbtest.Each(t, func(t *testing.T, db *database.DB) {
+		s, project := newTestLogStore(t, db)
+		line := models.RuntimeLogLine{ProjectID: project.ID, Instance: "a", Stream: "stdout", Text: "stored", Time: time.Now()}
+		if err := s.insert([]models.RuntimeLogLine{line}); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+		line.Text = "pending"
+		s.pending = append(s.pending, line)
+
+		if err := s.deleteProject(project.ID); err != nil {
+			t.Fatalf("deleteProject: %v", err)
+		}
+		if len(s.pending) != 0 {
+			t.Errorf("%d lines of the deleted project are still pending", len(s.pending))
+		}
+		if got, _ := s.query(project.ID, LogQuery{}); len(got) != 0 {
+			t.Errorf("query after deleteProject returned %d lines", len(got))
+		}
+	})
+}
@@ -0,0 +1,650 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"goth-deploy/internal/database"
+	"goth-deploy/internal/models"
+	"goth-deploy/internal/store"
+)
+
+// OrganizationService manages organizations, their members and invites
+type OrganizationService struct {
+	DB     *database.DB
+	Stores *store.Stores
+	GitHub *GitHubService
+}
+
+// NewOrganizationService creates a new organization service
+func NewOrganizationService(db *database.DB, stores *store.Stores, gh *GitHubService) *OrganizationService {
+	return &OrganizationService{
+		DB:     db,
+		Stores: stores,
+		GitHub: gh,
+	}
+}
+
+// roleRanks orders the roles, higher ranks may do everything lower ranks may do
+var roleRanks = map[string]int{
+	models.RoleViewer:   1,
+	models.RoleDeployer: 2,
+	models.RoleAdmin:    3,
+	models.RoleOwner:    4,
+}
+
+// orgSlugPattern matches valid organization slugs
+var orgSlugPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// githubLoginPattern matches valid GitHub user and organization names
+var githubLoginPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,37}[A-Za-z0-9])?$`)
+
+// ErrOrgNotFound is returned for organizations that do not exist
+var ErrOrgNotFound = errors.New("organization not found")
+
+// ErrOrgExists is returned when the slug of an organization is already taken
+var ErrOrgExists = errors.New("organization slug already taken")
+
+// ErrOrgHasProjects is returned when deleting an organization that still owns projects
+var ErrOrgHasProjects = errors.New("organization still owns projects, delete or transfer them first")
+
+// ErrMemberNotFound is returned for users that are not members of the organization
+var ErrMemberNotFound = errors.New("member not found")
+
+// ErrMemberExists is returned when inviting a user who is already a member
+var ErrMemberExists = errors.New("user is already a member")
+
+// ErrInviteNotFound is returned for invites that do not exist or are not addressed to the user
+var ErrInviteNotFound = errors.New("invite not found")
+
+// ErrInviteExists is returned when the user already has a pending invite to the organization
+var ErrInviteExists = errors.New("user is already invited")
+
+// ErrLastOwner is returned when a change would leave an organization without an owner
+var ErrLastOwner = errors.New("an organization needs at least one owner")
+
+// ErrGitHubSyncDisabled is returned when syncing an organization without a GitHub organization
+var ErrGitHubSyncDisabled = errors.New("organization has no GitHub organization to sync with")
+
+// GitHubSyncResult lists the changes made by a GitHub organization sync
+type GitHubSyncResult struct {
+	Added   []string `json:"added"`   // members added
+	Removed []string `json:"removed"` // synced members no longer in the GitHub organization
+	Invited []string `json:"invited"` // GitHub members without an account here yet
+}
+
+// RoleAtLeast reports whether role grants at least the permissions of min. An empty role,
+// meaning no access, grants nothing.
+func RoleAtLeast(role, min string) bool {
+	return roleRanks[role] > 0 && roleRanks[role] >= roleRanks[min]
+}
+
+// ValidateRole checks that role is a known organization role
+func ValidateRole(role string) error {
+	if _, ok := roleRanks[role]; !ok {
+		return fmt.Errorf("role must be one of owner, admin, deployer or viewer")
+	}
+	return nil
+}
+
+// ValidateGitHubUsername checks that username is a valid GitHub user name
+func ValidateGitHubUsername(username string) error {
+	if !githubLoginPattern.MatchString(username) {
+		return fmt.Errorf("invalid GitHub username %q", username)
+	}
+	return nil
+}
+
+// ValidateOrganization checks an organization's settings
+func ValidateOrganization(org models.Organization) error {
+	if strings.TrimSpace(org.Name) == "" || len(org.Name) > 100 {
+		return fmt.Errorf("name must be between 1 and 100 characters")
+	}
+	if len(org.Slug) > 63 || !orgSlugPattern.MatchString(org.Slug) {
+		return fmt.Errorf("slug must be up to 63 lowercase letters, digits and hyphens, not starting or ending with a hyphen")
+	}
+	if org.GitHubOrg != "" && !githubLoginPattern.MatchString(org.GitHubOrg) {
+		return fmt.Errorf("invalid GitHub organization %q", org.GitHubOrg)
+	}
+	if err := ValidateRole(org.GitHubSyncRole); err != nil {
+		return fmt.Errorf("github_sync_role: %w", err)
+	}
+	if org.GitHubSyncRole == models.RoleOwner {
+		return fmt.Errorf("github_sync_role cannot be owner")
+	}
+	return nil
+}
+
+// ProjectRole returns the role a user has on a project: owner for the creator of a personal
+// project, the user's organization role for organization projects, or "" without access
+func (o *OrganizationService) ProjectRole(userID int64, project *models.Project) (string, error) {
+	if project.OrganizationID == nil {
+		if project.UserID == userID {
+			return models.RoleOwner, nil
+		}
+		return "", nil
+	}
+	return o.MemberRole(*project.OrganizationID, userID)
+}
+
+// MemberRole returns a user's role in an organization, or "" if the user is not a member
+func (o *OrganizationService) MemberRole(orgID, userID int64) (string, error) {
+	var role string
+	err := o.DB.QueryRow(
+		"SELECT role FROM organization_members WHERE organization_id = ? AND user_id = ?", orgID, userID,
+	).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get member role: %w", err)
+	}
+	return role, nil
+}
+
+// ListOrganizations returns the organizations a user is a member of, with the user's role
+func (o *OrganizationService) ListOrganizations(userID int64) ([]models.Organization, error) {
+	rows, err := o.DB.Query(`
+		SELECT o.id, o.name, o.slug, o.github_org, o.github_sync_role, o.created_at, o.updated_at, m.role
+		FROM organizations o
+		JOIN organization_members m ON m.organization_id = o.id
+		WHERE m.user_id = ?
+		ORDER BY o.name
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get organizations: %w", err)
+	}
+	defer rows.Close()
+
+	orgs := []models.Organization{}
+	for rows.Next() {
+		var org models.Organization
+		if err := rows.Scan(&org.ID, &org.Name, &org.Slug, &org.GitHubOrg, &org.GitHubSyncRole, &org.CreatedAt, &org.UpdatedAt, &org.Role); err != nil {
+			return nil, fmt.Errorf("failed to scan organization: %w", err)
+		}
+		orgs = append(orgs, org)
+	}
+	return orgs, rows.Err()
+}
+
+// GetOrganization returns an organization
+func (o *OrganizationService) GetOrganization(orgID int64) (*models.Organization, error) {
+	var org models.Organization
+	err := o.DB.QueryRow(`
+		SELECT id, name, slug, github_org, github_sync_role, created_at, updated_at
+		FROM organizations WHERE id = ?
+	`, orgID).Scan(&org.ID, &org.Name, &org.Slug, &org.GitHubOrg, &org.GitHubSyncRole, &org.CreatedAt, &org.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrOrgNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get organization: %w", err)
+	}
+	return &org, nil
+}
+
+// CreateOrganization creates an organization with the given user as its owner
+func (o *OrganizationService) CreateOrganization(org *models.Organization, ownerID int64) error {
+	if err := o.checkSlugAvailable(org.ID, org.Slug); err != nil {
+		return err
+	}
+
+	tx, err := o.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	err = tx.QueryRow(`
+		INSERT INTO organizations (name, slug, github_org, github_sync_role, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, org.Name, org.Slug, org.GitHubOrg, org.GitHubSyncRole, now, now).Scan(&org.ID)
+	if err != nil {
+		return fmt.Errorf("failed to create organization: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO organization_members (organization_id, user_id, role, source, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, org.ID, ownerID, models.RoleOwner, models.MemberSourceManual, now)
+	if err != nil {
+		return fmt.Errorf("failed to add organization owner: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit organization: %w", err)
+	}
+
+	org.Role = models.RoleOwner
+	org.CreatedAt, org.UpdatedAt = now, now
+	return nil
+}
+
+// UpdateOrganization saves an organization's settings
+func (o *OrganizationService) UpdateOrganization(org *models.Organization) error {
+	if err := o.checkSlugAvailable(org.ID, org.Slug); err != nil {
+		return err
+	}
+
+	org.UpdatedAt = time.Now()
+	result, err := o.DB.Exec(`
+		UPDATE organizations SET name = ?, slug = ?, github_org = ?, github_sync_role = ?, updated_at = ?
+		WHERE id = ?
+	`, org.Name, org.Slug, org.GitHubOrg, org.GitHubSyncRole, org.UpdatedAt, org.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update organization: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrOrgNotFound
+	}
+	return nil
+}
+
+// DeleteOrganization deletes an organization with its members and invites. Organizations that
+// still own projects are not deleted.
+func (o *OrganizationService) DeleteOrganization(orgID int64) error {
+	projects, err := o.Stores.Projects.Count(store.ProjectFilter{OrganizationID: &orgID})
+	if err != nil {
+		return fmt.Errorf("failed to count organization projects: %w", err)
+	}
+	if projects > 0 {
+		return ErrOrgHasProjects
+	}
+
+	// Members and invites are removed by ON DELETE CASCADE, projects moved into the
+	// organization meanwhile make the delete fail with ON DELETE RESTRICT
+	if _, err := o.DB.Exec("DELETE FROM organizations WHERE id = ?", orgID); err != nil {
+		return fmt.Errorf("failed to delete organization: %w", err)
+	}
+	return nil
+}
+
+// checkSlugAvailable returns ErrOrgExists if another organization uses the slug
+func (o *OrganizationService) checkSlugAvailable(orgID int64, slug string) error {
+	var exists bool
+	err := o.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM organizations WHERE slug = ? AND id != ?)", slug, orgID).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check organization slug: %w", err)
+	}
+	if exists {
+		return fmt.Errorf("%w: %s", ErrOrgExists, slug)
+	}
+	return nil
+}
+
+// TransferProject moves a project into an organization, or makes it a personal project of the
+// given user if orgID is nil
+func (o *OrganizationService) TransferProject(projectID int64, orgID *int64, userID int64) error {
+	if err := o.Stores.Projects.Transfer(projectID, orgID, userID); err != nil {
+		return fmt.Errorf("failed to transfer project: %w", err)
+	}
+	return nil
+}
+
+// ListMembers returns the members of an organization
+func (o *OrganizationService) ListMembers(orgID int64) ([]models.OrganizationMember, error) {
+	rows, err := o.DB.Query(`
+		SELECT m.organization_id, m.user_id, u.username, COALESCE(u.avatar_url, ''), m.role, m.source, m.created_at
+		FROM organization_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.organization_id = ?
+		ORDER BY u.username COLLATE NOCASE
+	`, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get organization members: %w", err)
+	}
+	defer rows.Close()
+
+	members := []models.OrganizationMember{}
+	for rows.Next() {
+		var member models.OrganizationMember
+		if err := rows.Scan(&member.OrganizationID, &member.UserID, &member.Username, &member.AvatarURL, &member.Role, &member.Source, &member.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan organization member: %w", err)
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// SetMemberRole changes the role of a member. Members given a role by hand are no longer
+// managed by the GitHub sync.
+func (o *OrganizationService) SetMemberRole(orgID, userID int64, role string) error {
+	tx, err := o.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if role != models.RoleOwner {
+		if err := checkNotLastOwner(tx, orgID, userID); err != nil {
+			return err
+		}
+	}
+
+	result, err := tx.Exec(`
+		UPDATE organization_members SET role = ?, source = ?
+		WHERE organization_id = ? AND user_id = ?
+	`, role, models.MemberSourceManual, orgID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update member role: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrMemberNotFound
+	}
+
+	return tx.Commit()
+}
+
+// RemoveMember removes a user from an organization
+func (o *OrganizationService) RemoveMember(orgID, userID int64) error {
+	tx, err := o.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkNotLastOwner(tx, orgID, userID); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec("DELETE FROM organization_members WHERE organization_id = ? AND user_id = ?", orgID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to remove member: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrMemberNotFound
+	}
+
+	return tx.Commit()
+}
+
+// checkNotLastOwner returns ErrLastOwner if the user is the only owner of the organization
+func checkNotLastOwner(tx *database.Tx, orgID, userID int64) error {
+	var otherOwners int
+	var isOwner bool
+	err := tx.QueryRow(`
+		SELECT
+			COUNT(*) FILTER (WHERE user_id != ?),
+			COUNT(*) FILTER (WHERE user_id = ?) > 0
+		FROM organization_members WHERE organization_id = ? AND role = ?
+	`, userID, userID, orgID, models.RoleOwner).Scan(&otherOwners, &isOwner)
+	if err != nil {
+		return fmt.Errorf("failed to count organization owners: %w", err)
+	}
+	if isOwner && otherOwners == 0 {
+		return ErrLastOwner
+	}
+	return nil
+}
+
+// ListInvites returns the pending invites of an organization
+func (o *OrganizationService) ListInvites(orgID int64) ([]models.OrganizationInvite, error) {
+	return o.queryInvites("i.organization_id = ?", orgID)
+}
+
+// ListUserInvites returns the pending invites addressed to a GitHub username
+func (o *OrganizationService) ListUserInvites(username string) ([]models.OrganizationInvite, error) {
+	return o.queryInvites("i.github_username = ?", username)
+}
+
+// queryInvites returns the invites matching a condition
+func (o *OrganizationService) queryInvites(condition string, args ...interface{}) ([]models.OrganizationInvite, error) {
+	rows, err := o.DB.Query(`
+		SELECT i.id, i.organization_id, o.name, i.github_username, i.role, i.source, i.invited_by, i.created_at
+		FROM organization_invites i
+		JOIN organizations o ON o.id = i.organization_id
+		WHERE `+condition+`
+		ORDER BY i.created_at
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get invites: %w", err)
+	}
+	defer rows.Close()
+
+	invites := []models.OrganizationInvite{}
+	for rows.Next() {
+		var invite models.OrganizationInvite
+		var invitedBy sql.NullInt64
+		if err := rows.Scan(&invite.ID, &invite.OrganizationID, &invite.OrganizationName, &invite.Username, &invite.Role, &invite.Source, &invitedBy, &invite.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan invite: %w", err)
+		}
+		if invitedBy.Valid {
+			invite.InvitedBy = &invitedBy.Int64
+		}
+		invites = append(invites, invite)
+	}
+	return invites, rows.Err()
+}
+
+// CreateInvite invites a GitHub user to an organization
+func (o *OrganizationService) CreateInvite(invite *models.OrganizationInvite) error {
+	var member, invited bool
+	err := o.DB.QueryRow(`
+		SELECT
+			EXISTS(SELECT 1 FROM organization_members m JOIN users u ON u.id = m.user_id
+				WHERE m.organization_id = ? AND u.username = ? COLLATE NOCASE),
+			EXISTS(SELECT 1 FROM organization_invites WHERE organization_id = ? AND github_username = ?)
+	`, invite.OrganizationID, invite.Username, invite.OrganizationID, invite.Username).Scan(&member, &invited)
+	if err != nil {
+		return fmt.Errorf("failed to check existing members: %w", err)
+	}
+	if member {
+		return fmt.Errorf("%w: %s", ErrMemberExists, invite.Username)
+	}
+	if invited {
+		return fmt.Errorf("%w: %s", ErrInviteExists, invite.Username)
+	}
+
+	if invite.Source == "" {
+		invite.Source = models.MemberSourceManual
+	}
+	invite.CreatedAt = time.Now()
+	err = o.DB.QueryRow(`
+		INSERT INTO organization_invites (organization_id, github_username, role, source, invited_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, invite.OrganizationID, invite.Username, invite.Role, invite.Source, invite.InvitedBy, invite.CreatedAt).Scan(&invite.ID)
+	if err != nil {
+		return fmt.Errorf("failed to create invite: %w", err)
+	}
+	return nil
+}
+
+// DeleteInvite withdraws a pending invite of an organization
+func (o *OrganizationService) DeleteInvite(orgID, inviteID int64) error {
+	result, err := o.DB.Exec("DELETE FROM organization_invites WHERE id = ? AND organization_id = ?", inviteID, orgID)
+	if err != nil {
+		return fmt.Errorf("failed to delete invite: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrInviteNotFound
+	}
+	return nil
+}
+
+// AcceptInvite makes the user a member with the invited role. The invite must be addressed to
+// the user's GitHub username. Users who are already members keep their role.
+func (o *OrganizationService) AcceptInvite(inviteID int64, user *models.User) (*models.OrganizationInvite, error) {
+	tx, err := o.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	invite := models.OrganizationInvite{ID: inviteID}
+	err = tx.QueryRow(`
+		SELECT i.organization_id, o.name, i.github_username, i.role, i.source
+		FROM organization_invites i
+		JOIN organizations o ON o.id = i.organization_id
+		WHERE i.id = ? AND i.github_username = ?
+	`, inviteID, user.Username).Scan(&invite.OrganizationID, &invite.OrganizationName, &invite.Username, &invite.Role, &invite.Source)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInviteNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get invite: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO organization_members (organization_id, user_id, role, source, created_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING
+	`, invite.OrganizationID, user.ID, invite.Role, invite.Source, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to add member: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM organization_invites WHERE id = ?", inviteID); err != nil {
+		return nil, fmt.Errorf("failed to delete invite: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit invite: %w", err)
+	}
+	return &invite, nil
+}
+
+// DeclineInvite deletes an invite addressed to the user
+func (o *OrganizationService) DeclineInvite(inviteID int64, user *models.User) error {
+	result, err := o.DB.Exec("DELETE FROM organization_invites WHERE id = ? AND github_username = ?", inviteID, user.Username)
+	if err != nil {
+		return fmt.Errorf("failed to decline invite: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrInviteNotFound
+	}
+	return nil
+}
+
+// SyncGitHubMembers brings the members of an organization in line with its GitHub
+// organization. Members of the GitHub organization who have an account are added with the
+// sync role, the others are invited. Members and invites that came from an earlier sync are
+// removed once the user left the GitHub organization. Members whose role was set by hand are
+// left alone.
+func (o *OrganizationService) SyncGitHubMembers(ctx context.Context, orgID int64, accessToken string) (*GitHubSyncResult, error) {
+	org, err := o.GetOrganization(orgID)
+	if err != nil {
+		return nil, err
+	}
+	if org.GitHubOrg == "" {
+		return nil, ErrGitHubSyncDisabled
+	}
+
+	logins, err := o.GitHub.ListOrgMembers(ctx, accessToken, org.GitHubOrg)
+	if err != nil {
+		return nil, err
+	}
+	inGitHubOrg := make(map[string]bool, len(logins))
+	userIDs := map[string]int64{}
+	for _, login := range logins {
+		inGitHubOrg[strings.ToLower(login)] = true
+
+		user, err := o.Stores.Users.GetByUsername(login)
+		if err == nil {
+			userIDs[login] = user.ID
+		} else if !errors.Is(err, store.ErrNotFound) {
+			return nil, fmt.Errorf("failed to look up %s: %w", login, err)
+		}
+	}
+
+	tx, err := o.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result := &GitHubSyncResult{Added: []string{}, Removed: []string{}, Invited: []string{}}
+
+	// Remove synced members and invites of users who left the GitHub organization
+	type syncedUser struct {
+		id       int64
+		username string
+		invite   bool
+	}
+	var synced []syncedUser
+	rows, err := tx.Query(`
+		SELECT m.user_id, u.username, 0 FROM organization_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.organization_id = ? AND m.source = ?
+		UNION ALL
+		SELECT id, github_username, 1 FROM organization_invites
+		WHERE organization_id = ? AND source = ?
+	`, orgID, models.MemberSourceGitHub, orgID, models.MemberSourceGitHub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get synced members: %w", err)
+	}
+	for rows.Next() {
+		var user syncedUser
+		if err := rows.Scan(&user.id, &user.username, &user.invite); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan synced member: %w", err)
+		}
+		synced = append(synced, user)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get synced members: %w", err)
+	}
+
+	for _, user := range synced {
+		if inGitHubOrg[strings.ToLower(user.username)] {
+			continue
+		}
+		if user.invite {
+			_, err = tx.Exec("DELETE FROM organization_invites WHERE id = ?", user.id)
+		} else {
+			_, err = tx.Exec("DELETE FROM organization_members WHERE organization_id = ? AND user_id = ?", orgID, user.id)
+			result.Removed = append(result.Removed, user.username)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to remove %s: %w", user.username, err)
+		}
+	}
+
+	// Add the GitHub organization's members, or invite those without an account
+	now := time.Now()
+	for _, login := range logins {
+		userID, hasAccount := userIDs[login]
+		var changed sql.Result
+		var err error
+		if hasAccount {
+			changed, err = tx.Exec(`
+				INSERT INTO organization_members (organization_id, user_id, role, source, created_at)
+				VALUES (?, ?, ?, ?, ?)
+				ON CONFLICT DO NOTHING
+			`, orgID, userID, org.GitHubSyncRole, models.MemberSourceGitHub, now)
+		} else {
+			changed, err = tx.Exec(`
+				INSERT INTO organization_invites (organization_id, github_username, role, source, created_at)
+				VALUES (?, ?, ?, ?, ?)
+				ON CONFLICT DO NOTHING
+			`, orgID, login, org.GitHubSyncRole, models.MemberSourceGitHub, now)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to add %s: %w", login, err)
+		}
+		if n, _ := changed.RowsAffected(); n == 0 {
+			continue
+		}
+		if hasAccount {
+			result.Added = append(result.Added, login)
+		} else {
+			result.Invited = append(result.Invited, login)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit sync: %w", err)
+	}
+
+	log.Printf("👥 [ORGS] Synced organization %d with GitHub organization %s: %d added, %d removed, %d invited",
+		orgID, org.GitHubOrg, len(result.Added), len(result.Removed), len(result.Invited))
+	return result, nil
+}
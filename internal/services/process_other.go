@@ -0,0 +1,25 @@
+//go:build !unix
+
+package services
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup is a no-op on platforms without process groups
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// terminateGroup kills the process, as there is no portable graceful stop signal
+func terminateGroup(pid int) error {
+	return killGroup(pid)
+}
+
+// killGroup kills the process led by pid
+func killGroup(pid int) error {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return process.Kill()
+}
@@ -0,0 +1,24 @@
+//go:build unix
+
+package services
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts the command in its own process group, so that stopping it also
+// stops everything it spawned
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// terminateGroup asks the process group led by pid to exit
+func terminateGroup(pid int) error {
+	return syscall.Kill(-pid, syscall.SIGTERM)
+}
+
+// killGroup kills the process group led by pid
+func killGroup(pid int) error {
+	return syscall.Kill(-pid, syscall.SIGKILL)
+}
@@ -1,7 +1,6 @@
 package services
 
 import (
-	"database/sql"
 	"fmt"
 	"net/http"
 	"net/http/httputil"
@@ -10,24 +9,34 @@ import (
 	"sync"
 
 	"goth-deploy/internal/config"
+	"goth-deploy/internal/database"
 	"goth-deploy/internal/models"
+	"goth-deploy/internal/store"
 )
 
 // ProxyService handles reverse proxy for deployed applications
 type ProxyService struct {
-	DB         *sql.DB
+	DB         *database.DB
+	Stores     *store.Stores
 	Config     *config.Config
 	Deployment *DeploymentService
-	proxies    map[string]*httputil.ReverseProxy
+	proxies    map[string]*proxyEntry
 	mutex      sync.RWMutex
 }
 
+// proxyEntry is a cached reverse proxy and the release port it targets
+type proxyEntry struct {
+	proxy *httputil.ReverseProxy
+	port  int
+}
+
 // NewProxyService creates a new proxy service
-func NewProxyService(db *sql.DB, cfg *config.Config) *ProxyService {
+func NewProxyService(db *database.DB, stores *store.Stores, cfg *config.Config) *ProxyService {
 	return &ProxyService{
 		DB:      db,
+		Stores:  stores,
 		Config:  cfg,
-		proxies: make(map[string]*httputil.ReverseProxy),
+		proxies: make(map[string]*proxyEntry),
 	}
 }
 
@@ -58,30 +67,20 @@ func (p *ProxyService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if project is active
-	if project.Status != models.ProjectStatusActive {
-		// Try to start the application if deployment service is available
-		if p.Deployment != nil && project.Status == models.ProjectStatusInactive {
-			if err := p.Deployment.RestartProject(project.ID); err != nil {
-				http.Error(w, "Project is not available", http.StatusServiceUnavailable)
-				return
-			}
-			// Wait a moment for the app to start
-			// Note: In production, you might want to implement a more sophisticated waiting mechanism
-		} else {
-			http.Error(w, "Project is not active", http.StatusServiceUnavailable)
-			return
-		}
-	}
-
-	// Ensure the application is running
-	if p.Deployment != nil {
-		if !p.Deployment.IsProjectRunning(subdomain) {
-			if err := p.Deployment.RestartProject(project.ID); err != nil {
-				http.Error(w, "Failed to start application", http.StatusInternalServerError)
-				return
-			}
-		}
+	// Check if project is active (unhealthy apps keep receiving traffic while they recover). The
+	// active release of a project that is building keeps serving until the new one replaces it.
+	running := p.Deployment == nil || p.Deployment.IsProjectRunning(subdomain)
+	serving := project.Status == models.ProjectStatusActive || project.Status == models.ProjectStatusUnhealthy ||
+		(running && project.ActiveDeploymentID != nil)
+	if !serving {
+		http.Error(w, "Project is not active", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Restarting crashed applications is up to the supervisor
+	if !running {
+		http.Error(w, "Project is not running", http.StatusServiceUnavailable)
+		return
 	}
 
 	// Get or create reverse proxy
@@ -122,39 +121,16 @@ func (p *ProxyService) extractSubdomain(host string) string {
 
 // getProjectBySubdomain retrieves a project by its subdomain
 func (p *ProxyService) getProjectBySubdomain(subdomain string) (*models.Project, error) {
-	var project models.Project
-	err := p.DB.QueryRow(`
-		SELECT id, user_id, name, github_repo_id, repo_url, branch, subdomain, 
-		       build_command, start_command, port, status, last_deploy, created_at, updated_at
-		FROM projects WHERE subdomain = ?
-	`, subdomain).Scan(
-		&project.ID,
-		&project.UserID,
-		&project.Name,
-		&project.GitHubRepoID,
-		&project.RepoURL,
-		&project.Branch,
-		&project.Subdomain,
-		&project.BuildCommand,
-		&project.StartCommand,
-		&project.Port,
-		&project.Status,
-		&project.LastDeploy,
-		&project.CreatedAt,
-		&project.UpdatedAt,
-	)
-	if err != nil {
-		return nil, err
-	}
-	return &project, nil
+	return p.Stores.Projects.GetBySubdomain(subdomain)
 }
 
-// getOrCreateProxy gets or creates a reverse proxy for the given subdomain and port
+// getOrCreateProxy gets or creates a reverse proxy for the given subdomain and port.
+// A new proxy is created when a deploy has moved the project to another port.
 func (p *ProxyService) getOrCreateProxy(subdomain string, port int) *httputil.ReverseProxy {
 	p.mutex.RLock()
-	if proxy, exists := p.proxies[subdomain]; exists {
+	if entry, exists := p.proxies[subdomain]; exists && entry.port == port {
 		p.mutex.RUnlock()
-		return proxy
+		return entry.proxy
 	}
 	p.mutex.RUnlock()
 
@@ -162,8 +138,8 @@ func (p *ProxyService) getOrCreateProxy(subdomain string, port int) *httputil.Re
 	defer p.mutex.Unlock()
 
 	// Double-check pattern
-	if proxy, exists := p.proxies[subdomain]; exists {
-		return proxy
+	if entry, exists := p.proxies[subdomain]; exists && entry.port == port {
+		return entry.proxy
 	}
 
 	// Create new proxy
@@ -209,7 +185,7 @@ func (p *ProxyService) getOrCreateProxy(subdomain string, port int) *httputil.Re
 	}
 
 	// Store the proxy
-	p.proxies[subdomain] = proxy
+	p.proxies[subdomain] = &proxyEntry{proxy: proxy, port: port}
 	return proxy
 }
 
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+
+	"goth-deploy/internal/models"
+)
+
+func TestProxyServesActiveReleaseWhileBuilding(t *testing.T) {
+	d, project := newTestService(t)
+
+	release := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("old release"))
+	}))
+	defer release.Close()
+	_, portText, _ := net.SplitHostPort(release.Listener.Addr().String())
+	port, _ := strconv.Atoi(portText)
+
+	// The old release is live and its process is running
+	active := &models.Deployment{ProjectID: project.ID}
+	if err := d.Stores.Deployments.Enqueue(active); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	if err := d.Stores.Deployments.Activate(project.ID, active.ID, t.TempDir(), port); err != nil {
+		t.Fatalf("Activate: %v", err)
+	}
+	cmd := &exec.Cmd{Process: &os.Process{Pid: os.Getpid()}}
+	d.processes[project.Subdomain] = &appProcess{cmd: cmd, projectID: project.ID, deploymentID: active.ID, port: port, done: make(chan struct{})}
+
+	// A new deployment is building
+	deployment, err := d.DeployProject(project.ID, "")
+	if err != nil {
+		t.Fatalf("DeployProject: %v", err)
+	}
+	d.updateDeploymentStatus(deployment.ID, models.StatusBuilding, "", "")
+
+	p := NewProxyService(nil, d.Stores, d.Config)
+	p.SetDeploymentService(d)
+
+	req := httptest.NewRequest(http.MethodGet, "http://app.localhost/", nil)
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != "old release" {
+		t.Errorf("proxied response = %d %q, want 200 from the old release", rec.Code, rec.Body.String())
+	}
+
+	// Without a running release a building project is not served
+	delete(d.processes, project.Subdomain)
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status without a running release = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
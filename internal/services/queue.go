@@ -0,0 +1,175 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"goth-deploy/internal/models"
+)
+
+// queuePollInterval is how often idle workers re-check the queue without being woken
+const queuePollInterval = 5 * time.Second
+
+// maxJobAttempts is how many times a job may be started before recovery gives up on it
+const maxJobAttempts = 3
+
+// enqueueDeployment creates a pending deployment and its queue job. Older jobs for the same
+// project that have not started yet are superseded, since only the newest deploy matters.
+// rollbackOf links a rollback to the deployment it restores and is nil for regular deploys.
+func (d *DeploymentService) enqueueDeployment(projectID int64, commitSHA string, rollbackOf *int64) (*models.Deployment, error) {
+	deployment := &models.Deployment{
+		ProjectID:  projectID,
+		CommitSHA:  commitSHA,
+		RollbackOf: rollbackOf,
+	}
+	if err := d.Stores.Deployments.Enqueue(deployment); err != nil {
+		return nil, err
+	}
+	return deployment, nil
+}
+
+// RecoverDeployments repairs the queue after the platform was stopped mid-deploy. Jobs that
+// were running are queued again (unless a newer deploy superseded them or they keep failing),
+// and deployments left pending or building without a queued job are marked failed.
+func (d *DeploymentService) RecoverDeployments() error {
+	requeued, failed, err := d.Stores.Deployments.Recover(maxJobAttempts)
+	if err != nil {
+		return err
+	}
+
+	if requeued > 0 || failed > 0 {
+		log.Printf("♻️  [QUEUE] Recovered deployments: %d requeued, %d marked failed", requeued, failed)
+	}
+	return nil
+}
+
+// StartWorkers starts the deployment worker pool. At most Config.DeployConcurrency deployments
+// run at once, and never more than one per project. Workers stop when ctx is cancelled.
+func (d *DeploymentService) StartWorkers(ctx context.Context) {
+	workers := d.Config.DeployConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	log.Printf("👷 [QUEUE] Starting %d deployment worker(s)", workers)
+	for i := 0; i < workers; i++ {
+		go d.worker(ctx, i+1)
+	}
+
+	// Pick up anything that was queued before the workers started
+	d.wakeWorkers()
+}
+
+// wakeWorkers signals idle workers that the queue has changed
+func (d *DeploymentService) wakeWorkers() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// worker processes queued deployment jobs until ctx is cancelled
+func (d *DeploymentService) worker(ctx context.Context, id int) {
+	ticker := time.NewTicker(queuePollInterval)
+	defer ticker.Stop()
+
+	for {
+		job, err := d.claimJob()
+		if err != nil {
+			log.Printf("⚠️  [QUEUE] Worker %d failed to claim job: %v", id, err)
+		}
+
+		if job != nil {
+			// Other workers may be able to claim jobs for other projects
+			d.wakeWorkers()
+			d.runJob(id, job)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// claimJob marks the oldest queued job of a project without a running job as running
+func (d *DeploymentService) claimJob() (*models.DeploymentJob, error) {
+	d.jobsMutex.Lock()
+	defer d.jobsMutex.Unlock()
+
+	// Queued jobs are left for the next start once shutdown has begun
+	if d.shuttingDown.Load() {
+		return nil, nil
+	}
+
+	job, err := d.Stores.Deployments.ClaimJob(d.runningJobs)
+	if err != nil || job == nil {
+		return nil, err
+	}
+
+	d.runningJobs[job.ProjectID] = true
+	d.runningDeployments.Add(1)
+	return job, nil
+}
+
+// runJob performs the deployment of a claimed job and marks it done. A deployment interrupted
+// by shutdown is left running so that RecoverDeployments queues it again on the next start.
+func (d *DeploymentService) runJob(workerID int, job *models.DeploymentJob) {
+	defer d.runningDeployments.Done()
+	defer func() {
+		d.jobsMutex.Lock()
+		delete(d.runningJobs, job.ProjectID)
+		d.jobsMutex.Unlock()
+
+		if d.interrupt.Err() != nil && !d.deploymentSucceeded(job.DeploymentID) {
+			log.Printf("⏸️  [QUEUE] Deployment #%d interrupted by shutdown", job.DeploymentID)
+			return
+		}
+
+		if err := d.Stores.Deployments.FinishJob(job.ID); err != nil {
+			log.Printf("⚠️  [QUEUE] Failed to mark job %d done: %v", job.ID, err)
+		}
+
+		// The project may have newer work waiting
+		d.wakeWorkers()
+	}()
+
+	deployment, project, err := d.loadJobTarget(job)
+	if err != nil {
+		log.Printf("❌ [QUEUE] Worker %d cannot run job %d: %v", workerID, job.ID, err)
+		d.updateDeploymentStatus(job.DeploymentID, models.StatusFailed, "", err.Error())
+		return
+	}
+
+	log.Printf("👷 [QUEUE] Worker %d running deployment #%d for project '%s'", workerID, deployment.ID, project.Name)
+	d.performDeployment(deployment, project)
+}
+
+// deploymentSucceeded reports whether a deployment finished successfully
+func (d *DeploymentService) deploymentSucceeded(deploymentID int64) bool {
+	deployment, err := d.Stores.Deployments.Get(deploymentID)
+	if err != nil {
+		return false
+	}
+	return deployment.Status == models.StatusSuccess
+}
+
+// loadJobTarget loads the deployment and current project settings for a job
+func (d *DeploymentService) loadJobTarget(job *models.DeploymentJob) (*models.Deployment, *models.Project, error) {
+	deployment, err := d.Stores.Deployments.Get(job.DeploymentID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get deployment: %w", err)
+	}
+
+	project, err := d.Stores.Projects.Get(job.ProjectID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get project: %w", err)
+	}
+
+	return deployment, project, nil
+}
@@ -0,0 +1,213 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"goth-deploy/internal/models"
+	"goth-deploy/internal/store"
+)
+
+// orphanExitTimeout is how long reconciliation waits for a killed orphan to exit
+const orphanExitTimeout = 5 * time.Second
+
+// pidFile records a running application process so it can be found after a platform crash
+type pidFile struct {
+	PID          int       `json:"pid"`
+	ProjectID    int64     `json:"project_id"`
+	DeploymentID int64     `json:"deployment_id"`
+	Port         int       `json:"port"`
+	ReleaseDir   string    `json:"release_dir"`
+	StartedAt    time.Time `json:"started_at"`
+}
+
+// runDir returns the directory holding the pidfiles of a project's processes
+func (d *DeploymentService) runDir(subdomain string) string {
+	return filepath.Join(d.projectDir(subdomain), "run")
+}
+
+// writePidFile records a started process
+func (d *DeploymentService) writePidFile(subdomain string, proc *appProcess) {
+	releaseDir, err := filepath.Abs(proc.releaseDir)
+	if err != nil {
+		releaseDir = proc.releaseDir
+	}
+
+	data, err := json.Marshal(pidFile{
+		PID:          proc.cmd.Process.Pid,
+		ProjectID:    proc.projectID,
+		DeploymentID: proc.deploymentID,
+		Port:         proc.port,
+		ReleaseDir:   releaseDir,
+		StartedAt:    time.Now(),
+	})
+	if err != nil {
+		return
+	}
+
+	runDir := d.runDir(subdomain)
+	if err := os.MkdirAll(runDir, 0755); err == nil {
+		err = os.WriteFile(filepath.Join(runDir, strconv.Itoa(proc.cmd.Process.Pid)+".pid"), data, 0644)
+	}
+	if err != nil {
+		log.Printf("⚠️  [RECONCILE] Failed to write pidfile for %s: %v", subdomain, err)
+	}
+}
+
+// removePidFile forgets an exited process
+func (d *DeploymentService) removePidFile(subdomain string, pid int) {
+	os.Remove(filepath.Join(d.runDir(subdomain), strconv.Itoa(pid)+".pid"))
+}
+
+// ReconcileApplications brings running applications back in line with the database after the
+// platform restarted. Processes left behind by the previous run are killed, and every project
+// that should be serving is started again from its active release. Projects whose release
+// cannot be started are marked failed.
+func (d *DeploymentService) ReconcileApplications() error {
+	if killed := d.killOrphans(); killed > 0 {
+		log.Printf("🧹 [RECONCILE] Killed %d orphaned application process(es)", killed)
+	}
+
+	stored, err := d.Stores.Projects.List(store.ProjectFilter{
+		Statuses: []string{models.ProjectStatusActive, models.ProjectStatusUnhealthy, models.ProjectStatusBuilding},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get projects: %w", err)
+	}
+	projects := make([]*models.Project, len(stored))
+	for i := range stored {
+		projects[i] = &stored[i]
+	}
+
+	// Start everything in parallel so one slow application doesn't hold up the rest
+	var wg sync.WaitGroup
+	for _, project := range projects {
+		wg.Add(1)
+		go func(project *models.Project) {
+			defer wg.Done()
+			d.reconcileProject(project)
+		}(project)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+// reconcileProject starts the active release of a project that should be serving
+func (d *DeploymentService) reconcileProject(project *models.Project) {
+	if d.IsProjectRunning(project.Subdomain) {
+		return
+	}
+
+	// A project being deployed for the first time has nothing to start yet
+	if project.Status == models.ProjectStatusBuilding {
+		if _, _, err := d.activeRelease(project); err != nil {
+			return
+		}
+	}
+
+	log.Printf("♻️  [RECONCILE] Starting %s (was %s)", project.Subdomain, project.Status)
+	if err := d.startActiveRelease(project); err != nil {
+		log.Printf("❌ [RECONCILE] Failed to start %s: %v", project.Subdomain, err)
+		if project.Status != models.ProjectStatusBuilding {
+			d.updateProjectStatus(project.ID, models.ProjectStatusFailed)
+		}
+		return
+	}
+
+	// Building projects stay building, their queued deploy is picked up by the workers
+	if project.Status != models.ProjectStatusBuilding {
+		d.updateProjectStatus(project.ID, models.ProjectStatusActive)
+	}
+	log.Printf("✅ [RECONCILE] %s is running on port %d", project.Subdomain, project.Port)
+}
+
+// killOrphans kills application processes recorded in pidfiles that this platform instance
+// did not start, and returns how many were killed
+func (d *DeploymentService) killOrphans() int {
+	pidFiles, err := filepath.Glob(filepath.Join(d.Config.DeploymentRoot, "*", "run", "*.pid"))
+	if err != nil {
+		return 0
+	}
+
+	d.mutex.RLock()
+	ours := map[int]bool{}
+	for _, proc := range d.processes {
+		ours[proc.cmd.Process.Pid] = true
+	}
+	d.mutex.RUnlock()
+
+	killed := 0
+	for _, path := range pidFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var pf pidFile
+		if err := json.Unmarshal(data, &pf); err != nil || pf.PID <= 0 {
+			os.Remove(path)
+			continue
+		}
+		if ours[pf.PID] {
+			continue
+		}
+
+		if processAlive(pf.PID) && processInDir(pf.PID, pf.ReleaseDir) {
+			log.Printf("🧹 [RECONCILE] Killing orphaned process %d (project %d, port %d)", pf.PID, pf.ProjectID, pf.Port)
+			if killOrphan(pf.PID) {
+				killed++
+			} else {
+				log.Printf("⚠️  [RECONCILE] Orphaned process %d did not exit", pf.PID)
+				continue
+			}
+		}
+		os.Remove(path)
+	}
+
+	return killed
+}
+
+// processAlive reports whether a process with the given PID exists
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return process.Signal(syscall.Signal(0)) == nil
+}
+
+// processInDir guards against PID reuse by checking that the process still runs in the
+// release directory it was started in. Without /proc the PID is trusted.
+func processInDir(pid int, dir string) bool {
+	cwd, err := os.Readlink(fmt.Sprintf("/proc/%d/cwd", pid))
+	if err != nil {
+		_, statErr := os.Stat("/proc/self")
+		return statErr != nil
+	}
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	return filepath.Clean(cwd) == filepath.Clean(dir)
+}
+
+// killOrphan kills the process group of a process that is not our child and waits for it
+// to disappear
+func killOrphan(pid int) bool {
+	killGroup(pid)
+
+	deadline := time.Now().Add(orphanExitTimeout)
+	for time.Now().Before(deadline) {
+		if !processAlive(pid) {
+			return true
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return false
+}
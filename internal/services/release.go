@@ -0,0 +1,261 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"goth-deploy/internal/models"
+	"goth-deploy/internal/store"
+)
+
+// stopWaitTimeout is how long stopProcess waits for a killed process to exit
+const stopWaitTimeout = 10 * time.Second
+
+// appProcess is a running release of a deployed application
+type appProcess struct {
+	cmd          *exec.Cmd
+	projectID    int64
+	deploymentID int64
+	instance     string // identifies this process in the runtime logs
+	port         int
+	releaseDir   string
+	done         chan struct{}          // closed once the process has exited
+	stopReason   atomic.Pointer[string] // set when the platform stops the process on purpose
+}
+
+// newInstanceID returns a random ID for a new application process
+func newInstanceID() string {
+	bytes := make([]byte, 6)
+	if _, err := rand.Read(bytes); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(bytes)
+}
+
+// running reports whether the process has not exited yet
+func (p *appProcess) running() bool {
+	select {
+	case <-p.done:
+		return false
+	default:
+		return p.cmd.Process != nil
+	}
+}
+
+// Reasons recorded when the platform stops an application process
+const (
+	stopReasonUser     = "stopped by user"
+	stopReasonRestart  = "restarted"
+	stopReasonDelete   = "project deleted"
+	stopReasonReplaced = "replaced by a newer release"
+	stopReasonNotReady = "failed readiness check"
+	stopReasonShutdown = "platform shutdown"
+	stopReasonActivate = "release activation failed"
+)
+
+// projectDir returns the directory holding all releases and logs of a project
+func (d *DeploymentService) projectDir(subdomain string) string {
+	return filepath.Join(d.Config.DeploymentRoot, subdomain)
+}
+
+// releaseDir returns the directory a deployment is built into
+func (d *DeploymentService) releaseDir(subdomain string, deploymentID int64) string {
+	return filepath.Join(d.projectDir(subdomain), "releases", strconv.FormatInt(deploymentID, 10))
+}
+
+// logDir returns the directory runtime logs are written to, shared by all releases
+func (d *DeploymentService) logDir(subdomain string) string {
+	return filepath.Join(d.projectDir(subdomain), "logs")
+}
+
+// allocatePort finds a free local TCP port for a new release
+func allocatePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+// activateRelease points the project at a ready release, then drains the release it replaces
+func (d *DeploymentService) activateRelease(project *models.Project, proc *appProcess) error {
+	if err := d.Stores.Deployments.Activate(project.ID, proc.deploymentID, proc.releaseDir, proc.port); err != nil {
+		return err
+	}
+	project.Port = proc.port
+
+	d.mutex.Lock()
+	old := d.processes[project.Subdomain]
+	d.processes[project.Subdomain] = proc
+	d.mutex.Unlock()
+
+	if old != nil && old != proc {
+		go d.drainProcess(project.Subdomain, old)
+	}
+
+	return nil
+}
+
+// drainProcess gives a replaced release time to finish in-flight requests, then stops it
+func (d *DeploymentService) drainProcess(subdomain string, proc *appProcess) {
+	log.Printf("🚰 [DEPLOY] Draining previous release of %s (port %d) for %v", subdomain, proc.port, d.Config.DrainTimeout)
+
+	select {
+	case <-proc.done:
+		return
+	case <-time.After(d.Config.DrainTimeout):
+	}
+
+	d.stopProcess(proc, stopReasonReplaced)
+	log.Printf("🛑 [DEPLOY] Stopped previous release of %s (port %d)", subdomain, proc.port)
+}
+
+// stopProcess stops a release and waits for it to exit. The release's process group gets
+// SIGTERM first and SIGKILL once the project's stop timeout has passed.
+func (d *DeploymentService) stopProcess(proc *appProcess, reason string) {
+	if !proc.running() {
+		return
+	}
+
+	proc.stopReason.Store(&reason)
+	pid := proc.cmd.Process.Pid
+	timeout := d.stopTimeout(proc.projectID)
+
+	if err := terminateGroup(pid); err != nil {
+		proc.cmd.Process.Kill()
+	}
+
+	select {
+	case <-proc.done:
+	case <-time.After(timeout):
+		log.Printf("⚠️  [DEPLOY] Process %d did not exit within %v after SIGTERM, killing it", pid, timeout)
+		killGroup(pid)
+
+		select {
+		case <-proc.done:
+		case <-time.After(stopWaitTimeout):
+			log.Printf("⚠️  [DEPLOY] Process %d did not exit after kill", pid)
+		}
+	}
+
+	// Don't leave anything the application spawned behind
+	killGroup(pid)
+}
+
+// stopTimeout returns how long a project's application gets to exit after SIGTERM
+func (d *DeploymentService) stopTimeout(projectID int64) time.Duration {
+	policy, err := d.GetRestartPolicy(projectID)
+	if err != nil || policy.StopTimeout <= 0 {
+		return d.Config.StopTimeout
+	}
+	return time.Duration(policy.StopTimeout) * time.Second
+}
+
+// activeRelease returns the deployment ID and directory of the release a project serves.
+// Projects deployed before releases existed are served from the project directory itself.
+func (d *DeploymentService) activeRelease(project *models.Project) (int64, string, error) {
+	active, err := d.getActiveDeployment(project.ID)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to get active release: %w", err)
+	}
+
+	if active != nil && active.ReleaseDir != "" {
+		if _, err := os.Stat(active.ReleaseDir); err == nil {
+			return active.ID, active.ReleaseDir, nil
+		}
+		return 0, "", fmt.Errorf("release directory %s is missing", active.ReleaseDir)
+	}
+
+	legacyDir := d.projectDir(project.Subdomain)
+	if _, err := os.Stat(filepath.Join(legacyDir, ".git")); err == nil {
+		return 0, legacyDir, nil
+	}
+
+	return 0, "", fmt.Errorf("project %s has no release to start", project.Subdomain)
+}
+
+// getActiveDeployment loads the deployment a project serves, or returns nil if it has none
+func (d *DeploymentService) getActiveDeployment(projectID int64) (*models.Deployment, error) {
+	project, err := d.Stores.Projects.Get(projectID)
+	if err != nil {
+		return nil, err
+	}
+	if project.ActiveDeploymentID == nil {
+		return nil, nil
+	}
+	deployment, err := d.Stores.Deployments.Get(*project.ActiveDeploymentID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	return deployment, err
+}
+
+// pruneReleases removes release directories beyond the retention limit, always keeping
+// the active release
+func (d *DeploymentService) pruneReleases(project *models.Project) {
+	keep := map[string]bool{}
+
+	if d.Config.ReleaseRetention > 0 {
+		releases, err := d.Stores.Deployments.List(store.DeploymentFilter{
+			ProjectID: project.ID,
+			Status:    models.StatusSuccess,
+			Released:  true,
+			Limit:     d.Config.ReleaseRetention,
+		})
+		if err != nil {
+			log.Printf("⚠️  [DEPLOY] Failed to list releases of %s: %v", project.Subdomain, err)
+			return
+		}
+		for _, release := range releases {
+			keep[filepath.Clean(release.ReleaseDir)] = true
+		}
+	}
+
+	if _, activeDir, err := d.activeRelease(project); err == nil {
+		keep[filepath.Clean(activeDir)] = true
+	}
+
+	d.mutex.RLock()
+	if proc, ok := d.processes[project.Subdomain]; ok {
+		keep[filepath.Clean(proc.releaseDir)] = true
+	}
+	d.mutex.RUnlock()
+
+	releasesDir := filepath.Join(d.projectDir(project.Subdomain), "releases")
+	entries, err := os.ReadDir(releasesDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		dir := filepath.Join(releasesDir, entry.Name())
+		if !entry.IsDir() || keep[filepath.Clean(dir)] {
+			continue
+		}
+		// Skip releases that are still being built
+		if id, err := strconv.ParseInt(entry.Name(), 10, 64); err == nil && d.isBuilding(id) {
+			continue
+		}
+		log.Printf("🧹 [DEPLOY] Removing old release %s", dir)
+		os.RemoveAll(dir)
+	}
+}
+
+// isBuilding reports whether a deployment has not finished yet
+func (d *DeploymentService) isBuilding(deploymentID int64) bool {
+	deployment, err := d.Stores.Deployments.Get(deploymentID)
+	if err != nil {
+		return false
+	}
+	return deployment.Status == models.StatusPending || deployment.Status == models.StatusBuilding
+}
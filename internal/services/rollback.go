@@ -0,0 +1,108 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"goth-deploy/internal/models"
+	"goth-deploy/internal/store"
+)
+
+// RollbackProject queues a new deployment that restores an earlier successful deployment of
+// the project. The build artifact and environment of the source deployment are reused when
+// they are still available, otherwise its commit is rebuilt.
+func (d *DeploymentService) RollbackProject(projectID, sourceDeploymentID int64) (*models.Deployment, error) {
+	log.Printf("⏪ [DEPLOY] Rolling back project ID %d to deployment #%d", projectID, sourceDeploymentID)
+
+	source, err := d.getDeployment(sourceDeploymentID)
+	if err != nil {
+		return nil, err
+	}
+	if source.ProjectID != projectID {
+		return nil, fmt.Errorf("deployment #%d does not belong to project %d", sourceDeploymentID, projectID)
+	}
+	if source.Status != models.StatusSuccess {
+		return nil, fmt.Errorf("deployment #%d did not succeed and cannot be rolled back to", sourceDeploymentID)
+	}
+	if source.CommitSHA == "" && source.ReleaseDir == "" {
+		return nil, fmt.Errorf("deployment #%d has neither a commit nor a release to restore", sourceDeploymentID)
+	}
+
+	deployment, err := d.enqueueDeployment(projectID, source.CommitSHA, &source.ID)
+	if err != nil {
+		log.Printf("❌ [DEPLOY] Failed to queue rollback: %v", err)
+		return nil, err
+	}
+
+	log.Printf("✅ [DEPLOY] Rollback deployment #%d queued (commit %s)", deployment.ID, source.CommitSHA)
+
+	if err := d.Stores.Projects.SetStatus(projectID, models.ProjectStatusBuilding); err != nil {
+		log.Printf("⚠️  [DEPLOY] Failed to update project status: %v", err)
+		return deployment, fmt.Errorf("failed to update project status: %w", err)
+	}
+
+	d.wakeWorkers()
+
+	return deployment, nil
+}
+
+// getRollbackSource loads the deployment a rollback restores
+func (d *DeploymentService) getRollbackSource(deployment *models.Deployment) (*models.Deployment, error) {
+	source, err := d.getDeployment(*deployment.RollbackOf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rollback source: %w", err)
+	}
+	if source.ProjectID != deployment.ProjectID {
+		return nil, fmt.Errorf("deployment #%d belongs to another project", source.ID)
+	}
+	return source, nil
+}
+
+// getDeployment loads the release details of a deployment
+func (d *DeploymentService) getDeployment(deploymentID int64) (*models.Deployment, error) {
+	deployment, err := d.Stores.Deployments.Get(deploymentID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, fmt.Errorf("deployment #%d not found", deploymentID)
+		}
+		return nil, fmt.Errorf("failed to get deployment: %w", err)
+	}
+
+	envSnapshot, err := d.Stores.Deployments.EnvSnapshot(deploymentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get environment snapshot: %w", err)
+	}
+	if envSnapshot != "" {
+		snapshot, err := d.Secrets.Decrypt(envSnapshot)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt environment snapshot: %w", err)
+		}
+		if err := json.Unmarshal([]byte(snapshot), &deployment.EnvSnapshot); err != nil {
+			return nil, fmt.Errorf("failed to decode environment snapshot: %w", err)
+		}
+	}
+
+	return deployment, nil
+}
+
+// saveEnvSnapshot stores the environment a release was started with
+func (d *DeploymentService) saveEnvSnapshot(deploymentID int64, envVars []string) {
+	if envVars == nil {
+		envVars = []string{}
+	}
+	snapshot, err := json.Marshal(envVars)
+	if err != nil {
+		log.Printf("⚠️  [DEPLOY-%d] Failed to encode environment snapshot: %v", deploymentID, err)
+		return
+	}
+	encrypted, err := d.Secrets.Encrypt(string(snapshot))
+	if err != nil {
+		log.Printf("⚠️  [DEPLOY-%d] Failed to encrypt environment snapshot: %v", deploymentID, err)
+		return
+	}
+	if err := d.Stores.Deployments.SetEnvSnapshot(deploymentID, encrypted); err != nil {
+		log.Printf("⚠️  [DEPLOY-%d] Failed to save environment snapshot: %v", deploymentID, err)
+	}
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// Shutdown stops the deployment service when the platform exits. Running deployments get
+// until ctx is done to finish and are interrupted after that; interrupted deployments are
+// queued again on the next start. Finally every application receives SIGTERM and is killed
+// if it is still running after its stop timeout.
+//
+// Project statuses are left untouched so that ReconcileApplications brings the same
+// applications back up on the next start.
+func (d *DeploymentService) Shutdown(ctx context.Context) {
+	d.shuttingDown.Store(true)
+
+	// No more supervised restarts
+	d.supervisorMutex.Lock()
+	for _, state := range d.restarts {
+		if state.timer != nil {
+			state.timer.Stop()
+			state.timer = nil
+		}
+	}
+	d.supervisorMutex.Unlock()
+
+	deploymentsDone := make(chan struct{})
+	go func() {
+		d.runningDeployments.Wait()
+		close(deploymentsDone)
+	}()
+
+	select {
+	case <-deploymentsDone:
+	case <-ctx.Done():
+		log.Printf("⏸️  [SHUTDOWN] Interrupting running deployments")
+		d.interruptDeployments()
+		<-deploymentsDone
+	}
+	d.interruptDeployments()
+
+	d.stopAllApplications()
+
+	// Store the last words of the applications
+	d.logStore.flush()
+}
+
+// stopAllApplications stops every application process, each within its project's stop timeout
+func (d *DeploymentService) stopAllApplications() {
+	d.mutex.RLock()
+	procs := make([]*appProcess, 0, len(d.children))
+	for proc := range d.children {
+		procs = append(procs, proc)
+	}
+	d.mutex.RUnlock()
+
+	if len(procs) == 0 {
+		return
+	}
+
+	log.Printf("🛑 [SHUTDOWN] Stopping %d application process(es)", len(procs))
+
+	var wg sync.WaitGroup
+	for _, proc := range procs {
+		wg.Add(1)
+		go func(proc *appProcess) {
+			defer wg.Done()
+			d.stopProcess(proc, stopReasonShutdown)
+		}(proc)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,228 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"goth-deploy/internal/models"
+)
+
+// restartBaseDelay is the delay before the first restart after a crash, doubled for every
+// further restart within the project's restart window
+const restartBaseDelay = time.Second
+
+// restartMaxDelay caps the backoff between restarts
+const restartMaxDelay = time.Minute
+
+// restartState tracks supervised restarts of a single project
+type restartState struct {
+	history []time.Time // restarts within the current window
+	timer   *time.Timer // pending restart, if any
+}
+
+// GetRestartPolicy loads the restart policy of a project
+func (d *DeploymentService) GetRestartPolicy(projectID int64) (models.RestartPolicy, error) {
+	project, err := d.Stores.Projects.Get(projectID)
+	if err != nil {
+		return models.RestartPolicy{}, fmt.Errorf("failed to get restart policy: %w", err)
+	}
+	return project.RestartPolicy, nil
+}
+
+// UpdateRestartPolicy validates and saves the restart policy of a project
+func (d *DeploymentService) UpdateRestartPolicy(projectID int64, policy models.RestartPolicy) error {
+	if err := ValidateRestartPolicy(policy); err != nil {
+		return err
+	}
+
+	if err := d.Stores.Projects.UpdateRestartPolicy(projectID, policy); err != nil {
+		return fmt.Errorf("failed to update restart policy: %w", err)
+	}
+	return nil
+}
+
+// ValidateRestartPolicy reports whether a restart policy is usable
+func ValidateRestartPolicy(policy models.RestartPolicy) error {
+	switch policy.Policy {
+	case models.RestartPolicyNever, models.RestartPolicyOnFailure, models.RestartPolicyAlways:
+	default:
+		return fmt.Errorf("restart policy must be one of never, on-failure or always")
+	}
+	if policy.MaxRestarts < 0 {
+		return fmt.Errorf("max restarts must not be negative")
+	}
+	if policy.Window < 1 {
+		return fmt.Errorf("restart window must be at least 1 second")
+	}
+	if policy.StopTimeout < 0 {
+		return fmt.Errorf("stop timeout must not be negative")
+	}
+	return nil
+}
+
+// GetProcessExits returns the most recent process exits of a project
+func (d *DeploymentService) GetProcessExits(projectID int64, limit int) ([]models.ProcessExit, error) {
+	rows, err := d.DB.Query(`
+		SELECT id, project_id, deployment_id, exit_code, signal, crashed, reason, exited_at
+		FROM process_exits WHERE project_id = ?
+		ORDER BY id DESC
+		LIMIT ?
+	`, projectID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get process exits: %w", err)
+	}
+	defer rows.Close()
+
+	var exits []models.ProcessExit
+	for rows.Next() {
+		var exit models.ProcessExit
+		if err := rows.Scan(&exit.ID, &exit.ProjectID, &exit.DeploymentID, &exit.ExitCode, &exit.Signal, &exit.Crashed, &exit.Reason, &exit.ExitedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan process exit: %w", err)
+		}
+		exits = append(exits, exit)
+	}
+
+	return exits, rows.Err()
+}
+
+// recordExit stores how a process exited and reports whether it crashed
+func (d *DeploymentService) recordExit(proc *appProcess, waitErr error) bool {
+	exitCode, signal := exitStatus(proc.cmd, waitErr)
+
+	var reason string
+	crashed := false
+	if stopReason := proc.stopReason.Load(); stopReason != nil {
+		reason = *stopReason
+	} else if waitErr != nil {
+		reason = "crashed"
+		crashed = true
+	} else {
+		reason = "exited"
+	}
+
+	var deploymentID *int64
+	if proc.deploymentID != 0 {
+		deploymentID = &proc.deploymentID
+	}
+
+	_, err := d.DB.Exec(`
+		INSERT INTO process_exits (project_id, deployment_id, exit_code, signal, crashed, reason, exited_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, proc.projectID, deploymentID, exitCode, signal, crashed, reason, time.Now())
+	if err != nil {
+		log.Printf("⚠️  [SUPERVISOR] Failed to record exit of project %d: %v", proc.projectID, err)
+	}
+
+	return crashed
+}
+
+// exitStatus extracts the exit code and terminating signal of a finished command
+func exitStatus(cmd *exec.Cmd, waitErr error) (int, string) {
+	state := cmd.ProcessState
+	if state == nil {
+		var exitErr *exec.ExitError
+		if !errors.As(waitErr, &exitErr) {
+			return -1, ""
+		}
+		state = exitErr.ProcessState
+	}
+
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return -1, status.Signal().String()
+	}
+	return state.ExitCode(), ""
+}
+
+// superviseExit applies the project's restart policy after its live release exited
+func (d *DeploymentService) superviseExit(project *models.Project, crashed bool) {
+	policy, err := d.GetRestartPolicy(project.ID)
+	if err != nil {
+		log.Printf("⚠️  [SUPERVISOR] %v", err)
+		return
+	}
+
+	if d.shuttingDown.Load() || policy.Policy == models.RestartPolicyNever || (policy.Policy == models.RestartPolicyOnFailure && !crashed) {
+		return
+	}
+
+	now := time.Now()
+	window := time.Duration(policy.Window) * time.Second
+
+	d.supervisorMutex.Lock()
+	defer d.supervisorMutex.Unlock()
+
+	state, ok := d.restarts[project.Subdomain]
+	if !ok {
+		state = &restartState{}
+		d.restarts[project.Subdomain] = state
+	}
+
+	// Forget restarts that have left the window
+	recent := state.history[:0]
+	for _, t := range state.history {
+		if now.Sub(t) < window {
+			recent = append(recent, t)
+		}
+	}
+	state.history = recent
+
+	if len(state.history) >= policy.MaxRestarts {
+		log.Printf("🛑 [SUPERVISOR] %s restarted %d times in %v, giving up", project.Subdomain, len(state.history), window)
+		d.updateProjectStatus(project.ID, models.ProjectStatusFailed)
+		return
+	}
+
+	delay := restartBaseDelay << len(state.history)
+	if delay > restartMaxDelay || delay <= 0 {
+		delay = restartMaxDelay
+	}
+	state.history = append(state.history, now)
+
+	if state.timer != nil {
+		state.timer.Stop()
+	}
+	log.Printf("⏱️  [SUPERVISOR] Restarting %s in %v (restart %d/%d)", project.Subdomain, delay, len(state.history), policy.MaxRestarts)
+	state.timer = time.AfterFunc(delay, func() {
+		d.supervisedRestart(project)
+	})
+}
+
+// supervisedRestart restarts a project whose restart was scheduled by the supervisor
+func (d *DeploymentService) supervisedRestart(project *models.Project) {
+	d.supervisorMutex.Lock()
+	if state, ok := d.restarts[project.Subdomain]; ok {
+		state.timer = nil
+	}
+	d.supervisorMutex.Unlock()
+
+	// A deploy, stop or manual restart may have happened in the meantime
+	current, err := d.Stores.Projects.Get(project.ID)
+	if err != nil {
+		return
+	}
+	if current.Status == models.ProjectStatusBuilding || d.IsProjectRunning(project.Subdomain) {
+		return
+	}
+
+	log.Printf("🔄 [SUPERVISOR] Restarting %s", project.Subdomain)
+	if err := d.RestartProject(project.ID); err != nil {
+		log.Printf("❌ [SUPERVISOR] Failed to restart %s: %v", project.Subdomain, err)
+		// A release that cannot start counts as another crash
+		d.superviseExit(project, true)
+	}
+}
+
+// cancelRestart drops a pending supervised restart, e.g. because the project was stopped
+func (d *DeploymentService) cancelRestart(subdomain string) {
+	d.supervisorMutex.Lock()
+	defer d.supervisorMutex.Unlock()
+
+	if state, ok := d.restarts[subdomain]; ok && state.timer != nil {
+		state.timer.Stop()
+		state.timer = nil
+	}
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"goth-deploy/internal/database"
+	"goth-deploy/internal/models"
+)
+
+// apiTokenPrefix starts every API token, so leaked tokens are easy to recognize
+const apiTokenPrefix = "gdp_"
+
+// apiTokenPrefixLength is how much of a token is kept in the clear to tell tokens apart
+const apiTokenPrefixLength = len(apiTokenPrefix) + 6
+
+// MaxTokenLifetime is the longest an API token may stay valid
+const MaxTokenLifetime = 365 * 24 * time.Hour
+
+// tokenUseInterval is how often the last use of a token is recorded
+const tokenUseInterval = time.Minute
+
+// ErrTokenInvalid is returned for API tokens that are unknown or expired
+var ErrTokenInvalid = errors.New("invalid or expired API token")
+
+// ErrTokenNotFound is returned for API tokens that do not belong to the user
+var ErrTokenNotFound = errors.New("API token not found")
+
+// tokenScopes lists the valid API token scopes
+var tokenScopes = map[string]bool{
+	models.TokenScopeRead:   true,
+	models.TokenScopeDeploy: true,
+	models.TokenScopeEnv:    true,
+	models.TokenScopeManage: true,
+}
+
+// TokenService manages personal API tokens
+type TokenService struct {
+	DB *database.DB
+}
+
+// NewTokenService creates a new token service
+func NewTokenService(db *database.DB) *TokenService {
+	return &TokenService{DB: db}
+}
+
+// ValidateToken checks the name, scopes and lifetime of a new API token
+func ValidateToken(token models.APIToken, lifetime time.Duration) error {
+	if strings.TrimSpace(token.Name) == "" || len(token.Name) > 100 {
+		return fmt.Errorf("name must be between 1 and 100 characters")
+	}
+	if len(token.Scopes) == 0 {
+		return fmt.Errorf("at least one scope is required")
+	}
+	for _, scope := range token.Scopes {
+		if !tokenScopes[scope] {
+			return fmt.Errorf("unknown scope %q, scopes are read, deploy, env and manage", scope)
+		}
+	}
+	if lifetime <= 0 || lifetime > MaxTokenLifetime {
+		return fmt.Errorf("tokens must expire within %d days", int(MaxTokenLifetime.Hours()/24))
+	}
+	return nil
+}
+
+// HasScope reports whether a token was granted a scope
+func HasScope(token *models.APIToken, scope string) bool {
+	for _, granted := range token.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateToken creates an API token that expires after lifetime and returns it with the
+// secret token, which is not stored and cannot be shown again
+func (t *TokenService) CreateToken(token *models.APIToken, lifetime time.Duration) (string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("failed to generate API token: %w", err)
+	}
+	secret := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(random)
+
+	token.Prefix = secret[:apiTokenPrefixLength]
+	token.CreatedAt = time.Now()
+	token.ExpiresAt = token.CreatedAt.Add(lifetime)
+	token.LastUsedAt = nil
+
+	err := t.DB.QueryRow(`
+		INSERT INTO api_tokens (user_id, name, token_hash, prefix, scopes, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, token.UserID, token.Name, hashToken(secret), token.Prefix, strings.Join(token.Scopes, ","), token.ExpiresAt, token.CreatedAt).Scan(&token.ID)
+	if err != nil {
+		return "", fmt.Errorf("failed to create API token: %w", err)
+	}
+
+	return secret, nil
+}
+
+// ListTokens returns the API tokens of a user, expired ones included
+func (t *TokenService) ListTokens(userID int64) ([]models.APIToken, error) {
+	rows, err := t.DB.Query(`
+		SELECT id, user_id, name, prefix, scopes, expires_at, last_used_at, created_at
+		FROM api_tokens WHERE user_id = ?
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := []models.APIToken{}
+	for rows.Next() {
+		token, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *token)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeToken deletes an API token of a user
+func (t *TokenService) RevokeToken(userID, tokenID int64) error {
+	result, err := t.DB.Exec("DELETE FROM api_tokens WHERE id = ? AND user_id = ?", tokenID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke API token: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrTokenNotFound
+	}
+	return nil
+}
+
+// AuthenticateToken returns the API token matching a secret token, or ErrTokenInvalid if it
+// does not exist or has expired. The time of use is recorded.
+func (t *TokenService) AuthenticateToken(secret string) (*models.APIToken, error) {
+	if !strings.HasPrefix(secret, apiTokenPrefix) {
+		return nil, ErrTokenInvalid
+	}
+
+	token, err := scanToken(t.DB.QueryRow(`
+		SELECT id, user_id, name, prefix, scopes, expires_at, last_used_at, created_at
+		FROM api_tokens WHERE token_hash = ?
+	`, hashToken(secret)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if !now.Before(token.ExpiresAt) {
+		return nil, ErrTokenInvalid
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= tokenUseInterval {
+		if _, err := t.DB.Exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ?", now, token.ID); err != nil {
+			return nil, fmt.Errorf("failed to record API token use: %w", err)
+		}
+		token.LastUsedAt = &now
+	}
+
+	return token, nil
+}
+
+// scanToken reads an API token from a row of id, user_id, name, prefix, scopes, expires_at,
+// last_used_at and created_at
+func scanToken(row interface{ Scan(...interface{}) error }) (*models.APIToken, error) {
+	var token models.APIToken
+	var scopes string
+	var lastUsedAt sql.NullTime
+	if err := row.Scan(&token.ID, &token.UserID, &token.Name, &token.Prefix, &scopes, &token.ExpiresAt, &lastUsedAt, &token.CreatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to scan API token: %w", err)
+	}
+	token.Scopes = strings.Split(scopes, ",")
+	if lastUsedAt.Valid {
+		token.LastUsedAt = &lastUsedAt.Time
+	}
+	return &token, nil
+}
+
+// hashToken returns the hash an API token is stored under. Tokens are random, so a plain
+// SHA-256 is enough.
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
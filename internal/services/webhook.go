@@ -0,0 +1,300 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"goth-deploy/internal/config"
+	"goth-deploy/internal/database"
+	"goth-deploy/internal/models"
+	"goth-deploy/internal/store"
+
+	"github.com/google/go-github/v66/github"
+)
+
+// deliveryRetention is how long webhook delivery IDs are remembered for deduplication
+const deliveryRetention = 7 * 24 * time.Hour
+
+// WebhookService handles incoming GitHub webhook deliveries
+type WebhookService struct {
+	DB         *database.DB
+	Stores     *store.Stores
+	Config     *config.Config
+	Deployment *DeploymentService
+	GitHub     *GitHubService
+}
+
+// NewWebhookService creates a new webhook service
+func NewWebhookService(db *database.DB, stores *store.Stores, cfg *config.Config, deployment *DeploymentService, gh *GitHubService) *WebhookService {
+	return &WebhookService{
+		DB:         db,
+		Stores:     stores,
+		Config:     cfg,
+		Deployment: deployment,
+		GitHub:     gh,
+	}
+}
+
+// RegisterProjectHook makes sure the project's repository has a push webhook pointing at this platform.
+// Projects that deploy the same repository share a single hook.
+func (s *WebhookService) RegisterProjectHook(ctx context.Context, projectID int64, accessToken string) error {
+	if s.Config.GitHubWebhookSecret == "" {
+		log.Printf("🔔 [WEBHOOK] GITHUB_WEBHOOK_SECRET not set, skipping webhook for project %d", projectID)
+		return nil
+	}
+
+	githubRepoID, repoURL, hookID, err := s.getProjectHook(projectID)
+	if err != nil {
+		return err
+	}
+	if hookID != 0 {
+		return nil
+	}
+
+	// Reuse a hook that another project on the same repository already registered
+	siblings, err := s.Stores.Projects.List(store.ProjectFilter{GitHubRepoID: githubRepoID})
+	if err != nil {
+		return fmt.Errorf("failed to look up existing webhook: %w", err)
+	}
+	for _, sibling := range siblings {
+		if sibling.ID != projectID && sibling.WebhookID != nil && sameRepository(sibling, githubRepoID, repoURL) {
+			hookID = *sibling.WebhookID
+			break
+		}
+	}
+
+	if hookID != 0 {
+		log.Printf("🔔 [WEBHOOK] Project %d shares existing webhook %d", projectID, hookID)
+	} else {
+		repoFullName, err := RepoFullNameFromURL(repoURL)
+		if err != nil {
+			return err
+		}
+		hookID, err = s.GitHub.CreateWebhook(ctx, accessToken, repoFullName, s.Config.WebhookURL(), s.Config.GitHubWebhookSecret)
+		if err != nil {
+			return err
+		}
+		log.Printf("🔔 [WEBHOOK] Created webhook %d on %s for project %d", hookID, repoFullName, projectID)
+	}
+
+	if err := s.Stores.Projects.SetWebhook(projectID, &hookID); err != nil {
+		return fmt.Errorf("failed to save webhook ID: %w", err)
+	}
+
+	return nil
+}
+
+// RemoveProjectHook deletes the project's webhook from GitHub unless another project still uses it
+func (s *WebhookService) RemoveProjectHook(ctx context.Context, projectID int64, accessToken string) error {
+	githubRepoID, repoURL, hookID, err := s.getProjectHook(projectID)
+	if err != nil {
+		return err
+	}
+	if hookID == 0 {
+		return nil
+	}
+
+	// Other projects of the repository may share the hook
+	siblings, err := s.Stores.Projects.List(store.ProjectFilter{GitHubRepoID: githubRepoID})
+	if err != nil {
+		return fmt.Errorf("failed to count webhook users: %w", err)
+	}
+	users := 0
+	for _, sibling := range siblings {
+		if sibling.ID != projectID && sibling.WebhookID != nil && *sibling.WebhookID == hookID && sameRepository(sibling, githubRepoID, repoURL) {
+			users++
+		}
+	}
+	if users > 0 {
+		if err := s.Stores.Projects.SetWebhook(projectID, nil); err != nil {
+			return fmt.Errorf("failed to clear webhook ID: %w", err)
+		}
+		log.Printf("🔔 [WEBHOOK] Keeping webhook %d, still used by %d project(s)", hookID, users)
+		return nil
+	}
+
+	// The hook ID is only cleared once the hook is gone, so a failed delete can be retried
+	repoFullName, err := RepoFullNameFromURL(repoURL)
+	if err != nil {
+		return err
+	}
+	if err := s.GitHub.DeleteWebhook(ctx, accessToken, repoFullName, hookID); err != nil {
+		return err
+	}
+	if err := s.Stores.Projects.SetWebhook(projectID, nil); err != nil {
+		return fmt.Errorf("failed to clear webhook ID: %w", err)
+	}
+
+	log.Printf("🔔 [WEBHOOK] Deleted webhook %d from %s", hookID, repoFullName)
+	return nil
+}
+
+// SyncProjectHook repairs the project's webhook on GitHub, recreating it if it was removed
+// or edited, and returns the ID of the hook now in place
+func (s *WebhookService) SyncProjectHook(ctx context.Context, projectID int64, accessToken string) (int64, error) {
+	if s.Config.GitHubWebhookSecret == "" {
+		return 0, fmt.Errorf("GITHUB_WEBHOOK_SECRET is not configured")
+	}
+
+	githubRepoID, repoURL, hookID, err := s.getProjectHook(projectID)
+	if err != nil {
+		return 0, err
+	}
+
+	repoFullName, err := RepoFullNameFromURL(repoURL)
+	if err != nil {
+		return 0, err
+	}
+
+	newHookID, err := s.GitHub.SyncWebhook(ctx, accessToken, repoFullName, hookID, s.Config.WebhookURL(), s.Config.GitHubWebhookSecret)
+	if err != nil {
+		return 0, err
+	}
+
+	// Point every project sharing the old hook (or this repository, if it had none) at the new one
+	if hookID != 0 {
+		err = s.Stores.Projects.ReplaceWebhook(githubRepoID, hookID, newHookID)
+	}
+	if err == nil {
+		err = s.Stores.Projects.SetWebhook(projectID, &newHookID)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to save webhook ID: %w", err)
+	}
+
+	log.Printf("🔔 [WEBHOOK] Synced webhook %d on %s for project %d", newHookID, repoFullName, projectID)
+	return newHookID, nil
+}
+
+// getProjectHook loads the repository and webhook details of a project
+func (s *WebhookService) getProjectHook(projectID int64) (githubRepoID int64, repoURL string, hookID int64, err error) {
+	project, err := s.Stores.Projects.Get(projectID)
+	if err != nil {
+		return 0, "", 0, fmt.Errorf("failed to get project: %w", err)
+	}
+	if project.WebhookID != nil {
+		hookID = *project.WebhookID
+	}
+	return project.GitHubRepoID, project.RepoURL, hookID, nil
+}
+
+// sameRepository reports whether a project deploys the repository with the given ID and URL.
+// Projects without a repository ID are compared by URL; the store lists every project for a
+// zero ID, and those must not share a hook with other repositories.
+func sameRepository(project models.Project, githubRepoID int64, repoURL string) bool {
+	if githubRepoID != 0 && project.GitHubRepoID != 0 {
+		return project.GitHubRepoID == githubRepoID
+	}
+	return normalizeRepoURL(project.RepoURL) == normalizeRepoURL(repoURL)
+}
+
+// RecordDelivery stores a delivery ID and reports whether it has already been seen
+func (s *WebhookService) RecordDelivery(deliveryID, event string) (bool, error) {
+	now := time.Now()
+
+	// Forget old deliveries so the table doesn't grow forever
+	if _, err := s.DB.Exec("DELETE FROM webhook_deliveries WHERE received_at < ?", now.Add(-deliveryRetention)); err != nil {
+		log.Printf("Failed to prune webhook deliveries: %v", err)
+	}
+
+	result, err := s.DB.Exec(`
+		INSERT INTO webhook_deliveries (delivery_id, event, received_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT DO NOTHING
+	`, deliveryID, event, now)
+	if err != nil {
+		return false, fmt.Errorf("failed to record webhook delivery: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to record webhook delivery: %w", err)
+	}
+
+	return affected == 0, nil
+}
+
+// ForgetDelivery removes a delivery ID so that a redelivery of it is processed again
+func (s *WebhookService) ForgetDelivery(deliveryID string) {
+	if _, err := s.DB.Exec("DELETE FROM webhook_deliveries WHERE delivery_id = ?", deliveryID); err != nil {
+		log.Printf("Failed to forget webhook delivery %s: %v", deliveryID, err)
+	}
+}
+
+// HandlePush deploys every project that tracks the pushed repository and branch. The deployments
+// that were queued are returned along with the errors of the projects that failed.
+func (s *WebhookService) HandlePush(event *github.PushEvent) ([]*models.Deployment, error) {
+	ref := event.GetRef()
+	if !strings.HasPrefix(ref, "refs/heads/") {
+		log.Printf("🔔 [WEBHOOK] Ignoring push to non-branch ref %s", ref)
+		return nil, nil
+	}
+	branch := strings.TrimPrefix(ref, "refs/heads/")
+
+	headSHA := event.GetAfter()
+	if event.GetDeleted() || headSHA == "" || strings.Trim(headSHA, "0") == "" {
+		log.Printf("🔔 [WEBHOOK] Ignoring deletion of branch %s", branch)
+		return nil, nil
+	}
+
+	repo := event.GetRepo()
+	projectIDs, err := s.findProjectsForPush(repo, branch)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("🔔 [WEBHOOK] Push to %s@%s (%s) matches %d project(s)", repo.GetFullName(), branch, headSHA, len(projectIDs))
+
+	// A project that fails to deploy does not hold up the others
+	var deployments []*models.Deployment
+	var errs []error
+	for _, projectID := range projectIDs {
+		deployment, err := s.Deployment.DeployProject(projectID, headSHA)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to deploy project %d: %w", projectID, err))
+			continue
+		}
+		deployments = append(deployments, deployment)
+	}
+
+	return deployments, errors.Join(errs...)
+}
+
+// findProjectsForPush returns the IDs of projects that track the given repository and branch
+func (s *WebhookService) findProjectsForPush(repo *github.PushEventRepository, branch string) ([]int64, error) {
+	projects, err := s.Stores.Projects.List(store.ProjectFilter{Branch: branch})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find projects: %w", err)
+	}
+
+	urls := map[string]bool{}
+	for _, u := range []string{repo.GetCloneURL(), repo.GetHTMLURL(), repo.GetSSHURL(), repo.GetGitURL()} {
+		if u != "" {
+			urls[normalizeRepoURL(u)] = true
+		}
+	}
+
+	var projectIDs []int64
+	for _, project := range projects {
+		if (repo.GetID() != 0 && project.GitHubRepoID == repo.GetID()) || urls[normalizeRepoURL(project.RepoURL)] {
+			projectIDs = append(projectIDs, project.ID)
+		}
+	}
+
+	return projectIDs, nil
+}
+
+// normalizeRepoURL reduces the different forms of a GitHub repository URL to host/owner/repo
+func normalizeRepoURL(repoURL string) string {
+	u := strings.ToLower(strings.TrimSpace(repoURL))
+	for _, prefix := range []string{"https://", "http://", "git://", "ssh://", "git@"} {
+		u = strings.TrimPrefix(u, prefix)
+	}
+	u = strings.Replace(u, ":", "/", 1)
+	u = strings.TrimSuffix(u, "/")
+	return strings.TrimSuffix(u, ".git")
+}
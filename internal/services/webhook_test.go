@@ -0,0 +1,205 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"goth-deploy/internal/config"
+	"goth-deploy/internal/models"
+	"goth-deploy/internal/store"
+
+	"github.com/google/go-github/v66/github"
+	"golang.org/x/oauth2"
+)
+
+// githubStub answers every GitHub API request with status and body, and records the requests
+type githubStub struct {
+	status   int
+	body     string
+	requests []string
+}
+
+func (s *githubStub) RoundTrip(req *http.Request) (*http.Response, error) {
+	s.requests = append(s.requests, req.Method+" "+req.URL.Path)
+	body := s.body
+	if body == "" {
+		body = `{}`
+	}
+	return &http.Response{
+		StatusCode: s.status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// newTestWebhookService returns a webhook service whose project uses hook 42, with the
+// GitHub API stubbed to answer status
+func newTestWebhookService(t *testing.T, status int) (*WebhookService, *models.Project, *githubStub, context.Context) {
+	t.Helper()
+	d, project := newTestService(t)
+	hookID := int64(42)
+	if err := d.Stores.Projects.SetWebhook(project.ID, &hookID); err != nil {
+		t.Fatalf("failed to set webhook: %v", err)
+	}
+
+	stub := &githubStub{status: status}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: stub})
+	s := NewWebhookService(nil, d.Stores, &config.Config{}, d, NewGitHubService("id", "secret", ""))
+	return s, project, stub, ctx
+}
+
+// webhookID returns the webhook ID saved for a project, 0 if it has none
+func webhookID(t *testing.T, s *WebhookService, projectID int64) int64 {
+	t.Helper()
+	project, err := s.Stores.Projects.Get(projectID)
+	if err != nil {
+		t.Fatalf("failed to get project: %v", err)
+	}
+	if project.WebhookID == nil {
+		return 0
+	}
+	return *project.WebhookID
+}
+
+func TestRemoveProjectHookDeletesUnusedHook(t *testing.T) {
+	s, project, stub, ctx := newTestWebhookService(t, http.StatusNoContent)
+
+	if err := s.RemoveProjectHook(ctx, project.ID, "token"); err != nil {
+		t.Fatalf("RemoveProjectHook: %v", err)
+	}
+	if want := []string{"DELETE /repos/octo/app/hooks/42"}; len(stub.requests) != 1 || stub.requests[0] != want[0] {
+		t.Errorf("GitHub requests = %v, want %v", stub.requests, want)
+	}
+	if id := webhookID(t, s, project.ID); id != 0 {
+		t.Errorf("webhook ID = %d after the hook was deleted, want none", id)
+	}
+}
+
+func TestRemoveProjectHookKeepsIDWhenDeleteFails(t *testing.T) {
+	s, project, _, ctx := newTestWebhookService(t, http.StatusInternalServerError)
+
+	if err := s.RemoveProjectHook(ctx, project.ID, "token"); err == nil {
+		t.Fatal("RemoveProjectHook succeeded although GitHub failed")
+	}
+	if id := webhookID(t, s, project.ID); id != 42 {
+		t.Errorf("webhook ID = %d after a failed delete, want 42 so it can be retried", id)
+	}
+}
+
+func TestRemoveProjectHookKeepsSharedHook(t *testing.T) {
+	s, project, stub, ctx := newTestWebhookService(t, http.StatusNoContent)
+
+	sibling := &models.Project{UserID: project.UserID, Name: "app-staging", RepoURL: project.RepoURL, Branch: "staging", Subdomain: "app-staging", Port: 8081, GitHubRepoID: project.GitHubRepoID}
+	if err := s.Stores.Projects.Create(sibling); err != nil {
+		t.Fatalf("failed to create sibling: %v", err)
+	}
+	hookID := int64(42)
+	if err := s.Stores.Projects.SetWebhook(sibling.ID, &hookID); err != nil {
+		t.Fatalf("failed to set webhook: %v", err)
+	}
+
+	if err := s.RemoveProjectHook(ctx, project.ID, "token"); err != nil {
+		t.Fatalf("RemoveProjectHook: %v", err)
+	}
+	if len(stub.requests) != 0 {
+		t.Errorf("GitHub requests = %v, want none while the hook is shared", stub.requests)
+	}
+	if id := webhookID(t, s, project.ID); id != 0 {
+		t.Errorf("webhook ID of the project = %d, want none", id)
+	}
+	if id := webhookID(t, s, sibling.ID); id != 42 {
+		t.Errorf("webhook ID of the sibling = %d, want 42", id)
+	}
+}
+
+func TestProjectHooksWithoutRepositoryID(t *testing.T) {
+	s, project, stub, ctx := newTestWebhookService(t, http.StatusCreated)
+	s.Config.GitHubWebhookSecret = "secret"
+	stub.body = `{"id":99}`
+
+	// The store lists every project for a zero repository ID, the hook of another repository
+	// must not be adopted
+	other := &models.Project{UserID: project.UserID, Name: "other", RepoURL: "https://github.com/octo/other", Branch: "main", Subdomain: "other", Port: 8081}
+	if err := s.Stores.Projects.Create(other); err != nil {
+		t.Fatalf("failed to create project: %v", err)
+	}
+	if err := s.RegisterProjectHook(ctx, other.ID, "token"); err != nil {
+		t.Fatalf("RegisterProjectHook: %v", err)
+	}
+	if want := "POST /repos/octo/other/hooks"; len(stub.requests) != 1 || stub.requests[0] != want {
+		t.Errorf("GitHub requests = %v, want [%s]", stub.requests, want)
+	}
+	if id := webhookID(t, s, other.ID); id != 99 {
+		t.Errorf("webhook ID = %d, want the created hook 99", id)
+	}
+
+	// Nor is it kept alive by projects of other repositories
+	stub.status, stub.requests = http.StatusNoContent, nil
+	hookID := int64(42)
+	if err := s.Stores.Projects.SetWebhook(other.ID, &hookID); err != nil {
+		t.Fatalf("failed to set webhook: %v", err)
+	}
+	if err := s.RemoveProjectHook(ctx, other.ID, "token"); err != nil {
+		t.Fatalf("RemoveProjectHook: %v", err)
+	}
+	if want := "DELETE /repos/octo/other/hooks/42"; len(stub.requests) != 1 || stub.requests[0] != want {
+		t.Errorf("GitHub requests = %v, want [%s]", stub.requests, want)
+	}
+	if id := webhookID(t, s, project.ID); id != 42 {
+		t.Errorf("webhook ID of the other repository's project = %d, want 42", id)
+	}
+}
+
+// failingDeployments fails to queue deployments of one project
+type failingDeployments struct {
+	store.DeploymentStore
+	projectID int64
+}
+
+func (s failingDeployments) Enqueue(deployment *models.Deployment) error {
+	if deployment.ProjectID == s.projectID {
+		return errors.New("queue unavailable")
+	}
+	return s.DeploymentStore.Enqueue(deployment)
+}
+
+func TestHandlePushDeploysRemainingProjects(t *testing.T) {
+	d, project := newTestService(t)
+
+	var projects []*models.Project
+	for _, name := range []string{"app-a", "app-b"} {
+		other := &models.Project{UserID: project.UserID, Name: name, GitHubRepoID: project.GitHubRepoID, RepoURL: project.RepoURL, Branch: project.Branch, Subdomain: name, Port: 8080}
+		if err := d.Stores.Projects.Create(other); err != nil {
+			t.Fatalf("failed to create project: %v", err)
+		}
+		projects = append(projects, other)
+	}
+	d.Stores.Deployments = failingDeployments{DeploymentStore: d.Stores.Deployments, projectID: projects[0].ID}
+	s := NewWebhookService(nil, d.Stores, &config.Config{}, d, nil)
+
+	event := &github.PushEvent{
+		Ref:   github.String("refs/heads/main"),
+		After: github.String("0123456789abcdef0123456789abcdef01234567"),
+		Repo:  &github.PushEventRepository{ID: github.Int64(project.GitHubRepoID), CloneURL: github.String(project.RepoURL + ".git")},
+	}
+	deployments, err := s.HandlePush(event)
+	if err == nil {
+		t.Error("HandlePush succeeded although a project failed to deploy")
+	}
+
+	var deployed []int64
+	for _, deployment := range deployments {
+		deployed = append(deployed, deployment.ProjectID)
+	}
+	sort.Slice(deployed, func(i, j int) bool { return deployed[i] < deployed[j] })
+	if want := []int64{project.ID, projects[1].ID}; !reflect.DeepEqual(deployed, want) {
+		t.Errorf("deployed projects = %v, want %v", deployed, want)
+	}
+}
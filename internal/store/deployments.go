@@ -0,0 +1,416 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"goth-deploy/internal/database"
+	"goth-deploy/internal/models"
+)
+
+// DeploymentStore keeps deployments and the queue of deployment jobs that runs them
+type DeploymentStore interface {
+	// Get returns a deployment without its build log and environment snapshot, or ErrNotFound
+	Get(id int64) (*models.Deployment, error)
+	// List returns the deployments selected by the filter without their build logs, newest first
+	List(filter DeploymentFilter) ([]models.Deployment, error)
+	// Count returns how many deployments the filter selects, ignoring its limit and offset
+	Count(filter DeploymentFilter) (int, error)
+
+	// BuildLog returns the saved build log of a deployment, or ErrNotFound
+	BuildLog(id int64) (string, error)
+	// SetBuildLog saves the build log of a running deployment
+	SetBuildLog(id int64, buildLog string) error
+	// UpdateStatus saves the status, build log and error of a deployment and when it got there
+	UpdateStatus(id int64, status, buildLog, errorMsg string, at time.Time) error
+	// SetCommitSHA records the commit a deployment was built from
+	SetCommitSHA(id int64, commitSHA string) error
+	// EnvSnapshot returns the encrypted environment a deployment was started with, empty if it
+	// has none, or ErrNotFound
+	EnvSnapshot(id int64) (string, error)
+	// SetEnvSnapshot saves the encrypted environment a deployment was started with
+	SetEnvSnapshot(id int64, snapshot string) error
+	// Activate records the release a deployment was started as and switches its project to it
+	Activate(projectID, deploymentID int64, releaseDir string, port int) error
+
+	// Enqueue saves a new pending deployment with its queue job and sets its ID, status and
+	// timestamps. Queued jobs of the project that have not started yet are superseded.
+	Enqueue(deployment *models.Deployment) error
+	// ClaimJob marks the oldest queued job of a project that is not busy as running and
+	// returns it, or nil if there is none
+	ClaimJob(busy map[int64]bool) (*models.DeploymentJob, error)
+	// FinishJob marks a job done
+	FinishJob(id int64) error
+	// Recover repairs the queue after the platform was stopped mid-deploy, see
+	// services.DeploymentService.RecoverDeployments. It returns how many jobs were queued
+	// again and how many deployments were marked failed.
+	Recover(maxAttempts int) (requeued, failed int64, err error)
+}
+
+// DeploymentFilter selects deployments. The zero value selects all of them.
+type DeploymentFilter struct {
+	ProjectID int64     // only deployments of a project
+	Access    *Access   // only deployments of projects the user can access
+	Status    string    // only deployments with the status
+	Since     time.Time // only deployments created since
+	Released  bool      // only deployments that were built into a release directory
+	Limit     int       // at most this many deployments, all if 0
+	Offset    int
+}
+
+// deploymentColumns are the columns scanned by scanDeployment, on deployments aliased d
+const deploymentColumns = `d.id, d.project_id, d.commit_sha, d.status, d.error_msg, d.release_dir, d.port,
+	d.rollback_of, d.started_at, d.finished_at, d.created_at`
+
+// scanDeployment reads a deployment from a row of deploymentColumns
+func scanDeployment(row interface{ Scan(...interface{}) error }) (*models.Deployment, error) {
+	var deployment models.Deployment
+	var status, errorMsg, releaseDir sql.NullString
+	var port sql.NullInt64
+	err := row.Scan(
+		&deployment.ID,
+		&deployment.ProjectID,
+		&deployment.CommitSHA,
+		&status,
+		&errorMsg,
+		&releaseDir,
+		&port,
+		&deployment.RollbackOf,
+		&deployment.StartedAt,
+		&deployment.FinishedAt,
+		&deployment.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	deployment.Status = status.String
+	deployment.ErrorMsg = errorMsg.String
+	deployment.ReleaseDir = releaseDir.String
+	deployment.Port = int(port.Int64)
+	return &deployment, nil
+}
+
+// sqlDeployments is the DeploymentStore of a database
+type sqlDeployments struct {
+	db *database.DB
+}
+
+func (s *sqlDeployments) Get(id int64) (*models.Deployment, error) {
+	deployment, err := scanDeployment(s.db.QueryRow("SELECT "+deploymentColumns+" FROM deployments d WHERE d.id = ?", id))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return deployment, nil
+}
+
+// where builds the FROM and WHERE clauses of a filter
+func (f DeploymentFilter) where() (string, []interface{}) {
+	from := " FROM deployments d"
+	var conditions []string
+	var args []interface{}
+	if f.Access != nil {
+		from += " JOIN projects p ON p.id = d.project_id"
+		condition, accessArgs := f.Access.condition()
+		conditions = append(conditions, condition)
+		args = append(args, accessArgs...)
+	}
+	if f.ProjectID != 0 {
+		conditions = append(conditions, "d.project_id = ?")
+		args = append(args, f.ProjectID)
+	}
+	if f.Status != "" {
+		conditions = append(conditions, "d.status = ?")
+		args = append(args, f.Status)
+	}
+	if !f.Since.IsZero() {
+		conditions = append(conditions, "d.created_at >= ?")
+		args = append(args, f.Since)
+	}
+	if f.Released {
+		conditions = append(conditions, "d.release_dir IS NOT NULL")
+	}
+	if len(conditions) == 0 {
+		return from, nil
+	}
+	return from + " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+func (s *sqlDeployments) List(filter DeploymentFilter) ([]models.Deployment, error) {
+	where, args := filter.where()
+	query, args := page("SELECT "+deploymentColumns+where+" ORDER BY d.id DESC", args, filter.Limit, filter.Offset)
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deployments := []models.Deployment{}
+	for rows.Next() {
+		deployment, err := scanDeployment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan deployment: %w", err)
+		}
+		deployments = append(deployments, *deployment)
+	}
+	return deployments, rows.Err()
+}
+
+func (s *sqlDeployments) Count(filter DeploymentFilter) (int, error) {
+	where, args := filter.where()
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*)"+where, args...).Scan(&count)
+	return count, err
+}
+
+func (s *sqlDeployments) BuildLog(id int64) (string, error) {
+	var buildLog sql.NullString
+	if err := s.db.QueryRow("SELECT build_log FROM deployments WHERE id = ?", id).Scan(&buildLog); err != nil {
+		return "", notFound(err)
+	}
+	return buildLog.String, nil
+}
+
+func (s *sqlDeployments) SetBuildLog(id int64, buildLog string) error {
+	_, err := s.db.Exec("UPDATE deployments SET build_log = ? WHERE id = ?", buildLog, id)
+	return err
+}
+
+func (s *sqlDeployments) UpdateStatus(id int64, status, buildLog, errorMsg string, at time.Time) error {
+	_, err := s.db.Exec(`
+		UPDATE deployments
+		SET status = ?, build_log = ?, error_msg = ?, finished_at = ?
+		WHERE id = ?
+	`, status, buildLog, errorMsg, at, id)
+	return err
+}
+
+func (s *sqlDeployments) SetCommitSHA(id int64, commitSHA string) error {
+	_, err := s.db.Exec("UPDATE deployments SET commit_sha = ? WHERE id = ?", commitSHA, id)
+	return err
+}
+
+func (s *sqlDeployments) EnvSnapshot(id int64) (string, error) {
+	var snapshot sql.NullString
+	if err := s.db.QueryRow("SELECT env_snapshot FROM deployments WHERE id = ?", id).Scan(&snapshot); err != nil {
+		return "", notFound(err)
+	}
+	return snapshot.String, nil
+}
+
+func (s *sqlDeployments) SetEnvSnapshot(id int64, snapshot string) error {
+	_, err := s.db.Exec("UPDATE deployments SET env_snapshot = ? WHERE id = ?", snapshot, id)
+	return err
+}
+
+func (s *sqlDeployments) Activate(projectID, deploymentID int64, releaseDir string, port int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE deployments SET release_dir = ?, port = ? WHERE id = ?", releaseDir, port, deploymentID); err != nil {
+		return fmt.Errorf("failed to record release: %w", err)
+	}
+
+	// The proxy reads the port from the project, so this switches traffic
+	if _, err := tx.Exec("UPDATE projects SET port = ?, active_deployment_id = ? WHERE id = ?", port, deploymentID, projectID); err != nil {
+		return fmt.Errorf("failed to switch project to release: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit release: %w", err)
+	}
+	return nil
+}
+
+func (s *sqlDeployments) Enqueue(deployment *models.Deployment) error {
+	now := time.Now()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`
+		INSERT INTO deployments (project_id, commit_sha, status, rollback_of, started_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, deployment.ProjectID, deployment.CommitSHA, models.StatusPending, deployment.RollbackOf, now, now).Scan(&deployment.ID)
+	if err != nil {
+		return fmt.Errorf("failed to create deployment: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		UPDATE deployments
+		SET status = ?, error_msg = ?, finished_at = ?
+		WHERE id IN (
+			SELECT deployment_id FROM deployment_jobs
+			WHERE project_id = ? AND status = ?
+		)
+	`, models.StatusSuperseded, fmt.Sprintf("Superseded by deployment #%d", deployment.ID), now, deployment.ProjectID, models.JobStatusQueued)
+	if err != nil {
+		return fmt.Errorf("failed to supersede queued deployments: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		UPDATE deployment_jobs SET status = ?, finished_at = ?
+		WHERE project_id = ? AND status = ?
+	`, models.JobStatusSuperseded, now, deployment.ProjectID, models.JobStatusQueued)
+	if err != nil {
+		return fmt.Errorf("failed to supersede queued jobs: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO deployment_jobs (deployment_id, project_id, status, created_at)
+		VALUES (?, ?, ?, ?)
+	`, deployment.ID, deployment.ProjectID, models.JobStatusQueued, now)
+	if err != nil {
+		return fmt.Errorf("failed to queue deployment: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit deployment: %w", err)
+	}
+
+	deployment.Status = models.StatusPending
+	deployment.StartedAt, deployment.CreatedAt = now, now
+	return nil
+}
+
+func (s *sqlDeployments) ClaimJob(busy map[int64]bool) (*models.DeploymentJob, error) {
+	rows, err := s.db.Query(`
+		SELECT id, deployment_id, project_id, attempts, created_at FROM deployment_jobs
+		WHERE status = ?
+		ORDER BY id
+	`, models.JobStatusQueued)
+	if err != nil {
+		return nil, err
+	}
+
+	var job *models.DeploymentJob
+	for rows.Next() {
+		var candidate models.DeploymentJob
+		if err := rows.Scan(&candidate.ID, &candidate.DeploymentID, &candidate.ProjectID, &candidate.Attempts, &candidate.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if !busy[candidate.ProjectID] {
+			job = &candidate
+			break
+		}
+	}
+	rows.Close()
+	if job == nil {
+		return nil, rows.Err()
+	}
+
+	now := time.Now()
+	result, err := s.db.Exec(`
+		UPDATE deployment_jobs SET status = ?, started_at = ?, attempts = attempts + 1
+		WHERE id = ? AND status = ?
+	`, models.JobStatusRunning, now, job.ID, models.JobStatusQueued)
+	if err != nil {
+		return nil, err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		// Superseded between the select and the update
+		return nil, nil
+	}
+
+	job.Status = models.JobStatusRunning
+	job.Attempts++
+	job.StartedAt = &now
+	return job, nil
+}
+
+func (s *sqlDeployments) FinishJob(id int64) error {
+	_, err := s.db.Exec("UPDATE deployment_jobs SET status = ?, finished_at = ? WHERE id = ?", models.JobStatusDone, time.Now(), id)
+	return err
+}
+
+func (s *sqlDeployments) Recover(maxAttempts int) (requeued, failed int64, err error) {
+	now := time.Now()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Give up on jobs that have already been interrupted too many times
+	_, err = tx.Exec(`
+		UPDATE deployment_jobs SET status = ?, finished_at = ?
+		WHERE status = ? AND attempts >= ?
+	`, models.JobStatusDone, now, models.JobStatusRunning, maxAttempts)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to abandon jobs: %w", err)
+	}
+
+	// Put interrupted jobs back in the queue
+	result, err := tx.Exec("UPDATE deployment_jobs SET status = ? WHERE status = ?", models.JobStatusQueued, models.JobStatusRunning)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to requeue jobs: %w", err)
+	}
+	requeued, _ = result.RowsAffected()
+
+	// Only the newest queued job of each project survives
+	newestQueued := `
+		SELECT MAX(id) FROM deployment_jobs WHERE status = ? GROUP BY project_id
+	`
+	_, err = tx.Exec(`
+		UPDATE deployments SET status = ?, error_msg = ?, finished_at = ?
+		WHERE id IN (
+			SELECT deployment_id FROM deployment_jobs
+			WHERE status = ? AND id NOT IN (`+newestQueued+`)
+		)
+	`, models.StatusSuperseded, "Superseded by a newer deployment", now, models.JobStatusQueued, models.JobStatusQueued)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to supersede deployments: %w", err)
+	}
+	_, err = tx.Exec(`
+		UPDATE deployment_jobs SET status = ?, finished_at = ?
+		WHERE status = ? AND id NOT IN (`+newestQueued+`)
+	`, models.JobStatusSuperseded, now, models.JobStatusQueued, models.JobStatusQueued)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to supersede jobs: %w", err)
+	}
+
+	// Requeued deployments start over from scratch
+	_, err = tx.Exec(`
+		UPDATE deployments SET status = ?, build_log = NULL, error_msg = NULL, finished_at = NULL
+		WHERE id IN (SELECT deployment_id FROM deployment_jobs WHERE status = ?)
+	`, models.StatusPending, models.JobStatusQueued)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to reset queued deployments: %w", err)
+	}
+
+	// Anything else still in flight can never finish
+	result, err = tx.Exec(`
+		UPDATE deployments SET status = ?, error_msg = ?, finished_at = ?
+		WHERE status IN (?, ?)
+		AND id NOT IN (SELECT deployment_id FROM deployment_jobs WHERE status = ?)
+	`, models.StatusFailed, "Interrupted by platform restart", now,
+		models.StatusPending, models.StatusBuilding, models.JobStatusQueued)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to fail interrupted deployments: %w", err)
+	}
+	failed, _ = result.RowsAffected()
+
+	_, err = tx.Exec(`
+		UPDATE projects SET status = ?
+		WHERE status = ?
+		AND id NOT IN (SELECT project_id FROM deployment_jobs WHERE status = ?)
+	`, models.ProjectStatusFailed, models.ProjectStatusBuilding, models.JobStatusQueued)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to reset building projects: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("failed to commit recovery: %w", err)
+	}
+	return requeued, failed, nil
+}
@@ -0,0 +1,168 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"goth-deploy/internal/database"
+	"goth-deploy/internal/models"
+)
+
+// EnvStore keeps the environment variables of projects. Values are stored as given, the
+// services encrypt them.
+type EnvStore interface {
+	// List returns the variables of a project ordered by name
+	List(projectID int64) ([]models.EnvironmentVariable, error)
+	// Get returns a variable of a project, or ErrNotFound
+	Get(projectID, id int64) (*models.EnvironmentVariable, error)
+	// FindKey returns the variable of a project other than exceptID that is named key,
+	// ignoring case, or ErrNotFound
+	FindKey(projectID int64, key string, exceptID int64) (*models.EnvironmentVariable, error)
+
+	// Create saves a new variable and sets its ID and timestamps
+	Create(envVar *models.EnvironmentVariable) error
+	// Update saves the name, value and scope of a variable, or returns ErrNotFound
+	Update(envVar *models.EnvironmentVariable) error
+	// Delete removes a variable of a project, or returns ErrNotFound
+	Delete(projectID, id int64) error
+	// Apply makes a batch of changes to the variables of a project in one transaction
+	Apply(projectID int64, batch EnvBatch) error
+}
+
+// EnvBatch is a set of changes to the variables of a project
+type EnvBatch struct {
+	Create []models.EnvironmentVariable
+	Update []models.EnvironmentVariable
+	Delete []models.EnvironmentVariable // identified by ID
+}
+
+// envColumns are the columns scanned by scanEnv
+const envColumns = "id, project_id, key, value, scope, created_at, updated_at"
+
+// scanEnv reads an environment variable from a row of envColumns
+func scanEnv(row interface{ Scan(...interface{}) error }) (*models.EnvironmentVariable, error) {
+	var envVar models.EnvironmentVariable
+	err := row.Scan(&envVar.ID, &envVar.ProjectID, &envVar.Key, &envVar.Value, &envVar.Scope, &envVar.CreatedAt, &envVar.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &envVar, nil
+}
+
+// sqlEnv is the EnvStore of a database
+type sqlEnv struct {
+	db *database.DB
+}
+
+func (s *sqlEnv) List(projectID int64) ([]models.EnvironmentVariable, error) {
+	rows, err := s.db.Query("SELECT "+envColumns+" FROM environment_variables WHERE project_id = ? ORDER BY key", projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var envVars []models.EnvironmentVariable
+	for rows.Next() {
+		envVar, err := scanEnv(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan environment variable: %w", err)
+		}
+		envVars = append(envVars, *envVar)
+	}
+	return envVars, rows.Err()
+}
+
+func (s *sqlEnv) Get(projectID, id int64) (*models.EnvironmentVariable, error) {
+	envVar, err := scanEnv(s.db.QueryRow("SELECT "+envColumns+" FROM environment_variables WHERE id = ? AND project_id = ?", id, projectID))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return envVar, nil
+}
+
+func (s *sqlEnv) FindKey(projectID int64, key string, exceptID int64) (*models.EnvironmentVariable, error) {
+	envVar, err := scanEnv(s.db.QueryRow(`
+		SELECT `+envColumns+` FROM environment_variables
+		WHERE project_id = ? AND key = ? COLLATE NOCASE AND id != ?
+	`, projectID, key, exceptID))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return envVar, nil
+}
+
+func (s *sqlEnv) Create(envVar *models.EnvironmentVariable) error {
+	now := time.Now()
+	err := s.db.QueryRow(`
+		INSERT INTO environment_variables (project_id, key, value, scope, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, envVar.ProjectID, envVar.Key, envVar.Value, envVar.Scope, now, now).Scan(&envVar.ID)
+	if err != nil {
+		return err
+	}
+	envVar.CreatedAt, envVar.UpdatedAt = now, now
+	return nil
+}
+
+func (s *sqlEnv) Update(envVar *models.EnvironmentVariable) error {
+	now := time.Now()
+	result, err := s.db.Exec(`
+		UPDATE environment_variables SET key = ?, value = ?, scope = ?, updated_at = ?
+		WHERE id = ? AND project_id = ?
+	`, envVar.Key, envVar.Value, envVar.Scope, now, envVar.ID, envVar.ProjectID)
+	if err != nil {
+		return err
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return ErrNotFound
+	}
+	envVar.UpdatedAt = now
+	return nil
+}
+
+func (s *sqlEnv) Delete(projectID, id int64) error {
+	result, err := s.db.Exec("DELETE FROM environment_variables WHERE id = ? AND project_id = ?", id, projectID)
+	if err != nil {
+		return err
+	}
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqlEnv) Apply(projectID int64, batch EnvBatch) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	for _, envVar := range batch.Create {
+		_, err := tx.Exec(`
+			INSERT INTO environment_variables (project_id, key, value, scope, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, projectID, envVar.Key, envVar.Value, envVar.Scope, now, now)
+		if err != nil {
+			return fmt.Errorf("failed to save environment variable %s: %w", envVar.Key, err)
+		}
+	}
+	for _, envVar := range batch.Update {
+		_, err := tx.Exec(`
+			UPDATE environment_variables SET key = ?, value = ?, scope = ?, updated_at = ?
+			WHERE id = ? AND project_id = ?
+		`, envVar.Key, envVar.Value, envVar.Scope, now, envVar.ID, projectID)
+		if err != nil {
+			return fmt.Errorf("failed to save environment variable %s: %w", envVar.Key, err)
+		}
+	}
+	for _, envVar := range batch.Delete {
+		if _, err := tx.Exec("DELETE FROM environment_variables WHERE id = ? AND project_id = ?", envVar.ID, projectID); err != nil {
+			return fmt.Errorf("failed to delete environment variable %s: %w", envVar.Key, err)
+		}
+	}
+
+	return tx.Commit()
+}
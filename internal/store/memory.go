@@ -0,0 +1,779 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"goth-deploy/internal/models"
+)
+
+// memory holds the records of the in-memory stores. All stores created by one NewMemory
+// share it, so that like the database, deleting a project removes its deployments.
+type memory struct {
+	mutex       sync.Mutex
+	lastID      int64
+	projects    map[int64]*models.Project
+	deployments map[int64]*memoryDeployment
+	jobs        []*models.DeploymentJob // in ID order
+	envVars     map[int64]*models.EnvironmentVariable
+	users       map[int64]*models.User
+}
+
+// memoryDeployment is a deployment with the columns models.Deployment does not carry as stored
+type memoryDeployment struct {
+	models.Deployment
+	envSnapshot string
+}
+
+// NewMemory creates stores that keep everything in memory, for tests
+func NewMemory() *Stores {
+	m := &memory{
+		projects:    make(map[int64]*models.Project),
+		deployments: make(map[int64]*memoryDeployment),
+		envVars:     make(map[int64]*models.EnvironmentVariable),
+		users:       make(map[int64]*models.User),
+	}
+	return &Stores{
+		Projects:    memoryProjects{m},
+		Deployments: memoryDeployments{m},
+		Env:         memoryEnv{m},
+		Users:       memoryUsers{m},
+	}
+}
+
+// nextID returns a new record ID, IDs are unique across all records
+func (m *memory) nextID() int64 {
+	m.lastID++
+	return m.lastID
+}
+
+// pageOf returns the indexes [start, end) of a page of n records
+func pageOf(n, limit, offset int) (int, int) {
+	if offset > n {
+		offset = n
+	}
+	if limit <= 0 || offset+limit > n {
+		return offset, n
+	}
+	return offset, offset + limit
+}
+
+// memoryProjects is the in-memory ProjectStore
+type memoryProjects struct {
+	m *memory
+}
+
+func (s memoryProjects) Get(id int64) (*models.Project, error) {
+	s.m.mutex.Lock()
+	defer s.m.mutex.Unlock()
+
+	project, ok := s.m.projects[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *project
+	return &copied, nil
+}
+
+func (s memoryProjects) GetBySubdomain(subdomain string) (*models.Project, error) {
+	s.m.mutex.Lock()
+	defer s.m.mutex.Unlock()
+
+	for _, project := range s.m.projects {
+		if project.Subdomain == subdomain {
+			copied := *project
+			return &copied, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// matches reports whether the filter selects a project
+func (f ProjectFilter) matches(project *models.Project) bool {
+	if f.Access != nil && !f.Access.allows(project.UserID, project.OrganizationID) {
+		return false
+	}
+	if f.OrganizationID != nil && (project.OrganizationID == nil || *project.OrganizationID != *f.OrganizationID) {
+		return false
+	}
+	if f.GitHubRepoID != 0 && project.GitHubRepoID != f.GitHubRepoID {
+		return false
+	}
+	if f.Branch != "" && project.Branch != f.Branch {
+		return false
+	}
+	if f.Port != 0 && project.Port != f.Port {
+		return false
+	}
+	if len(f.Statuses) > 0 {
+		for _, status := range f.Statuses {
+			if project.Status == status {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// filter returns the projects selected by a filter, newest first
+func (s memoryProjects) filter(filter ProjectFilter) []models.Project {
+	projects := []models.Project{}
+	for _, project := range s.m.projects {
+		if filter.matches(project) {
+			projects = append(projects, *project)
+		}
+	}
+	sort.Slice(projects, func(i, j int) bool {
+		if !projects[i].CreatedAt.Equal(projects[j].CreatedAt) {
+			return projects[i].CreatedAt.After(projects[j].CreatedAt)
+		}
+		return projects[i].ID > projects[j].ID
+	})
+	return projects
+}
+
+func (s memoryProjects) List(filter ProjectFilter) ([]models.Project, error) {
+	s.m.mutex.Lock()
+	defer s.m.mutex.Unlock()
+
+	projects := s.filter(filter)
+	start, end := pageOf(len(projects), filter.Limit, filter.Offset)
+	return projects[start:end], nil
+}
+
+func (s memoryProjects) Count(filter ProjectFilter) (int, error) {
+	s.m.mutex.Lock()
+	defer s.m.mutex.Unlock()
+
+	return len(s.filter(filter)), nil
+}
+
+func (s memoryProjects) Create(project *models.Project) error {
+	s.m.mutex.Lock()
+	defer s.m.mutex.Unlock()
+
+	for _, existing := range s.m.projects {
+		if existing.Subdomain == project.Subdomain {
+			return fmt.Errorf("subdomain %s is already taken", project.Subdomain)
+		}
+	}
+
+	now := time.Now()
+	project.ID = s.m.nextID()
+	project.Status = models.ProjectStatusInactive
+	project.CreatedAt, project.UpdatedAt = now, now
+	// The defaults of the database schema
+	if project.HealthCheck == (models.HealthCheck{}) {
+		project.HealthCheck = models.HealthCheck{ExpectedStatus: 200, Interval: 30, Timeout: 5, FailureThreshold: 3}
+	}
+	if project.RestartPolicy == (models.RestartPolicy{}) {
+		project.RestartPolicy = models.RestartPolicy{Policy: models.RestartPolicyOnFailure, MaxRestarts: 5, Window: 300}
+	}
+	if project.LogRetention == (models.LogRetention{}) {
+		project.LogRetention = models.LogRetention{MaxSize: 10, MaxFiles: 5, MaxAge: 7}
+	}
+
+	copied := *project
+	s.m.projects[project.ID] = &copied
+	return nil
+}
+
+func (s memoryProjects) Delete(id int64) error {
+	s.m.mutex.Lock()
+	defer s.m.mutex.Unlock()
+
+	delete(s.m.projects, id)
+	for deploymentID, deployment := range s.m.deployments {
+		if deployment.ProjectID == id {
+			delete(s.m.deployments, deploymentID)
+		}
+	}
+	jobs := s.m.jobs[:0]
+	for _, job := range s.m.jobs {
+		if job.ProjectID != id {
+			jobs = append(jobs, job)
+		}
+	}
+	s.m.jobs = jobs
+	for envVarID, envVar := range s.m.envVars {
+		if envVar.ProjectID == id {
+			delete(s.m.envVars, envVarID)
+		}
+	}
+	return nil
+}
+
+// update changes a project if it exists
+func (s memoryProjects) update(id int64, change func(project *models.Project)) {
+	s.m.mutex.Lock()
+	defer s.m.mutex.Unlock()
+
+	if project, ok := s.m.projects[id]; ok {
+		change(project)
+	}
+}
+
+func (s memoryProjects) Transfer(id int64, orgID *int64, userID int64) error {
+	s.update(id, func(project *models.Project) {
+		if orgID != nil {
+			id := *orgID
+			project.OrganizationID = &id
+		} else {
+			project.OrganizationID = nil
+			project.UserID = userID
+		}
+		project.UpdatedAt = time.Now()
+	})
+	return nil
+}
+
+func (s memoryProjects) SetStatus(id int64, status string) error {
+	s.update(id, func(project *models.Project) { project.Status = status })
+	return nil
+}
+
+func (s memoryProjects) SetStatusIf(id int64, from, to string) (bool, error) {
+	changed := false
+	s.update(id, func(project *models.Project) {
+		if project.Status == from {
+			project.Status = to
+			changed = true
+		}
+	})
+	return changed, nil
+}
+
+func (s memoryProjects) SetLastDeploy(id int64, at time.Time) error {
+	s.update(id, func(project *models.Project) { project.LastDeploy = &at })
+	return nil
+}
+
+func (s memoryProjects) UpdateHealthCheck(id int64, hc models.HealthCheck) error {
+	s.update(id, func(project *models.Project) {
+		project.HealthCheck = hc
+		project.UpdatedAt = time.Now()
+	})
+	return nil
+}
+
+func (s memoryProjects) UpdateRestartPolicy(id int64, policy models.RestartPolicy) error {
+	s.update(id, func(project *models.Project) {
+		project.RestartPolicy = policy
+		project.UpdatedAt = time.Now()
+	})
+	return nil
+}
+
+func (s memoryProjects) UpdateLogRetention(id int64, retention models.LogRetention) error {
+	s.update(id, func(project *models.Project) {
+		project.LogRetention = retention
+		project.UpdatedAt = time.Now()
+	})
+	return nil
+}
+
+func (s memoryProjects) SetWebhook(id int64, hookID *int64) error {
+	s.update(id, func(project *models.Project) {
+		if hookID != nil {
+			id := *hookID
+			hookID = &id
+		}
+		project.WebhookID = hookID
+	})
+	return nil
+}
+
+func (s memoryProjects) ReplaceWebhook(githubRepoID, oldID, newID int64) error {
+	s.m.mutex.Lock()
+	defer s.m.mutex.Unlock()
+
+	for _, project := range s.m.projects {
+		if project.GitHubRepoID == githubRepoID && project.WebhookID != nil && *project.WebhookID == oldID {
+			id := newID
+			project.WebhookID = &id
+		}
+	}
+	return nil
+}
+
+// memoryDeployments is the in-memory DeploymentStore
+type memoryDeployments struct {
+	m *memory
+}
+
+// get returns the deployment as Get does
+func (d *memoryDeployment) get() *models.Deployment {
+	copied := d.Deployment
+	copied.BuildLog = ""
+	copied.EnvSnapshot = nil
+	return &copied
+}
+
+func (s memoryDeployments) Get(id int64) (*models.Deployment, error) {
+	s.m.mutex.Lock()
+	defer s.m.mutex.Unlock()
+
+	deployment, ok := s.m.deployments[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return deployment.get(), nil
+}
+
+// filter returns the deployments selected by a filter, newest first
+func (s memoryDeployments) filter(filter DeploymentFilter) []models.Deployment {
+	deployments := []models.Deployment{}
+	for _, deployment := range s.m.deployments {
+		if filter.ProjectID != 0 && deployment.ProjectID != filter.ProjectID {
+			continue
+		}
+		if filter.Access != nil {
+			project, ok := s.m.projects[deployment.ProjectID]
+			if !ok || !filter.Access.allows(project.UserID, project.OrganizationID) {
+				continue
+			}
+		}
+		if filter.Status != "" && deployment.Status != filter.Status {
+			continue
+		}
+		if !filter.Since.IsZero() && deployment.CreatedAt.Before(filter.Since) {
+			continue
+		}
+		if filter.Released && deployment.ReleaseDir == "" {
+			continue
+		}
+		deployments = append(deployments, *deployment.get())
+	}
+	sort.Slice(deployments, func(i, j int) bool { return deployments[i].ID > deployments[j].ID })
+	return deployments
+}
+
+func (s memoryDeployments) List(filter DeploymentFilter) ([]models.Deployment, error) {
+	s.m.mutex.Lock()
+	defer s.m.mutex.Unlock()
+
+	deployments := s.filter(filter)
+	start, end := pageOf(len(deployments), filter.Limit, filter.Offset)
+	return deployments[start:end], nil
+}
+
+func (s memoryDeployments) Count(filter DeploymentFilter) (int, error) {
+	s.m.mutex.Lock()
+	defer s.m.mutex.Unlock()
+
+	return len(s.filter(filter)), nil
+}
+
+func (s memoryDeployments) BuildLog(id int64) (string, error) {
+	s.m.mutex.Lock()
+	defer s.m.mutex.Unlock()
+
+	deployment, ok := s.m.deployments[id]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return deployment.BuildLog, nil
+}
+
+// update changes a deployment if it exists
+func (s memoryDeployments) update(id int64, change func(deployment *memoryDeployment)) {
+	s.m.mutex.Lock()
+	defer s.m.mutex.Unlock()
+
+	if deployment, ok := s.m.deployments[id]; ok {
+		change(deployment)
+	}
+}
+
+func (s memoryDeployments) SetBuildLog(id int64, buildLog string) error {
+	s.update(id, func(deployment *memoryDeployment) { deployment.BuildLog = buildLog })
+	return nil
+}
+
+func (s memoryDeployments) UpdateStatus(id int64, status, buildLog, errorMsg string, at time.Time) error {
+	s.update(id, func(deployment *memoryDeployment) {
+		deployment.Status = status
+		deployment.BuildLog = buildLog
+		deployment.ErrorMsg = errorMsg
+		deployment.FinishedAt = &at
+	})
+	return nil
+}
+
+func (s memoryDeployments) SetCommitSHA(id int64, commitSHA string) error {
+	s.update(id, func(deployment *memoryDeployment) { deployment.CommitSHA = commitSHA })
+	return nil
+}
+
+func (s memoryDeployments) EnvSnapshot(id int64) (string, error) {
+	s.m.mutex.Lock()
+	defer s.m.mutex.Unlock()
+
+	deployment, ok := s.m.deployments[id]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return deployment.envSnapshot, nil
+}
+
+func (s memoryDeployments) SetEnvSnapshot(id int64, snapshot string) error {
+	s.update(id, func(deployment *memoryDeployment) { deployment.envSnapshot = snapshot })
+	return nil
+}
+
+func (s memoryDeployments) Activate(projectID, deploymentID int64, releaseDir string, port int) error {
+	s.m.mutex.Lock()
+	defer s.m.mutex.Unlock()
+
+	if deployment, ok := s.m.deployments[deploymentID]; ok {
+		deployment.ReleaseDir = releaseDir
+		deployment.Port = port
+	}
+	if project, ok := s.m.projects[projectID]; ok {
+		id := deploymentID
+		project.Port = port
+		project.ActiveDeploymentID = &id
+	}
+	return nil
+}
+
+func (s memoryDeployments) Enqueue(deployment *models.Deployment) error {
+	s.m.mutex.Lock()
+	defer s.m.mutex.Unlock()
+
+	now := time.Now()
+	deployment.ID = s.m.nextID()
+	deployment.Status = models.StatusPending
+	deployment.StartedAt, deployment.CreatedAt = now, now
+
+	for _, job := range s.m.jobs {
+		if job.ProjectID == deployment.ProjectID && job.Status == models.JobStatusQueued {
+			finishJob(job, models.JobStatusSuperseded, now)
+			s.m.finishDeployment(job.DeploymentID, models.StatusSuperseded, fmt.Sprintf("Superseded by deployment #%d", deployment.ID), now)
+		}
+	}
+
+	stored := &memoryDeployment{Deployment: *deployment}
+	if deployment.RollbackOf != nil {
+		id := *deployment.RollbackOf
+		stored.RollbackOf = &id
+	}
+	s.m.deployments[deployment.ID] = stored
+	s.m.jobs = append(s.m.jobs, &models.DeploymentJob{
+		ID:           s.m.nextID(),
+		DeploymentID: deployment.ID,
+		ProjectID:    deployment.ProjectID,
+		Status:       models.JobStatusQueued,
+		CreatedAt:    now,
+	})
+	return nil
+}
+
+// finishJob ends a job with a status
+func finishJob(job *models.DeploymentJob, status string, at time.Time) {
+	job.Status = status
+	job.FinishedAt = &at
+}
+
+// finishDeployment ends a deployment with a status and error
+func (m *memory) finishDeployment(id int64, status, errorMsg string, at time.Time) int64 {
+	deployment, ok := m.deployments[id]
+	if !ok {
+		return 0
+	}
+	deployment.Status = status
+	deployment.ErrorMsg = errorMsg
+	deployment.FinishedAt = &at
+	return 1
+}
+
+func (s memoryDeployments) ClaimJob(busy map[int64]bool) (*models.DeploymentJob, error) {
+	s.m.mutex.Lock()
+	defer s.m.mutex.Unlock()
+
+	for _, job := range s.m.jobs {
+		if job.Status != models.JobStatusQueued || busy[job.ProjectID] {
+			continue
+		}
+		now := time.Now()
+		job.Status = models.JobStatusRunning
+		job.StartedAt = &now
+		job.Attempts++
+		copied := *job
+		return &copied, nil
+	}
+	return nil, nil
+}
+
+func (s memoryDeployments) FinishJob(id int64) error {
+	s.m.mutex.Lock()
+	defer s.m.mutex.Unlock()
+
+	for _, job := range s.m.jobs {
+		if job.ID == id {
+			finishJob(job, models.JobStatusDone, time.Now())
+		}
+	}
+	return nil
+}
+
+func (s memoryDeployments) Recover(maxAttempts int) (requeued, failed int64, err error) {
+	s.m.mutex.Lock()
+	defer s.m.mutex.Unlock()
+
+	now := time.Now()
+
+	// Give up on jobs that have already been interrupted too many times, queue the others again
+	for _, job := range s.m.jobs {
+		if job.Status != models.JobStatusRunning {
+			continue
+		}
+		if job.Attempts >= maxAttempts {
+			finishJob(job, models.JobStatusDone, now)
+		} else {
+			job.Status = models.JobStatusQueued
+			requeued++
+		}
+	}
+
+	// Only the newest queued job of each project survives
+	newest := map[int64]*models.DeploymentJob{}
+	for _, job := range s.m.jobs {
+		if job.Status == models.JobStatusQueued {
+			newest[job.ProjectID] = job
+		}
+	}
+	queued := map[int64]bool{}         // deployment IDs
+	queuedProjects := map[int64]bool{} // project IDs
+	for _, job := range s.m.jobs {
+		if job.Status != models.JobStatusQueued {
+			continue
+		}
+		if newest[job.ProjectID] != job {
+			s.m.finishDeployment(job.DeploymentID, models.StatusSuperseded, "Superseded by a newer deployment", now)
+			finishJob(job, models.JobStatusSuperseded, now)
+			continue
+		}
+		queued[job.DeploymentID] = true
+		queuedProjects[job.ProjectID] = true
+	}
+
+	for id, deployment := range s.m.deployments {
+		switch {
+		case queued[id]:
+			// Requeued deployments start over from scratch
+			deployment.Status = models.StatusPending
+			deployment.BuildLog = ""
+			deployment.ErrorMsg = ""
+			deployment.FinishedAt = nil
+		case deployment.Status == models.StatusPending || deployment.Status == models.StatusBuilding:
+			// Anything else still in flight can never finish
+			failed += s.m.finishDeployment(id, models.StatusFailed, "Interrupted by platform restart", now)
+		}
+	}
+
+	for id, project := range s.m.projects {
+		if project.Status == models.ProjectStatusBuilding && !queuedProjects[id] {
+			project.Status = models.ProjectStatusFailed
+		}
+	}
+
+	return requeued, failed, nil
+}
+
+// memoryEnv is the in-memory EnvStore
+type memoryEnv struct {
+	m *memory
+}
+
+func (s memoryEnv) List(projectID int64) ([]models.EnvironmentVariable, error) {
+	s.m.mutex.Lock()
+	defer s.m.mutex.Unlock()
+
+	var envVars []models.EnvironmentVariable
+	for _, envVar := range s.m.envVars {
+		if envVar.ProjectID == projectID {
+			envVars = append(envVars, *envVar)
+		}
+	}
+	sort.Slice(envVars, func(i, j int) bool { return envVars[i].Key < envVars[j].Key })
+	return envVars, nil
+}
+
+func (s memoryEnv) Get(projectID, id int64) (*models.EnvironmentVariable, error) {
+	s.m.mutex.Lock()
+	defer s.m.mutex.Unlock()
+
+	envVar, ok := s.m.envVars[id]
+	if !ok || envVar.ProjectID != projectID {
+		return nil, ErrNotFound
+	}
+	copied := *envVar
+	return &copied, nil
+}
+
+func (s memoryEnv) FindKey(projectID int64, key string, exceptID int64) (*models.EnvironmentVariable, error) {
+	s.m.mutex.Lock()
+	defer s.m.mutex.Unlock()
+
+	for _, envVar := range s.m.envVars {
+		if envVar.ProjectID == projectID && envVar.ID != exceptID && strings.EqualFold(envVar.Key, key) {
+			copied := *envVar
+			return &copied, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// createEnv saves a new variable, names are unique per project like in the database
+func (m *memory) createEnv(envVar *models.EnvironmentVariable, at time.Time) error {
+	for _, existing := range m.envVars {
+		if existing.ProjectID == envVar.ProjectID && existing.Key == envVar.Key {
+			return fmt.Errorf("environment variable %s already exists", envVar.Key)
+		}
+	}
+	envVar.ID = m.nextID()
+	envVar.CreatedAt, envVar.UpdatedAt = at, at
+	copied := *envVar
+	m.envVars[envVar.ID] = &copied
+	return nil
+}
+
+// updateEnv saves the name, value and scope of a variable of a project
+func (m *memory) updateEnv(projectID int64, envVar *models.EnvironmentVariable, at time.Time) bool {
+	existing, ok := m.envVars[envVar.ID]
+	if !ok || existing.ProjectID != projectID {
+		return false
+	}
+	existing.Key, existing.Value, existing.Scope = envVar.Key, envVar.Value, envVar.Scope
+	existing.UpdatedAt = at
+	envVar.UpdatedAt = at
+	return true
+}
+
+func (s memoryEnv) Create(envVar *models.EnvironmentVariable) error {
+	s.m.mutex.Lock()
+	defer s.m.mutex.Unlock()
+
+	return s.m.createEnv(envVar, time.Now())
+}
+
+func (s memoryEnv) Update(envVar *models.EnvironmentVariable) error {
+	s.m.mutex.Lock()
+	defer s.m.mutex.Unlock()
+
+	if !s.m.updateEnv(envVar.ProjectID, envVar, time.Now()) {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s memoryEnv) Delete(projectID, id int64) error {
+	s.m.mutex.Lock()
+	defer s.m.mutex.Unlock()
+
+	envVar, ok := s.m.envVars[id]
+	if !ok || envVar.ProjectID != projectID {
+		return ErrNotFound
+	}
+	delete(s.m.envVars, id)
+	return nil
+}
+
+func (s memoryEnv) Apply(projectID int64, batch EnvBatch) error {
+	s.m.mutex.Lock()
+	defer s.m.mutex.Unlock()
+
+	// Work on a copy so that a failing batch changes nothing
+	saved := make(map[int64]*models.EnvironmentVariable, len(s.m.envVars))
+	for id, envVar := range s.m.envVars {
+		copied := *envVar
+		saved[id] = &copied
+	}
+
+	now := time.Now()
+	for _, envVar := range batch.Create {
+		envVar.ProjectID = projectID
+		if err := s.m.createEnv(&envVar, now); err != nil {
+			s.m.envVars = saved
+			return fmt.Errorf("failed to save environment variable %s: %w", envVar.Key, err)
+		}
+	}
+	for _, envVar := range batch.Update {
+		s.m.updateEnv(projectID, &envVar, now)
+	}
+	for _, envVar := range batch.Delete {
+		if existing, ok := s.m.envVars[envVar.ID]; ok && existing.ProjectID == projectID {
+			delete(s.m.envVars, envVar.ID)
+		}
+	}
+	return nil
+}
+
+// memoryUsers is the in-memory UserStore
+type memoryUsers struct {
+	m *memory
+}
+
+// find returns a copy of the first user matching, or ErrNotFound
+func (s memoryUsers) find(match func(user *models.User) bool) (*models.User, error) {
+	s.m.mutex.Lock()
+	defer s.m.mutex.Unlock()
+
+	for _, user := range s.m.users {
+		if match(user) {
+			copied := *user
+			return &copied, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s memoryUsers) Get(id int64) (*models.User, error) {
+	return s.find(func(user *models.User) bool { return user.ID == id })
+}
+
+func (s memoryUsers) GetByGitHubID(githubID int64) (*models.User, error) {
+	return s.find(func(user *models.User) bool { return user.GitHubID == githubID })
+}
+
+func (s memoryUsers) GetByUsername(username string) (*models.User, error) {
+	return s.find(func(user *models.User) bool { return strings.EqualFold(user.Username, username) })
+}
+
+func (s memoryUsers) Create(user *models.User) error {
+	s.m.mutex.Lock()
+	defer s.m.mutex.Unlock()
+
+	for _, existing := range s.m.users {
+		if existing.GitHubID == user.GitHubID {
+			return fmt.Errorf("user with GitHub ID %d already exists", user.GitHubID)
+		}
+	}
+
+	now := time.Now()
+	user.ID = s.m.nextID()
+	user.CreatedAt, user.UpdatedAt = now, now
+	copied := *user
+	s.m.users[user.ID] = &copied
+	return nil
+}
+
+func (s memoryUsers) Update(user *models.User) error {
+	s.m.mutex.Lock()
+	defer s.m.mutex.Unlock()
+
+	user.UpdatedAt = time.Now()
+	if existing, ok := s.m.users[user.ID]; ok {
+		existing.Username = user.Username
+		existing.Email = user.Email
+		existing.AvatarURL = user.AvatarURL
+		existing.AccessToken = user.AccessToken
+		existing.UpdatedAt = user.UpdatedAt
+	}
+	return nil
+}
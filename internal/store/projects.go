@@ -0,0 +1,289 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"goth-deploy/internal/database"
+	"goth-deploy/internal/models"
+)
+
+// ProjectStore keeps projects and their settings
+type ProjectStore interface {
+	// Get returns a project, or ErrNotFound
+	Get(id int64) (*models.Project, error)
+	// GetBySubdomain returns the project served on a subdomain, or ErrNotFound
+	GetBySubdomain(subdomain string) (*models.Project, error)
+	// List returns the projects selected by the filter, newest first
+	List(filter ProjectFilter) ([]models.Project, error)
+	// Count returns how many projects the filter selects, ignoring its limit and offset
+	Count(filter ProjectFilter) (int, error)
+
+	// Create saves a new inactive project and sets its ID, status and timestamps
+	Create(project *models.Project) error
+	// Delete removes a project
+	Delete(id int64) error
+	// Transfer moves a project into an organization, or makes it a personal project of
+	// userID if orgID is nil
+	Transfer(id int64, orgID *int64, userID int64) error
+
+	// SetStatus changes the status of a project
+	SetStatus(id int64, status string) error
+	// SetStatusIf changes the status of a project only if it currently is from, and reports
+	// whether it did
+	SetStatusIf(id int64, from, to string) (bool, error)
+	// SetLastDeploy records when a project was last deployed successfully
+	SetLastDeploy(id int64, at time.Time) error
+
+	UpdateHealthCheck(id int64, hc models.HealthCheck) error
+	UpdateRestartPolicy(id int64, policy models.RestartPolicy) error
+	UpdateLogRetention(id int64, retention models.LogRetention) error
+
+	// SetWebhook sets the push webhook of a project, nil clears it
+	SetWebhook(id int64, hookID *int64) error
+	// ReplaceWebhook points the projects of a repository that use hook oldID at hook newID
+	ReplaceWebhook(githubRepoID, oldID, newID int64) error
+}
+
+// ProjectFilter selects projects. The zero value selects all of them.
+type ProjectFilter struct {
+	Access         *Access  // only projects the user can access
+	OrganizationID *int64   // only projects of an organization
+	GitHubRepoID   int64    // only projects of a repository
+	Branch         string   // only projects deploying a branch
+	Port           int      // only projects on a port
+	Statuses       []string // only projects with one of the statuses
+	Limit          int      // at most this many projects, all if 0
+	Offset         int
+}
+
+// projectColumns are the columns scanned by scanProject, on projects aliased p
+const projectColumns = `p.id, p.user_id, p.organization_id, p.name, p.github_repo_id, p.repo_url,
+	p.branch, p.subdomain, p.build_command, p.start_command, p.port, p.status, p.last_deploy,
+	p.webhook_id, p.active_deployment_id, p.health_check_path, p.health_check_status,
+	p.health_check_interval, p.health_check_timeout, p.health_check_threshold, p.health_check_restart,
+	p.restart_policy, p.restart_max, p.restart_window, p.stop_timeout, p.log_max_size, p.log_max_files,
+	p.log_max_age, p.created_at, p.updated_at`
+
+// scanProject reads a project from a row of projectColumns
+func scanProject(row interface{ Scan(...interface{}) error }) (*models.Project, error) {
+	var project models.Project
+	var buildCommand, startCommand, status sql.NullString
+	var port sql.NullInt64
+	err := row.Scan(
+		&project.ID,
+		&project.UserID,
+		&project.OrganizationID,
+		&project.Name,
+		&project.GitHubRepoID,
+		&project.RepoURL,
+		&project.Branch,
+		&project.Subdomain,
+		&buildCommand,
+		&startCommand,
+		&port,
+		&status,
+		&project.LastDeploy,
+		&project.WebhookID,
+		&project.ActiveDeploymentID,
+		&project.HealthCheck.Path,
+		&project.HealthCheck.ExpectedStatus,
+		&project.HealthCheck.Interval,
+		&project.HealthCheck.Timeout,
+		&project.HealthCheck.FailureThreshold,
+		&project.HealthCheck.RestartOnFailure,
+		&project.RestartPolicy.Policy,
+		&project.RestartPolicy.MaxRestarts,
+		&project.RestartPolicy.Window,
+		&project.RestartPolicy.StopTimeout,
+		&project.LogRetention.MaxSize,
+		&project.LogRetention.MaxFiles,
+		&project.LogRetention.MaxAge,
+		&project.CreatedAt,
+		&project.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	project.BuildCommand = buildCommand.String
+	project.StartCommand = startCommand.String
+	project.Port = int(port.Int64)
+	project.Status = status.String
+	return &project, nil
+}
+
+// sqlProjects is the ProjectStore of a database
+type sqlProjects struct {
+	db *database.DB
+}
+
+func (s *sqlProjects) Get(id int64) (*models.Project, error) {
+	project, err := scanProject(s.db.QueryRow("SELECT "+projectColumns+" FROM projects p WHERE p.id = ?", id))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return project, nil
+}
+
+func (s *sqlProjects) GetBySubdomain(subdomain string) (*models.Project, error) {
+	project, err := scanProject(s.db.QueryRow("SELECT "+projectColumns+" FROM projects p WHERE p.subdomain = ?", subdomain))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return project, nil
+}
+
+// where builds the WHERE clause of a filter
+func (f ProjectFilter) where() (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	if f.Access != nil {
+		condition, accessArgs := f.Access.condition()
+		conditions = append(conditions, condition)
+		args = append(args, accessArgs...)
+	}
+	if f.OrganizationID != nil {
+		conditions = append(conditions, "p.organization_id = ?")
+		args = append(args, *f.OrganizationID)
+	}
+	if f.GitHubRepoID != 0 {
+		conditions = append(conditions, "p.github_repo_id = ?")
+		args = append(args, f.GitHubRepoID)
+	}
+	if f.Branch != "" {
+		conditions = append(conditions, "p.branch = ?")
+		args = append(args, f.Branch)
+	}
+	if f.Port != 0 {
+		conditions = append(conditions, "p.port = ?")
+		args = append(args, f.Port)
+	}
+	if len(f.Statuses) > 0 {
+		conditions = append(conditions, "p.status IN ("+placeholders(len(f.Statuses))+")")
+		for _, status := range f.Statuses {
+			args = append(args, status)
+		}
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+func (s *sqlProjects) List(filter ProjectFilter) ([]models.Project, error) {
+	where, args := filter.where()
+	query, args := page("SELECT "+projectColumns+" FROM projects p"+where+" ORDER BY p.created_at DESC, p.id DESC", args, filter.Limit, filter.Offset)
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	projects := []models.Project{}
+	for rows.Next() {
+		project, err := scanProject(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan project: %w", err)
+		}
+		projects = append(projects, *project)
+	}
+	return projects, rows.Err()
+}
+
+func (s *sqlProjects) Count(filter ProjectFilter) (int, error) {
+	where, args := filter.where()
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM projects p"+where, args...).Scan(&count)
+	return count, err
+}
+
+func (s *sqlProjects) Create(project *models.Project) error {
+	now := time.Now()
+	err := s.db.QueryRow(`
+		INSERT INTO projects (
+			user_id, organization_id, name, github_repo_id, repo_url, branch, subdomain,
+			build_command, start_command, port, status, created_at, updated_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, project.UserID, project.OrganizationID, project.Name, project.GitHubRepoID, project.RepoURL, project.Branch, project.Subdomain,
+		project.BuildCommand, project.StartCommand, project.Port, models.ProjectStatusInactive, now, now).Scan(&project.ID)
+	if err != nil {
+		return err
+	}
+	project.Status = models.ProjectStatusInactive
+	project.CreatedAt, project.UpdatedAt = now, now
+	return nil
+}
+
+func (s *sqlProjects) Delete(id int64) error {
+	_, err := s.db.Exec("DELETE FROM projects WHERE id = ?", id)
+	return err
+}
+
+func (s *sqlProjects) Transfer(id int64, orgID *int64, userID int64) error {
+	var err error
+	if orgID != nil {
+		_, err = s.db.Exec("UPDATE projects SET organization_id = ?, updated_at = ? WHERE id = ?", *orgID, time.Now(), id)
+	} else {
+		_, err = s.db.Exec("UPDATE projects SET organization_id = NULL, user_id = ?, updated_at = ? WHERE id = ?", userID, time.Now(), id)
+	}
+	return err
+}
+
+func (s *sqlProjects) SetStatus(id int64, status string) error {
+	_, err := s.db.Exec("UPDATE projects SET status = ? WHERE id = ?", status, id)
+	return err
+}
+
+func (s *sqlProjects) SetStatusIf(id int64, from, to string) (bool, error) {
+	result, err := s.db.Exec("UPDATE projects SET status = ? WHERE id = ? AND status = ?", to, id, from)
+	if err != nil {
+		return false, err
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0, nil
+}
+
+func (s *sqlProjects) SetLastDeploy(id int64, at time.Time) error {
+	_, err := s.db.Exec("UPDATE projects SET last_deploy = ? WHERE id = ?", at, id)
+	return err
+}
+
+func (s *sqlProjects) UpdateHealthCheck(id int64, hc models.HealthCheck) error {
+	_, err := s.db.Exec(`
+		UPDATE projects
+		SET health_check_path = ?, health_check_status = ?, health_check_interval = ?,
+		    health_check_timeout = ?, health_check_threshold = ?, health_check_restart = ?,
+		    updated_at = ?
+		WHERE id = ?
+	`, hc.Path, hc.ExpectedStatus, hc.Interval, hc.Timeout, hc.FailureThreshold, hc.RestartOnFailure, time.Now(), id)
+	return err
+}
+
+func (s *sqlProjects) UpdateRestartPolicy(id int64, policy models.RestartPolicy) error {
+	_, err := s.db.Exec(`
+		UPDATE projects SET restart_policy = ?, restart_max = ?, restart_window = ?, stop_timeout = ?, updated_at = ?
+		WHERE id = ?
+	`, policy.Policy, policy.MaxRestarts, policy.Window, policy.StopTimeout, time.Now(), id)
+	return err
+}
+
+func (s *sqlProjects) UpdateLogRetention(id int64, retention models.LogRetention) error {
+	_, err := s.db.Exec(`
+		UPDATE projects SET log_max_size = ?, log_max_files = ?, log_max_age = ?, updated_at = ?
+		WHERE id = ?
+	`, retention.MaxSize, retention.MaxFiles, retention.MaxAge, time.Now(), id)
+	return err
+}
+
+func (s *sqlProjects) SetWebhook(id int64, hookID *int64) error {
+	_, err := s.db.Exec("UPDATE projects SET webhook_id = ? WHERE id = ?", hookID, id)
+	return err
+}
+
+func (s *sqlProjects) ReplaceWebhook(githubRepoID, oldID, newID int64) error {
+	_, err := s.db.Exec("UPDATE projects SET webhook_id = ? WHERE github_repo_id = ? AND webhook_id = ?", newID, githubRepoID, oldID)
+	return err
+}
@@ -0,0 +1,90 @@
+// Package store keeps the platform's projects, deployments, environment variables and users.
+// Each has an interface with a SQL implementation, see NewSQL, and an in-memory fake for
+// tests, see NewMemory.
+//
+// Stores save values as they are given. Secrets such as environment variable values and
+// access tokens are encrypted and decrypted by the services.
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"goth-deploy/internal/database"
+)
+
+// ErrNotFound is returned for records that do not exist
+var ErrNotFound = errors.New("not found")
+
+// Stores bundles the stores of the platform
+type Stores struct {
+	Projects    ProjectStore
+	Deployments DeploymentStore
+	Env         EnvStore
+	Users       UserStore
+}
+
+// NewSQL creates stores that keep everything in the database
+func NewSQL(db *database.DB) *Stores {
+	return &Stores{
+		Projects:    &sqlProjects{db: db},
+		Deployments: &sqlDeployments{db: db},
+		Env:         &sqlEnv{db: db},
+		Users:       &sqlUsers{db: db},
+	}
+}
+
+// Access selects what a user can access: their personal projects and the projects of the
+// organizations they are a member of
+type Access struct {
+	UserID          int64
+	OrganizationIDs []int64
+}
+
+// condition restricts a query on projects aliased p to the accessible projects
+func (a *Access) condition() (string, []interface{}) {
+	query := "((p.organization_id IS NULL AND p.user_id = ?)"
+	args := []interface{}{a.UserID}
+	if len(a.OrganizationIDs) > 0 {
+		query += " OR p.organization_id IN (" + placeholders(len(a.OrganizationIDs)) + ")"
+		for _, id := range a.OrganizationIDs {
+			args = append(args, id)
+		}
+	}
+	return query + ")", args
+}
+
+// allows reports whether a project of the user and organization is accessible
+func (a *Access) allows(userID int64, organizationID *int64) bool {
+	if organizationID == nil {
+		return userID == a.UserID
+	}
+	for _, id := range a.OrganizationIDs {
+		if id == *organizationID {
+			return true
+		}
+	}
+	return false
+}
+
+// placeholders returns n comma separated ? placeholders
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// page appends the LIMIT and OFFSET of a page to a query. A zero limit selects everything.
+func page(query string, args []interface{}, limit, offset int) (string, []interface{}) {
+	if limit <= 0 {
+		return query, args
+	}
+	return query + " LIMIT ? OFFSET ?", append(args, limit, offset)
+}
+
+// notFound translates the sql.ErrNoRows of a single row query to ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,469 @@
+package store_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"goth-deploy/internal/database"
+	"goth-deploy/internal/database/dbtest"
+	"goth-deploy/internal/models"
+	"goth-deploy/internal/store"
+)
+
+// eachStores runs fn as a subtest for the in-memory stores and the SQL stores of every dialect,
+// so the fakes used by tests elsewhere are held to the behavior of the database. Organizations
+// are not kept by the stores, createOrg adds one and returns its ID.
+func eachStores(t *testing.T, fn func(t *testing.T, stores *store.Stores, createOrg func() int64)) {
+	t.Run("memory", func(t *testing.T) {
+		var lastOrgID int64
+		fn(t, store.NewMemory(), func() int64 {
+			lastOrgID++
+			return lastOrgID
+		})
+	})
+	dbtest.Each(t, func(t *testing.T, db *database.DB) {
+		fn(t, store.NewSQL(db), func() int64 {
+			var id int64
+			slug := fmt.Sprintf("org-%d", time.Now().UnixNano())
+			if err := db.QueryRow("INSERT INTO organizations (name, slug) VALUES (?, ?) RETURNING id", slug, slug).Scan(&id); err != nil {
+				t.Fatalf("failed to create organization: %v", err)
+			}
+			return id
+		})
+	})
+}
+
+// createUser adds a user with a GitHub ID and name
+func createUser(t *testing.T, stores *store.Stores, githubID int64, username string) *models.User {
+	t.Helper()
+	user := &models.User{GitHubID: githubID, Username: username, AccessToken: "token"}
+	if err := stores.Users.Create(user); err != nil {
+		t.Fatalf("Users.Create: %v", err)
+	}
+	return user
+}
+
+// createProject adds a personal project of a user on a subdomain
+func createProject(t *testing.T, stores *store.Stores, userID int64, subdomain string, repoID int64) *models.Project {
+	t.Helper()
+	project := &models.Project{
+		UserID:       userID,
+		Name:         subdomain,
+		GitHubRepoID: repoID,
+		RepoURL:      "https://github.com/octo/" + subdomain,
+		Branch:       "main",
+		Subdomain:    subdomain,
+		BuildCommand: "go build -o main .",
+		StartCommand: "./main",
+		Port:         8080,
+	}
+	if err := stores.Projects.Create(project); err != nil {
+		t.Fatalf("Projects.Create: %v", err)
+	}
+	return project
+}
+
+// enqueue adds a pending deployment of a project
+func enqueue(t *testing.T, stores *store.Stores, projectID int64) *models.Deployment {
+	t.Helper()
+	deployment := &models.Deployment{ProjectID: projectID, CommitSHA: "abc123"}
+	if err := stores.Deployments.Enqueue(deployment); err != nil {
+		t.Fatalf("Deployments.Enqueue: %v", err)
+	}
+	return deployment
+}
+
+func TestUsers(t *testing.T) {
+	eachStores(t, func(t *testing.T, stores *store.Stores, _ func() int64) {
+		user := createUser(t, stores, 42, "Octocat")
+		if user.ID == 0 || user.CreatedAt.IsZero() {
+			t.Fatalf("Create did not set the ID and timestamps: %+v", user)
+		}
+		if err := stores.Users.Create(&models.User{GitHubID: 42, Username: "again", AccessToken: "token"}); err == nil {
+			t.Error("Create accepted a second user of the same GitHub account")
+		}
+
+		got, err := stores.Users.GetByUsername("octocat")
+		if err != nil || got.ID != user.ID {
+			t.Fatalf("GetByUsername ignoring case = %v, %v", got, err)
+		}
+		if _, err := stores.Users.GetByGitHubID(7); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("GetByGitHubID of an unknown account = %v, want ErrNotFound", err)
+		}
+
+		user.Email, user.AccessToken = "octo@example.com", "new-token"
+		if err := stores.Users.Update(user); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		got, err = stores.Users.Get(user.ID)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if got.Email != "octo@example.com" || got.AccessToken != "new-token" || got.AvatarURL != "" {
+			t.Errorf("Get after Update = %+v", got)
+		}
+	})
+}
+
+func TestProjects(t *testing.T) {
+	eachStores(t, func(t *testing.T, stores *store.Stores, createOrg func() int64) {
+		owner := createUser(t, stores, 1, "owner")
+		other := createUser(t, stores, 2, "other")
+		first := createProject(t, stores, owner.ID, "first", 100)
+		second := createProject(t, stores, owner.ID, "second", 100)
+		foreign := createProject(t, stores, other.ID, "foreign", 200)
+
+		if first.Status != models.ProjectStatusInactive {
+			t.Errorf("new project status = %q, want inactive", first.Status)
+		}
+		if err := stores.Projects.Create(&models.Project{UserID: owner.ID, Name: "x", RepoURL: "x", Branch: "main", Subdomain: "first"}); err == nil {
+			t.Error("Create accepted a taken subdomain")
+		}
+
+		got, err := stores.Projects.GetBySubdomain("second")
+		if err != nil || got.ID != second.ID {
+			t.Fatalf("GetBySubdomain = %v, %v", got, err)
+		}
+		// The settings start at the schema defaults
+		if got.HealthCheck.ExpectedStatus != 200 || got.RestartPolicy.Policy != models.RestartPolicyOnFailure || got.LogRetention.MaxAge != 7 {
+			t.Errorf("new project settings = %+v %+v %+v", got.HealthCheck, got.RestartPolicy, got.LogRetention)
+		}
+		if _, err := stores.Projects.Get(9999); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Get of an unknown project = %v, want ErrNotFound", err)
+		}
+
+		projects, err := stores.Projects.List(store.ProjectFilter{Access: &store.Access{UserID: owner.ID}})
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		if len(projects) != 2 || projects[0].ID != second.ID || projects[1].ID != first.ID {
+			t.Errorf("List of the owner's projects = %v, want second and first", projectIDs(projects))
+		}
+		page, err := stores.Projects.List(store.ProjectFilter{Limit: 1, Offset: 1})
+		if err != nil || len(page) != 1 || page[0].ID != second.ID {
+			t.Errorf("second page of one project = %v, %v", projectIDs(page), err)
+		}
+		if count, err := stores.Projects.Count(store.ProjectFilter{GitHubRepoID: 100, Limit: 1}); err != nil || count != 2 {
+			t.Errorf("Count of a repository's projects = %d, %v, want 2", count, err)
+		}
+
+		// Organization projects are accessible to members only
+		orgID := createOrg()
+		if err := stores.Projects.Transfer(foreign.ID, &orgID, other.ID); err != nil {
+			t.Fatalf("Transfer: %v", err)
+		}
+		if count, _ := stores.Projects.Count(store.ProjectFilter{Access: &store.Access{UserID: other.ID}}); count != 0 {
+			t.Errorf("non-member sees %d organization projects", count)
+		}
+		member := &store.Access{UserID: owner.ID, OrganizationIDs: []int64{orgID}}
+		if count, _ := stores.Projects.Count(store.ProjectFilter{Access: member}); count != 3 {
+			t.Errorf("member sees %d projects, want 3", count)
+		}
+		if count, _ := stores.Projects.Count(store.ProjectFilter{OrganizationID: &orgID}); count != 1 {
+			t.Errorf("organization has %d projects, want 1", count)
+		}
+
+		changed, err := stores.Projects.SetStatusIf(first.ID, models.ProjectStatusActive, models.ProjectStatusFailed)
+		if err != nil || changed {
+			t.Errorf("SetStatusIf from the wrong status = %v, %v", changed, err)
+		}
+		changed, err = stores.Projects.SetStatusIf(first.ID, models.ProjectStatusInactive, models.ProjectStatusActive)
+		if err != nil || !changed {
+			t.Errorf("SetStatusIf from the current status = %v, %v", changed, err)
+		}
+		if active, _ := stores.Projects.Count(store.ProjectFilter{Statuses: []string{models.ProjectStatusActive}}); active != 1 {
+			t.Errorf("%d active projects, want 1", active)
+		}
+
+		hc := models.HealthCheck{Path: "/healthz", ExpectedStatus: 204, Interval: 10, Timeout: 2, FailureThreshold: 5, RestartOnFailure: true}
+		if err := stores.Projects.UpdateHealthCheck(first.ID, hc); err != nil {
+			t.Fatalf("UpdateHealthCheck: %v", err)
+		}
+		if got, _ := stores.Projects.Get(first.ID); got.HealthCheck != hc {
+			t.Errorf("health check = %+v, want %+v", got.HealthCheck, hc)
+		}
+
+		hookID := int64(77)
+		stores.Projects.SetWebhook(first.ID, &hookID)
+		stores.Projects.SetWebhook(second.ID, &hookID)
+		if err := stores.Projects.ReplaceWebhook(100, 77, 78); err != nil {
+			t.Fatalf("ReplaceWebhook: %v", err)
+		}
+		for _, id := range []int64{first.ID, second.ID} {
+			if got, _ := stores.Projects.Get(id); got.WebhookID == nil || *got.WebhookID != 78 {
+				t.Errorf("webhook of project %d = %v, want 78", id, got.WebhookID)
+			}
+		}
+		stores.Projects.SetWebhook(first.ID, nil)
+		if got, _ := stores.Projects.Get(first.ID); got.WebhookID != nil {
+			t.Errorf("cleared webhook = %d", *got.WebhookID)
+		}
+	})
+}
+
+func TestDeleteProjectRemovesItsRecords(t *testing.T) {
+	eachStores(t, func(t *testing.T, stores *store.Stores, _ func() int64) {
+		user := createUser(t, stores, 1, "owner")
+		project := createProject(t, stores, user.ID, "doomed", 100)
+		deployment := enqueue(t, stores, project.ID)
+		envVar := &models.EnvironmentVariable{ProjectID: project.ID, Key: "PORT", Value: "1", Scope: models.EnvScopeBoth}
+		if err := stores.Env.Create(envVar); err != nil {
+			t.Fatalf("Env.Create: %v", err)
+		}
+
+		if err := stores.Projects.Delete(project.ID); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if _, err := stores.Deployments.Get(deployment.ID); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("deployment of a deleted project = %v, want ErrNotFound", err)
+		}
+		if _, err := stores.Env.Get(project.ID, envVar.ID); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("variable of a deleted project = %v, want ErrNotFound", err)
+		}
+		if job, err := stores.Deployments.ClaimJob(nil); job != nil || err != nil {
+			t.Errorf("ClaimJob after deleting the project = %v, %v", job, err)
+		}
+	})
+}
+
+func TestDeployments(t *testing.T) {
+	eachStores(t, func(t *testing.T, stores *store.Stores, _ func() int64) {
+		user := createUser(t, stores, 1, "owner")
+		project := createProject(t, stores, user.ID, "app", 100)
+		deployment := enqueue(t, stores, project.ID)
+		if deployment.Status != models.StatusPending || deployment.ID == 0 {
+			t.Fatalf("Enqueue = %+v", deployment)
+		}
+
+		if err := stores.Deployments.SetBuildLog(deployment.ID, "building\n"); err != nil {
+			t.Fatalf("SetBuildLog: %v", err)
+		}
+		if buildLog, err := stores.Deployments.BuildLog(deployment.ID); err != nil || buildLog != "building\n" {
+			t.Errorf("BuildLog = %q, %v", buildLog, err)
+		}
+		if _, err := stores.Deployments.BuildLog(9999); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("BuildLog of an unknown deployment = %v, want ErrNotFound", err)
+		}
+
+		if err := stores.Deployments.SetEnvSnapshot(deployment.ID, "snapshot"); err != nil {
+			t.Fatalf("SetEnvSnapshot: %v", err)
+		}
+		if snapshot, err := stores.Deployments.EnvSnapshot(deployment.ID); err != nil || snapshot != "snapshot" {
+			t.Errorf("EnvSnapshot = %q, %v", snapshot, err)
+		}
+
+		finished := time.Now()
+		if err := stores.Deployments.UpdateStatus(deployment.ID, models.StatusSuccess, "done\n", "", finished); err != nil {
+			t.Fatalf("UpdateStatus: %v", err)
+		}
+		if err := stores.Deployments.Activate(project.ID, deployment.ID, "/srv/releases/1", 9001); err != nil {
+			t.Fatalf("Activate: %v", err)
+		}
+
+		got, err := stores.Deployments.Get(deployment.ID)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if got.Status != models.StatusSuccess || got.ReleaseDir != "/srv/releases/1" || got.Port != 9001 || got.FinishedAt == nil {
+			t.Errorf("Get = %+v", got)
+		}
+		if got.BuildLog != "" || got.EnvSnapshot != nil {
+			t.Error("Get returned the build log or environment snapshot")
+		}
+		activated, _ := stores.Projects.Get(project.ID)
+		if activated.Port != 9001 || activated.ActiveDeploymentID == nil || *activated.ActiveDeploymentID != deployment.ID {
+			t.Errorf("activated project port %d, active deployment %v", activated.Port, activated.ActiveDeploymentID)
+		}
+
+		rollbackOf := deployment.ID
+		rollback := &models.Deployment{ProjectID: project.ID, CommitSHA: "abc123", RollbackOf: &rollbackOf}
+		if err := stores.Deployments.Enqueue(rollback); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+		if got, _ := stores.Deployments.Get(rollback.ID); got.RollbackOf == nil || *got.RollbackOf != deployment.ID {
+			t.Errorf("rollback of = %v, want %d", got.RollbackOf, deployment.ID)
+		}
+
+		deployments, err := stores.Deployments.List(store.DeploymentFilter{ProjectID: project.ID})
+		if err != nil || len(deployments) != 2 || deployments[0].ID != rollback.ID {
+			t.Errorf("List = %v, %v, want the rollback first", deploymentIDs(deployments), err)
+		}
+		if count, _ := stores.Deployments.Count(store.DeploymentFilter{Released: true}); count != 1 {
+			t.Errorf("%d released deployments, want 1", count)
+		}
+		if count, _ := stores.Deployments.Count(store.DeploymentFilter{Access: &store.Access{UserID: user.ID}, Status: models.StatusPending}); count != 1 {
+			t.Errorf("%d accessible pending deployments, want 1", count)
+		}
+		if count, _ := stores.Deployments.Count(store.DeploymentFilter{Access: &store.Access{UserID: user.ID + 1}}); count != 0 {
+			t.Errorf("another user can access %d deployments", count)
+		}
+	})
+}
+
+func TestDeploymentQueue(t *testing.T) {
+	eachStores(t, func(t *testing.T, stores *store.Stores, _ func() int64) {
+		user := createUser(t, stores, 1, "owner")
+		app := createProject(t, stores, user.ID, "app", 100)
+		api := createProject(t, stores, user.ID, "api", 200)
+
+		// A newer deployment supersedes the queued one
+		superseded := enqueue(t, stores, app.ID)
+		queued := enqueue(t, stores, app.ID)
+		if got, _ := stores.Deployments.Get(superseded.ID); got.Status != models.StatusSuperseded {
+			t.Errorf("older queued deployment is %s, want superseded", got.Status)
+		}
+		other := enqueue(t, stores, api.ID)
+
+		job, err := stores.Deployments.ClaimJob(nil)
+		if err != nil || job == nil || job.DeploymentID != queued.ID {
+			t.Fatalf("ClaimJob = %+v, %v, want the job of deployment %d", job, err, queued.ID)
+		}
+		if job.Status != models.JobStatusRunning || job.Attempts != 1 {
+			t.Errorf("claimed job = %+v", job)
+		}
+
+		// A project with a running job waits
+		if next, err := stores.Deployments.ClaimJob(map[int64]bool{api.ID: true}); next != nil || err != nil {
+			t.Errorf("ClaimJob with every project busy = %+v, %v", next, err)
+		}
+		next, err := stores.Deployments.ClaimJob(map[int64]bool{app.ID: true})
+		if err != nil || next == nil || next.DeploymentID != other.ID {
+			t.Fatalf("ClaimJob = %+v, %v, want the job of deployment %d", next, err, other.ID)
+		}
+		if err := stores.Deployments.FinishJob(next.ID); err != nil {
+			t.Fatalf("FinishJob: %v", err)
+		}
+		stores.Deployments.UpdateStatus(other.ID, models.StatusSuccess, "", "", time.Now())
+
+		// The platform stopped while the first job was running
+		stores.Deployments.UpdateStatus(queued.ID, models.StatusBuilding, "half a log", "", time.Now())
+		requeued, failed, err := stores.Deployments.Recover(3)
+		if err != nil || requeued != 1 || failed != 0 {
+			t.Fatalf("Recover = %d requeued, %d failed, %v", requeued, failed, err)
+		}
+		got, _ := stores.Deployments.Get(queued.ID)
+		buildLog, _ := stores.Deployments.BuildLog(queued.ID)
+		if got.Status != models.StatusPending || buildLog != "" || got.FinishedAt != nil {
+			t.Errorf("requeued deployment is %s with log %q", got.Status, buildLog)
+		}
+
+		retried, err := stores.Deployments.ClaimJob(nil)
+		if err != nil || retried == nil || retried.ID != job.ID || retried.Attempts != 2 {
+			t.Fatalf("ClaimJob after Recover = %+v, %v", retried, err)
+		}
+
+		// Once out of attempts an interrupted deployment fails
+		stores.Deployments.UpdateStatus(queued.ID, models.StatusBuilding, "", "", time.Now())
+		stores.Projects.SetStatus(app.ID, models.ProjectStatusBuilding)
+		requeued, failed, err = stores.Deployments.Recover(2)
+		if err != nil || requeued != 0 || failed != 1 {
+			t.Fatalf("Recover = %d requeued, %d failed, %v", requeued, failed, err)
+		}
+		if got, _ := stores.Deployments.Get(queued.ID); got.Status != models.StatusFailed {
+			t.Errorf("abandoned deployment is %s, want failed", got.Status)
+		}
+		if got, _ := stores.Projects.Get(app.ID); got.Status != models.ProjectStatusFailed {
+			t.Errorf("project of the abandoned deployment is %s, want failed", got.Status)
+		}
+	})
+}
+
+func TestEnv(t *testing.T) {
+	eachStores(t, func(t *testing.T, stores *store.Stores, _ func() int64) {
+		user := createUser(t, stores, 1, "owner")
+		project := createProject(t, stores, user.ID, "app", 100)
+		other := createProject(t, stores, user.ID, "other", 200)
+
+		port := &models.EnvironmentVariable{ProjectID: project.ID, Key: "PORT", Value: "8080", Scope: models.EnvScopeRuntime}
+		token := &models.EnvironmentVariable{ProjectID: project.ID, Key: "API_TOKEN", Value: "secret", Scope: models.EnvScopeBoth}
+		for _, envVar := range []*models.EnvironmentVariable{port, token} {
+			if err := stores.Env.Create(envVar); err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+		}
+		if err := stores.Env.Create(&models.EnvironmentVariable{ProjectID: project.ID, Key: "PORT", Value: "1", Scope: models.EnvScopeBoth}); err == nil {
+			t.Error("Create accepted a duplicate name")
+		}
+
+		envVars, err := stores.Env.List(project.ID)
+		if err != nil || len(envVars) != 2 || envVars[0].Key != "API_TOKEN" || envVars[1].Key != "PORT" {
+			t.Fatalf("List = %v, %v, want API_TOKEN and PORT", envVars, err)
+		}
+		if envVars, _ := stores.Env.List(other.ID); len(envVars) != 0 {
+			t.Errorf("another project has %d variables", len(envVars))
+		}
+
+		if found, err := stores.Env.FindKey(project.ID, "port", 0); err != nil || found.ID != port.ID {
+			t.Errorf("FindKey ignoring case = %v, %v", found, err)
+		}
+		if _, err := stores.Env.FindKey(project.ID, "PORT", port.ID); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("FindKey excluding the variable = %v, want ErrNotFound", err)
+		}
+		if _, err := stores.Env.Get(other.ID, port.ID); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Get through another project = %v, want ErrNotFound", err)
+		}
+
+		port.Value, port.Scope = "9090", models.EnvScopeBoth
+		if err := stores.Env.Update(port); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		if got, _ := stores.Env.Get(project.ID, port.ID); got.Value != "9090" || got.Scope != models.EnvScopeBoth {
+			t.Errorf("Get after Update = %+v", got)
+		}
+		moved := *port
+		moved.ProjectID = other.ID
+		if err := stores.Env.Update(&moved); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Update through another project = %v, want ErrNotFound", err)
+		}
+		if err := stores.Env.Delete(other.ID, port.ID); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Delete through another project = %v, want ErrNotFound", err)
+		}
+
+		renamed := *token
+		renamed.Key = "TOKEN"
+		err = stores.Env.Apply(project.ID, store.EnvBatch{
+			Create: []models.EnvironmentVariable{{Key: "DEBUG", Value: "1", Scope: models.EnvScopeBuild}},
+			Update: []models.EnvironmentVariable{renamed},
+			Delete: []models.EnvironmentVariable{*port},
+		})
+		if err != nil {
+			t.Fatalf("Apply: %v", err)
+		}
+		envVars, _ = stores.Env.List(project.ID)
+		if len(envVars) != 2 || envVars[0].Key != "DEBUG" || envVars[1].Key != "TOKEN" {
+			t.Errorf("List after Apply = %v, want DEBUG and TOKEN", envVars)
+		}
+
+		// A failing batch changes nothing
+		err = stores.Env.Apply(project.ID, store.EnvBatch{
+			Create: []models.EnvironmentVariable{{Key: "NEW", Value: "1", Scope: models.EnvScopeBoth}, {Key: "DEBUG", Value: "2", Scope: models.EnvScopeBoth}},
+			Delete: []models.EnvironmentVariable{envVars[1]},
+		})
+		if err == nil {
+			t.Fatal("Apply accepted a duplicate name")
+		}
+		if after, _ := stores.Env.List(project.ID); len(after) != 2 || after[0].Value != "1" {
+			t.Errorf("List after a failed Apply = %v", after)
+		}
+	})
+}
+
+// projectIDs returns the IDs of projects
+func projectIDs(projects []models.Project) []int64 {
+	var ids []int64
+	for _, project := range projects {
+		ids = append(ids, project.ID)
+	}
+	return ids
+}
+
+// deploymentIDs returns the IDs of deployments
+func deploymentIDs(deployments []models.Deployment) []int64 {
+	var ids []int64
+	for _, deployment := range deployments {
+		ids = append(ids, deployment.ID)
+	}
+	return ids
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+
+	"goth-deploy/internal/database"
+	"goth-deploy/internal/models"
+)
+
+// UserStore keeps user accounts. Access tokens are stored as given, the services encrypt them.
+type UserStore interface {
+	// Get returns a user, or ErrNotFound
+	Get(id int64) (*models.User, error)
+	// GetByGitHubID returns the user of a GitHub account, or ErrNotFound
+	GetByGitHubID(githubID int64) (*models.User, error)
+	// GetByUsername returns the user with a GitHub username, ignoring case, or ErrNotFound
+	GetByUsername(username string) (*models.User, error)
+
+	// Create saves a new user and sets its ID and timestamps
+	Create(user *models.User) error
+	// Update saves the profile and access token of a user
+	Update(user *models.User) error
+}
+
+// userColumns are the columns scanned by scanUser
+const userColumns = "id, github_id, username, email, avatar_url, access_token, created_at, updated_at"
+
+// scanUser reads a user from a row of userColumns
+func scanUser(row interface{ Scan(...interface{}) error }) (*models.User, error) {
+	var user models.User
+	var email, avatarURL sql.NullString
+	err := row.Scan(
+		&user.ID,
+		&user.GitHubID,
+		&user.Username,
+		&email,
+		&avatarURL,
+		&user.AccessToken,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	user.Email = email.String
+	user.AvatarURL = avatarURL.String
+	return &user, nil
+}
+
+// sqlUsers is the UserStore of a database
+type sqlUsers struct {
+	db *database.DB
+}
+
+func (s *sqlUsers) Get(id int64) (*models.User, error) {
+	return scanUser(s.db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
+}
+
+func (s *sqlUsers) GetByGitHubID(githubID int64) (*models.User, error) {
+	return scanUser(s.db.QueryRow("SELECT "+userColumns+" FROM users WHERE github_id = ?", githubID))
+}
+
+func (s *sqlUsers) GetByUsername(username string) (*models.User, error) {
+	return scanUser(s.db.QueryRow("SELECT "+userColumns+" FROM users WHERE username = ? COLLATE NOCASE", username))
+}
+
+func (s *sqlUsers) Create(user *models.User) error {
+	now := time.Now()
+	err := s.db.QueryRow(`
+		INSERT INTO users (github_id, username, email, avatar_url, access_token, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, user.GitHubID, user.Username, user.Email, user.AvatarURL, user.AccessToken, now, now).Scan(&user.ID)
+	if err != nil {
+		return err
+	}
+	user.CreatedAt, user.UpdatedAt = now, now
+	return nil
+}
+
+func (s *sqlUsers) Update(user *models.User) error {
+	user.UpdatedAt = time.Now()
+	_, err := s.db.Exec(`
+		UPDATE users
+		SET username = ?, email = ?, avatar_url = ?, access_token = ?, updated_at = ?
+		WHERE id = ?
+	`, user.Username, user.Email, user.AvatarURL, user.AccessToken, user.UpdatedAt, user.ID)
+	return err
+}